@@ -48,10 +48,15 @@ type IDSRuleSource struct {
 	Name string `json:"name"`
 
 	// URL allows fetching a remote ruleset (e.g., specific version of ET Open).
-	// The controller will need logic to download and cache this.
+	// The file can hold plain rules, or be a gzip or tar.gz archive of .rules files. It is downloaded again
+	// every IDS_RULES_REFRESH_INTERVAL, and the last good copy is kept while the download fails.
 	// +optional
 	URL string `json:"url,omitempty"`
 
+	// SHA256 is the hex checksum of the file at URL. The download is rejected when it doesn't match.
+	// +optional
+	SHA256 string `json:"sha256,omitempty"`
+
 	// Inline allows the user to paste a raw Suricata rule directly in the YAML.
 	// Example: "alert tcp any any -> any 80 (msg:\"test rule\"; sid:100001; rev:1;)"
 	// +optional
@@ -84,7 +89,8 @@ type IdsRules struct {
 	CustomRuleSources []IDSRuleSource `json:"customRuleSources,omitempty"`
 
 	// IgnorePorts allows whitelisting specific traffic flow from inspection
-	// to improve performance or reduce false positives (BPF Filter generation).
+	// to improve performance or reduce false positives. Traffic to or from these ports is left out by the
+	// BPF filter of the IDS.
 	// +optional
 	IgnorePorts []int32 `json:"ignorePorts,omitempty"`
 
@@ -92,15 +98,79 @@ type IdsRules struct {
 	// todo: make optional, and choose control plane as default if none is chosen
 	Node string `json:"node"`
 
-	// profile is for various profiles and ids systems. So far we only have "suricata", so any other
-	// should be implemented beforehand in the codebase
-	Profile string `json:"profile"`
+	// Profile sets how much Suricata inspects, and the resources it takes: "lightweight" only parses the most
+	// common protocols and logs alerts, "balanced" adds more protocols and the dns, http and tls events, and
+	// "full" parses every protocol and logs flows and files too. "custom" takes the suricata.yaml of
+	// CustomProfile instead. "suricata" is kept as an alias of "balanced".
+	// +kubebuilder:default:=balanced
+	// +optional
+	Profile string `json:"profile,omitempty"`
+
+	// CustomProfile is the ConfigMap, in the namespace of the ids, whose suricata.yaml key is used as the
+	// configuration of Suricata when the profile is "custom". It runs with the resources of the balanced profile.
+	// +optional
+	CustomProfile *corev1.LocalObjectReference `json:"customProfile,omitempty"`
+
+	// Image overrides the Suricata image of the IDS, which is pinned to a tested version by default.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// AlertPolicy quarantines the pods of the network whose traffic raises some alerts of the IDS.
+	// +optional
+	AlertPolicy *IDSAlertPolicy `json:"alertPolicy,omitempty"`
 
 	// namespace sets the namespace where the ids resources will be deployed. if not set, it will be the overlay
 	// by default.
 	Namespace string `json:"namespace,omitempty"`
 }
 
+// IDSAlertPolicy moves the pods that raise some alerts of the IDS to a quarantine network, by creating a
+// QuarantinePodRequest for them. An alert matches the policy when its signature or its severity is listed.
+type IDSAlertPolicy struct {
+	// SignatureIDs are the sids of the rules whose alerts quarantine the pod that raised them.
+	// +optional
+	SignatureIDs []int64 `json:"signatureIDs,omitempty"`
+
+	// Severities are the severities whose alerts quarantine the pod that raised them, 1 being the highest.
+	// +optional
+	Severities []int32 `json:"severities,omitempty"`
+
+	// QuarantineNetwork is the L2Network, in the namespace of the network, the pods are moved to.
+	// +kubebuilder:validation:MinLength=1
+	QuarantineNetwork string `json:"quarantineNetwork"`
+}
+
+// IPAMSpec tunes how the addresses of the pod address range are handed out to pods.
+type IPAMSpec struct {
+	// Exclusions lists addresses or CIDRs inside the pod address range that will never be assigned to a pod,
+	// e.g. 10.101.2.128/25 or 10.101.2.7.
+	// +optional
+	Exclusions []string `json:"exclusions,omitempty"`
+
+	// Gateways reserves the gateway address of the network, at most one per address family, so that it is never
+	// assigned to a pod.
+	// +optional
+	Gateways []string `json:"gateways,omitempty"`
+}
+
+// IPReservation pins addresses of the network to the pod with a given name, or to the pods chosen by a label selector.
+// Pods that match a reservation get its addresses when they don't request any, and the addresses are never handed
+// out to other pods.
+type IPReservation struct {
+	// PodName is the name of the pod the addresses are reserved for.
+	// +optional
+	PodName string `json:"podName,omitempty"`
+
+	// PodSelector chooses the pods the addresses are reserved for by their labels. Only one of them can hold the
+	// addresses at a time.
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// IPs are the reserved addresses, at most one per address family, e.g. 10.101.0.10 or fd00:101::10.
+	// +kubebuilder:validation:MinItems=1
+	IPs []string `json:"ips"`
+}
+
 // L2NetworkSpec defines the desired state of L2Network
 type L2NetworkSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
@@ -117,16 +187,123 @@ type L2NetworkSpec struct {
 
 	// NetworkCIDR defines the overall network CIDR used for routing pod interfaces.
 	// This value represents the broader network segment that encompasses all pod IPs,
-	// e.g. 10.101.0.0/16.
+	// e.g. 10.101.0.0/16. Dual-stack networks take an IPv4 and an IPv6 CIDR separated by a comma,
+	// e.g. 10.101.0.0/16,fd00:101::/64, and pods get an address of each.
 	NetworkCIDR string `json:"networkCIDR,omitempty"`
 
 	// PodAddressRange specifies the specific pool of IP addresses that can be assigned to pods.
-	// This range should be a subset of the overall network CIDR, e.g. 10.101.2.0/24.
+	// This range should be a subset of the overall network CIDR, e.g. 10.101.2.0/24. In dual-stack networks
+	// there can be one range per address family, separated by a comma.
 	PodAddressRange string `json:"podAddressRange,omitempty"`
 
+	// IPAM configures exclusions and reserved addresses for the automatic assignment of pod addresses.
+	// +optional
+	IPAM *IPAMSpec `json:"ipam,omitempty"`
+
+	// Reservations pins addresses of the network to specific pods. The addresses must be inside the network CIDR.
+	// +optional
+	Reservations []IPReservation `json:"reservations,omitempty"`
+
+	// MTU of the interfaces of the pods attached to the network, overriding the one of the overlay when the pods
+	// attach. It can't be larger than the MTU of any overlay.
+	// +kubebuilder:validation:Minimum=1280
+	// +kubebuilder:validation:Maximum=9216
+	// +optional
+	MTU int `json:"mtu,omitempty"`
+
 	// Ids configures the intrusion detection system.
 	// +optional
 	Ids *IdsRules `json:"ids,omitempty"`
+
+	// Path is the ordered list of overlay nodes a vlink network traverses, from one endpoint to the other.
+	// Every pair of consecutive nodes must be linked in the overlay topology. Only used with the vlink type.
+	// +optional
+	Path []string `json:"path,omitempty"`
+}
+
+// NEDAttachment records how an inter-domain network is connected to the network edge device of the cluster.
+type NEDAttachment struct {
+	// Name of the network edge device.
+	Name string `json:"name"`
+
+	// Node the network edge device runs in.
+	Node string `json:"node"`
+
+	// NetworkAttachmentDefinition is the interface of the node that bridges the l2sm switch and the network edge device.
+	NetworkAttachmentDefinition string `json:"networkAttachmentDefinition"`
+
+	// Port is the port of the network edge device attached to the network in the provider SDN controller.
+	// +optional
+	Port string `json:"port,omitempty"`
+}
+
+// IDSAttachment records the resources the intrusion detection system of a network holds.
+type IDSAttachment struct {
+	// Namespace the IDS resources are deployed in.
+	Namespace string `json:"namespace"`
+
+	// Node the IDS runs in.
+	Node string `json:"node"`
+
+	// NetworkAttachmentDefinition is the interface of the node that receives the mirrored traffic.
+	NetworkAttachmentDefinition string `json:"networkAttachmentDefinition"`
+
+	// MirrorPort is the switch port the traffic of the network is mirrored to.
+	MirrorPort string `json:"mirrorPort"`
+}
+
+// IDSRulesStatus reports the rules loaded by the intrusion detection system of a network.
+type IDSRulesStatus struct {
+	// RuleCount is the number of inline and remote rules rendered in the last sync. Rules from ConfigMaps are
+	// not counted.
+	RuleCount int `json:"ruleCount"`
+
+	// LastSyncTime is when the remote rule sources were last fetched.
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+}
+
+// IDSAlertsStatus summarizes the alerts raised by the intrusion detection system of a network.
+type IDSAlertsStatus struct {
+	// Total is the number of alerts raised since the IDS was deployed.
+	Total int64 `json:"total"`
+
+	// LastAlertTime is when the last counted alert was raised. Alerts are only counted once.
+	// +optional
+	LastAlertTime *metav1.MicroTime `json:"lastAlertTime,omitempty"`
+
+	// Signatures are the rules that raised alerts, the most recent first. Only the last 20 are kept.
+	// +optional
+	Signatures []IdsAlert `json:"signatures,omitempty"`
+}
+
+// IdsAlert summarizes the alerts raised by a rule of the intrusion detection system.
+type IdsAlert struct {
+	// SignatureID is the sid of the rule.
+	SignatureID int64 `json:"signatureID"`
+
+	// Signature is the message of the rule.
+	// +optional
+	Signature string `json:"signature,omitempty"`
+
+	// Category is the classification of the rule.
+	// +optional
+	Category string `json:"category,omitempty"`
+
+	// Severity is the severity of the rule, 1 being the highest.
+	// +optional
+	Severity int32 `json:"severity,omitempty"`
+
+	// Count is the number of alerts the rule raised.
+	Count int64 `json:"count"`
+
+	// LastSeen is when the rule last raised an alert.
+	LastSeen metav1.MicroTime `json:"lastSeen"`
+
+	// LastSource is the pod of the network whose traffic last raised the alert, or its source address when it
+	// isn't one.
+	// +optional
+	LastSource string `json:"lastSource,omitempty"`
 }
 
 // L2NetworkStatus defines the observed state of L2Network
@@ -149,6 +326,39 @@ type L2NetworkStatus struct {
 
 	// Status of the connectivity to the external provider SDN Controller. If there is no connectivity, the exisitng l2sm-ned in the cluster won't forward packages to the external clusters.
 	ProviderConnectivity *ConnectivityStatus `json:"providerConnectivity,omitempty"`
+
+	// Hops is the switch path programmed in the SDN controller for a vlink network, as OpenFlow device IDs.
+	// +optional
+	Hops []string `json:"hops,omitempty"`
+
+	// NEDAttachment is set while an inter-domain network is connected to the network edge device of the cluster.
+	// +optional
+	NEDAttachment *NEDAttachment `json:"nedAttachment,omitempty"`
+
+	// IDSAttachment is set while the intrusion detection system of the network is deployed.
+	// +optional
+	IDSAttachment *IDSAttachment `json:"idsAttachment,omitempty"`
+
+	// IDSRules reports the rules of the intrusion detection system of the network, once they have been synced.
+	// +optional
+	IDSRules *IDSRulesStatus `json:"idsRules,omitempty"`
+
+	// IDSAlerts summarizes the alerts raised by the intrusion detection system of the network.
+	// +optional
+	IDSAlerts *IDSAlertsStatus `json:"idsAlerts,omitempty"`
+
+	// ReleasedResources lists the resources of the network that have already been released while it is being deleted.
+	// +optional
+	ReleasedResources []string `json:"releasedResources,omitempty"`
+
+	// Conditions represent the latest observations of the network state. The "Synchronized" condition
+	// reports whether the network and its pod ports match what the SDN controller holds, the "IDSRulesSynced"
+	// condition whether the remote IDS rules could be fetched, and the "TornDown" condition reports the progress
+	// of the release of its resources when it is deleted.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
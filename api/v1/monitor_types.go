@@ -79,6 +79,8 @@ type MonitorSpec struct {
 
 	ExportMetrics *ExportMetricSpec `json:"exportMetric,omitempty"`
 
+	// NetworkCIDR is the IPv4 or IPv6 subnet the probing interfaces of the switches get their addresses from,
+	// e.g. 10.0.0.0/24 (the default) or fd00:ff::/64.
 	NetworkCIDR *string `json:"networkCIDR,omitempty"`
 	IpCIDR      *string `json:"ipCIDR,omitempty"`
 }
@@ -135,6 +135,24 @@ type NetworkEdgeDeviceStatus struct {
 	// LinkMetrics holds the performance data for every monitored link.
 	// +optional
 	LinkMetrics *[]LinkStatus `json:"linkMetrics,omitempty"`
+
+	// Phase summarizes the conditions of the network edge device.
+	// +optional
+	Phase FabricPhase `json:"phase,omitempty"`
+
+	// Switch holds the state of the network edge device switch.
+	// +optional
+	Switch *NodeSwitchStatus `json:"switch,omitempty"`
+
+	// ObservedGeneration is the generation of the spec the status refers to.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions describe the state of the switch, its configuration, the monitoring and the SDN controller connection.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -142,6 +160,8 @@ type NetworkEdgeDeviceStatus struct {
 
 // NetworkEdgeDevice is the Schema for the networkedgedevices API
 // +kubebuilder:printcolumn:name="STATUS",type="string",JSONPath=".status.availability",description="Availability status of the overlay"
+// +kubebuilder:printcolumn:name="PHASE",type="string",JSONPath=".status.phase",description="Summary of the network edge device conditions"
+// +kubebuilder:printcolumn:name="NODE",type="string",JSONPath=".spec.nodeConfig.nodeName",description="Node the switch is deployed in"
 // +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
 type NetworkEdgeDevice struct {
 	metav1.TypeMeta   `json:",inline"`
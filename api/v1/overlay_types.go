@@ -36,6 +36,48 @@ type TopologySpec struct {
 	Links []Link `json:"links,omitempty"`
 }
 
+// InterfaceOptions configures the bridge of the interfaces the switches offer to pods.
+type InterfaceOptions struct {
+	// MTU of the interfaces and their bridge. It must be at least as large as the MTU of any network attached
+	// through the overlay. 1400 is used if not set.
+	// +kubebuilder:validation:Minimum=1280
+	// +kubebuilder:validation:Maximum=9216
+	// +optional
+	MTU int `json:"mtu,omitempty"`
+
+	// VLAN tags the traffic of the interfaces with this VLAN ID in their bridge. Untagged if not set.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=4094
+	// +optional
+	VLAN int `json:"vlan,omitempty"`
+
+	// MacSpoofCheck drops the frames sent by pods with a source MAC address other than the one of their interface.
+	// +optional
+	MacSpoofCheck bool `json:"macspoofchk,omitempty"`
+}
+
+// InterfacePoolSpec lets the interfaces of the switches grow as pods take them, instead of being fixed to the
+// interface number.
+type InterfacePoolSpec struct {
+	// MinFree is the number of free interfaces a node can be left with before the pool grows.
+	// +kubebuilder:default:=2
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinFree int `json:"minFree,omitempty"`
+
+	// Step is how many interfaces are added to every switch each time the pool grows.
+	// +kubebuilder:default:=5
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Step int `json:"step,omitempty"`
+
+	// MaxSize is the largest number of interfaces the pool can grow to. 99, the most a switch can have, if not set.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=99
+	// +optional
+	MaxSize int `json:"maxSize,omitempty"`
+}
+
 // OverlaySpec defines the desired state of Overlay
 type OverlaySpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
@@ -49,7 +91,7 @@ type OverlaySpec struct {
 
 	// Topology represents the desired topology, it's represented by the 'Nodes' field, a list of nodes where the switches are going to be deployed and a list of bidirectional links,
 	// selecting the nodes that are going to be linked.
-	Topology *TopologySpec `json:"topology,omitempty"`
+	Topology *TopologySpec `json:"topology"`
 
 	// Template describes the virtual switch pod that will be created.
 	SwitchTemplate *SwitchTemplateSpec `json:"switchTemplate,omitempty"`
@@ -58,21 +100,122 @@ type OverlaySpec struct {
 	//+kubebuilder:default:value=10
 	InterfaceNumber int `json:"interfaceNumber,omitempty"`
 
+	// InterfaceOptions configures the MTU, VLAN and MAC spoof checking of the interfaces.
+	// +optional
+	InterfaceOptions *InterfaceOptions `json:"interfaceOptions,omitempty"`
+
+	// InterfacePool makes the number of interfaces grow when a node runs out of free ones. The interface number is
+	// the initial size of the pool, which never shrinks.
+	// +optional
+	InterfacePool *InterfacePoolSpec `json:"interfacePool,omitempty"`
+
 	// Monitor enables the performance measurement probing mechanism.
 	// If omitted, no metrics are collected.
 	// +optional
 	Monitor *MonitorSpec `json:"monitor,omitempty"`
 }
 
+// Condition types reported by overlays and network edge devices.
+const (
+	// SwitchesReadyCondition is true when every switch pod is running and ready.
+	SwitchesReadyCondition = "SwitchesReady"
+	// TopologyAppliedCondition is true when the switches, their configuration and services match the spec.
+	TopologyAppliedCondition = "TopologyApplied"
+	// MonitoringReadyCondition is true when the monitoring network is set up. It is only reported if monitoring is enabled.
+	MonitoringReadyCondition = "MonitoringReady"
+	// ControllerConnectedCondition is true when the SDN controller that manages the switches is reachable.
+	ControllerConnectedCondition = "ControllerConnected"
+)
+
+// FabricPhase is a summary of the conditions of an overlay or network edge device.
+type FabricPhase string
+
+const (
+	// FabricPending means that no switch is ready yet.
+	FabricPending FabricPhase = "Pending"
+	// FabricReady means that every condition is met.
+	FabricReady FabricPhase = "Ready"
+	// FabricDegraded means that some switches are up but some condition is not met.
+	FabricDegraded FabricPhase = "Degraded"
+)
+
+// NodeSwitchStatus is the observed state of the switch deployed in a node.
+type NodeSwitchStatus struct {
+	// Node is the name of the node the switch is deployed in.
+	Node string `json:"node"`
+	// Ready tells whether the switch pod is running and ready.
+	Ready bool `json:"ready"`
+	// Pod is the name of the switch pod, if it exists.
+	// +optional
+	Pod string `json:"pod,omitempty"`
+	// Message explains why the switch is not ready.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// NodeInterfaceUsage is the usage of the interfaces of the switch of a node.
+type NodeInterfaceUsage struct {
+	// Node is the name of the node.
+	Node string `json:"node"`
+	// Used is the number of interfaces attached to pods.
+	Used int `json:"used"`
+	// Free is the number of interfaces left for new pods.
+	Free int `json:"free"`
+}
+
+// InterfacePoolStatus is the observed state of the interfaces of the switches.
+type InterfacePoolStatus struct {
+	// Size is the number of interfaces of every switch.
+	Size int `json:"size"`
+	// Nodes holds the usage of the interfaces in every node of the topology.
+	// +optional
+	// +listType=map
+	// +listMapKey=node
+	Nodes []NodeInterfaceUsage `json:"nodes,omitempty"`
+}
+
 // OverlayStatus defines the observed state of Overlay
 type OverlayStatus struct {
 	// LinkMetrics holds the performance data for every monitored link.
 	// +optional
 	LinkMetrics *[]LinkStatus `json:"linkMetrics,omitempty"`
+
+	// Phase summarizes the conditions of the overlay.
+	// +optional
+	Phase FabricPhase `json:"phase,omitempty"`
+
+	// ReadySwitches is the number of ready switches out of the ones in the topology, e.g. 2/3.
+	// +optional
+	ReadySwitches string `json:"readySwitches,omitempty"`
+
+	// Nodes holds the state of the switch of every node in the topology.
+	// +optional
+	// +listType=map
+	// +listMapKey=node
+	Nodes []NodeSwitchStatus `json:"nodes,omitempty"`
+
+	// InterfacePool holds the size of the interface pool of the switches and its usage in every node.
+	// +optional
+	InterfacePool *InterfacePoolStatus `json:"interfacePool,omitempty"`
+
+	// ObservedGeneration is the generation of the spec the status refers to.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions describe the state of the switches, the topology, the monitoring and the SDN controller connection.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="PHASE",type="string",JSONPath=".status.phase",description="Summary of the overlay conditions"
+// +kubebuilder:printcolumn:name="SWITCHES",type="string",JSONPath=".status.readySwitches",description="Ready switches"
+// +kubebuilder:printcolumn:name="INTERFACES",type="integer",JSONPath=".status.interfacePool.size",description="Interfaces of every switch"
+// +kubebuilder:printcolumn:name="CONTROLLER",type="string",JSONPath=".status.conditions[?(@.type==\"ControllerConnected\")].status",description="SDN controller connectivity"
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
 
 // Overlay is the Schema for the overlays API
 type Overlay struct {
@@ -14,6 +14,10 @@
 
 package v1
 
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
 // ProviderSpec defines the provider's name and domain. This is used in the inter-cluster scenario, to allow managing of the network in the external environment by this certified SDN provider.
 type ProviderSpec struct {
 	Name   string   `json:"name"`
@@ -33,4 +37,15 @@ type ProviderSpec struct {
 
 	//+kubebuilder:default:value="6633"
 	OFPort string `json:"ofPort,omitempty"`
+
+	// SDNScheme is the scheme used to reach the SDN controller API.
+	//+kubebuilder:validation:Enum=http;https
+	//+kubebuilder:default:="http"
+	SDNScheme string `json:"sdnScheme,omitempty"`
+
+	// CredentialsSecretRef points to the Secret with the credentials of the SDN controller API: a username and a
+	// password, or a bearer token, plus the CA bundle that signs its certificate if it's not publicly trusted. The
+	// Secret must be in the namespace of the resource. Requests are not authenticated if it's not set.
+	// +optional
+	CredentialsSecretRef *corev1.LocalObjectReference `json:"credentialsSecretRef,omitempty"`
 }
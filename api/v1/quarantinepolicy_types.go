@@ -0,0 +1,230 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IDSAlertTrigger quarantines the pods whose traffic raised alerts of the IDS of the source L2Network.
+type IDSAlertTrigger struct {
+	// Severity is the lowest severity of the alerts that quarantine a pod. Suricata severities go from 1, the
+	// highest, downwards, so a severity of 2 quarantines the pods with alerts of severity 1 or 2.
+	// +kubebuilder:validation:Minimum=1
+	Severity int32 `json:"severity"`
+
+	// SignatureIDs, if set, only quarantines the pods with alerts of these rules.
+	// +optional
+	SignatureIDs []int64 `json:"signatureIDs,omitempty"`
+
+	// Window is how recent the alerts that quarantine a pod must be, e.g. 10m. The alerts raised before the policy
+	// was created never do.
+	// +kubebuilder:default:="10m"
+	// +optional
+	Window metav1.Duration `json:"window,omitempty"`
+}
+
+// MetricThreshold is crossed when the value of a link metric is above or below a limit.
+type MetricThreshold struct {
+	// Metric is the name of the metric, e.g. rtt, jitter or throughput.
+	// +kubebuilder:validation:MinLength=1
+	Metric string `json:"metric"`
+
+	// Above is crossed when the metric is above this value, e.g. "150" for an rtt in milliseconds.
+	// +kubebuilder:validation:Pattern=`^-?[0-9]+(\.[0-9]+)?$`
+	// +optional
+	Above string `json:"above,omitempty"`
+
+	// Below is crossed when the metric is below this value.
+	// +kubebuilder:validation:Pattern=`^-?[0-9]+(\.[0-9]+)?$`
+	// +optional
+	Below string `json:"below,omitempty"`
+}
+
+// LinkMetricsTrigger quarantines the pods running in the nodes whose links, measured by the LPM of a monitored
+// Overlay, cross a threshold.
+type LinkMetricsTrigger struct {
+	// Overlay names the monitored Overlay, in the namespace of the policy, whose link metrics are checked.
+	// +kubebuilder:validation:MinLength=1
+	Overlay string `json:"overlay"`
+
+	// Thresholds are the limits of the link metrics. A link crosses them when it crosses any of them.
+	// +kubebuilder:validation:MinItems=1
+	Thresholds []MetricThreshold `json:"thresholds"`
+}
+
+// QuarantineTriggers decide which pods of the source L2Network are quarantined. A pod is quarantined as soon as one
+// of the triggers fires for it.
+type QuarantineTriggers struct {
+	// IDSAlerts fires for the pods whose traffic raised alerts of the IDS of the source L2Network.
+	// +optional
+	IDSAlerts *IDSAlertTrigger `json:"idsAlerts,omitempty"`
+
+	// LinkMetrics fires for the pods running in the nodes whose links cross a threshold.
+	// +optional
+	LinkMetrics *LinkMetricsTrigger `json:"linkMetrics,omitempty"`
+
+	// PodSelector fires for the pods of the source L2Network with these labels.
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+}
+
+// QuarantineRateLimit bounds how many pods a policy quarantines in a period.
+type QuarantineRateLimit struct {
+	// MaxPods is how many pods can be quarantined in a period. The pods over the limit are quarantined in a later
+	// period if their trigger still fires.
+	// +kubebuilder:validation:Minimum=1
+	MaxPods int32 `json:"maxPods"`
+
+	// Period is the length of the period, e.g. 1h.
+	// +kubebuilder:default:="1h"
+	// +optional
+	Period metav1.Duration `json:"period,omitempty"`
+}
+
+// QuarantinePolicySpec defines the desired state of QuarantinePolicy
+type QuarantinePolicySpec struct {
+	// SourceL2Network names the L2Network, in the namespace of the policy, whose pods are quarantined.
+	// +required
+	// +kubebuilder:validation:MinLength=1
+	SourceL2Network string `json:"sourceL2Network"`
+
+	// TargetL2Network names the L2Network, in the namespace of the policy, the pods are moved to.
+	// +required
+	// +kubebuilder:validation:MinLength=1
+	TargetL2Network string `json:"targetL2Network"`
+
+	// Triggers decide which pods of the source L2Network are quarantined.
+	// +required
+	Triggers QuarantineTriggers `json:"triggers"`
+
+	// RateLimit bounds how many pods the policy quarantines. There is no limit if it isn't set.
+	// +optional
+	RateLimit *QuarantineRateLimit `json:"rateLimit,omitempty"`
+
+	// DryRun only records in the audit trail the pods that would be quarantined, without moving them.
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// QuarantinedPod is a pod quarantined by a policy.
+type QuarantinedPod struct {
+	// Pod is the name of the quarantined pod.
+	Pod string `json:"pod"`
+
+	// Request is the QuarantinePodRequest that moves the pod.
+	Request string `json:"request"`
+
+	// Trigger is what fired the quarantine of the pod.
+	Trigger string `json:"trigger"`
+
+	// Time is when the request was created.
+	Time metav1.Time `json:"time"`
+
+	// Moved is set once the request moved the pod to the target L2Network.
+	// +optional
+	Moved bool `json:"moved,omitempty"`
+}
+
+// Actions of the audit trail of a QuarantinePolicy.
+const (
+	QuarantineActionQuarantined = "Quarantined"
+	QuarantineActionDryRun      = "DryRun"
+	QuarantineActionRateLimited = "RateLimited"
+	QuarantineActionFailed      = "Failed"
+)
+
+// QuarantineAuditEntry records a decision of a QuarantinePolicy.
+type QuarantineAuditEntry struct {
+	// Time is when the decision was taken.
+	Time metav1.Time `json:"time"`
+
+	// Pod is the pod the decision is about.
+	Pod string `json:"pod"`
+
+	// Action is what was done: Quarantined, DryRun, RateLimited or Failed.
+	Action string `json:"action"`
+
+	// Trigger is what fired for the pod.
+	Trigger string `json:"trigger"`
+
+	// Message gives the details of the decision.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// QuarantinePolicyStatus defines the observed state of QuarantinePolicy.
+type QuarantinePolicyStatus struct {
+	// ObservedGeneration is the most recent generation reconciled by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// QuarantinedPods are the pods the policy created a QuarantinePodRequest for.
+	// +optional
+	QuarantinedPods []QuarantinedPod `json:"quarantinedPods,omitempty"`
+
+	// AuditTrail records the decisions of the policy, the most recent last. Only the last 50 are kept.
+	// +optional
+	AuditTrail []QuarantineAuditEntry `json:"auditTrail,omitempty"`
+
+	// RecentQuarantines are the times the policy quarantined a pod within the period of its rate limit. They
+	// are kept when the QuarantinePodRequests are deleted, so that deleting them doesn't lift the limit.
+	// +optional
+	RecentQuarantines []metav1.Time `json:"recentQuarantines,omitempty"`
+
+	// conditions represent the current state of the QuarantinePolicy resource.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="SOURCE",type="string",JSONPath=".spec.sourceL2Network"
+// +kubebuilder:printcolumn:name="TARGET",type="string",JSONPath=".spec.targetL2Network"
+// +kubebuilder:printcolumn:name="DRY_RUN",type="boolean",JSONPath=".spec.dryRun"
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+
+// QuarantinePolicy is the Schema for the quarantinepolicies API. It quarantines the pods of a L2Network
+// automatically, by creating a QuarantinePodRequest for every pod a trigger fires for.
+type QuarantinePolicy struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// metadata is a standard object metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitzero"`
+
+	// spec defines the desired state of QuarantinePolicy
+	// +required
+	Spec QuarantinePolicySpec `json:"spec"`
+
+	// status defines the observed state of QuarantinePolicy
+	// +optional
+	Status QuarantinePolicyStatus `json:"status,omitzero"`
+}
+
+// +kubebuilder:object:root=true
+
+// QuarantinePolicyList contains a list of QuarantinePolicy
+type QuarantinePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitzero"`
+	Items           []QuarantinePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&QuarantinePolicy{}, &QuarantinePolicyList{})
+}
@@ -61,6 +61,92 @@ func (in *ExportMetricSpec) DeepCopy() *ExportMetricSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IDSAlertPolicy) DeepCopyInto(out *IDSAlertPolicy) {
+	*out = *in
+	if in.SignatureIDs != nil {
+		in, out := &in.SignatureIDs, &out.SignatureIDs
+		*out = make([]int64, len(*in))
+		copy(*out, *in)
+	}
+	if in.Severities != nil {
+		in, out := &in.Severities, &out.Severities
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IDSAlertPolicy.
+func (in *IDSAlertPolicy) DeepCopy() *IDSAlertPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(IDSAlertPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IDSAlertTrigger) DeepCopyInto(out *IDSAlertTrigger) {
+	*out = *in
+	if in.SignatureIDs != nil {
+		in, out := &in.SignatureIDs, &out.SignatureIDs
+		*out = make([]int64, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IDSAlertTrigger.
+func (in *IDSAlertTrigger) DeepCopy() *IDSAlertTrigger {
+	if in == nil {
+		return nil
+	}
+	out := new(IDSAlertTrigger)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IDSAlertsStatus) DeepCopyInto(out *IDSAlertsStatus) {
+	*out = *in
+	if in.LastAlertTime != nil {
+		in, out := &in.LastAlertTime, &out.LastAlertTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Signatures != nil {
+		in, out := &in.Signatures, &out.Signatures
+		*out = make([]IdsAlert, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IDSAlertsStatus.
+func (in *IDSAlertsStatus) DeepCopy() *IDSAlertsStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(IDSAlertsStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IDSAttachment) DeepCopyInto(out *IDSAttachment) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IDSAttachment.
+func (in *IDSAttachment) DeepCopy() *IDSAttachment {
+	if in == nil {
+		return nil
+	}
+	out := new(IDSAttachment)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IDSRuleSource) DeepCopyInto(out *IDSRuleSource) {
 	*out = *in
@@ -81,6 +167,141 @@ func (in *IDSRuleSource) DeepCopy() *IDSRuleSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IDSRulesStatus) DeepCopyInto(out *IDSRulesStatus) {
+	*out = *in
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IDSRulesStatus.
+func (in *IDSRulesStatus) DeepCopy() *IDSRulesStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(IDSRulesStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPAMSpec) DeepCopyInto(out *IPAMSpec) {
+	*out = *in
+	if in.Exclusions != nil {
+		in, out := &in.Exclusions, &out.Exclusions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Gateways != nil {
+		in, out := &in.Gateways, &out.Gateways
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPAMSpec.
+func (in *IPAMSpec) DeepCopy() *IPAMSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(IPAMSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InterfacePoolSpec) DeepCopyInto(out *InterfacePoolSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InterfacePoolSpec.
+func (in *InterfacePoolSpec) DeepCopy() *InterfacePoolSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(InterfacePoolSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InterfacePoolStatus) DeepCopyInto(out *InterfacePoolStatus) {
+	*out = *in
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]NodeInterfaceUsage, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InterfacePoolStatus.
+func (in *InterfacePoolStatus) DeepCopy() *InterfacePoolStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(InterfacePoolStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InterfaceOptions) DeepCopyInto(out *InterfaceOptions) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InterfaceOptions.
+func (in *InterfaceOptions) DeepCopy() *InterfaceOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(InterfaceOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IPReservation) DeepCopyInto(out *IPReservation) {
+	*out = *in
+	if in.PodSelector != nil {
+		in, out := &in.PodSelector, &out.PodSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.IPs != nil {
+		in, out := &in.IPs, &out.IPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IPReservation.
+func (in *IPReservation) DeepCopy() *IPReservation {
+	if in == nil {
+		return nil
+	}
+	out := new(IPReservation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IdsAlert) DeepCopyInto(out *IdsAlert) {
+	*out = *in
+	in.LastSeen.DeepCopyInto(&out.LastSeen)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IdsAlert.
+func (in *IdsAlert) DeepCopy() *IdsAlert {
+	if in == nil {
+		return nil
+	}
+	out := new(IdsAlert)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IdsRules) DeepCopyInto(out *IdsRules) {
 	*out = *in
@@ -101,6 +322,16 @@ func (in *IdsRules) DeepCopyInto(out *IdsRules) {
 		*out = make([]int32, len(*in))
 		copy(*out, *in)
 	}
+	if in.CustomProfile != nil {
+		in, out := &in.CustomProfile, &out.CustomProfile
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
+	if in.AlertPolicy != nil {
+		in, out := &in.AlertPolicy, &out.AlertPolicy
+		*out = new(IDSAlertPolicy)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IdsRules.
@@ -185,11 +416,28 @@ func (in *L2NetworkSpec) DeepCopyInto(out *L2NetworkSpec) {
 		*out = new(ProviderSpec)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.IPAM != nil {
+		in, out := &in.IPAM, &out.IPAM
+		*out = new(IPAMSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Reservations != nil {
+		in, out := &in.Reservations, &out.Reservations
+		*out = make([]IPReservation, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.Ids != nil {
 		in, out := &in.Ids, &out.Ids
 		*out = new(IdsRules)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Path != nil {
+		in, out := &in.Path, &out.Path
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new L2NetworkSpec.
@@ -222,6 +470,43 @@ func (in *L2NetworkStatus) DeepCopyInto(out *L2NetworkStatus) {
 		*out = new(ConnectivityStatus)
 		**out = **in
 	}
+	if in.Hops != nil {
+		in, out := &in.Hops, &out.Hops
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NEDAttachment != nil {
+		in, out := &in.NEDAttachment, &out.NEDAttachment
+		*out = new(NEDAttachment)
+		**out = **in
+	}
+	if in.IDSAttachment != nil {
+		in, out := &in.IDSAttachment, &out.IDSAttachment
+		*out = new(IDSAttachment)
+		**out = **in
+	}
+	if in.IDSRules != nil {
+		in, out := &in.IDSRules, &out.IDSRules
+		*out = new(IDSRulesStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.IDSAlerts != nil {
+		in, out := &in.IDSAlerts, &out.IDSAlerts
+		*out = new(IDSAlertsStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ReleasedResources != nil {
+		in, out := &in.ReleasedResources, &out.ReleasedResources
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new L2NetworkStatus.
@@ -249,6 +534,26 @@ func (in *Link) DeepCopy() *Link {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LinkMetricsTrigger) DeepCopyInto(out *LinkMetricsTrigger) {
+	*out = *in
+	if in.Thresholds != nil {
+		in, out := &in.Thresholds, &out.Thresholds
+		*out = make([]MetricThreshold, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LinkMetricsTrigger.
+func (in *LinkMetricsTrigger) DeepCopy() *LinkMetricsTrigger {
+	if in == nil {
+		return nil
+	}
+	out := new(LinkMetricsTrigger)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LinkStatus) DeepCopyInto(out *LinkStatus) {
 	*out = *in
@@ -294,6 +599,21 @@ func (in *MetricSpec) DeepCopy() *MetricSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricThreshold) DeepCopyInto(out *MetricThreshold) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricThreshold.
+func (in *MetricThreshold) DeepCopy() *MetricThreshold {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricThreshold)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MetricValue) DeepCopyInto(out *MetricValue) {
 	*out = *in
@@ -346,6 +666,21 @@ func (in *MonitorSpec) DeepCopy() *MonitorSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NEDAttachment) DeepCopyInto(out *NEDAttachment) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NEDAttachment.
+func (in *NEDAttachment) DeepCopy() *NEDAttachment {
+	if in == nil {
+		return nil
+	}
+	out := new(NEDAttachment)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NeighborSpec) DeepCopyInto(out *NeighborSpec) {
 	*out = *in
@@ -493,6 +828,18 @@ func (in *NetworkEdgeDeviceStatus) DeepCopyInto(out *NetworkEdgeDeviceStatus) {
 			}
 		}
 	}
+	if in.Switch != nil {
+		in, out := &in.Switch, &out.Switch
+		*out = new(NodeSwitchStatus)
+		**out = **in
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NetworkEdgeDeviceStatus.
@@ -520,6 +867,36 @@ func (in *NodeConfigSpec) DeepCopy() *NodeConfigSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeInterfaceUsage) DeepCopyInto(out *NodeInterfaceUsage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeInterfaceUsage.
+func (in *NodeInterfaceUsage) DeepCopy() *NodeInterfaceUsage {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeInterfaceUsage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeSwitchStatus) DeepCopyInto(out *NodeSwitchStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeSwitchStatus.
+func (in *NodeSwitchStatus) DeepCopy() *NodeSwitchStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeSwitchStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Overlay) DeepCopyInto(out *Overlay) {
 	*out = *in
@@ -597,6 +974,16 @@ func (in *OverlaySpec) DeepCopyInto(out *OverlaySpec) {
 		*out = new(SwitchTemplateSpec)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.InterfaceOptions != nil {
+		in, out := &in.InterfaceOptions, &out.InterfaceOptions
+		*out = new(InterfaceOptions)
+		**out = **in
+	}
+	if in.InterfacePool != nil {
+		in, out := &in.InterfacePool, &out.InterfacePool
+		*out = new(InterfacePoolSpec)
+		**out = **in
+	}
 	if in.Monitor != nil {
 		in, out := &in.Monitor, &out.Monitor
 		*out = new(MonitorSpec)
@@ -628,6 +1015,23 @@ func (in *OverlayStatus) DeepCopyInto(out *OverlayStatus) {
 			}
 		}
 	}
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]NodeSwitchStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.InterfacePool != nil {
+		in, out := &in.InterfacePool, &out.InterfacePool
+		*out = new(InterfacePoolStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OverlayStatus.
@@ -648,6 +1052,11 @@ func (in *ProviderSpec) DeepCopyInto(out *ProviderSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.CredentialsSecretRef != nil {
+		in, out := &in.CredentialsSecretRef, &out.CredentialsSecretRef
+		*out = new(corev1.LocalObjectReference)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProviderSpec.
@@ -660,6 +1069,22 @@ func (in *ProviderSpec) DeepCopy() *ProviderSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuarantineAuditEntry) DeepCopyInto(out *QuarantineAuditEntry) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuarantineAuditEntry.
+func (in *QuarantineAuditEntry) DeepCopy() *QuarantineAuditEntry {
+	if in == nil {
+		return nil
+	}
+	out := new(QuarantineAuditEntry)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *QuarantinePodRequest) DeepCopyInto(out *QuarantinePodRequest) {
 	*out = *in
@@ -774,6 +1199,191 @@ func (in *QuarantinePodSelector) DeepCopy() *QuarantinePodSelector {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuarantinePolicy) DeepCopyInto(out *QuarantinePolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuarantinePolicy.
+func (in *QuarantinePolicy) DeepCopy() *QuarantinePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(QuarantinePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *QuarantinePolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuarantinePolicyList) DeepCopyInto(out *QuarantinePolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]QuarantinePolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuarantinePolicyList.
+func (in *QuarantinePolicyList) DeepCopy() *QuarantinePolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(QuarantinePolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *QuarantinePolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuarantinePolicySpec) DeepCopyInto(out *QuarantinePolicySpec) {
+	*out = *in
+	in.Triggers.DeepCopyInto(&out.Triggers)
+	if in.RateLimit != nil {
+		in, out := &in.RateLimit, &out.RateLimit
+		*out = new(QuarantineRateLimit)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuarantinePolicySpec.
+func (in *QuarantinePolicySpec) DeepCopy() *QuarantinePolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(QuarantinePolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuarantinePolicyStatus) DeepCopyInto(out *QuarantinePolicyStatus) {
+	*out = *in
+	if in.QuarantinedPods != nil {
+		in, out := &in.QuarantinedPods, &out.QuarantinedPods
+		*out = make([]QuarantinedPod, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AuditTrail != nil {
+		in, out := &in.AuditTrail, &out.AuditTrail
+		*out = make([]QuarantineAuditEntry, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RecentQuarantines != nil {
+		in, out := &in.RecentQuarantines, &out.RecentQuarantines
+		*out = make([]metav1.Time, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuarantinePolicyStatus.
+func (in *QuarantinePolicyStatus) DeepCopy() *QuarantinePolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(QuarantinePolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuarantineRateLimit) DeepCopyInto(out *QuarantineRateLimit) {
+	*out = *in
+	out.Period = in.Period
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuarantineRateLimit.
+func (in *QuarantineRateLimit) DeepCopy() *QuarantineRateLimit {
+	if in == nil {
+		return nil
+	}
+	out := new(QuarantineRateLimit)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuarantineTriggers) DeepCopyInto(out *QuarantineTriggers) {
+	*out = *in
+	if in.IDSAlerts != nil {
+		in, out := &in.IDSAlerts, &out.IDSAlerts
+		*out = new(IDSAlertTrigger)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LinkMetrics != nil {
+		in, out := &in.LinkMetrics, &out.LinkMetrics
+		*out = new(LinkMetricsTrigger)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PodSelector != nil {
+		in, out := &in.PodSelector, &out.PodSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuarantineTriggers.
+func (in *QuarantineTriggers) DeepCopy() *QuarantineTriggers {
+	if in == nil {
+		return nil
+	}
+	out := new(QuarantineTriggers)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QuarantinedPod) DeepCopyInto(out *QuarantinedPod) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QuarantinedPod.
+func (in *QuarantinedPod) DeepCopy() *QuarantinedPod {
+	if in == nil {
+		return nil
+	}
+	out := new(QuarantinedPod)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SwitchPodSpec) DeepCopyInto(out *SwitchPodSpec) {
 	*out = *in
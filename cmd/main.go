@@ -15,18 +15,22 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"os"
+	"path/filepath"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
@@ -37,7 +41,11 @@ import (
 	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
 	"github.com/Networks-it-uc3m/L2S-M/internal/controller"
 	"github.com/Networks-it-uc3m/L2S-M/internal/env"
+	"github.com/Networks-it-uc3m/L2S-M/internal/ipam"
 	"github.com/Networks-it-uc3m/L2S-M/internal/monitoringnetwork"
+	"github.com/Networks-it-uc3m/L2S-M/internal/schedulerextender"
+	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient"
+	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient/fakecontroller"
 
 	//+kubebuilder:scaffold:imports
 	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
@@ -64,6 +72,11 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var schedulerExtenderAddr string
+	var schedulerExtenderCertDir string
+	var schedulerExtenderClientCA string
+	var schedulerExtenderSchedulerName string
+	var fakeSDNController bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -73,6 +86,18 @@ func main() {
 		"If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&schedulerExtenderAddr, "scheduler-extender-bind-address", "0",
+		"The address the scheduler extender binds to. Use 0 to disable it and let the webhook pick the node of l2sm pods.")
+	flag.StringVar(&schedulerExtenderCertDir, "scheduler-extender-cert-dir",
+		filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs"),
+		"The directory with the tls.crt and tls.key the scheduler extender is served with. Defaults to the ones of the webhooks.")
+	flag.StringVar(&schedulerExtenderClientCA, "scheduler-extender-client-ca", "",
+		"The CA bundle that must sign the client certificate of the scheduler. If empty, clients are not authenticated.")
+	flag.StringVar(&schedulerExtenderSchedulerName, "scheduler-extender-scheduler-name", "l2sm-scheduler",
+		"The schedulerName of the pods placed by the scheduler that uses the scheduler extender. The webhook picks the node of the rest.")
+	flag.BoolVar(&fakeSDNController, "fake-sdn-controller", false,
+		"If set, networks are managed in an in-process fake of the l2sm-controller instead of the SDN controller. "+
+			"Meant for development, as no traffic is forwarded between the pods.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -118,6 +143,9 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "ec71f4b7.l2sm.k8s.local",
+		// Secrets are only read to get the credentials of the SDN controllers, and events only to find the ids alerts
+		// of a network by their labels, so they are not cached
+		Client: client.Options{Cache: &client.CacheOptions{DisableFor: []client.Object{&corev1.Secret{}, &corev1.Event{}}}},
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -135,9 +163,29 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Pod addresses are handed out by the webhook and moved by the quarantine controller, both through the same allocator
+	ipAllocator := ipam.NewCRDAllocator(mgr.GetClient(), mgr.GetAPIReader())
+
+	// The reconcilers connect to the SDN controller themselves, unless the fake one is used
+	var internalClient sdnclient.Client
+	var monitoringClientFactory monitoringnetwork.ClientFactory = monitoringnetwork.DefaultClientFactory{Reader: mgr.GetClient()}
+	if fakeSDNController {
+		setupLog.Info("using an in-process fake of the SDN controller")
+		internalClient, err = sdnclient.NewClient(context.Background(), sdnclient.InternalType, fakecontroller.New().ClientConfig())
+		if err != nil {
+			setupLog.Error(err, "unable to start fake SDN controller")
+			os.Exit(1)
+		}
+		monitoringClientFactory = monitoringnetwork.StaticClientFactory{
+			DefaultClientFactory: monitoringnetwork.DefaultClientFactory{Reader: mgr.GetClient()},
+			Client:               internalClient,
+		}
+	}
+
 	if err = (&controller.L2NetworkReconciler{
 		Client:            mgr.GetClient(),
 		Scheme:            mgr.GetScheme(),
+		InternalClient:    internalClient,
 		SwitchesNamespace: env.GetSwitchesNamespace(),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "L2Network")
@@ -147,6 +195,8 @@ func main() {
 		Client:            mgr.GetClient(),
 		Scheme:            mgr.GetScheme(),
 		SwitchesNamespace: env.GetSwitchesNamespace(),
+		InternalClient:    internalClient,
+		IPAM:              ipAllocator,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Pod")
 		os.Exit(1)
@@ -154,7 +204,7 @@ func main() {
 	if err = (&controller.NetworkEdgeDeviceReconciler{
 		Client:                  mgr.GetClient(),
 		Scheme:                  mgr.GetScheme(),
-		MonitoringClientFactory: monitoringnetwork.DefaultClientFactory{},
+		MonitoringClientFactory: monitoringClientFactory,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "NetworkEdgeDevice")
 		os.Exit(1)
@@ -162,24 +212,80 @@ func main() {
 	if err = (&controller.OverlayReconciler{
 		Client:                  mgr.GetClient(),
 		Scheme:                  mgr.GetScheme(),
-		MonitoringClientFactory: monitoringnetwork.DefaultClientFactory{},
+		MonitoringClientFactory: monitoringClientFactory,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Overlay")
 		os.Exit(1)
 	}
 	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
-		podAnnotator := &controller.PodAnnotator{Client: mgr.GetClient(), SwitchesNamespace: env.GetSwitchesNamespace()}
+		podAnnotator := &controller.PodAnnotator{
+			Client:            mgr.GetClient(),
+			SwitchesNamespace: env.GetSwitchesNamespace(),
+			IPAM:              ipAllocator,
+		}
+		if schedulerExtenderAddr != "0" {
+			podAnnotator.ExtenderSchedulerName = schedulerExtenderSchedulerName
+		}
 		if err := podAnnotator.InjectDecoder(admission.NewDecoder(mgr.GetScheme())); err != nil {
 			setupLog.Error(err, "unable to inject decoder into PodAnnotator")
 			os.Exit(1)
 		}
 		mgr.GetWebhookServer().Register("/mutate-v1-pod", &webhook.Admission{Handler: podAnnotator})
+
+		l2networkValidator := &controller.L2NetworkValidator{Client: mgr.GetClient(), SwitchesNamespace: env.GetSwitchesNamespace()}
+		if err := l2networkValidator.InjectDecoder(admission.NewDecoder(mgr.GetScheme())); err != nil {
+			setupLog.Error(err, "unable to inject decoder into L2NetworkValidator")
+			os.Exit(1)
+		}
+		mgr.GetWebhookServer().Register("/validate-l2sm-l2sm-k8s-local-v1-l2network", &webhook.Admission{Handler: l2networkValidator})
+
+		// The overlays and network edge devices are defaulted and validated before their switches are deployed
+		switchWebhooks := map[string]interface {
+			admission.Handler
+			InjectDecoder(*admission.Decoder) error
+		}{
+			"/mutate-l2sm-l2sm-k8s-local-v1-overlay":             &controller.OverlayDefaulter{},
+			"/validate-l2sm-l2sm-k8s-local-v1-overlay":           &controller.OverlayValidator{Client: mgr.GetClient()},
+			"/mutate-l2sm-l2sm-k8s-local-v1-networkedgedevice":   &controller.NetworkEdgeDeviceDefaulter{Client: mgr.GetClient()},
+			"/validate-l2sm-l2sm-k8s-local-v1-networkedgedevice": &controller.NetworkEdgeDeviceValidator{Client: mgr.GetClient()},
+		}
+		for path, handler := range switchWebhooks {
+			if err := handler.InjectDecoder(admission.NewDecoder(mgr.GetScheme())); err != nil {
+				setupLog.Error(err, "unable to inject decoder into webhook", "path", path)
+				os.Exit(1)
+			}
+			mgr.GetWebhookServer().Register(path, &webhook.Admission{Handler: handler})
+		}
+
+		// The scheduler extender lets a kube-scheduler place l2sm pods, filtering nodes by their free interfaces
+		if schedulerExtenderAddr != "0" {
+			if err := mgr.Add(&schedulerextender.Server{
+				BindAddress:       schedulerExtenderAddr,
+				CertDir:           schedulerExtenderCertDir,
+				ClientCAFile:      schedulerExtenderClientCA,
+				TLSOpts:           tlsOpts,
+				Scheduler:         podAnnotator,
+				SwitchesNamespace: env.GetSwitchesNamespace(),
+			}); err != nil {
+				setupLog.Error(err, "unable to set up scheduler extender")
+				os.Exit(1)
+			}
+		}
 	}
 	if err := (&controller.QuarantinePodRequestReconciler{
+		Client:         mgr.GetClient(),
+		Scheme:         mgr.GetScheme(),
+		InternalClient: internalClient,
+		IPAM:           ipAllocator,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "QuarantinePodRequest")
+		os.Exit(1)
+	}
+	if err := (&controller.QuarantinePolicyReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "QuarantinePodRequest")
+		setupLog.Error(err, "unable to create controller", "controller", "QuarantinePolicy")
 		os.Exit(1)
 	}
 	//+kubebuilder:scaffold:builder
@@ -0,0 +1,286 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/env"
+	"github.com/Networks-it-uc3m/L2S-M/internal/ids"
+)
+
+const (
+	// ReasonIDSAlert is the reason of the events of the alerts raised by the traffic of a pod.
+	ReasonIDSAlert = "IDSAlert"
+
+	// maxIDSAlertSignatures is how many signatures the alert summary of a network keeps.
+	maxIDSAlertSignatures = 20
+
+	// idsSignatureLabel and idsSeverityLabel are set on the events of the alerts to their sid and severity, so that
+	// the alerts of a pod can be looked up.
+	idsSignatureLabel = "l2sm/ids-signature"
+	idsSeverityLabel  = "l2sm/ids-severity"
+)
+
+//+kubebuilder:rbac:groups=core,resources=pods/log,verbs=get
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch;update
+//+kubebuilder:rbac:groups=l2sm.l2sm.k8s.local,resources=quarantinepodrequests,verbs=get;list;watch;create
+
+// collectIDSAlerts periodically reads the alerts of the intrusion detection systems, summarizes them in the status of
+// their networks, and records them in the pods that raised them.
+func (r *L2NetworkReconciler) collectIDSAlerts(ctx context.Context) error {
+	ticker := time.NewTicker(env.GetIDSAlertsInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.refreshIDSAlerts(ctx); err != nil {
+				log.FromContext(ctx).Error(err, "could not refresh ids alerts")
+			}
+		}
+	}
+}
+
+func (r *L2NetworkReconciler) refreshIDSAlerts(ctx context.Context) error {
+	networks := &l2smv1.L2NetworkList{}
+	if err := r.List(ctx, networks); err != nil {
+		return fmt.Errorf("could not list l2networks: %w", err)
+	}
+	for i := range networks.Items {
+		network := &networks.Items[i]
+		if !network.DeletionTimestamp.IsZero() || network.Spec.Ids == nil || !network.Spec.Ids.Enabled || network.Status.IDSAttachment == nil {
+			continue
+		}
+		if err := r.readIDSAlerts(ctx, network); err != nil {
+			log.FromContext(ctx).Error(err, "could not read ids alerts", "network", network.Name)
+		}
+	}
+	return nil
+}
+
+// readIDSAlerts reads the alerts the IDS pods of the network raised since the last counted one.
+func (r *L2NetworkReconciler) readIDSAlerts(ctx context.Context, network *l2smv1.L2Network) error {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(ids.Namespace(network)), client.MatchingLabels{ids.NetworkLabel: network.Name}); err != nil {
+		return fmt.Errorf("could not list ids pods: %w", err)
+	}
+
+	var since time.Time
+	if network.Status.IDSAlerts != nil && network.Status.IDSAlerts.LastAlertTime != nil {
+		since = network.Status.IDSAlerts.LastAlertTime.Time
+	}
+	var alerts []ids.Alert
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		podAlerts, err := r.AlertReader.ReadAlerts(ctx, pod, since)
+		if err != nil {
+			log.FromContext(ctx).V(1).Info("Could not read ids alerts", "network", network.Name, "pod", pod.Name, "error", err.Error())
+			continue
+		}
+		alerts = append(alerts, podAlerts...)
+	}
+	if len(alerts) == 0 {
+		return nil
+	}
+	// while the IDS is rolled out both pods may raise alerts, which are counted in the order they were raised
+	sort.SliceStable(alerts, func(i, j int) bool { return alerts[i].Timestamp.Before(alerts[j].Timestamp) })
+	return r.recordIDSAlerts(ctx, network, alerts)
+}
+
+// recordIDSAlerts counts the alerts in the status of the network, records an event in the pods of the network that
+// raised them, and quarantines those pods when the alerts match the alert policy of the network.
+func (r *L2NetworkReconciler) recordIDSAlerts(ctx context.Context, network *l2smv1.L2Network, alerts []ids.Alert) error {
+	var counted []ids.Alert
+	var assignedIPs map[string]string
+	err := UpdateL2NetworkStatus(ctx, r.Client, client.ObjectKeyFromObject(network), func(status *l2smv1.L2NetworkStatus) {
+		counted = summarizeIDSAlerts(status, alerts)
+		assignedIPs = status.AssignedIPs
+	})
+	if err != nil {
+		return fmt.Errorf("could not update the ids alerts: %w", err)
+	}
+
+	// the alerts of a pod are recorded once per signature, with how many times it was raised
+	type podSignature struct {
+		pod         string
+		signatureID int64
+	}
+	counts := map[podSignature]int32{}
+	var firsts []ids.Alert
+	quarantined := map[string]bool{}
+	policy := network.Spec.Ids.AlertPolicy
+	for _, alert := range counted {
+		podName, found := assignedIPs[normalizeAddress(alert.SrcIP)]
+		if !found {
+			continue
+		}
+		key := podSignature{pod: podName, signatureID: alert.SignatureID}
+		if counts[key] == 0 {
+			firsts = append(firsts, alert)
+		}
+		counts[key]++
+		if policy != nil && idsAlertMatchesPolicy(policy, alert) {
+			quarantined[podName] = true
+		}
+	}
+
+	for _, alert := range firsts {
+		podName := assignedIPs[normalizeAddress(alert.SrcIP)]
+		pod := &corev1.Pod{}
+		if err := r.Get(ctx, client.ObjectKey{Name: podName, Namespace: network.Namespace}, pod); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		createIDSAlertEvent(ctx, r.Client, network, pod, alert, counts[podSignature{pod: podName, signatureID: alert.SignatureID}])
+		if quarantined[podName] {
+			if err := r.quarantineIDSPod(ctx, network, pod); err != nil {
+				return fmt.Errorf("could not quarantine pod %s: %w", pod.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// summarizeIDSAlerts counts the alerts raised after the last counted one in the alert summary of the network, and
+// returns them.
+func summarizeIDSAlerts(status *l2smv1.L2NetworkStatus, alerts []ids.Alert) []ids.Alert {
+	if status.IDSAlerts == nil {
+		status.IDSAlerts = &l2smv1.IDSAlertsStatus{}
+	}
+	summary := status.IDSAlerts
+
+	var counted []ids.Alert
+	for _, alert := range alerts {
+		if summary.LastAlertTime != nil && !alert.Timestamp.After(summary.LastAlertTime.Time) {
+			continue
+		}
+		counted = append(counted, alert)
+		seen := metav1.NewMicroTime(alert.Timestamp)
+		summary.Total++
+		summary.LastAlertTime = &seen
+
+		signature := l2smv1.IdsAlert{SignatureID: alert.SignatureID}
+		if i := slices.IndexFunc(summary.Signatures, func(s l2smv1.IdsAlert) bool { return s.SignatureID == alert.SignatureID }); i != -1 {
+			signature = summary.Signatures[i]
+			summary.Signatures = slices.Delete(summary.Signatures, i, i+1)
+		}
+		signature.Signature = alert.Signature
+		signature.Category = alert.Category
+		signature.Severity = alert.Severity
+		signature.Count++
+		signature.LastSeen = seen
+		signature.LastSource = alert.SrcIP
+		if podName, found := status.AssignedIPs[normalizeAddress(alert.SrcIP)]; found {
+			signature.LastSource = podName
+		}
+		summary.Signatures = append([]l2smv1.IdsAlert{signature}, summary.Signatures...)
+	}
+	if len(summary.Signatures) > maxIDSAlertSignatures {
+		summary.Signatures = summary.Signatures[:maxIDSAlertSignatures]
+	}
+	return counted
+}
+
+// normalizeAddress writes the address the way the assigned addresses of the networks are written. Suricata may write
+// IPv6 addresses in full.
+func normalizeAddress(address string) string {
+	if addr, err := netip.ParseAddr(address); err == nil {
+		return addr.String()
+	}
+	return address
+}
+
+func idsAlertMatchesPolicy(policy *l2smv1.IDSAlertPolicy, alert ids.Alert) bool {
+	return slices.Contains(policy.SignatureIDs, alert.SignatureID) || slices.Contains(policy.Severities, alert.Severity)
+}
+
+// createIDSAlertEvent records in the pod the alerts of a signature its traffic raised, so that `kubectl describe pod`
+// shows them.
+func createIDSAlertEvent(ctx context.Context, c client.Client, network *l2smv1.L2Network, pod *corev1.Pod, alert ids.Alert, count int32) {
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "l2sm-",
+			Namespace:    pod.Namespace,
+			Labels: map[string]string{
+				ids.NetworkLabel:  network.Name,
+				idsSignatureLabel: strconv.FormatInt(alert.SignatureID, 10),
+				idsSeverityLabel:  strconv.FormatInt(int64(alert.Severity), 10),
+			},
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:            "Pod",
+			Name:            pod.Name,
+			Namespace:       pod.Namespace,
+			UID:             pod.UID,
+			APIVersion:      "v1",
+			ResourceVersion: pod.ResourceVersion,
+		},
+		Reason: ReasonIDSAlert,
+		Message: fmt.Sprintf("IDS of network %s: %s (sid %d, severity %d) from %s to %s, %d time(s)",
+			network.Name, alert.Signature, alert.SignatureID, alert.Severity, alert.SrcIP, alert.DestIP, count),
+		Type:           corev1.EventTypeWarning,
+		Source:         corev1.EventSource{Component: "l2sm"},
+		Count:          count,
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+	}
+
+	if err := c.Create(ctx, event); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to create ids alert event for Pod", "pod", pod.Name)
+	}
+}
+
+// quarantineIDSPod creates the QuarantinePodRequest that moves the pod to the quarantine network of the alert policy,
+// unless it was already created, or another request, e.g. of a QuarantinePolicy, already quarantines the pod.
+func (r *L2NetworkReconciler) quarantineIDSPod(ctx context.Context, network *l2smv1.L2Network, pod *corev1.Pod) error {
+	request := &l2smv1.QuarantinePodRequest{}
+	key := client.ObjectKey{Name: fmt.Sprintf("%s-ids-%s", network.Name, pod.Name), Namespace: network.Namespace}
+	if err := r.Get(ctx, key, request); !apierrors.IsNotFound(err) {
+		return err
+	}
+	name, err := requestPodQuarantine(ctx, r.Client, r.Scheme, network, key.Name, map[string]string{ids.NetworkLabel: network.Name},
+		network, pod, network.Spec.Ids.AlertPolicy.QuarantineNetwork)
+	if err != nil {
+		return err
+	}
+	if name != key.Name {
+		log.FromContext(ctx).Info("Pod already quarantined, skipping the alert policy", "network", network.Name, "pod", pod.Name, "request", name)
+		return nil
+	}
+	log.FromContext(ctx).Info("Quarantining pod after ids alerts", "network", network.Name, "pod", pod.Name, "request", key.Name)
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/ids"
+)
+
+// fakeAlertReader stands in for the logs of the IDS pods.
+type fakeAlertReader struct {
+	alerts map[string][]ids.Alert
+}
+
+func (f *fakeAlertReader) ReadAlerts(_ context.Context, pod *corev1.Pod, since time.Time) ([]ids.Alert, error) {
+	var alerts []ids.Alert
+	for _, alert := range f.alerts[pod.Name] {
+		if alert.Timestamp.After(since) {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts, nil
+}
+
+var _ = Describe("L2Network IDS alerts", func() {
+	ctx := context.Background()
+	raised := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+
+	var c client.Client
+	var reader *fakeAlertReader
+	var reconciler *L2NetworkReconciler
+	var network *l2smv1.L2Network
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(l2smv1.AddToScheme(scheme)).To(Succeed())
+
+		network = &l2smv1.L2Network{
+			ObjectMeta: metav1.ObjectMeta{Name: "monitored", Namespace: "default", UID: "monitored-uid"},
+			Spec: l2smv1.L2NetworkSpec{
+				Type: l2smv1.NetworkTypeVnet,
+				Ids:  &l2smv1.IdsRules{Enabled: true, Node: "node-a", Profile: "balanced"},
+			},
+			Status: l2smv1.L2NetworkStatus{
+				IDSAttachment: &l2smv1.IDSAttachment{Namespace: "default", Node: "node-a"},
+				AssignedIPs:   map[string]string{"10.0.0.4": "ping", "10.0.0.5": "pong"},
+			},
+		}
+		idsPod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "monitored-ids-7d9f", Namespace: "default", Labels: map[string]string{ids.NetworkLabel: "monitored"}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		ping := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "ping", Namespace: "default", UID: "ping-uid"}}
+		pong := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pong", Namespace: "default", UID: "pong-uid"}}
+
+		reader = &fakeAlertReader{alerts: map[string][]ids.Alert{"monitored-ids-7d9f": {
+			{Timestamp: raised, SrcIP: "10.0.0.4", DestIP: "10.0.0.5", SignatureID: 2100498, Signature: "id check returned root", Severity: 2},
+			{Timestamp: raised.Add(time.Second), SrcIP: "10.0.0.4", DestIP: "10.0.0.5", SignatureID: 2100498, Signature: "id check returned root", Severity: 2},
+			{Timestamp: raised.Add(2 * time.Second), SrcIP: "10.0.0.5", DestIP: "10.0.0.4", SignatureID: 5000001, Signature: "ping", Severity: 3},
+			{Timestamp: raised.Add(3 * time.Second), SrcIP: "10.0.0.9", DestIP: "10.0.0.4", SignatureID: 5000001, Signature: "ping", Severity: 3},
+		}}}
+		c = fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&l2smv1.L2Network{}).WithObjects(network, idsPod, ping, pong).Build()
+		reconciler = &L2NetworkReconciler{Client: c, Scheme: scheme, AlertReader: reader}
+	})
+
+	idsEvents := func() []corev1.Event {
+		events := &corev1.EventList{}
+		Expect(c.List(ctx, events, client.InNamespace("default"))).To(Succeed())
+		return events.Items
+	}
+
+	It("should summarize the alerts and record them in the pods that raised them", func() {
+		Expect(reconciler.refreshIDSAlerts(ctx)).To(Succeed())
+
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(network), network)).To(Succeed())
+		summary := network.Status.IDSAlerts
+		Expect(summary).NotTo(BeNil())
+		Expect(summary.Total).To(Equal(int64(4)))
+		Expect(summary.LastAlertTime.Time).To(BeTemporally("==", raised.Add(3*time.Second)))
+		Expect(summary.Signatures).To(HaveLen(2))
+		Expect(summary.Signatures[0].SignatureID).To(Equal(int64(5000001)))
+		Expect(summary.Signatures[0].Count).To(Equal(int64(2)))
+		Expect(summary.Signatures[0].LastSource).To(Equal("10.0.0.9"))
+		Expect(summary.Signatures[1].SignatureID).To(Equal(int64(2100498)))
+		Expect(summary.Signatures[1].Count).To(Equal(int64(2)))
+		Expect(summary.Signatures[1].LastSource).To(Equal("ping"))
+
+		events := idsEvents()
+		Expect(events).To(HaveLen(2))
+		counts := map[string]int32{}
+		for _, event := range events {
+			Expect(event.Reason).To(Equal(ReasonIDSAlert))
+			counts[event.InvolvedObject.Name] = event.Count
+		}
+		Expect(counts).To(Equal(map[string]int32{"ping": 2, "pong": 1}))
+
+		requests := &l2smv1.QuarantinePodRequestList{}
+		Expect(c.List(ctx, requests)).To(Succeed())
+		Expect(requests.Items).To(BeEmpty(), "no alert policy was set")
+	})
+
+	It("should count every alert only once", func() {
+		Expect(reconciler.refreshIDSAlerts(ctx)).To(Succeed())
+		Expect(reconciler.refreshIDSAlerts(ctx)).To(Succeed())
+
+		reader.alerts["monitored-ids-7d9f"] = append(reader.alerts["monitored-ids-7d9f"],
+			ids.Alert{Timestamp: raised.Add(time.Minute), SrcIP: "10.0.0.4", SignatureID: 2100498, Signature: "id check returned root", Severity: 2})
+		Expect(reconciler.refreshIDSAlerts(ctx)).To(Succeed())
+
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(network), network)).To(Succeed())
+		Expect(network.Status.IDSAlerts.Total).To(Equal(int64(5)))
+		Expect(network.Status.IDSAlerts.Signatures[0].SignatureID).To(Equal(int64(2100498)))
+		Expect(network.Status.IDSAlerts.Signatures[0].Count).To(Equal(int64(3)))
+		Expect(idsEvents()).To(HaveLen(3))
+	})
+
+	It("should quarantine the pods whose alerts match the alert policy", func() {
+		network.Spec.Ids.AlertPolicy = &l2smv1.IDSAlertPolicy{SignatureIDs: []int64{2100498}, QuarantineNetwork: "quarantine"}
+		Expect(c.Update(ctx, network)).To(Succeed())
+
+		Expect(reconciler.refreshIDSAlerts(ctx)).To(Succeed())
+
+		requests := &l2smv1.QuarantinePodRequestList{}
+		Expect(c.List(ctx, requests)).To(Succeed())
+		Expect(requests.Items).To(HaveLen(1))
+		request := requests.Items[0]
+		Expect(request.Name).To(Equal("monitored-ids-ping"))
+		Expect(request.Spec.TargetL2Network).To(Equal("quarantine"))
+		Expect(request.Spec.Selector.PodLabelSelector.MatchLabels).To(Equal(map[string]string{quarantineLabel: "ping-uid"}))
+		Expect(request.Spec.Selector.L2NetworkSelector.MatchLabels).To(Equal(map[string]string{quarantineLabel: "monitored-uid"}))
+		Expect(request.OwnerReferences).To(HaveLen(1))
+
+		ping := &corev1.Pod{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: "ping", Namespace: "default"}, ping)).To(Succeed())
+		Expect(ping.Labels[quarantineLabel]).To(Equal("ping-uid"))
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(network), network)).To(Succeed())
+		Expect(network.Labels[quarantineLabel]).To(Equal("monitored-uid"))
+	})
+
+	It("should not quarantine again the pods quarantined by a QuarantinePolicy", func() {
+		network.Spec.Ids.AlertPolicy = &l2smv1.IDSAlertPolicy{SignatureIDs: []int64{2100498}, QuarantineNetwork: "quarantine"}
+		Expect(c.Update(ctx, network)).To(Succeed())
+		ping := &corev1.Pod{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: "ping", Namespace: "default"}, ping)).To(Succeed())
+		ping.Labels = map[string]string{quarantineLabel: "ping-uid"}
+		Expect(c.Update(ctx, ping)).To(Succeed())
+		Expect(c.Create(ctx, &l2smv1.QuarantinePodRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: "isolate-ping", Namespace: "default"},
+			Spec: l2smv1.QuarantinePodRequestSpec{
+				Selector: l2smv1.QuarantinePodSelector{
+					PodLabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{quarantineLabel: "ping-uid"}},
+				},
+				TargetL2Network: "quarantine",
+			},
+		})).To(Succeed())
+
+		Expect(reconciler.refreshIDSAlerts(ctx)).To(Succeed())
+
+		requests := &l2smv1.QuarantinePodRequestList{}
+		Expect(c.List(ctx, requests)).To(Succeed())
+		Expect(requests.Items).To(HaveLen(1))
+		Expect(requests.Items[0].Name).To(Equal("isolate-ping"))
+	})
+
+	It("should skip the networks without a deployed IDS", func() {
+		network.Status.IDSAttachment = nil
+		Expect(c.Status().Update(ctx, network)).To(Succeed())
+
+		Expect(reconciler.refreshIDSAlerts(ctx)).To(Succeed())
+
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(network), network)).To(Succeed())
+		Expect(network.Status.IDSAlerts).To(BeNil())
+		Expect(idsEvents()).To(BeEmpty())
+	})
+})
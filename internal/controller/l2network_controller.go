@@ -22,11 +22,13 @@ import (
 
 	dp "github.com/Networks-it-uc3m/l2sm-switch/pkg/datapath"
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
 	"github.com/Networks-it-uc3m/L2S-M/internal/dnsinterface"
@@ -48,11 +50,19 @@ type L2NetworkReconciler struct {
 	// Manages interactions with the onos SDN Controller.
 	InternalClient    sdnclient.Client
 	SwitchesNamespace string
+
+	// RuleFetcher downloads the remote rule sources of the intrusion detection systems.
+	RuleFetcher *ids.RuleFetcher
+
+	// AlertReader reads the alerts raised by the intrusion detection systems.
+	AlertReader ids.AlertReader
 }
 
 //+kubebuilder:rbac:groups=l2sm.l2sm.k8s.local,resources=l2networks,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=l2sm.l2sm.k8s.local,resources=l2networks/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=l2sm.l2sm.k8s.local,resources=l2networks/finalizers,verbs=update
+// Secrets are read in the namespace of the L2Networks, but only the ones labeled l2sm/sdn-credentials=true are used.
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -80,11 +90,11 @@ func (r *L2NetworkReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	// Check if the object is being deleted
 	if network.GetDeletionTimestamp() != nil {
 		if slices.Contains(network.GetFinalizers(), l2smFinalizer) {
-			// The object is being deleted
-			if err := r.InternalClient.DeleteNetwork(network.Spec.Type, network.Name); err != nil {
+			// The object is being deleted, so everything it created outside of kubernetes is released
+			if err := r.teardownNetwork(ctx, network); err != nil {
 				// If fail to delete the external dependency here, return with error
 				// so that it can be retried
-				logger.Error(err, "couldn't delete network in sdn controller")
+				logger.Error(err, "couldn't release the resources of the network")
 				return ctrl.Result{}, err
 			}
 
@@ -102,7 +112,22 @@ func (r *L2NetworkReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 
 	// Add finalizer for this CR
 	if !slices.Contains(network.GetFinalizers(), l2smFinalizer) {
-		err := r.InternalClient.CreateNetwork(network.Spec.Type, sdnclient.VnetPayload{NetworkId: network.Name})
+		var payload any = sdnclient.VnetPayload{NetworkId: network.Name}
+
+		// vlinks are pinned to the switch path the user asked for, so we compute it before creating the network
+		if network.Spec.Type == l2smv1.NetworkTypeVlink {
+			hops, err := r.vlinkHops(ctx, network)
+			setVlinkPathCondition(network, err)
+			if err != nil {
+				logger.Error(err, "invalid vlink path")
+				r.updateControllerStatus(ctx, network, l2smv1.OfflineStatus)
+				return ctrl.Result{}, err
+			}
+			network.Status.Hops = hops
+			payload = sdnclient.VlinkPayload{NetworkId: network.Name, Path: hops}
+		}
+
+		err := r.InternalClient.CreateNetwork(ctx, network.Spec.Type, payload)
 		if err != nil {
 			logger.Error(err, "failed to create network")
 			r.updateControllerStatus(ctx, network, l2smv1.OfflineStatus)
@@ -118,7 +143,7 @@ func (r *L2NetworkReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		}
 		// If network is inter domain
 		if network.Spec.Provider != nil {
-			provStatus, err := interDomainReconcile(network, logger)
+			provStatus, err := r.interDomainReconcile(ctx, network, logger)
 			if err != nil {
 				logger.Error(err, "failed to connect to provider")
 			}
@@ -148,6 +173,11 @@ func (r *L2NetworkReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 				logger.Error(err, "error connecting NED")
 				return ctrl.Result{}, nil
 			}
+			// The interface is recorded as soon as it is taken, so that it is given back when the network is deleted
+			network.Status.NEDAttachment = &l2smv1.NEDAttachment{Name: ned.Name, Node: ned.Spec.NodeConfig.NodeName, NetworkAttachmentDefinition: nedNetworkAttachDef.Name}
+			if err := r.Status().Update(ctx, network); err != nil {
+				return ctrl.Result{}, err
+			}
 			// We attach the ned to this new network, connecting with the IDCO SDN Controller. We need
 			// The Network name so we can know which network to attach the port to.
 			// The multus network attachment definition that will be used as a bridge between the internal switch and the NED.
@@ -157,126 +187,100 @@ func (r *L2NetworkReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 				// port we are trying to attach.
 				return ctrl.Result{}, fmt.Errorf("could not get port number from the multus network annotation: %v. Can't attach pod to network", err)
 			}
-			err = r.CreateNewNEDConnection(network, fmt.Sprintf("br%s", bridgeName), ned)
+			nedPort, err := r.CreateNewNEDConnection(ctx, network, fmt.Sprintf("br%s", bridgeName), ned)
 			if err != nil {
 				logger.Error(err, "error attaching NED to the l2network")
 
 				return ctrl.Result{}, nil
 			}
+			network.Status.NEDAttachment.Port = nedPort
+			if err := r.Status().Update(ctx, network); err != nil {
+				return ctrl.Result{}, err
+			}
 			logger.Info("Connected overlay to inter-domain network")
 
 			dnsinterface.AddServerToLocalCoreDNS(r.Client, network.Name, network.Spec.Provider.Domain[0], network.Spec.Provider.DNSPort)
 
 		}
 
-		// we check if intrusion detection system is set and true. if not, we skip this part
-		if network.Spec.Ids != nil && network.Spec.Ids.Enabled {
-
-			// first lets use a network attachment definition for our new ids pod. IDS in l2sm relies on traffic duplicated through the ovs switches
-			// so we need a multus interface where all network traffic will be sent to.
-			// note: the node is important as it will specify which node to get a free net attach def from
-			netAttachDefLabel := networkannotation.NET_ATTACH_LABEL_PREFIX + network.Spec.Ids.Node
-			netAttachDefs := GetFreeNetAttachDefs(ctx, r.Client, r.SwitchesNamespace, netAttachDefLabel)
-
-			// no interfaces means no monitoring for us
-			if len(netAttachDefs.Items) == 0 {
-				err = errors.New("no interfaces available in control plane node")
-				//logger.Error(err, fmt.Sprintf("No interfaces available for node %s", gatewayNodeName))
-
-				//todo: check if we should handle this differently. pending to test this case... should we continue but just with no monitoring and give back an error? by this
-				// point there is already some stuff we have created, and we would need to delete it...
-				return ctrl.Result{}, err
-			}
-
-			// we get a free attach definition from the node we chose. the first one, we just need one
-			netAttachDef := &netAttachDefs.Items[0]
-
-			// we specify that we are keeping this network attachment definition in the spec node by configuring to true
-			if netAttachDef.Labels == nil {
-				netAttachDef.Labels = map[string]string{}
-			}
-			netAttachDef.Labels[netAttachDefLabel] = "true"
-
-			// we generate the openflow id from this networkattach def + switch in the node. this way we can tell the network that we want to mirror to this new port
-			portNumber, err := utils.GetPortNumberFromNetAttachDef(netAttachDef.Name)
-			if err != nil {
-				// If there is an error, it must be that the name is not compliant, so we can't be certain of which
-				// port we are trying to attach.
-				return ctrl.Result{}, fmt.Errorf("could not get port number from the multus network annotation: %v. Can't attach pod to network", err)
-			}
-
-			mirrorPortOFID := fmt.Sprintf("of:%s/%s", dp.GenerateID(dp.GetSwitchName(dp.DatapathParams{NodeName: network.Spec.Ids.Node, ProviderName: l2smv1.OVERLAY_PROVIDER})), portNumber)
-			if err := r.InternalClient.SetUpMirrorPort(network.Spec.Type, sdnclient.VnetPayload{NetworkId: network.Name, MirrorPort: mirrorPortOFID}); err != nil {
-				return ctrl.Result{}, fmt.Errorf("could not set up mirror port for IDS on network %q: %w", network.Name, err)
-			}
+		// the intrusion detection system, if any, is deployed alongside the network
+		if err := r.reconcileIDS(ctx, network); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: env.GetSDNResyncInterval()}, nil
+	}
 
-			// we generate the network attachment definition annotation, so that multus appends this interface to the ids pod (not yet specified, that will come later)
-			netAnnot := networkannotation.NetworkAnnotation{Name: netAttachDef.Name, Namespace: netAttachDef.Namespace}
-			netAnnot.GenerateIPv6Address()
-			netAnnotString := networkannotation.MultusAnnotationToString([]networkannotation.NetworkAnnotation{netAnnot})
+	// The network already exists, so we make sure the sdn controller didn't lose it (or any of its ports) since then
+	if err := r.resyncNetwork(ctx, network); err != nil {
+		logger.Error(err, "could not resynchronize network with the sdn controller")
+	}
+	if err := r.reconcileIDS(ctx, network); err != nil {
+		return ctrl.Result{}, err
+	}
 
-			// generate resources. in total we get a configmap with the rules and a deployment
-			resArray, err := ids.GenerateExternalResources(network, netAnnotString)
+	return ctrl.Result{RequeueAfter: env.GetSDNResyncInterval()}, nil
+}
 
-			if err != nil {
-				logger.Error(err, "error creating intrusion detecion system resources")
-				return ctrl.Result{}, nil
-			}
-			for _, res := range resArray {
+// SetupWithManager sets up the controller with the Manager.
+func (r *L2NetworkReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Initialize the InternalClient with the base URL of the SDN controller, unless one was given
+	if r.InternalClient == nil {
+		ctx := context.Background()
+		clientConfig, err := sdnclient.InternalConfig(ctx, mgr.GetAPIReader())
+		if err != nil {
+			return err
+		}
 
-				// we set ownership to our new resources so that when deleting the network, these resources will go with it
-				if err := controllerutil.SetControllerReference(network, res, r.Scheme); err != nil {
-					return ctrl.Result{}, nil
-				}
+		r.InternalClient, err = sdnclient.NewClient(ctx, sdnclient.InternalType, clientConfig)
+		if err != nil {
+			r.Log.Error(err, "failed to initiate session with sdn controller")
+			return err
+		}
+	}
 
-				// create ids deploy + configmap in the k8s cluster
-				r.Client.Create(ctx, res)
-			}
-			// if everything went as expected, we update the network attachment definition with the used label, so that we dontr try using it again
-			err = r.Client.Update(ctx, netAttachDef)
-			if err != nil {
-				return ctrl.Result{}, fmt.Errorf("could not update network attachment definition: %s", err)
+	if r.RuleFetcher == nil {
+		r.RuleFetcher = ids.NewRuleFetcher(nil, env.GetETOpenURL())
+	}
 
-			}
+	if r.AlertReader == nil {
+		clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			return err
 		}
+		r.AlertReader = &ids.LogAlertReader{Clientset: clientset}
 	}
 
-	return ctrl.Result{}, nil
-}
-
-// SetupWithManager sets up the controller with the Manager.
-func (r *L2NetworkReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	var err error
+	// The alerts of the intrusion detection systems are read periodically from their logs
+	if err := mgr.Add(manager.RunnableFunc(r.collectIDSAlerts)); err != nil {
+		return err
+	}
 
-	// Initialize the InternalClient with the base URL of the SDN controller
-	clientConfig := sdnclient.ClientConfig{BaseURL: fmt.Sprintf("http://%s:%s/onos", env.GetControllerIP(), env.GetControllerPort()), Username: "karaf", Password: "karaf"}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Pod{}, podNetworksKey, podNetworksIndexer(r.SwitchesNamespace)); err != nil {
+		return err
+	}
 
-	r.InternalClient, err = sdnclient.NewClient(sdnclient.InternalType, clientConfig)
-	if err != nil {
-		r.Log.Error(err, "failed to initiate session with sdn controller")
+	// Networks left behind in the sdn controller by l2networks that no longer exist are removed periodically
+	if err := mgr.Add(manager.RunnableFunc(r.sweepOrphanNetworks)); err != nil {
 		return err
 	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&l2smv1.L2Network{}). // Watch for changes to primary resource L2Network
 		Complete(r)
 }
 
-func interDomainReconcile(network *l2smv1.L2Network, log logr.Logger) (l2smv1.ConnectivityStatus, error) {
+func (r *L2NetworkReconciler) interDomainReconcile(ctx context.Context, network *l2smv1.L2Network, log logr.Logger) (l2smv1.ConnectivityStatus, error) {
 
 	if network.Spec.Provider == nil {
 		return l2smv1.UnknownStatus, errors.New("ext-vnet doesn't have a provider specified")
 	}
 
-	providerAddress := fmt.Sprintf("%s:%s", network.Spec.Provider.Domain, utils.DefaultIfEmpty(network.Spec.Provider.SDNPort, "30808"))
-	clientConfig := sdnclient.ClientConfig{BaseURL: fmt.Sprintf("http://%s/onos", providerAddress), Username: "karaf", Password: "karaf"}
-
-	externalClient, err := sdnclient.NewClient(sdnclient.InternalType, clientConfig)
-
+	externalClient, err := r.providerClient(ctx, network)
 	if err != nil {
 		return l2smv1.OfflineStatus, fmt.Errorf("could not initialize session with external provider: %v", err)
 	}
 
-	exists, err := externalClient.CheckNetworkExists(network.Spec.Type, network.Name)
+	exists, err := externalClient.CheckNetworkExists(ctx, network.Spec.Type, network.Name)
 	if err != nil {
 		log.Error(err, "failed to check network existence")
 
@@ -284,7 +288,7 @@ func interDomainReconcile(network *l2smv1.L2Network, log logr.Logger) (l2smv1.Co
 	}
 
 	if !exists {
-		err := externalClient.CreateNetwork(network.Spec.Type, sdnclient.VnetPayload{NetworkId: network.Name})
+		err := externalClient.CreateNetwork(ctx, network.Spec.Type, sdnclient.MscsPayload{NetworkId: network.Name})
 		if err != nil {
 			log.Error(err, "failed to create network")
 			return l2smv1.OfflineStatus, err
@@ -326,7 +330,7 @@ func (r *L2NetworkReconciler) ConnectInternalSwitchToNED(ctx context.Context, ne
 
 	internalSwitchOFPort := fmt.Sprintf("%s/%s", internalSwitchOFID, portNumber)
 
-	err = r.InternalClient.AttachPodToNetwork("vnets", sdnclient.VnetPayload{NetworkId: networkName, Port: []string{internalSwitchOFPort}})
+	err = r.InternalClient.AttachPodToNetwork(ctx, l2smv1.NetworkTypeVnet, sdnclient.VnetPayload{NetworkId: networkName, Port: []string{internalSwitchOFPort}})
 
 	if err != nil {
 		return nettypes.NetworkAttachmentDefinition{}, fmt.Errorf("could not make a connection between the internal switch and the NED. Internal SDN controller error: %s", err)
@@ -343,16 +347,13 @@ func (r *L2NetworkReconciler) ConnectInternalSwitchToNED(ctx context.Context, ne
 	return *netAttachDef, nil
 }
 
-// CreateNEDConnection is a method that given the name of the network and the
-func (r *L2NetworkReconciler) CreateNewNEDConnection(network *l2smv1.L2Network, nedNetworkAttachDef string, ned l2smv1.NetworkEdgeDevice) error {
-
-	providerAddress := fmt.Sprintf("%s:%s", network.Spec.Provider.Domain, utils.DefaultIfEmpty(network.Spec.Provider.SDNPort, "30808"))
-	clientConfig := sdnclient.ClientConfig{BaseURL: fmt.Sprintf("http://%s/onos", providerAddress), Username: "karaf", Password: "karaf"}
-
-	externalClient, err := sdnclient.NewClient(sdnclient.InternalType, clientConfig)
+// CreateNEDConnection is a method that given the name of the network and the interface of the NED that is bridged to
+// the l2sm switch, attaches the interface to the network in the provider. It returns the port of the NED attached.
+func (r *L2NetworkReconciler) CreateNewNEDConnection(ctx context.Context, network *l2smv1.L2Network, nedNetworkAttachDef string, ned l2smv1.NetworkEdgeDevice) (string, error) {
 
+	externalClient, err := r.providerClient(ctx, network)
 	if err != nil {
-		return fmt.Errorf("no connection could be made with external sdn controller: %s", err)
+		return "", fmt.Errorf("no connection could be made with external sdn controller: %s", err)
 
 	}
 	// AddPort returns the port number to attach so we can talk directly with the IDCO
@@ -360,16 +361,25 @@ func (r *L2NetworkReconciler) CreateNewNEDConnection(network *l2smv1.L2Network,
 	nedPortNumber, err := talpainterface.AttachInterface(fmt.Sprintf("%s:50051", ned.Spec.NodeConfig.IPAddress), nedNetworkAttachDef)
 
 	if err != nil {
-		return fmt.Errorf("no connection could be made with ned: %v", err)
+		return "", fmt.Errorf("no connection could be made with ned: %v", err)
 	}
 
 	nedOFID := fmt.Sprintf("of:%s", dp.GenerateID(dp.GetSwitchName(dp.DatapathParams{NodeName: ned.Spec.NodeConfig.NodeName, ProviderName: network.Spec.Provider.Name})))
 	nedOFPort := fmt.Sprintf("%s/%s", nedOFID, nedPortNumber)
 
-	err = externalClient.AttachPodToNetwork(network.Spec.Type, sdnclient.VnetPayload{NetworkId: network.Name, Port: []string{nedOFPort}})
+	err = externalClient.AttachPodToNetwork(ctx, network.Spec.Type, sdnclient.MscsPayload{NetworkId: network.Name, Endpoints: []string{nedOFPort}})
 	if err != nil {
-		return errors.Join(err, errors.New("could not update network attachment definition"))
+		return "", errors.Join(err, errors.New("could not update network attachment definition"))
 
 	}
-	return nil
+	return nedOFPort, nil
+}
+
+// providerClient opens a session with the SDN controller of the provider of the network.
+func (r *L2NetworkReconciler) providerClient(ctx context.Context, network *l2smv1.L2Network) (sdnclient.Client, error) {
+	clientConfig, err := sdnclient.ProviderConfig(ctx, r.Client, network.Spec.Provider, network.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return sdnclient.NewClient(ctx, sdnclient.ExternalType, clientConfig)
 }
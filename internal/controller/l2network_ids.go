@@ -0,0 +1,284 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	dp "github.com/Networks-it-uc3m/l2sm-switch/pkg/datapath"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/env"
+	"github.com/Networks-it-uc3m/L2S-M/internal/ids"
+	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
+	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient"
+	"github.com/Networks-it-uc3m/L2S-M/internal/utils"
+)
+
+const (
+	// IDSRulesSyncedCondition reports whether the remote rule sources of the IDS could be fetched.
+	IDSRulesSyncedCondition = "IDSRulesSynced"
+
+	ReasonRulesFetched  = "RulesFetched"
+	ReasonFetchFailed   = "FetchFailed"
+	ReasonRulesTooLarge = "RulesTooLarge"
+
+	idsFieldOwner = "l2sm-ids-controller"
+
+	// idsRulesRetryInterval is how long a failed sync waits before fetching the sources again.
+	idsRulesRetryInterval = 5 * time.Minute
+)
+
+// reconcileIDS makes the intrusion detection system of the network match its spec on every pass. The IDS takes a free
+// interface of its node, where the SDN controller mirrors the traffic of the network, and its ConfigMap and Deployment
+// are applied with server-side apply, so that later changes to the spec reach them. Moving the IDS to another node or
+// namespace deploys it again there, and disabling it tears everything down.
+func (r *L2NetworkReconciler) reconcileIDS(ctx context.Context, network *l2smv1.L2Network) error {
+	idsRules := network.Spec.Ids
+	if idsRules == nil || !idsRules.Enabled {
+		return r.disableIDS(ctx, network)
+	}
+
+	if attachment := network.Status.IDSAttachment; attachment != nil && (attachment.Node != idsRules.Node || attachment.Namespace != ids.Namespace(network)) {
+		log.FromContext(ctx).Info("Moving intrusion detection system", "network", network.Name, "node", idsRules.Node, "namespace", ids.Namespace(network))
+		if err := r.disableIDS(ctx, network); err != nil {
+			return err
+		}
+	}
+	if network.Status.IDSAttachment == nil {
+		if err := r.attachIDS(ctx, network); err != nil {
+			return err
+		}
+	}
+	if err := r.applyIDS(ctx, network); err != nil {
+		return err
+	}
+	return r.syncIDSRules(ctx, network)
+}
+
+// attachIDS takes a free interface in the node of the IDS and mirrors the traffic of the network to it. The interface
+// is recorded in the status as soon as it is taken, so that it is given back when the IDS is removed.
+func (r *L2NetworkReconciler) attachIDS(ctx context.Context, network *l2smv1.L2Network) error {
+	node := network.Spec.Ids.Node
+	netAttachDefLabel := networkannotation.NET_ATTACH_LABEL_PREFIX + node
+	netAttachDefs := GetFreeNetAttachDefs(ctx, r.Client, r.SwitchesNamespace, netAttachDefLabel)
+	// no interfaces means no monitoring for us
+	if len(netAttachDefs.Items) == 0 {
+		return fmt.Errorf("no interfaces available for the ids in node %s", node)
+	}
+	netAttachDef := &netAttachDefs.Items[0]
+
+	// the openflow id of the port of this interface in the switch of the node is where the traffic is mirrored to
+	portNumber, err := utils.GetPortNumberFromNetAttachDef(netAttachDef.Name)
+	if err != nil {
+		return fmt.Errorf("could not get port number from the multus network annotation: %v. Can't attach ids to network", err)
+	}
+	mirrorPortOFID := fmt.Sprintf("of:%s/%s", dp.GenerateID(dp.GetSwitchName(dp.DatapathParams{NodeName: node, ProviderName: l2smv1.OVERLAY_PROVIDER})), portNumber)
+	if err := r.InternalClient.SetUpMirrorPort(ctx, network.Spec.Type, sdnclient.VnetPayload{NetworkId: network.Name, MirrorPort: mirrorPortOFID}); err != nil {
+		return fmt.Errorf("could not set up mirror port for IDS on network %q: %w", network.Name, err)
+	}
+
+	if netAttachDef.Labels == nil {
+		netAttachDef.Labels = map[string]string{}
+	}
+	netAttachDef.Labels[netAttachDefLabel] = "true"
+	if err := r.Update(ctx, netAttachDef); err != nil {
+		return fmt.Errorf("could not update network attachment definition: %w", err)
+	}
+	network.Status.IDSAttachment = &l2smv1.IDSAttachment{
+		Namespace:                   ids.Namespace(network),
+		Node:                        node,
+		NetworkAttachmentDefinition: netAttachDef.Name,
+		MirrorPort:                  mirrorPortOFID,
+	}
+	return r.Status().Update(ctx, network)
+}
+
+// applyIDS applies the rules ConfigMap and the Suricata Deployment of the IDS.
+func (r *L2NetworkReconciler) applyIDS(ctx context.Context, network *l2smv1.L2Network) error {
+	attachment := network.Status.IDSAttachment
+	netAnnot := networkannotation.NetworkAnnotation{
+		Name:        attachment.NetworkAttachmentDefinition,
+		Namespace:   r.SwitchesNamespace,
+		IPAddresses: []string{idsInterfaceAddress(network)},
+	}
+	customConfig, err := r.customIDSProfile(ctx, network)
+	if err != nil {
+		return err
+	}
+	resources, err := ids.GenerateExternalResources(network, networkannotation.MultusAnnotationToString([]networkannotation.NetworkAnnotation{netAnnot}), customConfig)
+	if err != nil {
+		return fmt.Errorf("could not generate intrusion detection system resources: %w", err)
+	}
+	for _, res := range resources {
+		// owner references can't cross namespaces, resources in another namespace are deleted with the IDS instead
+		if res.GetNamespace() == network.Namespace {
+			if err := controllerutil.SetControllerReference(network, res, r.Scheme); err != nil {
+				return err
+			}
+		}
+		if err := r.Patch(ctx, res, client.Apply, client.FieldOwner(idsFieldOwner), client.ForceOwnership); err != nil {
+			return fmt.Errorf("could not apply ids %s %s: %w", res.GetObjectKind().GroupVersionKind().Kind, res.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// customIDSProfile returns the suricata.yaml of the custom profile of the IDS, read from its ConfigMap in the
+// namespace of the IDS. Other profiles have none.
+func (r *L2NetworkReconciler) customIDSProfile(ctx context.Context, network *l2smv1.L2Network) (string, error) {
+	idsRules := network.Spec.Ids
+	if idsRules.Profile != ids.ProfileCustom {
+		return "", nil
+	}
+	if idsRules.CustomProfile == nil {
+		return "", fmt.Errorf("the custom ids profile needs a customProfile")
+	}
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(ctx, types.NamespacedName{Name: idsRules.CustomProfile.Name, Namespace: ids.Namespace(network)}, configMap); err != nil {
+		return "", fmt.Errorf("could not get the custom ids profile: %w", err)
+	}
+	config, found := configMap.Data[ids.SuricataConfigKey]
+	if !found {
+		return "", fmt.Errorf("custom ids profile %s has no %s", configMap.Name, ids.SuricataConfigKey)
+	}
+	return config, nil
+}
+
+// disableIDS stops mirroring the traffic of the network, deletes the resources of the IDS and gives its interface
+// back to its node.
+func (r *L2NetworkReconciler) disableIDS(ctx context.Context, network *l2smv1.L2Network) error {
+	attachment := network.Status.IDSAttachment
+	if attachment == nil {
+		return nil
+	}
+	err := r.InternalClient.RemoveMirrorPort(ctx, network.Spec.Type, sdnclient.VnetPayload{NetworkId: network.Name, MirrorPort: attachment.MirrorPort})
+	if err != nil && !sdnclient.IsNotFound(err) {
+		return fmt.Errorf("could not remove the mirror port of the ids: %w", err)
+	}
+	if err := r.deleteIDS(ctx, network); err != nil {
+		return fmt.Errorf("could not delete the ids: %w", err)
+	}
+	if err := r.releaseIDSInterface(ctx, network); err != nil {
+		return err
+	}
+	network.Status.IDSRules = nil
+	meta.RemoveStatusCondition(&network.Status.Conditions, IDSRulesSyncedCondition)
+	return r.Status().Update(ctx, network)
+}
+
+// idsInterfaceAddress returns the link-local address of the interface of the IDS. It is derived from the network, so
+// that the Deployment of the IDS doesn't change between passes.
+func idsInterfaceAddress(network *l2smv1.L2Network) string {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s/%s", network.Namespace, network.Name)
+	id := fmt.Sprintf("%016x", hash.Sum64())
+	return fmt.Sprintf("fe80::%s:%s:%s:%s/64", id[:4], id[4:8], id[8:12], id[12:])
+}
+
+// syncIDSRules fetches the remote rule sources of the deployed IDS of the network and renders them into its rules ConfigMaps,
+// once every IDS_RULES_REFRESH_INTERVAL or as soon as the spec changes. Sources that can't be fetched keep their last
+// good copy, and the failure is surfaced in the IDSRulesSynced condition.
+func (r *L2NetworkReconciler) syncIDSRules(ctx context.Context, network *l2smv1.L2Network) error {
+	now := time.Now()
+	if !idsRulesDue(network, now) {
+		return nil
+	}
+	logger := log.FromContext(ctx)
+	namespace := network.Status.IDSAttachment.Namespace
+
+	sources, fetchErr := r.RuleFetcher.Fetch(ctx, network.Spec.Ids)
+	configMaps, err := ids.GenerateRuleConfigMaps(network.Name, namespace, sources)
+	if errors.Is(err, ids.ErrRulesTooLarge) {
+		// the rules rendered last time are left in place, and the sync is retried later
+		if network.Status.IDSRules == nil {
+			network.Status.IDSRules = &l2smv1.IDSRulesStatus{}
+		}
+		network.Status.IDSRules.LastSyncTime = &metav1.Time{Time: now}
+		r.setIDSRulesCondition(network, metav1.ConditionFalse, ReasonRulesTooLarge, err.Error())
+		return r.Status().Update(ctx, network)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, cm := range configMaps {
+		// owner references can't cross namespaces, those configmaps are deleted with the IDS instead
+		if cm.Namespace == network.Namespace {
+			if err := controllerutil.SetControllerReference(network, cm, r.Scheme); err != nil {
+				return err
+			}
+		}
+		if err := r.Patch(ctx, cm, client.Apply, client.FieldOwner(idsFieldOwner), client.ForceOwnership); err != nil {
+			return fmt.Errorf("could not apply ids rules configmap %s: %w", cm.Name, err)
+		}
+	}
+	// chunks left over from larger rulesets would corrupt the rules once put back together
+	for i := len(configMaps); i < ids.MaxRuleChunks; i++ {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: utils.GenerateIdsRulesCMName(network.Name, i), Namespace: namespace}}
+		if err := r.Delete(ctx, cm); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("could not delete ids rules configmap %s: %w", cm.Name, err)
+		}
+	}
+
+	count := ids.InlineRuleCount(network.Spec.Ids)
+	for _, source := range sources {
+		count += source.Count
+	}
+	network.Status.IDSRules = &l2smv1.IDSRulesStatus{RuleCount: count, LastSyncTime: &metav1.Time{Time: now}}
+	if fetchErr != nil {
+		logger.Error(fetchErr, "could not fetch ids rule sources", "network", network.Name)
+		r.setIDSRulesCondition(network, metav1.ConditionFalse, ReasonFetchFailed, fetchErr.Error())
+	} else {
+		r.setIDSRulesCondition(network, metav1.ConditionTrue, ReasonRulesFetched, fmt.Sprintf("%d rules from %d remote sources", count, len(sources)))
+	}
+	return r.Status().Update(ctx, network)
+}
+
+// idsRulesDue reports whether the rules of the IDS have to be synced: they never were, the spec changed since, or the
+// last sync is older than the refresh interval, or the retry interval if it failed.
+func idsRulesDue(network *l2smv1.L2Network, now time.Time) bool {
+	condition := meta.FindStatusCondition(network.Status.Conditions, IDSRulesSyncedCondition)
+	rules := network.Status.IDSRules
+	if condition == nil || condition.ObservedGeneration != network.Generation || rules == nil || rules.LastSyncTime == nil {
+		return true
+	}
+	interval := env.GetIDSRulesRefreshInterval()
+	if condition.Status != metav1.ConditionTrue {
+		interval = min(interval, idsRulesRetryInterval)
+	}
+	return now.Sub(rules.LastSyncTime.Time) >= interval
+}
+
+func (r *L2NetworkReconciler) setIDSRulesCondition(network *l2smv1.L2Network, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&network.Status.Conditions, metav1.Condition{
+		Type:               IDSRulesSyncedCondition,
+		Status:             status,
+		ObservedGeneration: network.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
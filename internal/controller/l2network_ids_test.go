@@ -0,0 +1,269 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/ids"
+	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient"
+	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient/fakecontroller"
+)
+
+// applyCreates makes the fake client create the objects that are applied and don't exist yet, as the API server does.
+var applyCreates = interceptor.Funcs{
+	Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+		err := c.Patch(ctx, obj, patch, opts...)
+		if apierrors.IsNotFound(err) && patch.Type() == types.ApplyPatchType {
+			return c.Create(ctx, obj)
+		}
+		return err
+	},
+}
+
+var _ = Describe("L2Network IDS rules", func() {
+	ctx := context.Background()
+
+	var server *httptest.Server
+	var serverDown bool
+	var c client.Client
+	var reconciler *L2NetworkReconciler
+	var network *l2smv1.L2Network
+
+	BeforeEach(func() {
+		serverDown = false
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if serverDown {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintln(w, `alert tcp any any -> any 80 (msg:"remote"; sid:2000001; rev:1;)`)
+			fmt.Fprintln(w, `alert udp any any -> any 53 (msg:"remote"; sid:2000002; rev:1;)`)
+		}))
+
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(l2smv1.AddToScheme(scheme)).To(Succeed())
+
+		network = &l2smv1.L2Network{
+			ObjectMeta: metav1.ObjectMeta{Name: "monitored", Namespace: "default", Generation: 1},
+			Spec: l2smv1.L2NetworkSpec{
+				Type: l2smv1.NetworkTypeVnet,
+				Ids: &l2smv1.IdsRules{Enabled: true, Node: "node-a", Profile: "suricata", CustomRuleSources: []l2smv1.IDSRuleSource{
+					{Name: "remote", URL: server.URL + "/custom.rules"},
+					{Name: "inline", Inline: `alert icmp any any -> any any (msg:"inline"; sid:5000001; rev:1;)`},
+				}},
+			},
+			Status: l2smv1.L2NetworkStatus{IDSAttachment: &l2smv1.IDSAttachment{Namespace: "default", Node: "node-a"}},
+		}
+		leftover := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "monitored-ids-rules-3", Namespace: "default"}}
+		c = fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&l2smv1.L2Network{}).WithObjects(network, leftover).
+			WithInterceptorFuncs(applyCreates).Build()
+		reconciler = &L2NetworkReconciler{Client: c, Scheme: scheme, RuleFetcher: ids.NewRuleFetcher(server.Client(), "")}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should render the remote rules and report them", func() {
+		Expect(reconciler.syncIDSRules(ctx, network)).To(Succeed())
+
+		Expect(c.Get(ctx, client.ObjectKey{Name: "monitored-ids-rules-0", Namespace: "default"}, &corev1.ConfigMap{})).To(Succeed())
+		err := c.Get(ctx, client.ObjectKey{Name: "monitored-ids-rules-3", Namespace: "default"}, &corev1.ConfigMap{})
+		Expect(client.IgnoreNotFound(err)).NotTo(HaveOccurred())
+		Expect(err).To(HaveOccurred(), "leftover chunks should be deleted")
+
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(network), network)).To(Succeed())
+		Expect(network.Status.IDSRules.RuleCount).To(Equal(3))
+		Expect(network.Status.IDSRules.LastSyncTime).NotTo(BeNil())
+		Expect(meta.IsStatusConditionTrue(network.Status.Conditions, IDSRulesSyncedCondition)).To(BeTrue())
+	})
+
+	It("should only sync again when the rules are due", func() {
+		Expect(reconciler.syncIDSRules(ctx, network)).To(Succeed())
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(network), network)).To(Succeed())
+		Expect(idsRulesDue(network, time.Now())).To(BeFalse())
+		Expect(idsRulesDue(network, time.Now().Add(25*time.Hour))).To(BeTrue())
+
+		network.Generation++
+		Expect(idsRulesDue(network, time.Now())).To(BeTrue())
+	})
+
+	It("should keep the last good rules when a source can't be fetched", func() {
+		Expect(reconciler.syncIDSRules(ctx, network)).To(Succeed())
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(network), network)).To(Succeed())
+
+		serverDown = true
+		network.Generation++
+		Expect(reconciler.syncIDSRules(ctx, network)).To(Succeed())
+
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(network), network)).To(Succeed())
+		Expect(network.Status.IDSRules.RuleCount).To(Equal(3))
+		condition := meta.FindStatusCondition(network.Status.Conditions, IDSRulesSyncedCondition)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(ReasonFetchFailed))
+		Expect(idsRulesDue(network, time.Now().Add(6*time.Minute))).To(BeTrue())
+	})
+})
+
+var _ = Describe("L2Network IDS", func() {
+	ctx := context.Background()
+	const switchesNamespace = "l2sm-system"
+
+	var sdn *fakecontroller.Controller
+	var c client.Client
+	var reconciler *L2NetworkReconciler
+	var network *l2smv1.L2Network
+
+	// interfaces are shared by every node, and taken in each of them separately
+	netAttachDef := &nettypes.NetworkAttachmentDefinition{ObjectMeta: metav1.ObjectMeta{
+		Name:      "overlay-veth5",
+		Namespace: switchesNamespace,
+		Labels:    map[string]string{"app": "l2sm"},
+	}}
+	used := func(name, node string) bool {
+		nad := &nettypes.NetworkAttachmentDefinition{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: name, Namespace: switchesNamespace}, nad)).To(Succeed())
+		return nad.Labels["used-"+node] == "true"
+	}
+	deployment := func() (*appsv1.Deployment, error) {
+		deploy := &appsv1.Deployment{}
+		err := c.Get(ctx, client.ObjectKey{Name: "monitored-ids", Namespace: "default"}, deploy)
+		return deploy, err
+	}
+	mirrorPort := func() string {
+		controllerNetwork, _ := sdn.Network(l2smv1.NetworkTypeVnet, "monitored")
+		return controllerNetwork.MirrorPort
+	}
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		Expect(nettypes.AddToScheme(scheme)).To(Succeed())
+		Expect(l2smv1.AddToScheme(scheme)).To(Succeed())
+
+		sdn = fakecontroller.New()
+		sdn.AddNetwork(l2smv1.NetworkTypeVnet, fakecontroller.Network{NetworkId: "monitored"})
+		internalClient, err := sdnclient.NewClient(ctx, sdnclient.InternalType, sdn.ClientConfig())
+		Expect(err).NotTo(HaveOccurred())
+
+		network = &l2smv1.L2Network{
+			ObjectMeta: metav1.ObjectMeta{Name: "monitored", Namespace: "default", Generation: 1},
+			Spec: l2smv1.L2NetworkSpec{
+				Type: l2smv1.NetworkTypeVnet,
+				Ids:  &l2smv1.IdsRules{Enabled: true, Node: "node-a", Profile: "suricata"},
+			},
+		}
+		c = fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&l2smv1.L2Network{}).
+			WithObjects(network, netAttachDef.DeepCopy()).
+			WithInterceptorFuncs(applyCreates).Build()
+		reconciler = &L2NetworkReconciler{
+			Client:            c,
+			Scheme:            scheme,
+			InternalClient:    internalClient,
+			SwitchesNamespace: switchesNamespace,
+			RuleFetcher:       ids.NewRuleFetcher(nil, ""),
+		}
+		Expect(reconciler.reconcileIDS(ctx, network)).To(Succeed())
+	})
+
+	It("should deploy the ids in its node and mirror the traffic to it", func() {
+		Expect(network.Status.IDSAttachment).NotTo(BeNil())
+		Expect(network.Status.IDSAttachment.NetworkAttachmentDefinition).To(Equal("overlay-veth5"))
+		Expect(used("overlay-veth5", "node-a")).To(BeTrue())
+		Expect(mirrorPort()).To(Equal(network.Status.IDSAttachment.MirrorPort))
+
+		deploy, err := deployment()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deploy.Spec.Template.Spec.NodeName).To(Equal("node-a"))
+		Expect(c.Get(ctx, client.ObjectKey{Name: "monitored-ids-cm", Namespace: "default"}, &corev1.ConfigMap{})).To(Succeed())
+	})
+
+	It("should update the ids when its spec changes", func() {
+		deploy, err := deployment()
+		Expect(err).NotTo(HaveOccurred())
+		annotations := deploy.Spec.Template.Annotations
+
+		network.Spec.Ids.IgnorePorts = []int32{22}
+		network.Spec.Ids.CustomRuleSources = []l2smv1.IDSRuleSource{
+			{Name: "inline", Inline: `alert icmp any any -> any any (msg:"test"; sid:5000001; rev:1;)`},
+		}
+		Expect(c.Update(ctx, network)).To(Succeed())
+		Expect(reconciler.reconcileIDS(ctx, network)).To(Succeed())
+
+		cm := &corev1.ConfigMap{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: "monitored-ids-cm", Namespace: "default"}, cm)).To(Succeed())
+		Expect(cm.Data["suricata.rules"]).To(ContainSubstring("sid:5000001"))
+		Expect(cm.Data).To(HaveKeyWithValue("bpf-filter", "not (port 22)"))
+
+		deploy, err = deployment()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deploy.Spec.Template.Spec.Containers[0].Args[0]).To(ContainSubstring("-F /var/lib/suricata/generated-rules/bpf-filter"))
+		Expect(deploy.Spec.Template.Annotations).To(Equal(annotations), "the interface of the ids should be stable")
+	})
+
+	It("should move the ids to its new node", func() {
+		network.Spec.Ids.Node = "node-b"
+		Expect(c.Update(ctx, network)).To(Succeed())
+		Expect(reconciler.reconcileIDS(ctx, network)).To(Succeed())
+
+		Expect(used("overlay-veth5", "node-a")).To(BeFalse())
+		Expect(used("overlay-veth5", "node-b")).To(BeTrue())
+		Expect(network.Status.IDSAttachment.Node).To(Equal("node-b"))
+		Expect(mirrorPort()).To(Equal(network.Status.IDSAttachment.MirrorPort))
+
+		deploy, err := deployment()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(deploy.Spec.Template.Spec.NodeName).To(Equal("node-b"))
+	})
+
+	It("should tear the ids down when it's disabled", func() {
+		network.Spec.Ids.Enabled = false
+		Expect(c.Update(ctx, network)).To(Succeed())
+		Expect(reconciler.reconcileIDS(ctx, network)).To(Succeed())
+
+		Expect(network.Status.IDSAttachment).To(BeNil())
+		Expect(network.Status.IDSRules).To(BeNil())
+		Expect(meta.FindStatusCondition(network.Status.Conditions, IDSRulesSyncedCondition)).To(BeNil())
+		Expect(used("overlay-veth5", "node-a")).To(BeFalse())
+		Expect(mirrorPort()).To(BeEmpty())
+
+		_, err := deployment()
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		err = c.Get(ctx, client.ObjectKey{Name: "monitored-ids-rules-0", Namespace: "default"}, &corev1.ConfigMap{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
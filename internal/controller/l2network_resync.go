@@ -0,0 +1,269 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/env"
+	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
+	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient"
+	"github.com/Networks-it-uc3m/L2S-M/internal/utils"
+)
+
+const (
+	// SynchronizedCondition reports whether the SDN controller holds the network and every pod port attached to it.
+	SynchronizedCondition = "Synchronized"
+
+	ReasonInSync                = "InSync"
+	ReasonNetworkRecreated      = "NetworkRecreated"
+	ReasonPortsReattached       = "PortsReattached"
+	ReasonControllerUnreachable = "ControllerUnreachable"
+	ReasonResyncFailed          = "ResyncFailed"
+
+	// podNetworksKey indexes the pods by the l2networks their interfaces are attached to.
+	podNetworksKey = ".metadata.annotations.attachedNetworks"
+)
+
+// podNetworksIndexer returns the l2networks the interfaces of the pod are attached to, for the podNetworksKey index.
+func podNetworksIndexer(switchesNamespace string) client.IndexerFunc {
+	return func(obj client.Object) []string {
+		interfaces, err := GetPodInterfaces(obj.(*corev1.Pod), switchesNamespace)
+		if err != nil {
+			return nil
+		}
+		var networks []string
+		for _, podInterface := range interfaces {
+			if podInterface.Network != "" && !slices.Contains(networks, podInterface.Network) {
+				networks = append(networks, podInterface.Network)
+			}
+		}
+		return networks
+	}
+}
+
+// resyncNetwork compares the network against the state held by the SDN controller. If the controller lost the network,
+// for example after a restart, it is created again, and every pod port recorded in the pod annotations that the controller
+// doesn't know about is attached back. The outcome is surfaced in the Synchronized condition.
+func (r *L2NetworkReconciler) resyncNetwork(ctx context.Context, network *l2smv1.L2Network) error {
+	logger := log.FromContext(ctx)
+	oldStatus := network.Status.DeepCopy()
+
+	reason, message, err := r.syncWithController(ctx, network)
+	if err != nil {
+		status := l2smv1.OfflineStatus
+		if reason == ReasonControllerUnreachable {
+			network.Status.InternalConnectivity = &status
+		}
+		r.setSynchronizedCondition(network, metav1.ConditionFalse, reason, err.Error())
+	} else {
+		status := l2smv1.OnlineStatus
+		network.Status.InternalConnectivity = &status
+		r.setSynchronizedCondition(network, metav1.ConditionTrue, reason, message)
+		if reason != ReasonInSync {
+			logger.Info("L2Network resynchronized with the SDN controller", "network", network.Name, "reason", reason, "detail", message)
+		}
+	}
+
+	if !equality.Semantic.DeepEqual(oldStatus, &network.Status) {
+		if statusErr := r.Status().Update(ctx, network); statusErr != nil {
+			return client.IgnoreNotFound(statusErr)
+		}
+	}
+	return err
+}
+
+// syncWithController performs the actual drift correction, returning the condition reason that describes it.
+func (r *L2NetworkReconciler) syncWithController(ctx context.Context, network *l2smv1.L2Network) (string, string, error) {
+	controllerNetworks, err := r.InternalClient.ListNetworks(ctx, network.Spec.Type)
+	if err != nil {
+		return ReasonControllerUnreachable, "", fmt.Errorf("could not list networks in the sdn controller: %w", err)
+	}
+
+	var endpoints []string
+	found := false
+	for _, controllerNetwork := range controllerNetworks {
+		if controllerNetwork.NetworkId == network.Name {
+			endpoints = controllerNetwork.Port
+			found = true
+			break
+		}
+	}
+
+	reason, message := ReasonInSync, "network and pod ports match the sdn controller"
+	if !found {
+		var payload any = sdnclient.VnetPayload{NetworkId: network.Name}
+		if network.Spec.Type == l2smv1.NetworkTypeVlink {
+			payload = sdnclient.VlinkPayload{NetworkId: network.Name, Path: network.Status.Hops}
+		}
+		if err := r.InternalClient.CreateNetwork(ctx, network.Spec.Type, payload); err != nil {
+			return ReasonResyncFailed, "", fmt.Errorf("could not recreate network in the sdn controller: %w", err)
+		}
+		reason, message = ReasonNetworkRecreated, "network was missing in the sdn controller and has been recreated"
+
+		// the mirror port went away with the network, so the traffic is mirrored to the ids again
+		if attachment := network.Status.IDSAttachment; attachment != nil {
+			if err := r.InternalClient.SetUpMirrorPort(ctx, network.Spec.Type, sdnclient.VnetPayload{NetworkId: network.Name, MirrorPort: attachment.MirrorPort}); err != nil {
+				return ReasonResyncFailed, "", fmt.Errorf("could not set up the mirror port of the ids again: %w", err)
+			}
+		}
+	}
+
+	ports, err := r.networkPodPorts(ctx, network.Name)
+	if err != nil {
+		return ReasonResyncFailed, "", err
+	}
+
+	var missing []string
+	for _, port := range ports {
+		if !slices.Contains(endpoints, port) {
+			missing = append(missing, port)
+		}
+	}
+	if len(missing) == 0 {
+		return reason, message, nil
+	}
+
+	if err := r.InternalClient.AttachPodToNetwork(ctx, network.Spec.Type, sdnclient.VnetPayload{NetworkId: network.Name, Port: missing}); err != nil {
+		return ReasonResyncFailed, "", fmt.Errorf("could not attach %d pod port(s) back to the network: %w", len(missing), err)
+	}
+	if reason == ReasonInSync {
+		reason, message = ReasonPortsReattached, fmt.Sprintf("%d pod port(s) were missing in the sdn controller and have been attached again", len(missing))
+	} else {
+		message = fmt.Sprintf("%s, along with %d pod port(s)", message, len(missing))
+	}
+	return reason, message, nil
+}
+
+// networkPodPorts returns the switch ports of every running l2sm pod that is connected to the given network. Only the
+// pods attached to it are listed, through the podNetworksKey index.
+func (r *L2NetworkReconciler) networkPodPorts(ctx context.Context, networkName string) ([]string, error) {
+	logger := log.FromContext(ctx)
+
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.MatchingFields{podNetworksKey: networkName}); err != nil {
+		return nil, fmt.Errorf("could not list pods: %w", err)
+	}
+
+	var ports []string
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		// only pods that the pod controller already attached are considered, the rest are still on their way
+		if _, ok := pod.Annotations[networkannotation.L2SM_NETWORK_ANNOTATION]; !ok || pod.DeletionTimestamp != nil || !slices.Contains(pod.Finalizers, l2smFinalizer) {
+			continue
+		}
+		podPorts, err := GetPodNetworkPorts(pod, r.SwitchesNamespace)
+		if err != nil {
+			logger.Error(err, "skipping pod during network resync", "pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+			continue
+		}
+		for _, podPort := range podPorts {
+			if podPort.Network == networkName {
+				ports = append(ports, podPort.Port)
+			}
+		}
+	}
+	return ports, nil
+}
+
+func (r *L2NetworkReconciler) setSynchronizedCondition(network *l2smv1.L2Network, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&network.Status.Conditions, metav1.Condition{
+		Type:               SynchronizedCondition,
+		Status:             status,
+		ObservedGeneration: network.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// sweepOrphanNetworks periodically removes the networks of the SDN controller that no L2Network owns. Monitoring
+// networks, which belong to overlays and network edge devices, are left untouched.
+func (r *L2NetworkReconciler) sweepOrphanNetworks(ctx context.Context) error {
+	ticker := time.NewTicker(env.GetSDNResyncInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.deleteOrphanNetworks(ctx); err != nil {
+				log.FromContext(ctx).Error(err, "could not sweep orphan networks in the sdn controller")
+			}
+		}
+	}
+}
+
+func (r *L2NetworkReconciler) deleteOrphanNetworks(ctx context.Context) error {
+	logger := log.FromContext(ctx)
+
+	owned := map[string]bool{}
+	l2networks := &l2smv1.L2NetworkList{}
+	if err := r.List(ctx, l2networks); err != nil {
+		return fmt.Errorf("could not list l2networks: %w", err)
+	}
+	for _, network := range l2networks.Items {
+		owned[network.Name] = true
+	}
+
+	overlays := &l2smv1.OverlayList{}
+	if err := r.List(ctx, overlays); err != nil {
+		return fmt.Errorf("could not list overlays: %w", err)
+	}
+	for _, overlay := range overlays.Items {
+		owned[utils.GenerateLPMNetworkName(overlay.Name)] = true
+	}
+
+	neds := &l2smv1.NetworkEdgeDeviceList{}
+	if err := r.List(ctx, neds); err != nil {
+		return fmt.Errorf("could not list network edge devices: %w", err)
+	}
+	for _, ned := range neds.Items {
+		owned[utils.GenerateLPMNetworkName(ned.Name)] = true
+	}
+
+	for _, networkType := range []l2smv1.NetworkType{l2smv1.NetworkTypeVnet, l2smv1.NetworkTypeVlink} {
+		controllerNetworks, err := r.InternalClient.ListNetworks(ctx, networkType)
+		if networkType == l2smv1.NetworkTypeVlink && sdnclient.IsNotFound(err) {
+			// controllers without the vlinks api hold no vlinks
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not list %s networks in the sdn controller: %w", networkType, err)
+		}
+		for _, controllerNetwork := range controllerNetworks {
+			if owned[controllerNetwork.NetworkId] {
+				continue
+			}
+			if err := r.InternalClient.DeleteNetwork(ctx, networkType, controllerNetwork.NetworkId); err != nil {
+				logger.Error(err, "could not delete orphan network", "network", controllerNetwork.NetworkId)
+				continue
+			}
+			logger.Info("Deleted orphan network from the SDN controller", "network", controllerNetwork.NetworkId, "type", networkType)
+		}
+	}
+	return nil
+}
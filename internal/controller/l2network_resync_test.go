@@ -0,0 +1,101 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"net/http"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
+	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient"
+	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient/fakecontroller"
+)
+
+var _ = Describe("L2Network resync", func() {
+	const switchesNamespace = "l2sm-system"
+	ctx := context.Background()
+	var (
+		sdn        *fakecontroller.Controller
+		reconciler *L2NetworkReconciler
+	)
+
+	attachedPod := func(name, network, netAttachDef string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       name,
+				Namespace:  "default",
+				Finalizers: []string{l2smFinalizer},
+				Annotations: map[string]string{
+					networkannotation.L2SM_NETWORK_ANNOTATION: `[{"name":"` + network + `"}]`,
+					networkannotation.MULTUS_ANNOTATION_KEY:   `[{"name":"` + netAttachDef + `"}]`,
+				},
+			},
+			Spec: corev1.PodSpec{NodeName: "node-a"},
+		}
+	}
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(l2smv1.AddToScheme(scheme)).To(Succeed())
+
+		sdn = fakecontroller.New()
+		internalClient, err := sdnclient.NewClient(ctx, sdnclient.InternalType, sdn.ClientConfig())
+		Expect(err).NotTo(HaveOccurred())
+
+		network := &l2smv1.L2Network{
+			ObjectMeta: metav1.ObjectMeta{Name: "resynced", Namespace: "default"},
+			Spec:       l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet},
+		}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&l2smv1.L2Network{}).
+			WithIndex(&corev1.Pod{}, podNetworksKey, podNetworksIndexer(switchesNamespace)).
+			WithObjects(network, attachedPod("ping", "resynced", "l2sm-veth1"), attachedPod("pong", "other", "l2sm-veth2")).Build()
+		reconciler = &L2NetworkReconciler{Client: c, Scheme: scheme, InternalClient: internalClient, SwitchesNamespace: switchesNamespace}
+	})
+
+	It("attaches back only the ports of the pods of the network", func() {
+		network := &l2smv1.L2Network{}
+		Expect(reconciler.Get(ctx, client.ObjectKey{Name: "resynced", Namespace: "default"}, network)).To(Succeed())
+		Expect(reconciler.resyncNetwork(ctx, network)).To(Succeed())
+
+		ping := attachedPod("ping", "resynced", "l2sm-veth1")
+		port, err := GetPodSwitchPort(ping, networkannotation.NetworkAnnotation{Name: "l2sm-veth1"})
+		Expect(err).NotTo(HaveOccurred())
+		controllerNetwork, ok := sdn.Network(l2smv1.NetworkTypeVnet, "resynced")
+		Expect(ok).To(BeTrue())
+		Expect(controllerNetwork.Endpoints).To(ConsistOf(port))
+	})
+
+	It("sweeps the orphan vnets of controllers without the vlinks api", func() {
+		sdn.AddNetwork(l2smv1.NetworkTypeVnet, fakecontroller.Network{NetworkId: "resynced"})
+		sdn.AddNetwork(l2smv1.NetworkTypeVnet, fakecontroller.Network{NetworkId: "orphan"})
+		sdn.InjectFault(fakecontroller.Fault{Method: http.MethodGet, Path: "/vlinks/api", StatusCode: http.StatusNotFound})
+
+		Expect(reconciler.deleteOrphanNetworks(ctx)).To(Succeed())
+		_, ok := sdn.Network(l2smv1.NetworkTypeVnet, "orphan")
+		Expect(ok).To(BeFalse())
+		_, ok = sdn.Network(l2smv1.NetworkTypeVnet, "resynced")
+		Expect(ok).To(BeTrue())
+	})
+})
@@ -0,0 +1,298 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/dnsinterface"
+	"github.com/Networks-it-uc3m/L2S-M/internal/ids"
+	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
+	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient"
+	"github.com/Networks-it-uc3m/L2S-M/internal/utils"
+)
+
+const (
+	// TornDownCondition reports whether every resource held by a network that is being deleted has been released.
+	TornDownCondition = "TornDown"
+
+	ReasonTornDown       = "TornDown"
+	ReasonTeardownFailed = "TeardownFailed"
+)
+
+// Resources released when a network is deleted, as recorded in its status.
+const (
+	releasedPods            = "pods"
+	releasedIDS             = "ids"
+	releasedIDSInterface    = "ids-interface"
+	releasedNEDPort         = "ned-port"
+	releasedProviderNetwork = "provider-network"
+	releasedNEDInterface    = "ned-interface"
+	releasedDNS             = "dns"
+	releasedNetwork         = "network"
+)
+
+type teardownStep struct {
+	resource string
+	release  func(ctx context.Context, network *l2smv1.L2Network) error
+}
+
+// teardownNetwork undoes every side effect of the creation of the network, in the reverse order they were made: the
+// pods still attached to it, the IDS and its interface, the connection to the network edge device and the network in the provider, the CoreDNS server
+// block, and finally the network in the SDN controller. Every step succeeds if there is nothing left to release, and
+// is recorded in the status once done, so a teardown that failed resumes where it stopped.
+func (r *L2NetworkReconciler) teardownNetwork(ctx context.Context, network *l2smv1.L2Network) error {
+	logger := log.FromContext(ctx)
+
+	steps := []teardownStep{
+		{releasedPods, r.detachPods},
+		{releasedIDS, r.deleteIDS},
+		{releasedIDSInterface, r.releaseIDSInterface},
+		{releasedNEDPort, r.detachNEDPort},
+		{releasedProviderNetwork, r.deleteProviderNetwork},
+		{releasedNEDInterface, r.releaseNEDInterface},
+		{releasedDNS, r.removeDNSServer},
+		{releasedNetwork, r.deleteInternalNetwork},
+	}
+
+	var err error
+	for _, step := range steps {
+		if slices.Contains(network.Status.ReleasedResources, step.resource) {
+			continue
+		}
+		if err = step.release(ctx, network); err != nil {
+			err = fmt.Errorf("could not release %s: %w", step.resource, err)
+			break
+		}
+		network.Status.ReleasedResources = append(network.Status.ReleasedResources, step.resource)
+	}
+
+	condition := metav1.Condition{
+		Type:               TornDownCondition,
+		Status:             metav1.ConditionTrue,
+		Reason:             ReasonTornDown,
+		Message:            "every resource of the network has been released",
+		ObservedGeneration: network.Generation,
+	}
+	if err != nil {
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, ReasonTeardownFailed, err.Error()
+	}
+	meta.SetStatusCondition(&network.Status.Conditions, condition)
+
+	// the progress is only informative, as every step can be repeated, so failing to record it doesn't hold the deletion
+	if statusErr := r.Status().Update(ctx, network); client.IgnoreNotFound(statusErr) != nil {
+		logger.Error(statusErr, "could not record the teardown progress of the network")
+	}
+	return err
+}
+
+// detachPods detaches the pods that are still attached to the network, which only happens when its deletion is forced.
+// Their ports are unplugged from the network and it is removed from their l2sm annotation, so they are not attached
+// again if a network with the same name is created. The interfaces stay in the pods, detached, as with hot-plugging.
+func (r *L2NetworkReconciler) detachPods(ctx context.Context, network *l2smv1.L2Network) error {
+	logger := log.FromContext(ctx)
+
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.MatchingLabels{"l2sm": "true"}); err != nil {
+		return err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if _, ok := pod.Annotations[networkannotation.MULTUS_ANNOTATION_KEY]; !ok {
+			continue
+		}
+		podInterfaces, err := GetPodInterfaces(pod, r.SwitchesNamespace)
+		if err != nil {
+			logger.Error(err, "could not get the interfaces of the pod, skipping it", "pod", client.ObjectKeyFromObject(pod).String())
+			continue
+		}
+
+		detached := false
+		for j := range podInterfaces {
+			if podInterfaces[j].Network != network.Name {
+				continue
+			}
+			ofPort, err := GetPodSwitchPort(pod, podInterfaces[j].NetAttachDef)
+			if err != nil {
+				return err
+			}
+			err = r.InternalClient.DetachPodFromNetwork(ctx, network.Spec.Type, sdnclient.VnetPayload{NetworkId: network.Name, Port: []string{ofPort}})
+			if err != nil && !sdnclient.IsNotFound(err) {
+				return fmt.Errorf("could not detach pod %s: %w", pod.Name, err)
+			}
+			podInterfaces[j].Network = ""
+			detached = true
+		}
+		if !detached {
+			continue
+		}
+
+		SetPodInterfaces(pod, podInterfaces)
+		pod.Annotations[networkannotation.L2SM_NETWORK_ANNOTATION] = networkannotation.RemoveNetwork(pod.Annotations[networkannotation.L2SM_NETWORK_ANNOTATION], network.Name)
+		if err := r.Update(ctx, pod); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("could not update pod %s: %w", pod.Name, err)
+		}
+		logger.Info("Pod detached from the deleted l2network", "pod", client.ObjectKeyFromObject(pod).String())
+	}
+	return nil
+}
+
+// deleteIDS deletes the IDS deployment and its rules. They are owned by the network, but the garbage collector
+// wouldn't delete them if they were deployed in another namespace.
+func (r *L2NetworkReconciler) deleteIDS(ctx context.Context, network *l2smv1.L2Network) error {
+	namespace := network.Namespace
+	switch {
+	case network.Status.IDSAttachment != nil:
+		namespace = network.Status.IDSAttachment.Namespace
+	case network.Spec.Ids == nil:
+		return nil
+	case network.Spec.Ids.Namespace != "":
+		namespace = network.Spec.Ids.Namespace
+	}
+
+	resources := []client.Object{
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: utils.GenerateIdsDeployname(network.Name), Namespace: namespace}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: utils.GenerateIdsCMName(network.Name), Namespace: namespace}},
+	}
+	for i := 0; i < ids.MaxRuleChunks; i++ {
+		resources = append(resources, &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: utils.GenerateIdsRulesCMName(network.Name, i), Namespace: namespace}})
+	}
+	for _, resource := range resources {
+		if err := r.Delete(ctx, resource); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseIDSInterface gives the interface that received the mirrored traffic back to its node. The mirror port itself
+// goes away with the network in the SDN controller.
+func (r *L2NetworkReconciler) releaseIDSInterface(ctx context.Context, network *l2smv1.L2Network) error {
+	attachment := network.Status.IDSAttachment
+	if attachment == nil {
+		return nil
+	}
+	if err := r.releaseNetAttachDef(ctx, attachment.NetworkAttachmentDefinition, attachment.Node); err != nil {
+		return err
+	}
+	network.Status.IDSAttachment = nil
+	return nil
+}
+
+// detachNEDPort detaches the port of the network edge device from the network in the provider.
+func (r *L2NetworkReconciler) detachNEDPort(ctx context.Context, network *l2smv1.L2Network) error {
+	attachment := network.Status.NEDAttachment
+	if network.Spec.Provider == nil || attachment == nil || attachment.Port == "" {
+		return nil
+	}
+	externalClient, err := r.providerClient(ctx, network)
+	if err != nil {
+		return err
+	}
+	err = externalClient.DetachPodFromNetwork(ctx, network.Spec.Type, sdnclient.MscsPayload{NetworkId: network.Name, Endpoints: []string{attachment.Port}})
+	if sdnclient.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// deleteProviderNetwork deletes the network in the provider, unless other domains still have endpoints attached to it.
+func (r *L2NetworkReconciler) deleteProviderNetwork(ctx context.Context, network *l2smv1.L2Network) error {
+	if network.Spec.Provider == nil {
+		return nil
+	}
+	externalClient, err := r.providerClient(ctx, network)
+	if err != nil {
+		return err
+	}
+
+	networks, err := externalClient.ListNetworks(ctx, network.Spec.Type)
+	if err != nil {
+		return err
+	}
+	idx := slices.IndexFunc(networks, func(n sdnclient.VnetPayload) bool { return n.NetworkId == network.Name })
+	if idx == -1 {
+		return nil
+	}
+	if len(networks[idx].Port) > 0 {
+		log.FromContext(ctx).Info("Network is still used by other domains, keeping it in the provider", "network", network.Name, "endpoints", len(networks[idx].Port))
+		return nil
+	}
+
+	err = externalClient.DeleteNetwork(ctx, network.Spec.Type, network.Name)
+	if sdnclient.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// releaseNEDInterface gives the interface that bridged the l2sm switch and the network edge device back to its node.
+func (r *L2NetworkReconciler) releaseNEDInterface(ctx context.Context, network *l2smv1.L2Network) error {
+	attachment := network.Status.NEDAttachment
+	if attachment == nil {
+		return nil
+	}
+	if err := r.releaseNetAttachDef(ctx, attachment.NetworkAttachmentDefinition, attachment.Node); err != nil {
+		return err
+	}
+	network.Status.NEDAttachment = nil
+	return nil
+}
+
+func (r *L2NetworkReconciler) removeDNSServer(ctx context.Context, network *l2smv1.L2Network) error {
+	if network.Spec.Provider == nil {
+		return nil
+	}
+	return dnsinterface.RemoveServerFromLocalCoreDNS(ctx, r.Client, network.Name)
+}
+
+func (r *L2NetworkReconciler) deleteInternalNetwork(ctx context.Context, network *l2smv1.L2Network) error {
+	err := r.InternalClient.DeleteNetwork(ctx, network.Spec.Type, network.Name)
+	if sdnclient.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// releaseNetAttachDef marks the network attachment definition as free in the node again.
+func (r *L2NetworkReconciler) releaseNetAttachDef(ctx context.Context, name, node string) error {
+	netAttachDef := &nettypes.NetworkAttachmentDefinition{}
+	if err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: r.SwitchesNamespace}, netAttachDef); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	netAttachDefLabel := networkannotation.NET_ATTACH_LABEL_PREFIX + node
+	if netAttachDef.Labels[netAttachDefLabel] != "true" {
+		return nil
+	}
+	netAttachDef.Labels[netAttachDefLabel] = "false"
+	return r.Update(ctx, netAttachDef)
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+
+	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient"
+	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient/fakecontroller"
+	"github.com/Networks-it-uc3m/L2S-M/internal/utils"
+)
+
+var _ = Describe("L2Network teardown", func() {
+	ctx := context.Background()
+	const switchesNamespace = "teardown-switches"
+	networkKey := types.NamespacedName{Name: "teardown-network", Namespace: "default"}
+
+	var sdn *fakecontroller.Controller
+	var reconciler *L2NetworkReconciler
+
+	BeforeEach(func() {
+		if err := createNamespace(ctx, switchesNamespace); err != nil {
+			Expect(apierrors.IsAlreadyExists(err)).To(BeTrue())
+		}
+
+		sdn = fakecontroller.New()
+		sdn.AddNetwork(l2smv1.NetworkTypeVnet, fakecontroller.Network{NetworkId: networkKey.Name, MirrorPort: "of:0000000000000001/5"})
+		internalClient, err := sdnclient.NewClient(ctx, sdnclient.InternalType, sdn.ClientConfig())
+		Expect(err).NotTo(HaveOccurred())
+		reconciler = &L2NetworkReconciler{
+			Client:            k8sClient,
+			Scheme:            k8sClient.Scheme(),
+			InternalClient:    internalClient,
+			SwitchesNamespace: switchesNamespace,
+		}
+
+		nad := &nettypes.NetworkAttachmentDefinition{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "teardown-veth5",
+				Namespace: switchesNamespace,
+				Labels:    map[string]string{"app": "l2sm", "used-node-a": "true"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, nad)).To(Succeed())
+
+		network := &l2smv1.L2Network{
+			ObjectMeta: metav1.ObjectMeta{Name: networkKey.Name, Namespace: networkKey.Namespace, Finalizers: []string{l2smFinalizer}},
+			Spec: l2smv1.L2NetworkSpec{
+				Type: l2smv1.NetworkTypeVnet,
+				Ids:  &l2smv1.IdsRules{Enabled: true, Node: "node-a", Profile: "suricata"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, network)).To(Succeed())
+		network.Status.IDSAttachment = &l2smv1.IDSAttachment{
+			Namespace:                   networkKey.Namespace,
+			Node:                        "node-a",
+			NetworkAttachmentDefinition: nad.Name,
+			MirrorPort:                  "of:0000000000000001/5",
+		}
+		Expect(k8sClient.Status().Update(ctx, network)).To(Succeed())
+
+		ids := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: utils.GenerateIdsDeployname(networkKey.Name), Namespace: networkKey.Namespace},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "ids"}},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "ids"}},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "suricata", Image: "jasonish/suricata"}}},
+				},
+			},
+		}
+		Expect(k8sClient.Create(ctx, ids)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		deleteIfExists(ctx, &nettypes.NetworkAttachmentDefinition{}, types.NamespacedName{Name: "teardown-veth5", Namespace: switchesNamespace})
+		network := &l2smv1.L2Network{}
+		if err := k8sClient.Get(ctx, networkKey, network); err == nil {
+			network.Finalizers = nil
+			Expect(k8sClient.Update(ctx, network)).To(Succeed())
+		}
+	})
+
+	It("releases everything the network created and then removes the finalizer", func() {
+		network := &l2smv1.L2Network{}
+		Expect(k8sClient.Get(ctx, networkKey, network)).To(Succeed())
+		Expect(k8sClient.Delete(ctx, network)).To(Succeed())
+
+		_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: networkKey})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, exists := sdn.Network(l2smv1.NetworkTypeVnet, networkKey.Name)
+		Expect(exists).To(BeFalse())
+
+		nad := &nettypes.NetworkAttachmentDefinition{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "teardown-veth5", Namespace: switchesNamespace}, nad)).To(Succeed())
+		Expect(nad.Labels["used-node-a"]).To(Equal("false"))
+
+		err = k8sClient.Get(ctx, types.NamespacedName{Name: utils.GenerateIdsDeployname(networkKey.Name), Namespace: networkKey.Namespace}, &appsv1.Deployment{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+		err = k8sClient.Get(ctx, networkKey, &l2smv1.L2Network{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("records the progress and resumes where it stopped when a step fails", func() {
+		sdn.InjectFault(fakecontroller.Fault{Method: "DELETE", Path: "/vnets/api/" + networkKey.Name, StatusCode: 500})
+
+		network := &l2smv1.L2Network{}
+		Expect(k8sClient.Get(ctx, networkKey, network)).To(Succeed())
+		Expect(k8sClient.Delete(ctx, network)).To(Succeed())
+
+		_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: networkKey})
+		Expect(err).To(HaveOccurred())
+
+		Expect(k8sClient.Get(ctx, networkKey, network)).To(Succeed())
+		Expect(network.Status.ReleasedResources).To(ContainElements(releasedIDS, releasedIDSInterface, releasedDNS))
+		Expect(network.Status.ReleasedResources).NotTo(ContainElement(releasedNetwork))
+		Expect(network.Status.IDSAttachment).To(BeNil())
+		tornDown := meta.FindStatusCondition(network.Status.Conditions, TornDownCondition)
+		Expect(tornDown).NotTo(BeNil())
+		Expect(tornDown.Status).To(Equal(metav1.ConditionFalse))
+		Expect(tornDown.Reason).To(Equal(ReasonTeardownFailed))
+
+		sdn.ClearFaults()
+		_, err = reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: networkKey})
+		Expect(err).NotTo(HaveOccurred())
+		err = k8sClient.Get(ctx, networkKey, &l2smv1.L2Network{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
@@ -0,0 +1,279 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/ids"
+	"github.com/Networks-it-uc3m/L2S-M/internal/ipam"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	// FORCE_DELETE_ANNOTATION lets an l2network be deleted while pods are still attached to it. The pods are detached
+	// from the network when it is torn down.
+	FORCE_DELETE_ANNOTATION = "l2sm/force-delete"
+)
+
+// +kubebuilder:webhook:path=/validate-l2sm-l2sm-k8s-local-v1-l2network,mutating=false,failurePolicy=fail,sideEffects=None,groups=l2sm.l2sm.k8s.local,resources=l2networks,verbs=create;update;delete,versions=v1,name=vl2network.kb.io,admissionReviewVersions=v1
+
+// L2NetworkValidator rejects l2networks with an invalid spec, and the deletion of the ones that still have pods attached.
+type L2NetworkValidator struct {
+	Client            client.Client
+	SwitchesNamespace string
+	Decoder           *admission.Decoder
+}
+
+func (v *L2NetworkValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	log := log.FromContext(ctx)
+
+	network := &l2smv1.L2Network{}
+	if req.Operation == admissionv1.Delete {
+		if err := v.Decoder.DecodeRaw(req.OldObject, network); err != nil {
+			log.Error(err, "Error decoding l2network")
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		return v.validateDelete(network)
+	}
+
+	if err := v.Decoder.Decode(req, network); err != nil {
+		log.Error(err, "Error decoding l2network")
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	// Networks being deleted are only updated to remove the finalizer, so their spec is not checked again
+	if !network.DeletionTimestamp.IsZero() {
+		return admission.Allowed("Allowing l2network's deletion")
+	}
+	problems, err := v.validateSpec(ctx, network)
+	if err != nil {
+		log.Error(err, "Error validating l2network")
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if len(problems) != 0 {
+		return admission.Denied(strings.Join(problems, "; "))
+	}
+	return admission.Allowed("")
+}
+
+// validateDelete only lets networks without pods be deleted, unless the deletion is forced.
+func (v *L2NetworkValidator) validateDelete(network *l2smv1.L2Network) admission.Response {
+	if network.Status.ConnectedPodCount == 0 {
+		return admission.Allowed("")
+	}
+	if network.Annotations[FORCE_DELETE_ANNOTATION] == "true" {
+		return admission.Allowed(fmt.Sprintf("Forcing deletion, %d pods will be detached", network.Status.ConnectedPodCount))
+	}
+	return admission.Denied(fmt.Sprintf("l2network %s still has %d pods attached. Detach them first, or set the %s annotation to \"true\" to detach them on deletion",
+		network.Name, network.Status.ConnectedPodCount, FORCE_DELETE_ANNOTATION))
+}
+
+// validateSpec returns the problems found in the spec of the network. An error is only returned when the validation
+// itself couldn't be done.
+func (v *L2NetworkValidator) validateSpec(ctx context.Context, network *l2smv1.L2Network) ([]string, error) {
+	var problems []string
+
+	problems = append(problems, validateNetworkAddressing(network.Spec)...)
+	problems = append(problems, validateReservations(network.Spec)...)
+
+	switch {
+	case network.Spec.Type == l2smv1.NetworkTypeExtVnet && network.Spec.Provider == nil:
+		problems = append(problems, "ext-vnet networks need a provider")
+	case network.Spec.Type == l2smv1.NetworkTypeVlink && network.Spec.Provider != nil:
+		problems = append(problems, "vlink networks can't have a provider, inter-domain networks must be vnet or ext-vnet")
+	}
+	if network.Spec.MTU != 0 && (network.Spec.MTU < minInterfaceMTU || network.Spec.MTU > maxInterfaceMTU) {
+		problems = append(problems, fmt.Sprintf("mtu must be between %d and %d", minInterfaceMTU, maxInterfaceMTU))
+	} else if network.Spec.MTU != 0 {
+		// the bridges of the overlays keep their own MTU, so larger frames of the pods would be dropped in them
+		overlays := &l2smv1.OverlayList{}
+		if err := v.Client.List(ctx, overlays, client.InNamespace(v.SwitchesNamespace)); err != nil {
+			return nil, fmt.Errorf("could not list overlays: %w", err)
+		}
+		for i := range overlays.Items {
+			if mtu := overlayInterfaceMTU(&overlays.Items[i]); network.Spec.MTU > mtu {
+				problems = append(problems, fmt.Sprintf("mtu can't be larger than the mtu %d of overlay %s", mtu, overlays.Items[i].Name))
+			}
+		}
+	}
+	if len(network.Spec.Path) != 0 && network.Spec.Type != l2smv1.NetworkTypeVlink {
+		problems = append(problems, "path is only used by vlink networks")
+	}
+
+	if network.Spec.Ids != nil {
+		problems = append(problems, validateIDSRuleSources(network.Spec.Ids.CustomRuleSources)...)
+		problems = append(problems, validateIDSProfile(network.Spec.Ids)...)
+		problems = append(problems, validateIDSAlertPolicy(network)...)
+	}
+	if network.Spec.Ids != nil && network.Spec.Ids.Enabled && network.Spec.Ids.Node == "" {
+		problems = append(problems, "ids.node is required when the ids is enabled")
+	} else if network.Spec.Ids != nil && network.Spec.Ids.Enabled {
+		err := v.Client.Get(ctx, client.ObjectKey{Name: network.Spec.Ids.Node}, &corev1.Node{})
+		switch {
+		case apierrors.IsNotFound(err):
+			problems = append(problems, fmt.Sprintf("ids node %s doesn't exist", network.Spec.Ids.Node))
+		case err != nil:
+			return nil, fmt.Errorf("could not get ids node %s: %w", network.Spec.Ids.Node, err)
+		}
+	}
+	return problems, nil
+}
+
+// validateNetworkAddressing checks that the addresses of the spec can be parsed, and that every pod address range is
+// inside the network CIDR of its family.
+func validateNetworkAddressing(spec l2smv1.L2NetworkSpec) []string {
+	if spec.NetworkCIDR == "" {
+		if spec.PodAddressRange != "" {
+			return []string{"podAddressRange needs a networkCIDR"}
+		}
+		return nil
+	}
+
+	ranges, err := ipam.RangesForNetwork(spec)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var problems []string
+	for _, value := range strings.Split(spec.PodAddressRange, ",") {
+		pool, err := netip.ParsePrefix(strings.TrimSpace(value))
+		if err != nil {
+			continue
+		}
+		found := false
+		for _, r := range ranges {
+			found = found || r.Subnet.Addr().Is4() == pool.Addr().Is4()
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("pod address range %s has no network CIDR of its address family", pool))
+		}
+	}
+	if _, err := ipam.ReservationsForNetwork(spec); err != nil {
+		problems = append(problems, err.Error())
+	}
+	return problems
+}
+
+// validateReservations checks that every reservation is meant for either a pod name or a pod selector, and that its
+// addresses can be assigned to pods and are not reserved twice.
+func validateReservations(spec l2smv1.L2NetworkSpec) []string {
+	if len(spec.Reservations) != 0 && spec.NetworkCIDR == "" {
+		return []string{"reservations need a networkCIDR"}
+	}
+
+	var problems []string
+	reserved := map[netip.Addr]bool{}
+	for i, reservation := range spec.Reservations {
+		if (reservation.PodName == "") == (reservation.PodSelector == nil) {
+			problems = append(problems, fmt.Sprintf("reservation %d must set either podName or podSelector", i))
+		}
+		if reservation.PodSelector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(reservation.PodSelector); err != nil {
+				problems = append(problems, fmt.Sprintf("reservation %d has an invalid podSelector: %v", i, err))
+			}
+		}
+
+		families := map[bool]bool{}
+		for _, ip := range reservation.IPs {
+			if err := ipam.Check(&l2smv1.L2Network{Spec: spec}, "", []string{ip}); err != nil {
+				problems = append(problems, fmt.Sprintf("reservation %d: %v", i, err))
+				continue
+			}
+			address, _, _ := strings.Cut(ip, "/")
+			addr := netip.MustParseAddr(address)
+			if families[addr.Is4()] {
+				problems = append(problems, fmt.Sprintf("reservation %d has more than one address of the family of %s", i, addr))
+			}
+			if reserved[addr] {
+				problems = append(problems, fmt.Sprintf("address %s is reserved more than once", addr))
+			}
+			families[addr.Is4()], reserved[addr] = true, true
+		}
+	}
+	return problems
+}
+
+// validateIDSRuleSources checks that inline rules are valid Suricata rules, and that remote sources are http(s) URLs
+// with a well formed checksum.
+func validateIDSRuleSources(sources []l2smv1.IDSRuleSource) []string {
+	var problems []string
+	for _, source := range sources {
+		if _, err := ids.CountRules(source.Inline); err != nil {
+			problems = append(problems, fmt.Sprintf("rule source %s: %v", source.Name, err))
+		}
+		if source.URL != "" {
+			if parsed, err := url.Parse(source.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				problems = append(problems, fmt.Sprintf("rule source %s needs an http or https url", source.Name))
+			}
+		}
+		if source.SHA256 != "" {
+			if source.URL == "" {
+				problems = append(problems, fmt.Sprintf("rule source %s has a sha256 but no url", source.Name))
+			} else if sum, err := hex.DecodeString(source.SHA256); err != nil || len(sum) != sha256.Size {
+				problems = append(problems, fmt.Sprintf("rule source %s has an invalid sha256", source.Name))
+			}
+		}
+	}
+	return problems
+}
+
+// validateIDSProfile checks that the profile of the IDS is known, and that only the custom profile has a custom
+// configuration.
+func validateIDSProfile(idsRules *l2smv1.IdsRules) []string {
+	switch {
+	case !ids.IsProfile(idsRules.Profile):
+		return []string{fmt.Sprintf("unknown ids profile %s, use lightweight, balanced, full or custom", idsRules.Profile)}
+	case idsRules.Profile == ids.ProfileCustom && idsRules.CustomProfile == nil:
+		return []string{"the custom ids profile needs a customProfile"}
+	case idsRules.Profile != ids.ProfileCustom && idsRules.CustomProfile != nil:
+		return []string{"customProfile is only used by the custom ids profile"}
+	}
+	return nil
+}
+
+// validateIDSAlertPolicy checks that the alert policy of the IDS matches some alerts, and that it doesn't quarantine
+// pods in their own network.
+func validateIDSAlertPolicy(network *l2smv1.L2Network) []string {
+	policy := network.Spec.Ids.AlertPolicy
+	if policy == nil {
+		return nil
+	}
+	var problems []string
+	if len(policy.SignatureIDs) == 0 && len(policy.Severities) == 0 {
+		problems = append(problems, "the ids alert policy needs signatureIDs or severities")
+	}
+	if policy.QuarantineNetwork == network.Name {
+		problems = append(problems, "the ids alert policy can't quarantine pods in their own network")
+	}
+	return problems
+}
+
+func (v *L2NetworkValidator) InjectDecoder(d *admission.Decoder) error {
+	v.Decoder = d
+	return nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+)
+
+var _ = Describe("L2Network validating webhook", func() {
+	ctx := context.Background()
+	var validator *L2NetworkValidator
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(l2smv1.AddToScheme(scheme)).To(Succeed())
+		overlay := &l2smv1.Overlay{
+			ObjectMeta: metav1.ObjectMeta{Name: "overlay", Namespace: "l2sm-system"},
+			Spec:       l2smv1.OverlaySpec{InterfaceOptions: &l2smv1.InterfaceOptions{MTU: 9000}},
+		}
+		validator = &L2NetworkValidator{
+			Client:            fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}, overlay).Build(),
+			SwitchesNamespace: "l2sm-system",
+			Decoder:           admission.NewDecoder(scheme),
+		}
+	})
+
+	request := func(operation admissionv1.Operation, network *l2smv1.L2Network) admission.Request {
+		raw, err := json.Marshal(network)
+		Expect(err).NotTo(HaveOccurred())
+		req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: operation}}
+		if operation == admissionv1.Delete {
+			req.OldObject = runtime.RawExtension{Raw: raw}
+		} else {
+			req.Object = runtime.RawExtension{Raw: raw}
+		}
+		return req
+	}
+
+	newNetwork := func(spec l2smv1.L2NetworkSpec) *l2smv1.L2Network {
+		return &l2smv1.L2Network{
+			TypeMeta:   metav1.TypeMeta{APIVersion: l2smv1.GroupVersion.String(), Kind: "L2Network"},
+			ObjectMeta: metav1.ObjectMeta{Name: "net", Namespace: "default"},
+			Spec:       spec,
+		}
+	}
+
+	Describe("deletion", func() {
+		It("allows deleting a network without pods", func() {
+			resp := validator.Handle(ctx, request(admissionv1.Delete, newNetwork(l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet})))
+			Expect(resp.Allowed).To(BeTrue())
+		})
+
+		It("rejects deleting a network with pods attached", func() {
+			network := newNetwork(l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet})
+			network.Status.ConnectedPodCount = 2
+			resp := validator.Handle(ctx, request(admissionv1.Delete, network))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Message).To(ContainSubstring(FORCE_DELETE_ANNOTATION))
+		})
+
+		It("allows forcing the deletion of a network with pods attached", func() {
+			network := newNetwork(l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet})
+			network.Status.ConnectedPodCount = 2
+			network.Annotations = map[string]string{FORCE_DELETE_ANNOTATION: "true"}
+			resp := validator.Handle(ctx, request(admissionv1.Delete, network))
+			Expect(resp.Allowed).To(BeTrue())
+		})
+	})
+
+	Describe("spec validation", func() {
+		DescribeTable("admission of the spec",
+			func(spec l2smv1.L2NetworkSpec, allowed bool, message string) {
+				resp := validator.Handle(ctx, request(admissionv1.Create, newNetwork(spec)))
+				Expect(resp.Allowed).To(Equal(allowed))
+				if message != "" {
+					Expect(resp.Result.Message).To(ContainSubstring(message))
+				}
+			},
+			Entry("a layer 2 vnet", l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet}, true, ""),
+			Entry("a pod address range inside the network CIDR",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, NetworkCIDR: "10.101.0.0/16", PodAddressRange: "10.101.2.0/24"}, true, ""),
+			Entry("an invalid network CIDR",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, NetworkCIDR: "10.101.0.0/33"}, false, "invalid network CIDR"),
+			Entry("a pod address range outside of the network CIDR",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, NetworkCIDR: "10.101.0.0/16", PodAddressRange: "10.102.2.0/24"}, false, "is not inside the network CIDR"),
+			Entry("a pod address range without network CIDR",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, PodAddressRange: "10.101.2.0/24"}, false, "needs a networkCIDR"),
+			Entry("a pod address range of another address family",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, NetworkCIDR: "10.101.0.0/16", PodAddressRange: "fd00::/64"}, false, "no network CIDR of its address family"),
+			Entry("an ext-vnet without provider", l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeExtVnet}, false, "need a provider"),
+			Entry("a vlink with provider",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVlink, Provider: &l2smv1.ProviderSpec{Name: "idco"}}, false, "can't have a provider"),
+			Entry("a path in a vnet", l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, Path: []string{"node-a", "node-b"}}, false, "only used by vlink"),
+			Entry("an ids in an existing node",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, Ids: &l2smv1.IdsRules{Enabled: true, Node: "node-a", Profile: "suricata"}}, true, ""),
+			Entry("an ids in a missing node",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, Ids: &l2smv1.IdsRules{Enabled: true, Node: "node-b", Profile: "suricata"}}, false, "ids node node-b doesn't exist"),
+			Entry("an ids without a node",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, Ids: &l2smv1.IdsRules{Enabled: true, Profile: "suricata"}}, false, "ids.node is required"),
+			Entry("ids rules from an inline rule and a url",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, Ids: &l2smv1.IdsRules{Node: "node-a", Profile: "suricata", CustomRuleSources: []l2smv1.IDSRuleSource{
+					{Name: "inline", Inline: `alert icmp any any -> any any (msg:"test"; sid:5000001; rev:1;)`},
+					{Name: "remote", URL: "https://rules.example.com/custom.rules", SHA256: strings.Repeat("ab", 32)},
+				}}}, true, ""),
+			Entry("an inline rule that isn't a rule",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, Ids: &l2smv1.IdsRules{Node: "node-a", Profile: "suricata", CustomRuleSources: []l2smv1.IDSRuleSource{
+					{Name: "inline", Inline: "block everything"},
+				}}}, false, "rule source inline: invalid rules"),
+			Entry("a rule source with an invalid checksum",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, Ids: &l2smv1.IdsRules{Node: "node-a", Profile: "suricata", CustomRuleSources: []l2smv1.IDSRuleSource{
+					{Name: "remote", URL: "ftp://rules.example.com/custom.rules", SHA256: "abc"},
+				}}}, false, "rule source remote needs an http or https url; rule source remote has an invalid sha256"),
+			Entry("an ids with the full profile",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, Ids: &l2smv1.IdsRules{Node: "node-a", Profile: "full"}}, true, ""),
+			Entry("an ids with an unknown profile",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, Ids: &l2smv1.IdsRules{Node: "node-a", Profile: "snort"}}, false, "unknown ids profile snort"),
+			Entry("an ids with the custom profile",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, Ids: &l2smv1.IdsRules{Node: "node-a", Profile: "custom",
+					CustomProfile: &corev1.LocalObjectReference{Name: "suricata-config"}}}, true, ""),
+			Entry("an ids with the custom profile and no configuration",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, Ids: &l2smv1.IdsRules{Node: "node-a", Profile: "custom"}}, false, "needs a customProfile"),
+			Entry("a custom configuration for another profile",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, Ids: &l2smv1.IdsRules{Node: "node-a", Profile: "lightweight",
+					CustomProfile: &corev1.LocalObjectReference{Name: "suricata-config"}}}, false, "only used by the custom ids profile"),
+			Entry("an ids alert policy",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, Ids: &l2smv1.IdsRules{Node: "node-a", Profile: "balanced",
+					AlertPolicy: &l2smv1.IDSAlertPolicy{Severities: []int32{1}, QuarantineNetwork: "quarantine"}}}, true, ""),
+			Entry("an ids alert policy that matches no alert",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, Ids: &l2smv1.IdsRules{Node: "node-a", Profile: "balanced",
+					AlertPolicy: &l2smv1.IDSAlertPolicy{QuarantineNetwork: "quarantine"}}}, false, "needs signatureIDs or severities"),
+			Entry("an ids alert policy that quarantines pods in their own network",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, Ids: &l2smv1.IdsRules{Node: "node-a", Profile: "balanced",
+					AlertPolicy: &l2smv1.IDSAlertPolicy{SignatureIDs: []int64{2100498}, QuarantineNetwork: "net"}}}, false, "in their own network"),
+			Entry("an mtu for jumbo frames", l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, MTU: 9000}, true, ""),
+			Entry("an mtu too large", l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, MTU: 65000}, false, "mtu must be between"),
+			Entry("an mtu larger than the overlay", l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, MTU: 9100}, false, "larger than the mtu 9000 of overlay overlay"),
+			Entry("a reservation for a pod",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, NetworkCIDR: "10.101.0.0/16", Reservations: []l2smv1.IPReservation{
+					{PodName: "ping", IPs: []string{"10.101.0.10"}},
+					{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "pong"}}, IPs: []string{"10.101.0.20/16"}},
+				}}, true, ""),
+			Entry("a reservation without network CIDR",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, Reservations: []l2smv1.IPReservation{{PodName: "ping", IPs: []string{"10.101.0.10"}}}}, false, "reservations need a networkCIDR"),
+			Entry("a reservation for both a pod name and a selector",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, NetworkCIDR: "10.101.0.0/16", Reservations: []l2smv1.IPReservation{
+					{PodName: "ping", PodSelector: &metav1.LabelSelector{}, IPs: []string{"10.101.0.10"}},
+				}}, false, "either podName or podSelector"),
+			Entry("a reservation outside of the network CIDR",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, NetworkCIDR: "10.101.0.0/16", Reservations: []l2smv1.IPReservation{{PodName: "ping", IPs: []string{"10.102.0.10"}}}}, false, "is not inside the network CIDR"),
+			Entry("a reservation of an excluded address",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, NetworkCIDR: "10.101.0.0/16", IPAM: &l2smv1.IPAMSpec{Gateways: []string{"10.101.0.1"}},
+					Reservations: []l2smv1.IPReservation{{PodName: "ping", IPs: []string{"10.101.0.1"}}}}, false, "is reserved"),
+			Entry("an address reserved twice",
+				l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, NetworkCIDR: "10.101.0.0/16", Reservations: []l2smv1.IPReservation{
+					{PodName: "ping", IPs: []string{"10.101.0.10"}},
+					{PodName: "pong", IPs: []string{"10.101.0.10"}},
+				}}, false, "reserved more than once"),
+		)
+	})
+})
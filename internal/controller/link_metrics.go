@@ -0,0 +1,182 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/env"
+	"github.com/Networks-it-uc3m/L2S-M/internal/lpminterface"
+	"github.com/Networks-it-uc3m/L2S-M/internal/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// collectorHTTPClient scrapes the LPM collectors. The timeout keeps an unreachable collector from delaying the
+// metrics of the rest.
+var collectorHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// collectLinkMetrics periodically scrapes the collectors of every monitored overlay and summarizes their measurements
+// in the overlay status.
+func (r *OverlayReconciler) collectLinkMetrics(ctx context.Context) error {
+	ticker := time.NewTicker(env.GetLinkMetricsInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.refreshLinkMetrics(ctx); err != nil {
+				log.FromContext(ctx).Error(err, "could not refresh overlay link metrics")
+			}
+		}
+	}
+}
+
+func (r *OverlayReconciler) refreshLinkMetrics(ctx context.Context) error {
+	logger := log.FromContext(ctx)
+
+	overlays := &l2smv1.OverlayList{}
+	if err := r.List(ctx, overlays); err != nil {
+		return fmt.Errorf("could not list overlays: %w", err)
+	}
+
+	for i := range overlays.Items {
+		overlay := &overlays.Items[i]
+		if !overlay.DeletionTimestamp.IsZero() || overlay.Spec.Topology == nil {
+			continue
+		}
+
+		var links []l2smv1.LinkStatus
+		if overlay.Spec.Monitor != nil {
+			links = []l2smv1.LinkStatus{}
+			for _, node := range overlay.Spec.Topology.Nodes {
+				// the switch services are headless, so their name resolves to the switch pod running the collector
+				serviceName := utils.GenerateServiceName(utils.GenerateSwitchPodName(overlay.Name, node, utils.SlicePacketSwitch))
+				url := lpminterface.CollectorMetricsURL(fmt.Sprintf("%s.%s.svc", serviceName, overlay.Namespace))
+				nodeLinks, err := lpminterface.ScrapeCollector(ctx, collectorHTTPClient, url)
+				if err != nil {
+					logger.V(1).Info("Could not scrape collector", "overlay", overlay.Name, "node", node, "error", err.Error())
+					continue
+				}
+				links = append(links, nodeLinks...)
+			}
+		} else if overlay.Status.LinkMetrics == nil {
+			continue
+		}
+
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			latest := &l2smv1.Overlay{}
+			if err := r.Get(ctx, client.ObjectKeyFromObject(overlay), latest); err != nil {
+				return err
+			}
+			if equality.Semantic.DeepEqual(linkMetrics(links), latest.Status.LinkMetrics) {
+				return nil
+			}
+			latest.Status.LinkMetrics = linkMetrics(links)
+			return r.Status().Update(ctx, latest)
+		})
+		if client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "could not update link metrics", "overlay", overlay.Name)
+		}
+	}
+	return nil
+}
+
+// collectLinkMetrics periodically scrapes the collector of every monitored network edge device and summarizes its
+// measurements in the device status.
+func (r *NetworkEdgeDeviceReconciler) collectLinkMetrics(ctx context.Context) error {
+	ticker := time.NewTicker(env.GetLinkMetricsInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.refreshLinkMetrics(ctx); err != nil {
+				log.FromContext(ctx).Error(err, "could not refresh network edge device link metrics")
+			}
+		}
+	}
+}
+
+func (r *NetworkEdgeDeviceReconciler) refreshLinkMetrics(ctx context.Context) error {
+	logger := log.FromContext(ctx)
+
+	neds := &l2smv1.NetworkEdgeDeviceList{}
+	if err := r.List(ctx, neds); err != nil {
+		return fmt.Errorf("could not list network edge devices: %w", err)
+	}
+
+	for i := range neds.Items {
+		ned := &neds.Items[i]
+		if !ned.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		var links []l2smv1.LinkStatus
+		if ned.Spec.Monitor != nil {
+			// the device has no service in front of its switch, so the collector is reached through the pod address
+			if ned.Status.Switch == nil || ned.Status.Switch.Pod == "" {
+				continue
+			}
+			pod := &corev1.Pod{}
+			if err := r.Get(ctx, client.ObjectKey{Namespace: ned.Namespace, Name: ned.Status.Switch.Pod}, pod); err != nil || pod.Status.PodIP == "" {
+				continue
+			}
+			var err error
+			links, err = lpminterface.ScrapeCollector(ctx, collectorHTTPClient, lpminterface.CollectorMetricsURL(pod.Status.PodIP))
+			if err != nil {
+				logger.V(1).Info("Could not scrape collector", "networkedgedevice", ned.Name, "error", err.Error())
+				continue
+			}
+		} else if ned.Status.LinkMetrics == nil {
+			continue
+		}
+
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			latest := &l2smv1.NetworkEdgeDevice{}
+			if err := r.Get(ctx, client.ObjectKeyFromObject(ned), latest); err != nil {
+				return err
+			}
+			if equality.Semantic.DeepEqual(linkMetrics(links), latest.Status.LinkMetrics) {
+				return nil
+			}
+			latest.Status.LinkMetrics = linkMetrics(links)
+			return r.Status().Update(ctx, latest)
+		})
+		if client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "could not update link metrics", "networkedgedevice", ned.Name)
+		}
+	}
+	return nil
+}
+
+// linkMetrics returns the value of the LinkMetrics status field for the links, which is unset when monitoring is off.
+func linkMetrics(links []l2smv1.LinkStatus) *[]l2smv1.LinkStatus {
+	if links == nil {
+		return nil
+	}
+	return &links
+}
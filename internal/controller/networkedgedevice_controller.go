@@ -22,6 +22,7 @@ import (
 	"time"
 
 	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/env"
 	"github.com/Networks-it-uc3m/L2S-M/internal/lpminterface"
 	"github.com/Networks-it-uc3m/L2S-M/internal/monitoringnetwork"
 	"github.com/Networks-it-uc3m/L2S-M/internal/talpainterface"
@@ -36,6 +37,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
 // NetworkEdgeDeviceReconciler reconciles a NetworkEdgeDevice object
@@ -80,13 +82,12 @@ func (r *NetworkEdgeDeviceReconciler) Reconcile(ctx context.Context, req ctrl.Re
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// The defaulting webhook persists the defaults. They are only applied in memory here, for the devices created
+	// while the webhooks were disabled
 	err := applyNetworkEdgeDeviceDefaults(ctx, r.Client, netEdgeDevice)
 	if err != nil {
 		return ctrl.Result{}, fmt.Errorf("failed to apply network edge device defaults: %w", err)
 	}
-	if err := r.Update(ctx, netEdgeDevice); err != nil {
-		return ctrl.Result{}, err
-	}
 	// examine DeletionTimestamp to determine if object is under deletion
 	if netEdgeDevice.ObjectMeta.DeletionTimestamp.IsZero() {
 		// The object is not being deleted, so if it does not have our finalizer,
@@ -94,7 +95,6 @@ func (r *NetworkEdgeDeviceReconciler) Reconcile(ctx context.Context, req ctrl.Re
 		// to registering our finalizer.
 		if !controllerutil.ContainsFinalizer(netEdgeDevice, l2smFinalizer) {
 
-			// we apply the defaults to this bad boy
 			controllerutil.AddFinalizer(netEdgeDevice, l2smFinalizer)
 			if err := r.Update(ctx, netEdgeDevice); err != nil {
 				return ctrl.Result{}, err
@@ -106,7 +106,7 @@ func (r *NetworkEdgeDeviceReconciler) Reconcile(ctx context.Context, req ctrl.Re
 		// The object is being deleted
 		if controllerutil.ContainsFinalizer(netEdgeDevice, l2smFinalizer) {
 			if netEdgeDevice.Spec.Monitor != nil {
-				if err := r.deleteMonitoringNetwork(netEdgeDevice); err != nil {
+				if err := r.deleteMonitoringNetwork(ctx, netEdgeDevice); err != nil {
 					return ctrl.Result{}, err
 				}
 			}
@@ -135,25 +135,39 @@ func (r *NetworkEdgeDeviceReconciler) Reconcile(ctx context.Context, req ctrl.Re
 		return ctrl.Result{}, err
 	}
 
-	if len(switchReplicaSets.Items) == 0 {
-		if err := r.createExternalResources(ctx, netEdgeDevice); err != nil {
-			log.Error(err, "unable to create ReplicaSet")
-			return ctrl.Result{}, err
+	var applyErr error
+	launched := len(switchReplicaSets.Items) == 0
+	if launched {
+		if applyErr = r.createExternalResources(ctx, netEdgeDevice); applyErr != nil {
+			log.Error(applyErr, "unable to create ReplicaSet")
+		} else {
+			log.Info("NED Launched")
 		}
-		log.Info("NED Launched")
-		return ctrl.Result{RequeueAfter: time.Second * 20}, nil
-	} else {
-		if err := r.reconcileNed(ctx, netEdgeDevice); err != nil {
-			log.Error(err, "unable to reconcile configmap")
-			return ctrl.Result{}, err
+	} else if applyErr = r.reconcileNed(ctx, netEdgeDevice); applyErr != nil {
+		log.Error(applyErr, "unable to reconcile configmap")
+	}
+	var monitorErr error
+	if netEdgeDevice.Spec.Monitor != nil && applyErr == nil {
+		if monitorErr = r.syncMonitoringNetwork(ctx, netEdgeDevice); monitorErr != nil {
+			log.Error(monitorErr, "could not sync monitoring network")
 		}
 	}
 
-	// 	//b, _ := json.Marshal(netEdgeDevice.Spec.Neighbors)
-
-	// }
+	if err := r.updateNetworkEdgeDeviceStatus(ctx, netEdgeDevice, applyErr, monitorErr); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update network edge device status: %w", err)
+	}
+	if applyErr != nil {
+		return ctrl.Result{}, applyErr
+	}
+	if monitorErr != nil {
+		return ctrl.Result{}, monitorErr
+	}
+	if launched {
+		return ctrl.Result{RequeueAfter: time.Second * 20}, nil
+	}
 
-	return ctrl.Result{}, nil
+	// The connection with the provider controller has to be polled to keep the availability up to date
+	return ctrl.Result{RequeueAfter: env.GetSDNResyncInterval()}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -192,6 +206,10 @@ func (r *NetworkEdgeDeviceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	}); err != nil {
 		return err
 	}
+	// The link metrics are scraped from the collectors on their own schedule, independent from the reconciliations
+	if err := mgr.Add(manager.RunnableFunc(r.collectLinkMetrics)); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&l2smv1.NetworkEdgeDevice{}).
 		Owns(&appsv1.ReplicaSet{}).
@@ -232,10 +250,6 @@ func (r *NetworkEdgeDeviceReconciler) createExternalResources(ctx context.Contex
 		lpminterface.AddLPMConfigMapToSps(&rs.Spec.Template.Spec)
 		lpminterface.AttachCollectorConfigToReplicaSet(&rs.Spec.Template.Spec, rs.Name)
 		extResources = append(extResources, monCMs[0])
-
-		if err := r.createMonitoringNetwork(netEdgeDevice); err != nil {
-			return fmt.Errorf("could not create monitoring network for network edge device: %w", err)
-		}
 	}
 
 	for _, obj := range extResources {
@@ -255,27 +269,29 @@ func (r *NetworkEdgeDeviceReconciler) monitoringClientFactory() monitoringnetwor
 		return r.MonitoringClientFactory
 	}
 
-	return monitoringnetwork.DefaultClientFactory{}
+	return monitoringnetwork.DefaultClientFactory{Reader: r.Client}
 }
 
-func (r *NetworkEdgeDeviceReconciler) createMonitoringNetwork(ned *l2smv1.NetworkEdgeDevice) error {
-	client, err := r.monitoringClientFactory().ForProvider(ned.Spec.Provider)
+// syncMonitoringNetwork creates the monitoring network of the network edge device if needed and attaches its probe
+// port to it.
+func (r *NetworkEdgeDeviceReconciler) syncMonitoringNetwork(ctx context.Context, ned *l2smv1.NetworkEdgeDevice) error {
+	client, err := r.monitoringClientFactory().ForProvider(ctx, ned.Spec.Provider, ned.Namespace)
 	if err != nil {
 		return err
 	}
 
 	manager := monitoringnetwork.Manager{Client: client}
-	return manager.Ensure(ned.Name, ned.Spec.Provider.Name, []string{ned.Spec.NodeConfig.NodeName})
+	return manager.Sync(ctx, ned.Name, ned.Spec.Provider.Name, []string{ned.Spec.NodeConfig.NodeName})
 }
 
-func (r *NetworkEdgeDeviceReconciler) deleteMonitoringNetwork(ned *l2smv1.NetworkEdgeDevice) error {
-	client, err := r.monitoringClientFactory().ForProvider(ned.Spec.Provider)
+func (r *NetworkEdgeDeviceReconciler) deleteMonitoringNetwork(ctx context.Context, ned *l2smv1.NetworkEdgeDevice) error {
+	client, err := r.monitoringClientFactory().ForProvider(ctx, ned.Spec.Provider, ned.Namespace)
 	if err != nil {
 		return err
 	}
 
 	manager := monitoringnetwork.Manager{Client: client}
-	return manager.Delete(ned.Name)
+	return manager.Delete(ctx, ned.Name)
 }
 
 func constructReplicaSetforNED(netEdgeDevice *l2smv1.NetworkEdgeDevice, configmapName string) (*appsv1.ReplicaSet, error) {
@@ -0,0 +1,96 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/utils"
+	dp "github.com/Networks-it-uc3m/l2sm-switch/pkg/datapath"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// updateNetworkEdgeDeviceStatus reports the state of the switch, the result of applying its configuration and of
+// syncing the monitoring network, and whether the provider SDN controller is reachable. The device is available
+// when its switch is ready and connected to the controller.
+func (r *NetworkEdgeDeviceReconciler) updateNetworkEdgeDeviceStatus(ctx context.Context, ned *l2smv1.NetworkEdgeDevice, applyErr, monitorErr error) error {
+	generation := ned.Generation
+	nodeName := ned.Spec.NodeConfig.NodeName
+
+	name := utils.GenerateReplicaSetName(utils.GenerateSwitchPodName(ned.Name, nodeName, utils.NetworkEdgeDevice))
+	switchState, err := switchStatus(ctx, r.Client, client.ObjectKey{Namespace: ned.Namespace, Name: name}, nodeName)
+	if err != nil {
+		return err
+	}
+	ready := 0
+	if switchState.Ready {
+		ready = 1
+	}
+
+	_, controllerErr := r.monitoringClientFactory().ForProvider(ctx, ned.Spec.Provider, ned.Namespace)
+
+	conditions := []metav1.Condition{
+		switchesReadyCondition([]l2smv1.NodeSwitchStatus{switchState}, generation),
+		resultCondition(l2smv1.TopologyAppliedCondition, "Applied", "ApplyFailed", applyErr, generation),
+		resultCondition(l2smv1.ControllerConnectedCondition, "Connected", "Unreachable", controllerErr, generation),
+	}
+	if ned.Spec.Monitor != nil {
+		conditions = append(conditions, resultCondition(l2smv1.MonitoringReadyCondition, "Synced", "SyncFailed", monitorErr, generation))
+	}
+
+	availability := l2smv1.OfflineStatus
+	var neighbors []l2smv1.NeighborSpec
+	if switchState.Ready && controllerErr == nil {
+		availability = l2smv1.OnlineStatus
+		neighbors = ned.Spec.Neighbors
+	}
+
+	var openflowId string
+	if ned.Spec.Provider != nil {
+		openflowId = fmt.Sprintf("of:%s", dp.GenerateID(dp.GetSwitchName(dp.DatapathParams{NodeName: nodeName, ProviderName: ned.Spec.Provider.Name})))
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &l2smv1.NetworkEdgeDevice{}
+		if err := r.Get(ctx, client.ObjectKeyFromObject(ned), latest); err != nil {
+			return err
+		}
+		status := latest.Status.DeepCopy()
+		status.Availability = &availability
+		status.ConnectedNeighbors = neighbors
+		status.OpenflowId = openflowId
+		status.Switch = &switchState
+		status.ObservedGeneration = generation
+		if ned.Spec.Monitor == nil {
+			meta.RemoveStatusCondition(&status.Conditions, l2smv1.MonitoringReadyCondition)
+		}
+		for _, condition := range conditions {
+			meta.SetStatusCondition(&status.Conditions, condition)
+		}
+		status.Phase = fabricPhase(status.Conditions, ready)
+
+		if equality.Semantic.DeepEqual(status, &latest.Status) {
+			return nil
+		}
+		latest.Status = *status
+		return r.Status().Update(ctx, latest)
+	})
+}
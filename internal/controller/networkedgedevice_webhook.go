@@ -0,0 +1,134 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:path=/mutate-l2sm-l2sm-k8s-local-v1-networkedgedevice,mutating=true,failurePolicy=fail,sideEffects=None,groups=l2sm.l2sm.k8s.local,resources=networkedgedevices,verbs=create;update,versions=v1,name=mnetworkedgedevice.kb.io,admissionReviewVersions=v1
+
+// NetworkEdgeDeviceDefaulter sets the default switch template of the network edge devices that don't have one, and
+// deploys them in the control plane node if they don't say where.
+type NetworkEdgeDeviceDefaulter struct {
+	Client  client.Client
+	Decoder *admission.Decoder
+}
+
+func (d *NetworkEdgeDeviceDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	log := log.FromContext(ctx)
+
+	ned := &l2smv1.NetworkEdgeDevice{}
+	if err := d.Decoder.Decode(req, ned); err != nil {
+		log.Error(err, "Error decoding network edge device")
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if err := applyNetworkEdgeDeviceDefaults(ctx, d.Client, ned); err != nil {
+		log.Error(err, "Error defaulting network edge device")
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return patchDefaults(req, ned)
+}
+
+func (d *NetworkEdgeDeviceDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.Decoder = decoder
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-l2sm-l2sm-k8s-local-v1-networkedgedevice,mutating=false,failurePolicy=fail,sideEffects=None,groups=l2sm.l2sm.k8s.local,resources=networkedgedevices,verbs=create;update,versions=v1,name=vnetworkedgedevice.kb.io,admissionReviewVersions=v1
+
+// NetworkEdgeDeviceValidator rejects network edge devices that can't be deployed or can't reach their neighbors,
+// before the switch is created.
+type NetworkEdgeDeviceValidator struct {
+	Client  client.Client
+	Decoder *admission.Decoder
+}
+
+func (v *NetworkEdgeDeviceValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	log := log.FromContext(ctx)
+
+	ned := &l2smv1.NetworkEdgeDevice{}
+	if err := v.Decoder.Decode(req, ned); err != nil {
+		log.Error(err, "Error decoding network edge device")
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if req.Operation == admissionv1.Update && !ned.DeletionTimestamp.IsZero() {
+		return admission.Allowed("Allowing network edge device's deletion")
+	}
+
+	problems := validateProvider(ned.Spec.Provider)
+	problems = append(problems, validateSwitchTemplate(ned.Spec.SwitchTemplate)...)
+	problems = append(problems, validateNeighbors(ned.Spec.Neighbors)...)
+
+	if ned.Spec.NodeConfig != nil {
+		if _, err := netip.ParseAddr(ned.Spec.NodeConfig.IPAddress); err != nil {
+			problems = append(problems, fmt.Sprintf("nodeConfig ipAddress %q is not a valid IP address", ned.Spec.NodeConfig.IPAddress))
+		}
+		missing, err := missingNodes(ctx, v.Client, []string{ned.Spec.NodeConfig.NodeName})
+		if err != nil {
+			log.Error(err, "Error validating network edge device")
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		for _, node := range missing {
+			problems = append(problems, fmt.Sprintf("node %s doesn't exist", node))
+		}
+	}
+
+	if len(problems) != 0 {
+		return admission.Denied(strings.Join(problems, "; "))
+	}
+	return admission.Allowed("")
+}
+
+// validateNeighbors checks that every neighbor is named once and can be reached at an IP address or domain name.
+func validateNeighbors(neighbors []l2smv1.NeighborSpec) []string {
+	var problems []string
+	seen := make(map[string]bool, len(neighbors))
+	for _, neighbor := range neighbors {
+		switch {
+		case neighbor.Node == "":
+			problems = append(problems, "neighbor node is required")
+		case seen[neighbor.Node]:
+			problems = append(problems, fmt.Sprintf("neighbor %s is declared more than once", neighbor.Node))
+		}
+		seen[neighbor.Node] = true
+		if !validDomain(neighbor.Domain) {
+			problems = append(problems, fmt.Sprintf("neighbor %s domain %q is not a valid IP address or domain name", neighbor.Node, neighbor.Domain))
+		}
+		if neighbor.LpmIp != nil {
+			if _, err := netip.ParsePrefix(*neighbor.LpmIp); err != nil {
+				if _, err := netip.ParseAddr(*neighbor.LpmIp); err != nil {
+					problems = append(problems, fmt.Sprintf("neighbor %s lpmIp %q is not a valid IP address", neighbor.Node, *neighbor.LpmIp))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+func (v *NetworkEdgeDeviceValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.Decoder = decoder
+	return nil
+}
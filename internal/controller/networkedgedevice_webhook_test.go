@@ -0,0 +1,111 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+)
+
+var _ = Describe("NetworkEdgeDevice webhooks", func() {
+	ctx := context.Background()
+	var scheme *runtime.Scheme
+	var c client.Client
+
+	BeforeEach(func() {
+		scheme = runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(l2smv1.AddToScheme(scheme)).To(Succeed())
+		controlPlane := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "control-plane", Labels: map[string]string{"node-role.kubernetes.io/control-plane": ""}},
+			Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}}},
+		}
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(controlPlane).Build()
+	})
+
+	request := func(ned *l2smv1.NetworkEdgeDevice) admission.Request {
+		raw, err := json.Marshal(ned)
+		Expect(err).NotTo(HaveOccurred())
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}}
+	}
+
+	newNED := func(mutate func(spec *l2smv1.NetworkEdgeDeviceSpec)) *l2smv1.NetworkEdgeDevice {
+		ned := &l2smv1.NetworkEdgeDevice{
+			TypeMeta:   metav1.TypeMeta{APIVersion: l2smv1.GroupVersion.String(), Kind: "NetworkEdgeDevice"},
+			ObjectMeta: metav1.ObjectMeta{Name: "ned", Namespace: "default"},
+			Spec: l2smv1.NetworkEdgeDeviceSpec{
+				Provider:   &l2smv1.ProviderSpec{Name: "idco-controller", Domain: []string{"192.168.122.60"}},
+				NodeConfig: &l2smv1.NodeConfigSpec{NodeName: "control-plane", IPAddress: "10.0.0.1"},
+				Neighbors:  []l2smv1.NeighborSpec{{Node: "cluster-b", Domain: "cluster-b.example.org"}},
+			},
+		}
+		if mutate != nil {
+			mutate(&ned.Spec)
+		}
+		return ned
+	}
+
+	It("deploys the device in the control plane node by default", func() {
+		defaulter := &NetworkEdgeDeviceDefaulter{Client: c, Decoder: admission.NewDecoder(scheme)}
+		resp := defaulter.Handle(ctx, request(newNED(func(spec *l2smv1.NetworkEdgeDeviceSpec) { spec.NodeConfig = nil })))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(resp.Patches).To(ContainElement(HaveField("Path", "/spec/nodeConfig")))
+		Expect(resp.Patches).To(ContainElement(HaveField("Path", "/spec/switchTemplate")))
+	})
+
+	DescribeTable("validation of the spec",
+		func(mutate func(spec *l2smv1.NetworkEdgeDeviceSpec), message string) {
+			validator := &NetworkEdgeDeviceValidator{Client: c, Decoder: admission.NewDecoder(scheme)}
+			resp := validator.Handle(ctx, request(newNED(mutate)))
+			if message == "" {
+				Expect(resp.Allowed).To(BeTrue(), resp.Result.Message)
+				return
+			}
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Message).To(ContainSubstring(message))
+		},
+		Entry("a valid device", nil, ""),
+		Entry("a node that doesn't exist", func(spec *l2smv1.NetworkEdgeDeviceSpec) {
+			spec.NodeConfig.NodeName = "node-z"
+		}, "node node-z doesn't exist"),
+		Entry("a malformed node address", func(spec *l2smv1.NetworkEdgeDeviceSpec) {
+			spec.NodeConfig.IPAddress = "10.0.0"
+		}, "not a valid IP address"),
+		Entry("an empty provider domain", func(spec *l2smv1.NetworkEdgeDeviceSpec) {
+			spec.Provider.Domain = []string{}
+		}, "provider domain is required"),
+		Entry("a malformed neighbor domain", func(spec *l2smv1.NetworkEdgeDeviceSpec) {
+			spec.Neighbors[0].Domain = "cluster_b:8080"
+		}, "neighbor cluster-b domain"),
+		Entry("a neighbor declared twice", func(spec *l2smv1.NetworkEdgeDeviceSpec) {
+			spec.Neighbors = append(spec.Neighbors, l2smv1.NeighborSpec{Node: "cluster-b", Domain: "10.0.0.2"})
+		}, "declared more than once"),
+	)
+})
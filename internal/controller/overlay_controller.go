@@ -19,9 +19,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"maps"
-	"time"
+	"slices"
+	"strings"
 
 	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/env"
 	"github.com/Networks-it-uc3m/L2S-M/internal/lpminterface"
 	"github.com/Networks-it-uc3m/L2S-M/internal/monitoringnetwork"
 	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
@@ -36,6 +38,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	talpav1 "github.com/Networks-it-uc3m/l2sm-switch/api/v1"
 )
@@ -93,7 +96,7 @@ func (r *OverlayReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 
 		if !controllerutil.ContainsFinalizer(overlay, l2smFinalizer) {
 
-			//if the object is new, we apply default fields in the first iteration
+			// the defaulting webhook sets the defaults, but the overlays created while it was disabled get them here
 			err = applyOverlayDefaults(overlay)
 			if err != nil {
 				return ctrl.Result{}, fmt.Errorf("failed to apply overlay defaults: %w", err)
@@ -117,7 +120,7 @@ func (r *OverlayReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 			}
 
 			if overlay.Spec.Monitor != nil {
-				if err = r.deleteMonitoringNetwork(overlay); err != nil {
+				if err = r.deleteMonitoringNetwork(ctx, overlay); err != nil {
 					return ctrl.Result{}, err
 				}
 			}
@@ -133,33 +136,37 @@ func (r *OverlayReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, nil
 	}
 
-	var switchReplicaSets appsv1.ReplicaSetList
-	if err = r.List(ctx, &switchReplicaSets, client.InNamespace(req.Namespace), client.MatchingFields{setOwnerKeyOverlay: req.Name}); err != nil {
-		log.Error(err, "unable to list child ReplicaSets")
-		return ctrl.Result{}, err
+	if overlay.Spec.Topology == nil {
+		// the topology is required, but overlays created before it was can lack it
+		log.Info("Overlay has no topology, nothing to deploy", "Overlay", overlay.Name)
+		return ctrl.Result{}, nil
 	}
 
-	if len(switchReplicaSets.Items) == 0 {
-		if err = r.createExternalResources(ctx, overlay); err != nil {
-			log.Error(err, "unable to create ReplicaSet")
-			return ctrl.Result{}, err
-		}
-		log.Info("Overlay Launched")
-		if overlay.Spec.Monitor != nil {
-			if err = r.createMonitoringNetwork(overlay); err != nil {
-				log.Error(err, "could not create monitoring network")
-				return ctrl.Result{}, err
-			}
+	// The resources are brought in line with the topology every time, so nodes and links can be added to or removed
+	// from a running overlay
+	applyErr := r.reconcileExternalResources(ctx, overlay)
+	if applyErr != nil {
+		log.Error(applyErr, "unable to reconcile overlay resources")
+	}
+	var monitorErr error
+	if overlay.Spec.Monitor != nil && applyErr == nil {
+		if monitorErr = r.syncMonitoringNetwork(ctx, overlay); monitorErr != nil {
+			log.Error(monitorErr, "could not sync monitoring network")
 		}
-		return ctrl.Result{RequeueAfter: time.Second * 20}, nil
 	}
-	// else {
-
-	// 	//b, _ := json.Marshal(netEdgeDevice.Spec.Neighbors)
 
-	// }
+	if err = r.updateOverlayStatus(ctx, overlay, applyErr, monitorErr); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to update overlay status: %w", err)
+	}
+	if applyErr != nil {
+		return ctrl.Result{}, applyErr
+	}
+	if monitorErr != nil {
+		return ctrl.Result{}, monitorErr
+	}
 
-	return ctrl.Result{}, nil
+	// Switch readiness is picked up through the owned replica sets, but the controller connection has to be polled
+	return ctrl.Result{RequeueAfter: env.GetSDNResyncInterval()}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -232,6 +239,10 @@ func (r *OverlayReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	}); err != nil {
 		return err
 	}
+	// The link metrics are scraped from the collectors on their own schedule, independent from the reconciliations
+	if err := mgr.Add(manager.RunnableFunc(r.collectLinkMetrics)); err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&l2smv1.Overlay{}).
 		Owns(&appsv1.ReplicaSet{}).
@@ -246,27 +257,29 @@ func (r *OverlayReconciler) monitoringClientFactory() monitoringnetwork.ClientFa
 		return r.MonitoringClientFactory
 	}
 
-	return monitoringnetwork.DefaultClientFactory{}
+	return monitoringnetwork.DefaultClientFactory{Reader: r.Client}
 }
 
-func (r *OverlayReconciler) createMonitoringNetwork(overlay *l2smv1.Overlay) error {
-	client, err := r.monitoringClientFactory().Internal()
+// syncMonitoringNetwork creates the monitoring network if needed and attaches the probe port of every node in the
+// topology to it, detaching the ones of the nodes that were removed.
+func (r *OverlayReconciler) syncMonitoringNetwork(ctx context.Context, overlay *l2smv1.Overlay) error {
+	client, err := r.monitoringClientFactory().Internal(ctx)
 	if err != nil {
 		return err
 	}
 
 	manager := monitoringnetwork.Manager{Client: client}
-	return manager.Ensure(overlay.Name, l2smv1.OVERLAY_PROVIDER, overlay.Spec.Topology.Nodes)
+	return manager.Sync(ctx, overlay.Name, l2smv1.OVERLAY_PROVIDER, overlay.Spec.Topology.Nodes)
 }
 
-func (r *OverlayReconciler) deleteMonitoringNetwork(overlay *l2smv1.Overlay) error {
-	client, err := r.monitoringClientFactory().Internal()
+func (r *OverlayReconciler) deleteMonitoringNetwork(ctx context.Context, overlay *l2smv1.Overlay) error {
+	client, err := r.monitoringClientFactory().Internal(ctx)
 	if err != nil {
 		return err
 	}
 
 	manager := monitoringnetwork.Manager{Client: client}
-	return manager.Delete(overlay.Name)
+	return manager.Delete(ctx, overlay.Name)
 }
 
 func (r *OverlayReconciler) deleteExternalResources(ctx context.Context, overlay *l2smv1.Overlay) error {
@@ -292,22 +305,22 @@ type NodeJson struct {
 	NodeIP string `json:"nodeIP"`
 }
 
-// createExternalResources is the main orchestrator.
-// It calls helpers to build objects, then creates them in the cluster.
-func (r *OverlayReconciler) createExternalResources(ctx context.Context, overlay *l2smv1.Overlay) error {
+// buildExternalResources is the main orchestrator.
+// It calls helpers to build every object the overlay needs, owned by the overlay.
+func (r *OverlayReconciler) buildExternalResources(overlay *l2smv1.Overlay) ([]client.Object, error) {
 
 	var extResources []client.Object
 
 	// 1. Build and Create the ConfigMap
 	configMap, err := r.buildTopologyConfigMap(overlay)
 	if err != nil {
-		return fmt.Errorf("failed to build config map: %w", err)
+		return nil, fmt.Errorf("failed to build config map: %w", err)
 	}
 	extResources = append(extResources, configMap)
 	// 2. Build and Create Network Attachment Definitions
 	netAttachDefs, err := r.buildNetworkAttachmentDefinitions(overlay)
 	if err != nil {
-		return fmt.Errorf("failed to build net attach defs: %w", err)
+		return nil, fmt.Errorf("failed to build net attach defs: %w", err)
 	}
 	for _, nad := range netAttachDefs {
 		extResources = append(extResources, nad)
@@ -316,7 +329,7 @@ func (r *OverlayReconciler) createExternalResources(ctx context.Context, overlay
 	// 3. Build and Create Node Resources (ReplicaSets and Services)
 	replicaSets, services, err := r.buildNodeResources(overlay, configMap.Name)
 	if err != nil {
-		return fmt.Errorf("failed to build node resources: %w", err)
+		return nil, fmt.Errorf("failed to build node resources: %w", err)
 	}
 	for _, rs := range replicaSets {
 		extResources = append(extResources, rs)
@@ -348,7 +361,7 @@ func (r *OverlayReconciler) createExternalResources(ctx context.Context, overlay
 			// Build exporter resources. Disclaimer: exporter is the prometheus exporter that retrieves metric from the collector instances.
 			exporterDeployment, exporterConfig, exporterService, err := lpmExporter.BuildResources(overlay.Spec.Monitor.ExportMetrics.ServiceAccount, targets)
 			if err != nil {
-				return fmt.Errorf("failed to build monitoring resources: %w", err)
+				return nil, fmt.Errorf("failed to build monitoring resources: %w", err)
 			}
 			extResources = append(extResources, exporterDeployment, exporterConfig, exporterService)
 		}
@@ -364,7 +377,7 @@ func (r *OverlayReconciler) createExternalResources(ctx context.Context, overlay
 			overlay,
 			collectorBuildOptions)
 		if err != nil {
-			return fmt.Errorf("failed to build collector monitoring resources; continuing without monitoring %w", err)
+			return nil, fmt.Errorf("failed to build collector monitoring resources; continuing without monitoring %w", err)
 		}
 		for _, rs := range replicaSets {
 			lpminterface.AddLPMConfigMapToSps(&rs.Spec.Template.Spec)
@@ -376,16 +389,26 @@ func (r *OverlayReconciler) createExternalResources(ctx context.Context, overlay
 		}
 	}
 
+	// Every switch is stamped with the hash of the configuration it reads, so that only the ones whose configuration
+	// changed are restarted when the topology does
+	monitorConfigs := map[string]string{}
 	for _, obj := range extResources {
-		if err := controllerutil.SetControllerReference(overlay, obj, r.Scheme); err != nil {
-			return fmt.Errorf("failed to set controller reference to obj %s: %w", obj.GetName(), err)
+		if cm, ok := obj.(*corev1.ConfigMap); ok {
+			monitorConfigs[cm.Name] = strings.Join(slices.Sorted(maps.Values(cm.Data)), "")
 		}
-		if err := r.Client.Create(ctx, obj); err != nil {
-			return fmt.Errorf("failed to create %s %s: %w", obj.GetObjectKind().GroupVersionKind().Kind, obj.GetName(), err)
+	}
+	for i, rs := range replicaSets {
+		node := overlay.Spec.Topology.Nodes[i]
+		rs.Spec.Template.Annotations[switchConfigHashAnnotation] = switchConfigHash(configMap.Data["config.json"], nodeLinks(overlay.Spec.Topology.Links, node), monitorConfigs[lpminterface.GenerateConfigmapName(rs.Name)])
+	}
+
+	for _, obj := range extResources {
+		if err := controllerutil.SetControllerReference(overlay, obj, r.Scheme); err != nil {
+			return nil, fmt.Errorf("failed to set controller reference to obj %s: %w", obj.GetName(), err)
 		}
 	}
 
-	return nil
+	return extResources, nil
 }
 
 // -----------------------------------------------------------------------------
@@ -452,18 +475,15 @@ func (r *OverlayReconciler) buildNetworkAttachmentDefinitions(overlay *l2smv1.Ov
 	var defs []*nettypes.NetworkAttachmentDefinition
 
 	for i := 1; i <= overlay.Spec.InterfaceNumber; i++ {
-		configSpec := fmt.Sprintf(`{
-            "cniVersion": "0.3.0",
-            "type": "bridge",
-            "bridge": "%sbr%d",
-            "mtu": 1400,
-            "device": "%s-veth%d",
-            "ipam": { "type":"static" }
-        }`, "", i, overlay.Name, i)
+		name := fmt.Sprintf("%s-veth%d", overlay.Name, i)
+		configSpec, err := interfaceConfig(overlay, name, i)
+		if err != nil {
+			return nil, err
+		}
 
 		nad := &nettypes.NetworkAttachmentDefinition{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      fmt.Sprintf("%s-veth%d", overlay.Name, i),
+				Name:      name,
 				Namespace: overlay.Namespace,
 				Labels:    map[string]string{"app": "l2sm", "overlay": overlay.Name},
 			},
@@ -477,6 +497,41 @@ func (r *OverlayReconciler) buildNetworkAttachmentDefinitions(overlay *l2smv1.Ov
 	return defs, nil
 }
 
+// interfaceConfig returns the CNI configuration of an interface of the switches. The bridge plugin plugs the pod
+// in the interface with the options of the overlay, the static IPAM plugin sets the addresses of the pod annotation,
+// and the tuning plugin applies the MTU of the network the pod attaches to, which is passed as a CNI argument.
+func interfaceConfig(overlay *l2smv1.Overlay, name string, index int) (string, error) {
+	options := l2smv1.InterfaceOptions{}
+	if overlay.Spec.InterfaceOptions != nil {
+		options = *overlay.Spec.InterfaceOptions
+	}
+	options.MTU = overlayInterfaceMTU(overlay)
+
+	bridge := map[string]any{
+		"type":   "bridge",
+		"bridge": fmt.Sprintf("br%d", index),
+		"mtu":    options.MTU,
+		"device": name,
+		"ipam":   map[string]string{"type": "static"},
+	}
+	if options.VLAN != 0 {
+		bridge["vlan"] = options.VLAN
+	}
+	if options.MacSpoofCheck {
+		bridge["macspoofchk"] = true
+	}
+
+	config, err := json.Marshal(map[string]any{
+		"cniVersion": "0.3.1",
+		"name":       name,
+		"plugins":    []any{bridge, map[string]string{"type": "tuning"}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal the configuration of interface %s: %w", name, err)
+	}
+	return string(config), nil
+}
+
 // buildNodeResources constructs the ReplicaSets and Headless Services for every node in the topology
 func (r *OverlayReconciler) buildNodeResources(overlay *l2smv1.Overlay, configMapName string) ([]*appsv1.ReplicaSet, []*corev1.Service, error) {
 	var replicaSets []*appsv1.ReplicaSet
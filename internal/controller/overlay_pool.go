@@ -0,0 +1,75 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
+)
+
+// defaultPoolStep is how many interfaces an elastic pool grows by if the overlay doesn't set it.
+const defaultPoolStep = 5
+
+// interfacePool returns the size of the interface pool of the switches and the usage of every node. The pool is as
+// large as the interface number of the spec, or as the network attachment definitions of the overlay if it grew past
+// it. An interface is used in a node while its network attachment definition is labelled as taken there.
+func (r *OverlayReconciler) interfacePool(ctx context.Context, overlay *l2smv1.Overlay) (l2smv1.InterfacePoolStatus, error) {
+	netAttachDefs := &nettypes.NetworkAttachmentDefinitionList{}
+	err := r.List(ctx, netAttachDefs, client.InNamespace(overlay.Namespace), client.MatchingLabels{"app": "l2sm", "overlay": overlay.Name})
+	if err != nil {
+		return l2smv1.InterfacePoolStatus{}, fmt.Errorf("failed to list the interfaces of the overlay: %w", err)
+	}
+
+	pool := l2smv1.InterfacePoolStatus{Size: max(overlay.Spec.InterfaceNumber, len(netAttachDefs.Items))}
+	for _, node := range overlay.Spec.Topology.Nodes {
+		usage := l2smv1.NodeInterfaceUsage{Node: node}
+		for _, netAttachDef := range netAttachDefs.Items {
+			if netAttachDef.Labels[networkannotation.NET_ATTACH_LABEL_PREFIX+node] == "true" {
+				usage.Used++
+			}
+		}
+		usage.Free = max(pool.Size-usage.Used, 0)
+		pool.Nodes = append(pool.Nodes, usage)
+	}
+	return pool, nil
+}
+
+// grownPoolSize returns the size the pool has to have for the usage of its nodes. An elastic pool grows by a step,
+// up to its maximum size, when any node is left with fewer free interfaces than the threshold.
+func grownPoolSize(spec *l2smv1.InterfacePoolSpec, pool l2smv1.InterfacePoolStatus) int {
+	if spec == nil {
+		return pool.Size
+	}
+	minFree, step, maxSize := spec.MinFree, spec.Step, spec.MaxSize
+	if step == 0 {
+		step = defaultPoolStep
+	}
+	if maxSize == 0 || maxSize > maxOverlayInterfaces {
+		maxSize = maxOverlayInterfaces
+	}
+
+	for _, usage := range pool.Nodes {
+		if usage.Free < minFree {
+			return max(pool.Size, min(pool.Size+step, maxSize))
+		}
+	}
+	return pool.Size
+}
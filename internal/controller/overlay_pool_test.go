@@ -0,0 +1,183 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+)
+
+var _ = Describe("Overlay interface pool", func() {
+	ctx := context.Background()
+	var scheme *runtime.Scheme
+
+	BeforeEach(func() {
+		scheme = runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(appsv1.AddToScheme(scheme)).To(Succeed())
+		Expect(nettypes.AddToScheme(scheme)).To(Succeed())
+		Expect(l2smv1.AddToScheme(scheme)).To(Succeed())
+	})
+
+	overlay := &l2smv1.Overlay{
+		ObjectMeta: metav1.ObjectMeta{Name: "overlay", Namespace: "l2sm-system"},
+		Spec: l2smv1.OverlaySpec{
+			InterfaceNumber: 3,
+			Topology:        &l2smv1.TopologySpec{Nodes: []string{"node-a", "node-b"}},
+		},
+	}
+
+	netAttachDef := func(index int, usedIn ...string) client.Object {
+		labels := map[string]string{"app": "l2sm", "overlay": "overlay"}
+		for _, node := range usedIn {
+			labels["used-"+node] = "true"
+		}
+		return &nettypes.NetworkAttachmentDefinition{ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("overlay-veth%d", index),
+			Namespace: "l2sm-system",
+			Labels:    labels,
+		}}
+	}
+
+	It("should report the usage of the interfaces of every node", func() {
+		r := &OverlayReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			netAttachDef(1, "node-a", "node-b"), netAttachDef(2, "node-a"), netAttachDef(3), netAttachDef(4),
+		).Build()}
+
+		pool, err := r.interfacePool(ctx, overlay)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pool.Size).To(Equal(4))
+		Expect(pool.Nodes).To(ConsistOf(
+			l2smv1.NodeInterfaceUsage{Node: "node-a", Used: 2, Free: 2},
+			l2smv1.NodeInterfaceUsage{Node: "node-b", Used: 1, Free: 3},
+		))
+	})
+
+	DescribeTable("growth of the pool",
+		func(spec *l2smv1.InterfacePoolSpec, free, size int) {
+			pool := l2smv1.InterfacePoolStatus{Size: 10, Nodes: []l2smv1.NodeInterfaceUsage{
+				{Node: "node-a", Used: 10 - free, Free: free},
+				{Node: "node-b", Used: 0, Free: 10},
+			}}
+			Expect(grownPoolSize(spec, pool)).To(Equal(size))
+		},
+		Entry("a fixed pool", nil, 0, 10),
+		Entry("enough free interfaces", &l2smv1.InterfacePoolSpec{MinFree: 2, Step: 5}, 2, 10),
+		Entry("a node below the threshold", &l2smv1.InterfacePoolSpec{MinFree: 2, Step: 5}, 1, 15),
+		Entry("a pool close to its maximum", &l2smv1.InterfacePoolSpec{MinFree: 2, Step: 5, MaxSize: 12}, 1, 12),
+		Entry("a pool at its maximum", &l2smv1.InterfacePoolSpec{MinFree: 2, Step: 5, MaxSize: 10}, 0, 10),
+	)
+
+	It("should skip overlays without topology", func() {
+		legacy := &l2smv1.Overlay{ObjectMeta: metav1.ObjectMeta{Name: "legacy", Namespace: "l2sm-system"}}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(legacy).Build()
+		r := &OverlayReconciler{Client: c, Scheme: scheme}
+
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(legacy)})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.refreshLinkMetrics(ctx)).To(Succeed())
+
+		replicaSets := &appsv1.ReplicaSetList{}
+		Expect(c.List(ctx, replicaSets)).To(Succeed())
+		Expect(replicaSets.Items).To(BeEmpty())
+	})
+
+	Describe("restart of the switches", func() {
+		replicaSet := func(node string) *appsv1.ReplicaSet {
+			return &appsv1.ReplicaSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "switch-" + node, Namespace: "l2sm-system"},
+				Spec: appsv1.ReplicaSetSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"switch": node}},
+					Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{
+						Annotations: map[string]string{switchConfigHashAnnotation: "new"},
+					}},
+				},
+			}
+		}
+		switchPod := func(node, hash string, ready bool) *corev1.Pod {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "switch-" + node + "-pod",
+					Namespace:   "l2sm-system",
+					Labels:      map[string]string{"switch": node},
+					Annotations: map[string]string{switchConfigHashAnnotation: hash},
+				},
+				Status: corev1.PodStatus{Phase: corev1.PodRunning},
+			}
+			if ready {
+				pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+			}
+			return pod
+		}
+		exists := func(c client.Client, pod *corev1.Pod) bool {
+			err := c.Get(ctx, client.ObjectKeyFromObject(pod), &corev1.Pod{})
+			Expect(client.IgnoreNotFound(err)).NotTo(HaveOccurred())
+			return !apierrors.IsNotFound(err)
+		}
+		replicaSets := []*appsv1.ReplicaSet{replicaSet("node-a"), replicaSet("node-b")}
+
+		It("should restart one ready switch at a time", func() {
+			podA, podB := switchPod("node-a", "old", true), switchPod("node-b", "old", true)
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(podA, podB).Build()
+			r := &OverlayReconciler{Client: c}
+
+			Expect(r.restartOutdatedSwitches(ctx, replicaSets)).To(Succeed())
+			Expect(exists(c, podA)).To(BeFalse())
+			Expect(exists(c, podB)).To(BeTrue())
+
+			// the next switch waits until the restarted one is back
+			Expect(r.restartOutdatedSwitches(ctx, replicaSets)).To(Succeed())
+			Expect(exists(c, podB)).To(BeTrue())
+
+			Expect(c.Create(ctx, switchPod("node-a", "new", true))).To(Succeed())
+			Expect(r.restartOutdatedSwitches(ctx, replicaSets)).To(Succeed())
+			Expect(exists(c, podB)).To(BeFalse())
+		})
+
+		It("should restart switches that are down right away", func() {
+			podA, podB := switchPod("node-a", "old", false), switchPod("node-b", "old", true)
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(podA, podB).Build()
+			r := &OverlayReconciler{Client: c}
+
+			Expect(r.restartOutdatedSwitches(ctx, replicaSets)).To(Succeed())
+			Expect(exists(c, podA)).To(BeFalse())
+			Expect(exists(c, podB)).To(BeTrue())
+		})
+
+		It("should restart switches launched before the configuration hash", func() {
+			pod := switchPod("node-a", "", true)
+			delete(pod.Annotations, switchConfigHashAnnotation)
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pod, switchPod("node-b", "new", true)).Build()
+			r := &OverlayReconciler{Client: c}
+
+			Expect(r.restartOutdatedSwitches(ctx, replicaSets)).To(Succeed())
+			Expect(exists(c, pod)).To(BeFalse())
+		})
+	})
+})
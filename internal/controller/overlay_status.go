@@ -0,0 +1,89 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/utils"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// updateOverlayStatus reports the state of the switches of every node, the result of applying the topology and of
+// syncing the monitoring network, and whether the SDN controller is reachable.
+func (r *OverlayReconciler) updateOverlayStatus(ctx context.Context, overlay *l2smv1.Overlay, applyErr, monitorErr error) error {
+	generation := overlay.Generation
+
+	var nodes []l2smv1.NodeSwitchStatus
+	ready := 0
+	for _, node := range overlay.Spec.Topology.Nodes {
+		name := utils.GenerateReplicaSetName(utils.GenerateSwitchPodName(overlay.Name, node, utils.SlicePacketSwitch))
+		status, err := switchStatus(ctx, r.Client, client.ObjectKey{Namespace: overlay.Namespace, Name: name}, node)
+		if err != nil {
+			return err
+		}
+		if status.Ready {
+			ready++
+		}
+		nodes = append(nodes, status)
+	}
+
+	pool, err := r.interfacePool(ctx, overlay)
+	if err != nil {
+		return err
+	}
+
+	_, controllerErr := r.monitoringClientFactory().Internal(ctx)
+
+	conditions := []metav1.Condition{
+		switchesReadyCondition(nodes, generation),
+		resultCondition(l2smv1.TopologyAppliedCondition, "Applied", "ApplyFailed", applyErr, generation),
+		resultCondition(l2smv1.ControllerConnectedCondition, "Connected", "Unreachable", controllerErr, generation),
+	}
+	if overlay.Spec.Monitor != nil {
+		conditions = append(conditions, resultCondition(l2smv1.MonitoringReadyCondition, "Synced", "SyncFailed", monitorErr, generation))
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &l2smv1.Overlay{}
+		if err := r.Get(ctx, client.ObjectKeyFromObject(overlay), latest); err != nil {
+			return err
+		}
+		status := latest.Status.DeepCopy()
+		status.Nodes = nodes
+		status.ReadySwitches = fmt.Sprintf("%d/%d", ready, len(nodes))
+		status.InterfacePool = &pool
+		status.ObservedGeneration = generation
+		if overlay.Spec.Monitor == nil {
+			meta.RemoveStatusCondition(&status.Conditions, l2smv1.MonitoringReadyCondition)
+		}
+		for _, condition := range conditions {
+			meta.SetStatusCondition(&status.Conditions, condition)
+		}
+		status.Phase = fabricPhase(status.Conditions, ready)
+
+		if equality.Semantic.DeepEqual(status, &latest.Status) {
+			return nil
+		}
+		latest.Status = *status
+		return r.Status().Update(ctx, latest)
+	})
+}
@@ -0,0 +1,204 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// switchConfigHashAnnotation is set in the pod template of every switch with the hash of the configuration it
+	// reads at startup.
+	switchConfigHashAnnotation = "l2sm/config-hash"
+	overlayFieldOwner          = "l2sm-overlay-controller"
+)
+
+// reconcileExternalResources applies every resource the overlay needs, with as many interfaces as the pool has,
+// restarts the switches whose configuration changed and deletes the per node resources of the nodes that are no
+// longer in the topology.
+func (r *OverlayReconciler) reconcileExternalResources(ctx context.Context, overlay *l2smv1.Overlay) error {
+	logger := log.FromContext(ctx)
+
+	pool, err := r.interfacePool(ctx, overlay)
+	if err != nil {
+		return err
+	}
+	// the switches are built with the size of the pool, which is larger than the interface number once it grew
+	sized := overlay.DeepCopy()
+	sized.Spec.InterfaceNumber = grownPoolSize(overlay.Spec.InterfacePool, pool)
+	if sized.Spec.InterfaceNumber > pool.Size {
+		logger.Info("Nodes running out of interfaces, growing the interface pool", "from", pool.Size, "to", sized.Spec.InterfaceNumber)
+	}
+
+	resources, err := r.buildExternalResources(sized)
+	if err != nil {
+		return err
+	}
+
+	desired := map[string]bool{}
+	var replicaSets []*appsv1.ReplicaSet
+	for _, obj := range resources {
+		gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+		if err != nil {
+			return err
+		}
+		desired[gvk.Kind+"/"+obj.GetName()] = true
+
+		if replicaSet, ok := obj.(*appsv1.ReplicaSet); ok {
+			replicaSets = append(replicaSets, replicaSet)
+		}
+
+		obj.GetObjectKind().SetGroupVersionKind(gvk)
+		if err := r.Patch(ctx, obj, client.Apply, client.FieldOwner(overlayFieldOwner), client.ForceOwnership); err != nil {
+			return fmt.Errorf("failed to apply %s %s: %w", gvk.Kind, obj.GetName(), err)
+		}
+	}
+
+	if err := r.restartOutdatedSwitches(ctx, replicaSets); err != nil {
+		return err
+	}
+	return r.pruneExternalResources(ctx, overlay, desired)
+}
+
+// restartOutdatedSwitches restarts the switches that run with an older configuration than the one of their replica
+// set, which doesn't replace its pods when the template changes. Switches that are down are restarted right away, but
+// the ones that are up are restarted one at a time, and only once every other switch is ready, so that growing the
+// interface pool or changing the topology never cuts more than one node off the overlay. The replica sets report when
+// the restarted switch is ready again, which triggers the restart of the next one. Switches that were launched before
+// the configuration hash existed are outdated too, as they may lack the interfaces the pool has grown since.
+func (r *OverlayReconciler) restartOutdatedSwitches(ctx context.Context, replicaSets []*appsv1.ReplicaSet) error {
+	logger := log.FromContext(ctx)
+
+	var outdatedPods []*corev1.Pod
+	allReady := true
+	for _, replicaSet := range replicaSets {
+		pods := &corev1.PodList{}
+		if err := r.List(ctx, pods, client.InNamespace(replicaSet.Namespace), client.MatchingLabels(replicaSet.Spec.Selector.MatchLabels)); err != nil {
+			return fmt.Errorf("failed to list the pods of switch %s: %w", replicaSet.Name, err)
+		}
+		running := false
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if !pod.DeletionTimestamp.IsZero() {
+				continue
+			}
+			running = true
+			ready, _ := podReadiness(pod)
+			outdated := pod.Annotations[switchConfigHashAnnotation] != replicaSet.Spec.Template.Annotations[switchConfigHashAnnotation]
+			switch {
+			case outdated && !ready:
+				if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+					return fmt.Errorf("failed to restart switch %s: %w", replicaSet.Name, err)
+				}
+				logger.Info("Switch configuration changed, restarting it", "replicaset", replicaSet.Name)
+				allReady = false
+			case outdated:
+				outdatedPods = append(outdatedPods, pod)
+			case !ready:
+				allReady = false
+			}
+		}
+		allReady = allReady && running
+	}
+
+	if len(outdatedPods) == 0 || !allReady {
+		return nil
+	}
+	if err := r.Delete(ctx, outdatedPods[0]); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to restart switch pod %s: %w", outdatedPods[0].Name, err)
+	}
+	logger.Info("Switch configuration changed, restarting it", "pod", outdatedPods[0].Name, "pending", len(outdatedPods)-1)
+	return nil
+}
+
+// pruneExternalResources deletes the replica sets, services and config maps of the overlay that are not desired
+// anymore, which are the ones of the nodes removed from the topology.
+func (r *OverlayReconciler) pruneExternalResources(ctx context.Context, overlay *l2smv1.Overlay, desired map[string]bool) error {
+	logger := log.FromContext(ctx)
+	opts := []client.ListOption{client.InNamespace(overlay.Namespace), client.MatchingFields{setOwnerKeyOverlay: overlay.Name}}
+
+	var owned []client.Object
+	replicaSets := &appsv1.ReplicaSetList{}
+	if err := r.List(ctx, replicaSets, opts...); err != nil {
+		return fmt.Errorf("unable to list child ReplicaSets: %w", err)
+	}
+	for i := range replicaSets.Items {
+		owned = append(owned, &replicaSets.Items[i])
+	}
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services, opts...); err != nil {
+		return fmt.Errorf("unable to list child Services: %w", err)
+	}
+	for i := range services.Items {
+		owned = append(owned, &services.Items[i])
+	}
+	configMaps := &corev1.ConfigMapList{}
+	if err := r.List(ctx, configMaps, opts...); err != nil {
+		return fmt.Errorf("unable to list child ConfigMaps: %w", err)
+	}
+	for i := range configMaps.Items {
+		owned = append(owned, &configMaps.Items[i])
+	}
+
+	for _, obj := range owned {
+		gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+		if err != nil {
+			return err
+		}
+		if desired[gvk.Kind+"/"+obj.GetName()] {
+			continue
+		}
+		if err := r.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete %s %s: %w", gvk.Kind, obj.GetName(), err)
+		}
+		logger.Info("Deleted resource of a node no longer in the overlay", "kind", gvk.Kind, "name", obj.GetName())
+	}
+	return nil
+}
+
+// nodeLinks returns the links of the topology that have the node as one of their endpoints.
+func nodeLinks(links []l2smv1.Link, node string) []l2smv1.Link {
+	var result []l2smv1.Link
+	for _, link := range links {
+		if link.EndpointA == node || link.EndpointB == node {
+			result = append(result, link)
+		}
+	}
+	return result
+}
+
+// switchConfigHash hashes the part of the overlay configuration a switch depends on: the general settings, the links
+// to its neighbours and, if the overlay is monitored, the configuration of its collector.
+func switchConfigHash(settings string, links []l2smv1.Link, monitorConfig string) string {
+	b, _ := json.Marshal(struct {
+		Settings string
+		Links    []l2smv1.Link
+		Monitor  string
+	}{settings, links, monitorConfig})
+	hash := sha1.Sum(b)
+	return hex.EncodeToString(hash[:5])
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+)
+
+var _ = Describe("Overlay topology updates", func() {
+	links := []l2smv1.Link{
+		{EndpointA: "node-a", EndpointB: "node-b"},
+		{EndpointA: "node-b", EndpointB: "node-c"},
+	}
+
+	It("should only change the hash of the switches whose links changed", func() {
+		extended := append(links, l2smv1.Link{EndpointA: "node-c", EndpointB: "node-d"})
+
+		Expect(switchConfigHash("{}", nodeLinks(extended, "node-a"), "")).To(Equal(switchConfigHash("{}", nodeLinks(links, "node-a"), "")))
+		Expect(switchConfigHash("{}", nodeLinks(extended, "node-c"), "")).NotTo(Equal(switchConfigHash("{}", nodeLinks(links, "node-c"), "")))
+	})
+
+	It("should change the hash of every switch when the settings change", func() {
+		Expect(switchConfigHash(`{"ControllerPort":"6633"}`, nodeLinks(links, "node-a"), "")).NotTo(Equal(switchConfigHash(`{"ControllerPort":"6653"}`, nodeLinks(links, "node-a"), "")))
+	})
+})
+
+var _ = Describe("Overlay interface configuration", func() {
+	plugins := func(options *l2smv1.InterfaceOptions) []map[string]any {
+		overlay := &l2smv1.Overlay{
+			ObjectMeta: metav1.ObjectMeta{Name: "overlay"},
+			Spec:       l2smv1.OverlaySpec{InterfaceNumber: 1, InterfaceOptions: options},
+		}
+		defs, err := (&OverlayReconciler{}).buildNetworkAttachmentDefinitions(overlay)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(defs).To(HaveLen(1))
+
+		config := struct {
+			Name    string           `json:"name"`
+			Plugins []map[string]any `json:"plugins"`
+		}{}
+		Expect(json.Unmarshal([]byte(defs[0].Spec.Config), &config)).To(Succeed())
+		Expect(config.Name).To(Equal("overlay-veth1"))
+		Expect(config.Plugins).To(HaveLen(2))
+		Expect(config.Plugins[1]).To(HaveKeyWithValue("type", "tuning"))
+		return config.Plugins
+	}
+
+	It("should use an MTU of 1400 by default", func() {
+		bridge := plugins(nil)[0]
+		Expect(bridge).To(HaveKeyWithValue("type", "bridge"))
+		Expect(bridge).To(HaveKeyWithValue("mtu", BeNumerically("==", 1400)))
+		Expect(bridge).NotTo(HaveKey("vlan"))
+		Expect(bridge).NotTo(HaveKey("macspoofchk"))
+	})
+
+	It("should apply the interface options of the overlay", func() {
+		bridge := plugins(&l2smv1.InterfaceOptions{MTU: 9000, VLAN: 100, MacSpoofCheck: true})[0]
+		Expect(bridge).To(HaveKeyWithValue("mtu", BeNumerically("==", 9000)))
+		Expect(bridge).To(HaveKeyWithValue("vlan", BeNumerically("==", 100)))
+		Expect(bridge).To(HaveKeyWithValue("macspoofchk", true))
+	})
+})
@@ -0,0 +1,149 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// maxOverlayInterfaces is the highest number of interfaces a switch can have, as their index is part of the link local
+// address of the interface, in two decimal digits.
+const maxOverlayInterfaces = 99
+
+// +kubebuilder:webhook:path=/mutate-l2sm-l2sm-k8s-local-v1-overlay,mutating=true,failurePolicy=fail,sideEffects=None,groups=l2sm.l2sm.k8s.local,resources=overlays,verbs=create;update,versions=v1,name=moverlay.kb.io,admissionReviewVersions=v1
+
+// OverlayDefaulter sets the default switch template of the overlays that don't have one.
+type OverlayDefaulter struct {
+	Decoder *admission.Decoder
+}
+
+func (d *OverlayDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	overlay := &l2smv1.Overlay{}
+	if err := d.Decoder.Decode(req, overlay); err != nil {
+		log.FromContext(ctx).Error(err, "Error decoding overlay")
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if err := applyOverlayDefaults(overlay); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return patchDefaults(req, overlay)
+}
+
+func (d *OverlayDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.Decoder = decoder
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-l2sm-l2sm-k8s-local-v1-overlay,mutating=false,failurePolicy=fail,sideEffects=None,groups=l2sm.l2sm.k8s.local,resources=overlays,verbs=create;update,versions=v1,name=voverlay.kb.io,admissionReviewVersions=v1
+
+// OverlayValidator rejects overlays whose topology can't be deployed, before any switch is created.
+type OverlayValidator struct {
+	Client  client.Client
+	Decoder *admission.Decoder
+}
+
+func (v *OverlayValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	log := log.FromContext(ctx)
+
+	overlay := &l2smv1.Overlay{}
+	if err := v.Decoder.Decode(req, overlay); err != nil {
+		log.Error(err, "Error decoding overlay")
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if req.Operation == admissionv1.Update && !overlay.DeletionTimestamp.IsZero() {
+		return admission.Allowed("Allowing overlay's deletion")
+	}
+
+	problems := validateProvider(overlay.Spec.Provider)
+	problems = append(problems, validateSwitchTemplate(overlay.Spec.SwitchTemplate)...)
+	if overlay.Spec.InterfaceNumber < 1 || overlay.Spec.InterfaceNumber > maxOverlayInterfaces {
+		problems = append(problems, fmt.Sprintf("interfaceNumber must be between 1 and %d", maxOverlayInterfaces))
+	}
+	if options := overlay.Spec.InterfaceOptions; options != nil {
+		if options.MTU != 0 && (options.MTU < minInterfaceMTU || options.MTU > maxInterfaceMTU) {
+			problems = append(problems, fmt.Sprintf("interface MTU must be between %d and %d", minInterfaceMTU, maxInterfaceMTU))
+		}
+		if options.VLAN < 0 || options.VLAN > 4094 {
+			problems = append(problems, "interface VLAN must be between 0 and 4094")
+		}
+	}
+	if pool := overlay.Spec.InterfacePool; pool != nil {
+		if pool.MinFree < 0 || pool.Step < 0 {
+			problems = append(problems, "interfacePool minFree and step can't be negative")
+		}
+		if pool.MaxSize != 0 && (pool.MaxSize < overlay.Spec.InterfaceNumber || pool.MaxSize > maxOverlayInterfaces) {
+			problems = append(problems, fmt.Sprintf("interfacePool maxSize must be between the interfaceNumber and %d", maxOverlayInterfaces))
+		}
+	}
+	problems = append(problems, validateTopology(overlay.Spec.Topology)...)
+
+	if overlay.Spec.Topology != nil {
+		missing, err := missingNodes(ctx, v.Client, overlay.Spec.Topology.Nodes)
+		if err != nil {
+			log.Error(err, "Error validating overlay")
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		for _, node := range missing {
+			problems = append(problems, fmt.Sprintf("node %s doesn't exist", node))
+		}
+	}
+
+	if len(problems) != 0 {
+		return admission.Denied(strings.Join(problems, "; "))
+	}
+	return admission.Allowed("")
+}
+
+// validateTopology checks that the topology declares its nodes once, and that the links join two different declared
+// nodes.
+func validateTopology(topology *l2smv1.TopologySpec) []string {
+	if topology == nil || len(topology.Nodes) == 0 {
+		return []string{"topology must have at least one node"}
+	}
+
+	var problems []string
+	declared := make(map[string]bool, len(topology.Nodes))
+	for _, node := range topology.Nodes {
+		if declared[node] {
+			problems = append(problems, fmt.Sprintf("node %s is declared more than once", node))
+		}
+		declared[node] = true
+	}
+	for _, link := range topology.Links {
+		for _, endpoint := range []string{link.EndpointA, link.EndpointB} {
+			if !declared[endpoint] {
+				problems = append(problems, fmt.Sprintf("link %s-%s references node %s, which is not in the topology", link.EndpointA, link.EndpointB, endpoint))
+			}
+		}
+		if link.EndpointA == link.EndpointB {
+			problems = append(problems, fmt.Sprintf("link %s-%s joins a node with itself", link.EndpointA, link.EndpointB))
+		}
+	}
+	return problems
+}
+
+func (v *OverlayValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.Decoder = decoder
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+)
+
+var _ = Describe("Overlay webhooks", func() {
+	ctx := context.Background()
+	var scheme *runtime.Scheme
+	var validator *OverlayValidator
+
+	BeforeEach(func() {
+		scheme = runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(l2smv1.AddToScheme(scheme)).To(Succeed())
+		nodes := []*corev1.Node{
+			{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}},
+		}
+		builder := fake.NewClientBuilder().WithScheme(scheme)
+		for _, node := range nodes {
+			builder = builder.WithObjects(node)
+		}
+		validator = &OverlayValidator{Client: builder.Build(), Decoder: admission.NewDecoder(scheme)}
+	})
+
+	request := func(overlay *l2smv1.Overlay) admission.Request {
+		raw, err := json.Marshal(overlay)
+		Expect(err).NotTo(HaveOccurred())
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}}
+	}
+
+	newOverlay := func(mutate func(spec *l2smv1.OverlaySpec)) *l2smv1.Overlay {
+		overlay := &l2smv1.Overlay{
+			TypeMeta:   metav1.TypeMeta{APIVersion: l2smv1.GroupVersion.String(), Kind: "Overlay"},
+			ObjectMeta: metav1.ObjectMeta{Name: "overlay", Namespace: "default"},
+			Spec: l2smv1.OverlaySpec{
+				Provider:        &l2smv1.ProviderSpec{Name: "l2sm-controller", Domain: []string{"l2sm-controller-service.l2sm-system.svc"}},
+				Topology:        &l2smv1.TopologySpec{Nodes: []string{"node-a", "node-b"}, Links: []l2smv1.Link{{EndpointA: "node-a", EndpointB: "node-b"}}},
+				InterfaceNumber: 10,
+			},
+		}
+		if mutate != nil {
+			mutate(&overlay.Spec)
+		}
+		return overlay
+	}
+
+	It("sets the default switch template", func() {
+		defaulter := &OverlayDefaulter{Decoder: admission.NewDecoder(scheme)}
+		resp := defaulter.Handle(ctx, request(newOverlay(nil)))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(resp.Patches).To(ContainElement(HaveField("Path", "/spec/switchTemplate")))
+	})
+
+	DescribeTable("validation of the spec",
+		func(mutate func(spec *l2smv1.OverlaySpec), message string) {
+			resp := validator.Handle(ctx, request(newOverlay(mutate)))
+			if message == "" {
+				Expect(resp.Allowed).To(BeTrue(), resp.Result.Message)
+				return
+			}
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Message).To(ContainSubstring(message))
+		},
+		Entry("a valid overlay", nil, ""),
+		Entry("a link to an undeclared node", func(spec *l2smv1.OverlaySpec) {
+			spec.Topology.Links = append(spec.Topology.Links, l2smv1.Link{EndpointA: "node-a", EndpointB: "node-c"})
+		}, "references node node-c"),
+		Entry("a node that doesn't exist", func(spec *l2smv1.OverlaySpec) {
+			spec.Topology.Nodes = append(spec.Topology.Nodes, "node-c")
+		}, "node node-c doesn't exist"),
+		Entry("a node declared twice", func(spec *l2smv1.OverlaySpec) {
+			spec.Topology.Nodes = append(spec.Topology.Nodes, "node-a")
+		}, "declared more than once"),
+		Entry("a self link", func(spec *l2smv1.OverlaySpec) {
+			spec.Topology.Links = []l2smv1.Link{{EndpointA: "node-a", EndpointB: "node-a"}}
+		}, "joins a node with itself"),
+		Entry("no topology", func(spec *l2smv1.OverlaySpec) { spec.Topology = nil }, "at least one node"),
+		Entry("too many interfaces", func(spec *l2smv1.OverlaySpec) { spec.InterfaceNumber = 100 }, "interfaceNumber"),
+		Entry("no interfaces", func(spec *l2smv1.OverlaySpec) { spec.InterfaceNumber = -1 }, "interfaceNumber"),
+		Entry("jumbo frames", func(spec *l2smv1.OverlaySpec) {
+			spec.InterfaceOptions = &l2smv1.InterfaceOptions{MTU: 9000, VLAN: 100, MacSpoofCheck: true}
+		}, ""),
+		Entry("an MTU too small for IPv6", func(spec *l2smv1.OverlaySpec) { spec.InterfaceOptions = &l2smv1.InterfaceOptions{MTU: 576} }, "interface MTU"),
+		Entry("an elastic interface pool", func(spec *l2smv1.OverlaySpec) {
+			spec.InterfacePool = &l2smv1.InterfacePoolSpec{MinFree: 2, Step: 5, MaxSize: 40}
+		}, ""),
+		Entry("an interface pool smaller than the interface number", func(spec *l2smv1.OverlaySpec) {
+			spec.InterfaceNumber = 20
+			spec.InterfacePool = &l2smv1.InterfacePoolSpec{MaxSize: 10}
+		}, "interfacePool maxSize"),
+		Entry("an invalid VLAN", func(spec *l2smv1.OverlaySpec) { spec.InterfaceOptions = &l2smv1.InterfaceOptions{VLAN: 4095} }, "interface VLAN"),
+		Entry("an empty provider domain", func(spec *l2smv1.OverlaySpec) { spec.Provider.Domain = nil }, "provider domain is required"),
+		Entry("a malformed provider domain", func(spec *l2smv1.OverlaySpec) {
+			spec.Provider.Domain = []string{"not a domain"}
+		}, "not a valid IP address or domain name"),
+	)
+})
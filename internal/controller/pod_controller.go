@@ -18,11 +18,12 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/netip"
 	"slices"
 
 	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
 	"github.com/Networks-it-uc3m/L2S-M/internal/dnsinterface"
-	"github.com/Networks-it-uc3m/L2S-M/internal/env"
+	"github.com/Networks-it-uc3m/L2S-M/internal/ipam"
 	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
 	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient"
 	"github.com/Networks-it-uc3m/L2S-M/internal/utils"
@@ -35,6 +36,7 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
 // PodReconciler reconciles a Pod object
@@ -45,6 +47,7 @@ type PodReconciler struct {
 	Scheme            *runtime.Scheme
 	SwitchesNamespace string
 	InternalClient    sdnclient.Client
+	IPAM              ipam.Allocator
 }
 
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
@@ -95,52 +98,59 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 			logger.Info("L2S-M Pod deleted: detaching l2network")
 
 			pod.SetFinalizers(utils.RemoveString(pod.GetFinalizers(), l2smFinalizer))
-			networkAnnotations, err := networkannotation.ExtractNetworks(pod.Annotations[networkannotation.L2SM_NETWORK_ANNOTATION], r.SwitchesNamespace)
+			// Every interface of the pod is detached from the network it is attached to, if any, and given back to the node
+			podInterfaces, err := GetPodInterfaces(pod, r.SwitchesNamespace)
 			if err != nil {
-				logger.Error(err, "l2 networks could not be extracted from the pod annotations during deletion", "pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+				logger.Error(err, "could not get the pod interfaces during deletion", "pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
 				return ctrl.Result{}, nil
 			}
 
-			multusAnnotations, ok := pod.Annotations[networkannotation.MULTUS_ANNOTATION_KEY]
-			if !ok {
-				logger.Error(nil, "pod is missing multus annotation during deletion", "pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
-				return ctrl.Result{}, nil
-			}
-			multusNetAttachDefinitions, err := networkannotation.ExtractNetworks(multusAnnotations, r.SwitchesNamespace)
-			if err != nil {
-				logger.Error(err, "could not extract multus annotations during pod deletion", "pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
-				return ctrl.Result{}, nil
-			}
-			if len(multusNetAttachDefinitions) != len(networkAnnotations) {
-				logger.Error(nil, "pod has mismatched l2sm and multus annotation counts during deletion", "pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name), "l2smNetworks", len(networkAnnotations), "multusAttachments", len(multusNetAttachDefinitions))
-				return ctrl.Result{}, nil
-			}
-
-			ofID := fmt.Sprintf("of:%s", dp.GenerateID(dp.GetSwitchName(dp.DatapathParams{NodeName: pod.Spec.NodeName, ProviderName: l2smv1.OVERLAY_PROVIDER})))
 			netAttachDefLabel := networkannotation.NET_ATTACH_LABEL_PREFIX + pod.Spec.NodeName
 
-			for i := range networkAnnotations {
-				portNumber, err := utils.GetPortNumberFromNetAttachDef(multusNetAttachDefinitions[i].Name)
-				if err != nil {
-					logger.Error(err, "could not get port number from network attachment definition during pod deletion", "nad", multusNetAttachDefinitions[i].Name, "pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
-					return ctrl.Result{}, nil
+			var networkAnnotations []networkannotation.NetworkAnnotation
+			for _, podInterface := range podInterfaces {
+				if podInterface.Network != "" {
+					networkAnnotations = append(networkAnnotations, networkannotation.NetworkAnnotation{Name: podInterface.Network})
 				}
-				ofPort := fmt.Sprintf("%s/%s", ofID, portNumber)
+			}
+			// The networks may already be gone, in which case we fall back to the vnet type when detaching.
+			networks, _ := GetL2NetworksMap(ctx, r.Client, networkAnnotations)
+
+			for _, podInterface := range podInterfaces {
+				if podInterface.Network != "" {
+					ofPort, err := GetPodSwitchPort(pod, podInterface.NetAttachDef)
+					if err != nil {
+						logger.Error(err, "could not get the switch port during pod deletion", "pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+						return ctrl.Result{}, nil
+					}
+
+					// if the pod is not attached in the first place, it means the controller has some desync. Just in case we let the code continue operating, as this
+					// doesnt affect the rest of the workflow. Probably should do a more robust reconciliation with the sdn controller in the future.
+					networkType := l2smv1.NetworkTypeVnet
+					if network, ok := networks[podInterface.Network]; ok {
+						networkType = network.Spec.Type
+					}
+					if err := r.InternalClient.DetachPodFromNetwork(ctx, networkType, sdnclient.VnetPayload{NetworkId: podInterface.Network, Port: []string{ofPort}}); err != nil {
+						logger.Error(err, "could not detach pod from network in SDN controller during deletion", "pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name), "network", podInterface.Network, "port", ofPort)
+					}
 
-				// if the pod is not attached in the first place, it means the controller has some desync. Just in case we let the code continue operating, as this
-				// doesnt affect the rest of the workflow. Probably should do a more robust reconciliation with the sdn controller in the future.
-				if err := r.InternalClient.DetachPodFromNetwork("vnets", sdnclient.VnetPayload{NetworkId: networkAnnotations[i].Name, Port: []string{ofPort}}); err != nil {
-					logger.Error(err, "could not detach pod from network in SDN controller during deletion", "pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name), "network", networkAnnotations[i].Name, "port", ofPort)
+					// The pod addresses go back to the network pool, and the network has one pod less. If this fails, the garbage
+					// collector will reclaim them later on, so we don't hold the pod deletion.
+					if network, ok := networks[podInterface.Network]; ok {
+						if err := r.releasePodFromNetwork(ctx, &network, podLeaseOwner(pod)); err != nil {
+							logger.Error(err, "could not release pod from network during deletion", "pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name), "network", network.Name)
+						}
+					}
 				}
 
 				netAttachDef := &nettypes.NetworkAttachmentDefinition{}
-				err = r.Get(ctx, client.ObjectKey{Name: multusNetAttachDefinitions[i].Name, Namespace: r.SwitchesNamespace}, netAttachDef)
+				err = r.Get(ctx, client.ObjectKey{Name: podInterface.NetAttachDef.Name, Namespace: r.SwitchesNamespace}, netAttachDef)
 				if err != nil {
 					if apierrors.IsNotFound(err) {
-						logger.Info("NetworkAttachmentDefinition not found during pod deletion cleanup", "nad", multusNetAttachDefinitions[i].Name, "namespace", r.SwitchesNamespace)
+						logger.Info("NetworkAttachmentDefinition not found during pod deletion cleanup", "nad", podInterface.NetAttachDef.Name, "namespace", r.SwitchesNamespace)
 						continue
 					}
-					logger.Error(err, "could not get network attachment definition during pod deletion", "nad", multusNetAttachDefinitions[i].Name, "namespace", r.SwitchesNamespace, "pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+					logger.Error(err, "could not get network attachment definition during pod deletion", "nad", podInterface.NetAttachDef.Name, "namespace", r.SwitchesNamespace, "pod", fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
 					return ctrl.Result{}, nil
 				}
 
@@ -165,6 +175,16 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 		return ctrl.Result{}, nil
 	}
 
+	// The pods bound by the scheduler extender get their interfaces before their node, and are attached once bound
+	if pod.Spec.NodeName == "" {
+		return ctrl.Result{}, nil
+	}
+
+	// The addresses leased while the pod had no name are handed over to it
+	if err := r.rebindLeases(ctx, pod); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	// Check if the pod has a finalizer attached to it. If not, we asume this pod is being created,
 	// so we attach the l2network to it.
 	if !slices.Contains(pod.GetFinalizers(), l2smFinalizer) {
@@ -247,7 +267,7 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 			ofPort := fmt.Sprintf("%s/%s", ofID, portNumber)
 
 			// we inform the sdn controller of this new port attachment
-			err = r.InternalClient.AttachPodToNetwork("vnets", sdnclient.VnetPayload{NetworkId: network.Name, Port: []string{ofPort}})
+			err = r.InternalClient.AttachPodToNetwork(ctx, network.Spec.Type, sdnclient.VnetPayload{NetworkId: network.Name, Port: []string{ofPort}})
 			if err != nil {
 				logger.Error(err, "Error attaching pod to the l2network")
 				return ctrl.Result{}, nil
@@ -261,7 +281,7 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 				if appName, ok := pod.GetLabels()[L2SM_PODNAME_LABEL]; ok {
 					podName = appName
 				}
-				if err = CreateDNSEntry(&network, podName, multusNetAttachDefinitions[index].IPAddresses[0]); err != nil {
+				if err = CreateDNSEntry(&network, podName, multusNetAttachDefinitions[index].IPAddresses); err != nil {
 					logger.Error(err, "could not add dns entry")
 				}
 				logger.Info("Connected pod to inter-domain network")
@@ -269,6 +289,13 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 			}
 		}
 
+		return ctrl.Result{}, nil
+	}
+
+	// The pod is already attached, so the changes the user makes to its l2sm annotation are applied to the running pod
+	if err := r.hotplugNetworks(ctx, pod); err != nil {
+		logger.Error(err, "could not update the l2networks of the pod")
+		return ctrl.Result{}, err
 	}
 
 	return ctrl.Result{}, nil
@@ -277,35 +304,54 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *PodReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	var err error
-	// Initialize the InternalClient with the base URL of the SDN controller
-	clientConfig := sdnclient.ClientConfig{BaseURL: fmt.Sprintf("http://%s:%s/onos", env.GetControllerIP(), env.GetControllerPort()), Username: "karaf", Password: "karaf"}
+	// Initialize the InternalClient with the base URL of the SDN controller, unless one was given
+	if r.InternalClient == nil {
+		ctx := context.Background()
+		clientConfig, err := sdnclient.InternalConfig(ctx, mgr.GetAPIReader())
+		if err != nil {
+			return err
+		}
 
-	r.InternalClient, err = sdnclient.NewClient(sdnclient.InternalType, clientConfig)
-	if err != nil {
-		r.Log.Error(err, "failed to initiate session with sdn controller")
+		r.InternalClient, err = sdnclient.NewClient(ctx, sdnclient.InternalType, clientConfig)
+		if err != nil {
+			r.Log.Error(err, "failed to initiate session with sdn controller")
+			return err
+		}
+	}
+
+	// Leases and pod counters of pods that went away without going through the finalizer are reclaimed periodically
+	if err := mgr.Add(manager.RunnableFunc(r.collectGarbage)); err != nil {
 		return err
 	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Pod{}).
 		Complete(r)
 }
 
-func CreateDNSEntry(network *l2smv1.L2Network, podName, podCIDR string) error {
+// CreateDNSEntry registers the pod addresses in the DNS service of the network provider, which serves the IPv4 ones as
+// A records and the IPv6 ones as AAAA records. The link local addresses given to the interfaces of layer 2 networks
+// are not registered, as they are not reachable from other domains.
+func CreateDNSEntry(network *l2smv1.L2Network, podName string, podCIDRs []string) error {
+	if len(network.Spec.Provider.Domain) == 0 {
+		return fmt.Errorf("provider %s has no domain", network.Spec.Provider.Name)
+	}
 	// We create a DNS Client for registring this pod in an external DNS
-	providerAddress := fmt.Sprintf("%s:%s", network.Spec.Provider.Domain, utils.DefaultIfEmpty(network.Spec.Provider.DNSGRPCPort, "30818"))
+	providerAddress := net.JoinHostPort(network.Spec.Provider.Domain[0], utils.DefaultIfEmpty(network.Spec.Provider.DNSGRPCPort, "30818"))
 
 	dnsClient := dnsinterface.DNSClient{ServerAddress: providerAddress, Scope: "inter"}
 
-	ip, _, err := net.ParseCIDR(podCIDR)
-	if err != nil {
-		return fmt.Errorf("could not parse pod cidr: %v", err)
-
-	}
-	err = dnsClient.AddDNSEntry(podName, network.Name, ip.To4().String())
-
-	if err != nil {
-		return fmt.Errorf("could not add dns entry in remote server: %v", err)
+	for _, podCIDR := range podCIDRs {
+		prefix, err := netip.ParsePrefix(podCIDR)
+		if err != nil {
+			return fmt.Errorf("could not parse pod cidr: %v", err)
+		}
+		if prefix.Addr().IsLinkLocalUnicast() {
+			continue
+		}
+		if err = dnsClient.AddDNSEntry(podName, network.Name, prefix.Addr().String()); err != nil {
+			return fmt.Errorf("could not add dns entry in remote server: %v", err)
+		}
 	}
 
 	return nil
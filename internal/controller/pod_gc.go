@@ -0,0 +1,210 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/env"
+	"github.com/Networks-it-uc3m/L2S-M/internal/ipam"
+	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
+)
+
+func (r *PodReconciler) allocator() ipam.Allocator {
+	if r.IPAM != nil {
+		return r.IPAM
+	}
+	return ipam.NewCRDAllocator(r.Client, nil)
+}
+
+// releasePodFromNetwork returns the addresses of the pod to the network pool and takes it out of the pod count.
+func (r *PodReconciler) releasePodFromNetwork(ctx context.Context, network *l2smv1.L2Network, podName string) error {
+	if err := r.allocator().Release(ctx, client.ObjectKeyFromObject(network), podName); err != nil {
+		return err
+	}
+	err := UpdateL2NetworkStatus(ctx, r.Client, client.ObjectKeyFromObject(network), func(status *l2smv1.L2NetworkStatus) {
+		if status.ConnectedPodCount > 0 {
+			status.ConnectedPodCount--
+		}
+	})
+	return client.IgnoreNotFound(err)
+}
+
+// rebindLeases hands the addresses leased to the token of a pod admitted without a name over to its name, and then
+// drops the token, so that the networks record the pod by its name.
+func (r *PodReconciler) rebindLeases(ctx context.Context, pod *corev1.Pod) error {
+	token, ok := pod.Annotations[leaseOwnerAnnotation]
+	if !ok {
+		return nil
+	}
+	podInterfaces, err := GetPodInterfaces(pod, r.SwitchesNamespace)
+	if err != nil {
+		return err
+	}
+	var networkAnnotations []networkannotation.NetworkAnnotation
+	for _, podInterface := range podInterfaces {
+		if podInterface.Network != "" {
+			networkAnnotations = append(networkAnnotations, networkannotation.NetworkAnnotation{Name: podInterface.Network})
+		}
+	}
+	// the networks that are gone have no leases left to rebind
+	networks, _ := GetL2NetworksMap(ctx, r.Client, networkAnnotations)
+	for _, network := range networks {
+		if err := r.allocator().Rebind(ctx, client.ObjectKeyFromObject(&network), token, pod.Name); err != nil {
+			return err
+		}
+	}
+	delete(pod.Annotations, leaseOwnerAnnotation)
+	return r.Update(ctx, pod)
+}
+
+// collectGarbage periodically reclaims the leases held by pods that no longer exist, and recomputes the pod count of
+// every network. Pods can go away without the finalizer ever running, e.g. when it is removed by hand.
+func (r *PodReconciler) collectGarbage(ctx context.Context) error {
+	ticker := time.NewTicker(env.GetIPAMGCInterval())
+	defer ticker.Stop()
+
+	// leases are only reclaimed once they have been seen orphaned in two consecutive runs, so an address that the
+	// webhook has just handed out to a pod that is still being admitted is not taken away from it
+	suspects := map[string]bool{}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			var err error
+			if suspects, err = r.reclaimOrphanLeases(ctx, suspects); err != nil {
+				log.FromContext(ctx).Error(err, "could not reclaim orphan address leases")
+			}
+		}
+	}
+}
+
+// reclaimOrphanLeases releases the leases of the suspects that are still orphaned and returns the new suspects. The
+// pod counts that are too low are raised right away, but the ones that are too high are only lowered once they have
+// been seen too high in two consecutive runs too, as the webhook counts a pod before it's created.
+func (r *PodReconciler) reclaimOrphanLeases(ctx context.Context, suspects map[string]bool) (map[string]bool, error) {
+	logger := log.FromContext(ctx)
+
+	networks := &l2smv1.L2NetworkList{}
+	if err := r.List(ctx, networks); err != nil {
+		return suspects, fmt.Errorf("could not list l2networks: %w", err)
+	}
+	attached, err := r.attachedPods(ctx, networks.Items)
+	if err != nil {
+		return suspects, err
+	}
+
+	nextSuspects := map[string]bool{}
+	var miscounted []types.NamespacedName
+	for i := range networks.Items {
+		network := &networks.Items[i]
+		key := client.ObjectKeyFromObject(network)
+		livePods := attached[key]
+
+		for _, owner := range network.Status.AssignedIPs {
+			if livePods[owner] {
+				continue
+			}
+			lease := fmt.Sprintf("%s/%s", key, owner)
+			if !suspects[lease] {
+				nextSuspects[lease] = true
+				continue
+			}
+			if err := r.allocator().Release(ctx, key, owner); err != nil {
+				logger.Error(err, "could not release orphan lease", "network", network.Name, "pod", owner)
+				continue
+			}
+			// the owner may hold more than one address, so it is only released once
+			suspects[lease] = false
+			logger.Info("Released address lease of a pod that no longer exists", "network", network.Name, "pod", owner)
+		}
+
+		if network.Status.ConnectedPodCount == len(livePods) {
+			continue
+		}
+		if network.Status.ConnectedPodCount > len(livePods) && !suspects[key.String()] {
+			nextSuspects[key.String()] = true
+			continue
+		}
+		miscounted = append(miscounted, key)
+	}
+	if len(miscounted) == 0 {
+		return nextSuspects, nil
+	}
+
+	// the pods are counted again right before the update, so that the pods counted meanwhile aren't overwritten
+	recount, err := r.attachedPods(ctx, networks.Items)
+	if err != nil {
+		return nextSuspects, err
+	}
+	for _, key := range miscounted {
+		count := len(recount[key])
+		err := UpdateL2NetworkStatus(ctx, r.Client, key, func(status *l2smv1.L2NetworkStatus) {
+			status.ConnectedPodCount = count
+		})
+		if client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "could not update connected pod count", "network", key.Name)
+		}
+	}
+	return nextSuspects, nil
+}
+
+// attachedPods returns the lease owners of the live pods attached to every network, by the namespace and name of the
+// network. Pods refer to networks by name only, so they are resolved as the webhook does, whatever the namespace of
+// the pod.
+func (r *PodReconciler) attachedPods(ctx context.Context, networks []l2smv1.L2Network) (map[types.NamespacedName]map[string]bool, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods); err != nil {
+		return nil, fmt.Errorf("could not list pods: %w", err)
+	}
+	byName := l2NetworksByName(networks)
+	attached := map[types.NamespacedName]map[string]bool{}
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		if _, ok := pod.Annotations[networkannotation.L2SM_NETWORK_ANNOTATION]; !ok {
+			continue
+		}
+		if _, ok := pod.Annotations[networkannotation.MULTUS_ANNOTATION_KEY]; !ok {
+			continue
+		}
+		podInterfaces, err := GetPodInterfaces(&pod, r.SwitchesNamespace)
+		if err != nil {
+			continue
+		}
+		for _, podInterface := range podInterfaces {
+			network, ok := byName[podInterface.Network]
+			if !ok {
+				continue
+			}
+			key := client.ObjectKeyFromObject(&network)
+			if attached[key] == nil {
+				attached[key] = map[string]bool{}
+			}
+			attached[key][podLeaseOwner(&pod)] = true
+		}
+	}
+	return attached, nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
+)
+
+var _ = Describe("Pod garbage collection", func() {
+	ctx := context.Background()
+	networkKey := types.NamespacedName{Name: "gc-network", Namespace: "default"}
+	podKey := types.NamespacedName{Name: "gc-ping", Namespace: "default"}
+
+	BeforeEach(func() {
+		network := &l2smv1.L2Network{
+			ObjectMeta: metav1.ObjectMeta{Name: networkKey.Name, Namespace: networkKey.Namespace},
+			Spec:       l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, NetworkCIDR: "10.0.0.0/24"},
+		}
+		Expect(k8sClient.Create(ctx, network)).To(Succeed())
+		network.Status.ConnectedPodCount = 3
+		network.Status.AssignedIPs = map[string]string{"10.0.0.2": podKey.Name, "10.0.0.3": "gc-gone"}
+		Expect(k8sClient.Status().Update(ctx, network)).To(Succeed())
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      podKey.Name,
+				Namespace: podKey.Namespace,
+				Annotations: map[string]string{
+					networkannotation.L2SM_NETWORK_ANNOTATION: `[{"name":"gc-network"}]`,
+					networkannotation.MULTUS_ANNOTATION_KEY:   `[{"name":"l2sm-veth1","ips":["10.0.0.2/24"]}]`,
+				},
+			},
+			Spec: corev1.PodSpec{
+				NodeName:   "node-a",
+				Containers: []corev1.Container{{Name: "ping", Image: "busybox"}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		deleteIfExists(ctx, &corev1.Pod{}, podKey)
+		deleteIfExists(ctx, &l2smv1.L2Network{}, networkKey)
+	})
+
+	It("reclaims the leases of missing pods and fixes the pod count on the second run", func() {
+		reconciler := &PodReconciler{Client: k8sClient, Scheme: k8sClient.Scheme()}
+
+		suspects, err := reconciler.reclaimOrphanLeases(ctx, map[string]bool{})
+		Expect(err).NotTo(HaveOccurred())
+
+		network := &l2smv1.L2Network{}
+		Expect(k8sClient.Get(ctx, networkKey, network)).To(Succeed())
+		Expect(network.Status.AssignedIPs).To(HaveKey("10.0.0.3"))
+		Expect(network.Status.ConnectedPodCount).To(Equal(3))
+
+		_, err = reconciler.reclaimOrphanLeases(ctx, suspects)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(k8sClient.Get(ctx, networkKey, network)).To(Succeed())
+		Expect(network.Status.AssignedIPs).NotTo(HaveKey("10.0.0.3"))
+		Expect(network.Status.AssignedIPs).To(HaveKeyWithValue("10.0.0.2", podKey.Name))
+		Expect(network.Status.ConnectedPodCount).To(Equal(1))
+	})
+})
+
+var _ = Describe("Pod garbage collection with fake client", func() {
+	ctx := context.Background()
+	var scheme *runtime.Scheme
+
+	BeforeEach(func() {
+		scheme = runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(l2smv1.AddToScheme(scheme)).To(Succeed())
+	})
+
+	network := func(namespace string, assigned map[string]string) *l2smv1.L2Network {
+		return &l2smv1.L2Network{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: namespace},
+			Spec:       l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, NetworkCIDR: "10.0.0.0/24"},
+			Status:     l2smv1.L2NetworkStatus{AssignedIPs: assigned},
+		}
+	}
+	attachedPod := func(name string, annotations map[string]string) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "team-a",
+			Annotations: map[string]string{
+				networkannotation.L2SM_NETWORK_ANNOTATION: `[{"name":"shared"}]`,
+				networkannotation.MULTUS_ANNOTATION_KEY:   `[{"name":"l2sm-veth1","ips":["10.0.0.2/24"]}]`,
+			},
+		}}
+		for key, value := range annotations {
+			pod.Annotations[key] = value
+		}
+		return pod
+	}
+	collectTwice := func(reconciler *PodReconciler) {
+		suspects, err := reconciler.reclaimOrphanLeases(ctx, map[string]bool{})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = reconciler.reclaimOrphanLeases(ctx, suspects)
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("counts the pods attached to a network of another namespace", func() {
+		shared := network("l2sm-networks", map[string]string{"10.0.0.2": "ping", "10.0.0.3": "gone"})
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&l2smv1.L2Network{}).
+			WithObjects(shared, attachedPod("ping", nil)).Build()
+		collectTwice(&PodReconciler{Client: c, Scheme: scheme})
+
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(shared), shared)).To(Succeed())
+		Expect(shared.Status.AssignedIPs).To(Equal(map[string]string{"10.0.0.2": "ping"}))
+		Expect(shared.Status.ConnectedPodCount).To(Equal(1))
+	})
+
+	It("keeps the leases of the pods created with a generated name", func() {
+		shared := network("team-a", map[string]string{"10.0.0.2": "web-uid-1"})
+		pod := attachedPod("web-x7k2p", map[string]string{leaseOwnerAnnotation: "web-uid-1"})
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&l2smv1.L2Network{}).
+			WithObjects(shared, pod).Build()
+		collectTwice(&PodReconciler{Client: c, Scheme: scheme})
+
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(shared), shared)).To(Succeed())
+		Expect(shared.Status.AssignedIPs).To(HaveKeyWithValue("10.0.0.2", "web-uid-1"))
+		Expect(shared.Status.ConnectedPodCount).To(Equal(1))
+	})
+})
+
+var _ = Describe("Pod lease owner", func() {
+	ctx := context.Background()
+
+	It("hands the leases of a pod admitted without a name over to its name", func() {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(l2smv1.AddToScheme(scheme)).To(Succeed())
+
+		network := &l2smv1.L2Network{
+			ObjectMeta: metav1.ObjectMeta{Name: "net", Namespace: "default"},
+			Spec:       l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, NetworkCIDR: "10.0.0.0/24"},
+			Status:     l2smv1.L2NetworkStatus{AssignedIPs: map[string]string{"10.0.0.2": "web-uid-1", "10.0.0.3": "web-uid-2"}},
+		}
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-x7k2p",
+			Namespace: "default",
+			Annotations: map[string]string{
+				networkannotation.L2SM_NETWORK_ANNOTATION: `[{"name":"net"}]`,
+				networkannotation.MULTUS_ANNOTATION_KEY:   `[{"name":"l2sm-veth1","ips":["10.0.0.2/24"]}]`,
+				leaseOwnerAnnotation:                      "web-uid-1",
+			},
+		}}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&l2smv1.L2Network{}).WithObjects(network, pod).Build()
+		reconciler := &PodReconciler{Client: c, Scheme: scheme}
+
+		Expect(reconciler.rebindLeases(ctx, pod)).To(Succeed())
+
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(network), network)).To(Succeed())
+		Expect(network.Status.AssignedIPs).To(Equal(map[string]string{"10.0.0.2": "web-x7k2p", "10.0.0.3": "web-uid-2"}))
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(pod), pod)).To(Succeed())
+		Expect(pod.Annotations).NotTo(HaveKey(leaseOwnerAnnotation))
+	})
+})
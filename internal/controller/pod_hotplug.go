@@ -0,0 +1,322 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/ipam"
+	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
+	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient"
+)
+
+// hotplugNetworks brings the networks the interfaces of a running pod are attached to in line with the l2sm
+// annotation, so that networks can be added to or removed from the pod without recreating it.
+//
+// The interfaces of the removed networks are unplugged from the switch but stay in the pod, and they are reused for
+// the new networks when the addresses they were created with are valid there. Otherwise, a free interface of the node
+// is added to the multus annotation, which is hot-plugged in the pod when the multus dynamic networks controller is
+// installed in the cluster.
+func (r *PodReconciler) hotplugNetworks(ctx context.Context, pod *corev1.Pod) error {
+	logger := log.FromContext(ctx)
+
+	desired, err := networkannotation.ExtractNetworks(pod.Annotations[networkannotation.L2SM_NETWORK_ANNOTATION], r.SwitchesNamespace)
+	if err != nil {
+		return fmt.Errorf("could not extract l2 networks from the pod annotations: %w", err)
+	}
+	podInterfaces, err := GetPodInterfaces(pod, r.SwitchesNamespace)
+	if err != nil {
+		return err
+	}
+
+	// Pods attached before the attached networks annotation existed are taken as they are, as there is no way to
+	// know if the l2sm annotation has been changed since.
+	if _, ok := pod.Annotations[networkannotation.ATTACHED_NETWORKS_ANNOTATION]; !ok {
+		return r.updatePodInterfaces(ctx, pod, podInterfaces)
+	}
+
+	toDetach, toAttach := diffPodNetworks(podInterfaces, desired)
+	if len(toDetach) == 0 && len(toAttach) == 0 {
+		return nil
+	}
+
+	var lookup []networkannotation.NetworkAnnotation
+	for _, i := range toDetach {
+		lookup = append(lookup, networkannotation.NetworkAnnotation{Name: podInterfaces[i].Network})
+	}
+	lookup = append(lookup, toAttach...)
+	// Missing networks are dealt with one by one: the ones being detached fall back to the vnet type, and the ones
+	// being attached are retried until they are created.
+	networks, _ := GetL2NetworksMap(ctx, r.Client, lookup)
+
+	var errs []error
+	for _, i := range toDetach {
+		if err := r.detachInterface(ctx, pod, &podInterfaces[i], networks); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		logger.Info("Pod detached from l2network", "pod", client.ObjectKeyFromObject(pod).String(), "interface", podInterfaces[i].NetAttachDef.Name)
+	}
+	for _, want := range toAttach {
+		network, ok := networks[want.Name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("network %s doesn't exist", want.Name))
+			continue
+		}
+		if podInterfaces, err = r.attachNetwork(ctx, pod, podInterfaces, &network, want); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		logger.Info("Pod attached to l2network", "pod", client.ObjectKeyFromObject(pod).String(), "network", network.Name)
+	}
+
+	// Whatever could be done is recorded in the pod, the rest is retried in the next reconciliation
+	if err := r.updatePodInterfaces(ctx, pod, podInterfaces); err != nil {
+		errs = append(errs, fmt.Errorf("could not update the pod interfaces: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// diffPodNetworks returns the indexes of the interfaces that are attached to networks no longer in the l2sm
+// annotation, and the networks of the annotation that no interface is attached to.
+func diffPodNetworks(podInterfaces []PodInterface, desired []networkannotation.NetworkAnnotation) ([]int, []networkannotation.NetworkAnnotation) {
+	matched := make([]bool, len(podInterfaces))
+	var toAttach []networkannotation.NetworkAnnotation
+	for _, want := range desired {
+		found := false
+		for i := range podInterfaces {
+			if !matched[i] && podInterfaces[i].Network == want.Name {
+				matched[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			toAttach = append(toAttach, want)
+		}
+	}
+
+	var toDetach []int
+	for i := range podInterfaces {
+		if podInterfaces[i].Network != "" && !matched[i] {
+			toDetach = append(toDetach, i)
+		}
+	}
+	return toDetach, toAttach
+}
+
+// detachInterface unplugs the interface from the switch and gives its addresses back to the network.
+func (r *PodReconciler) detachInterface(ctx context.Context, pod *corev1.Pod, podInterface *PodInterface, networks map[string]l2smv1.L2Network) error {
+	ofPort, err := GetPodSwitchPort(pod, podInterface.NetAttachDef)
+	if err != nil {
+		return err
+	}
+
+	networkType := l2smv1.NetworkTypeVnet
+	network, ok := networks[podInterface.Network]
+	if ok {
+		networkType = network.Spec.Type
+	}
+	if err := r.InternalClient.DetachPodFromNetwork(ctx, networkType, sdnclient.VnetPayload{NetworkId: podInterface.Network, Port: []string{ofPort}}); err != nil && !sdnclient.IsNotFound(err) {
+		return fmt.Errorf("could not detach port %s from network %s: %w", ofPort, podInterface.Network, err)
+	}
+	if ok {
+		if err := r.releasePodFromNetwork(ctx, &network, podLeaseOwner(pod)); err != nil {
+			return err
+		}
+	}
+	podInterface.Network = ""
+	return nil
+}
+
+// attachNetwork attaches the pod to the network through one of its detached interfaces or, if none can be reused,
+// through a new one, returning the updated interfaces.
+func (r *PodReconciler) attachNetwork(ctx context.Context, pod *corev1.Pod, podInterfaces []PodInterface, network *l2smv1.L2Network, want networkannotation.NetworkAnnotation) ([]PodInterface, error) {
+	// the pod gets the addresses reserved for it in the network if it doesn't request any
+	requested, err := ipam.RequestedAddresses(network.Spec, pod.Name, pod.Labels, want.IPAddresses)
+	if err != nil {
+		return podInterfaces, err
+	}
+	want.IPAddresses = requested
+
+	index := -1
+	for i := range podInterfaces {
+		if podInterfaces[i].Network != "" {
+			continue
+		}
+		addresses, ok := reusableAddresses(network, want, podInterfaces[i].NetAttachDef)
+		if !ok {
+			continue
+		}
+		if _, err := r.allocator().Allocate(ctx, client.ObjectKeyFromObject(network), podLeaseOwner(pod), addresses); err != nil {
+			if errors.Is(err, ipam.ErrConflict) {
+				continue
+			}
+			return podInterfaces, err
+		}
+		index = i
+		break
+	}
+	if index == -1 {
+		netAttachDef, err := r.addInterface(ctx, pod, network, want)
+		if err != nil {
+			return podInterfaces, err
+		}
+		podInterfaces = append(podInterfaces, PodInterface{NetAttachDef: netAttachDef})
+		index = len(podInterfaces) - 1
+	}
+
+	ofPort, err := GetPodSwitchPort(pod, podInterfaces[index].NetAttachDef)
+	if err != nil {
+		return podInterfaces, err
+	}
+	if err := r.InternalClient.AttachPodToNetwork(ctx, network.Spec.Type, sdnclient.VnetPayload{NetworkId: network.Name, Port: []string{ofPort}}); err != nil {
+		return podInterfaces, fmt.Errorf("could not attach port %s to network %s: %w", ofPort, network.Name, err)
+	}
+	podInterfaces[index].Network = network.Name
+
+	err = UpdateL2NetworkStatus(ctx, r.Client, client.ObjectKeyFromObject(network), func(status *l2smv1.L2NetworkStatus) {
+		status.ConnectedPodCount++
+	})
+	if err != nil {
+		log.FromContext(ctx).Error(err, "could not update l2network status", "network", network.Name)
+	}
+
+	if network.Spec.Provider != nil && len(podInterfaces[index].NetAttachDef.IPAddresses) != 0 {
+		podName := pod.GetName()
+		if appName, ok := pod.GetLabels()[L2SM_PODNAME_LABEL]; ok {
+			podName = appName
+		}
+		if err := CreateDNSEntry(network, podName, podInterfaces[index].NetAttachDef.IPAddresses); err != nil {
+			log.FromContext(ctx).Error(err, "could not add dns entry", "network", network.Name)
+		}
+	}
+	return podInterfaces, nil
+}
+
+// addInterface takes a free interface of the pod node for the network, with the addresses the allocator hands out.
+func (r *PodReconciler) addInterface(ctx context.Context, pod *corev1.Pod, network *l2smv1.L2Network, want networkannotation.NetworkAnnotation) (networkannotation.NetworkAnnotation, error) {
+	netAttachDefLabel := networkannotation.NET_ATTACH_LABEL_PREFIX + pod.Spec.NodeName
+	netAttachDefs := GetFreeNetAttachDefs(ctx, r.Client, r.SwitchesNamespace, netAttachDefLabel)
+	if len(netAttachDefs.Items) == 0 {
+		return networkannotation.NetworkAnnotation{}, fmt.Errorf("no interfaces available for node %s", pod.Spec.NodeName)
+	}
+
+	addresses, err := r.allocator().Allocate(ctx, client.ObjectKeyFromObject(network), podLeaseOwner(pod), want.IPAddresses)
+	if err != nil {
+		return networkannotation.NetworkAnnotation{}, err
+	}
+
+	netAttachDef := &netAttachDefs.Items[0]
+	if netAttachDef.Labels == nil {
+		netAttachDef.Labels = map[string]string{}
+	}
+	netAttachDef.Labels[netAttachDefLabel] = "true"
+	if err := r.Update(ctx, netAttachDef); err != nil {
+		if releaseErr := r.allocator().Release(ctx, client.ObjectKeyFromObject(network), podLeaseOwner(pod)); releaseErr != nil {
+			log.FromContext(ctx).Error(releaseErr, "could not release addresses", "network", network.Name)
+		}
+		return networkannotation.NetworkAnnotation{}, fmt.Errorf("could not update network attachment definition %s: %w", netAttachDef.Name, err)
+	}
+
+	multusAnnotation := networkannotation.NetworkAnnotation{Name: netAttachDef.Name, Namespace: r.SwitchesNamespace, IPAddresses: addresses, CNIArgs: networkCNIArgs(network)}
+	if len(addresses) == 0 {
+		// As in the webhook, l2 interfaces get a link local address to bypass the static ipam plugin
+		multusAnnotation.GenerateIPv6Address()
+	}
+	return multusAnnotation, nil
+}
+
+// reusableAddresses reports whether a detached interface can be attached to the network, returning the addresses it
+// has to keep there. The addresses were set when the interface was plugged in the pod and can't be changed, so the
+// interface is only reused when they match the ones requested, or, if none were, when they are valid in the network.
+func reusableAddresses(network *l2smv1.L2Network, want networkannotation.NetworkAnnotation, netAttachDef networkannotation.NetworkAnnotation) ([]string, bool) {
+	addresses, err := normalizePrefixes(netAttachDef.IPAddresses)
+	if err != nil {
+		return nil, false
+	}
+	// the link local address is only a placeholder for l2 interfaces
+	addresses = slices.DeleteFunc(addresses, func(prefix netip.Prefix) bool { return prefix.Addr().IsLinkLocalUnicast() })
+
+	if len(want.IPAddresses) != 0 {
+		requested, err := normalizePrefixes(want.IPAddresses)
+		if err != nil || !slices.Equal(prefixStrings(requested), prefixStrings(addresses)) {
+			return nil, false
+		}
+		return prefixStrings(addresses), true
+	}
+
+	ranges, err := ipam.RangesForNetwork(network.Spec)
+	if err != nil {
+		return nil, false
+	}
+	if len(ranges) == 0 || len(addresses) == 0 {
+		return nil, len(ranges) == 0 && len(addresses) == 0
+	}
+	for _, prefix := range addresses {
+		r, ok := ipam.Contains(ranges, prefix.Addr())
+		if !ok || r.Subnet.Bits() != prefix.Bits() {
+			return nil, false
+		}
+	}
+	return prefixStrings(addresses), true
+}
+
+// updatePodInterfaces writes the interfaces in the latest version of the pod, if they changed.
+func (r *PodReconciler) updatePodInterfaces(ctx context.Context, pod *corev1.Pod, podInterfaces []PodInterface) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &corev1.Pod{}
+		if err := r.Get(ctx, client.ObjectKeyFromObject(pod), latest); err != nil {
+			return err
+		}
+		annotations := maps.Clone(latest.Annotations)
+		SetPodInterfaces(latest, podInterfaces)
+		if maps.Equal(annotations, latest.Annotations) {
+			return nil
+		}
+		return r.Update(ctx, latest)
+	})
+}
+
+func normalizePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	slices.SortFunc(prefixes, func(a, b netip.Prefix) int { return a.Addr().Compare(b.Addr()) })
+	return prefixes, nil
+}
+
+func prefixStrings(prefixes []netip.Prefix) []string {
+	values := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		values = append(values, prefix.String())
+	}
+	return values
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
+)
+
+var _ = Describe("Pod network hot-plug", func() {
+	Describe("diffPodNetworks", func() {
+		It("should detach the removed networks and attach the new ones", func() {
+			podInterfaces := []PodInterface{
+				{Network: "v-network-1", NetAttachDef: networkannotation.NetworkAnnotation{Name: "l2sm-overlay-veth1"}},
+				{Network: "", NetAttachDef: networkannotation.NetworkAnnotation{Name: "l2sm-overlay-veth2"}},
+				{Network: "v-network-2", NetAttachDef: networkannotation.NetworkAnnotation{Name: "l2sm-overlay-veth3"}},
+			}
+			desired := []networkannotation.NetworkAnnotation{{Name: "v-network-2"}, {Name: "v-network-3"}}
+
+			toDetach, toAttach := diffPodNetworks(podInterfaces, desired)
+			Expect(toDetach).To(Equal([]int{0}))
+			Expect(toAttach).To(Equal([]networkannotation.NetworkAnnotation{{Name: "v-network-3"}}))
+		})
+
+		It("should report nothing when the pod is in sync", func() {
+			podInterfaces := []PodInterface{{Network: "v-network-1", NetAttachDef: networkannotation.NetworkAnnotation{Name: "l2sm-overlay-veth1"}}}
+
+			toDetach, toAttach := diffPodNetworks(podInterfaces, []networkannotation.NetworkAnnotation{{Name: "v-network-1"}})
+			Expect(toDetach).To(BeEmpty())
+			Expect(toAttach).To(BeEmpty())
+		})
+	})
+
+	Describe("reusableAddresses", func() {
+		l2Network := &l2smv1.L2Network{}
+		l3Network := &l2smv1.L2Network{Spec: l2smv1.L2NetworkSpec{NetworkCIDR: "10.0.0.0/24"}}
+		l2Interface := networkannotation.NetworkAnnotation{Name: "l2sm-overlay-veth1", IPAddresses: []string{"fe80::1234/64"}}
+		l3Interface := networkannotation.NetworkAnnotation{Name: "l2sm-overlay-veth2", IPAddresses: []string{"10.0.0.7/24"}}
+
+		It("should reuse l2 interfaces for l2 networks only", func() {
+			_, ok := reusableAddresses(l2Network, networkannotation.NetworkAnnotation{}, l2Interface)
+			Expect(ok).To(BeTrue())
+			_, ok = reusableAddresses(l3Network, networkannotation.NetworkAnnotation{}, l2Interface)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("should keep the addresses of the interface when they are valid in the network", func() {
+			addresses, ok := reusableAddresses(l3Network, networkannotation.NetworkAnnotation{}, l3Interface)
+			Expect(ok).To(BeTrue())
+			Expect(addresses).To(Equal([]string{"10.0.0.7/24"}))
+
+			otherNetwork := &l2smv1.L2Network{Spec: l2smv1.L2NetworkSpec{NetworkCIDR: "10.1.0.0/24"}}
+			_, ok = reusableAddresses(otherNetwork, networkannotation.NetworkAnnotation{}, l3Interface)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("should only reuse interfaces with the requested addresses", func() {
+			_, ok := reusableAddresses(l3Network, networkannotation.NetworkAnnotation{IPAddresses: []string{"10.0.0.7/24"}}, l3Interface)
+			Expect(ok).To(BeTrue())
+			_, ok = reusableAddresses(l3Network, networkannotation.NetworkAnnotation{IPAddresses: []string{"10.0.0.8/24"}}, l3Interface)
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
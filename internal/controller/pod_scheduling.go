@@ -0,0 +1,290 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
+)
+
+// selectNode picks, among the nodes that fit the pod, the one with the most free interfaces.
+func (a *PodAnnotator) selectNode(ctx context.Context, pod *corev1.Pod, interfaces int) (string, error) {
+	nodeList := &corev1.NodeList{}
+	if err := a.Client.List(ctx, nodeList); err != nil {
+		return "", fmt.Errorf("could not list nodes: %w", err)
+	}
+	if len(nodeList.Items) == 0 {
+		return "", fmt.Errorf("no available nodes")
+	}
+
+	fit, failed, err := a.FilterNodes(ctx, pod, nodeList.Items, interfaces)
+	if err != nil {
+		return "", err
+	}
+	if len(fit) == 0 {
+		return "", fmt.Errorf("no node fits the pod: %s", summarizeFailedNodes(failed))
+	}
+	return fit[0], nil
+}
+
+// FilterNodes returns the names of the nodes where the pod can run with the given number of l2sm interfaces, sorted
+// by the number of free interfaces they have, and the reason why every other node was discarded. A node fits when it
+// matches the pod node selector, required node affinity and taint tolerations, runs an overlay switch and has enough
+// free interfaces.
+func (a *PodAnnotator) FilterNodes(ctx context.Context, pod *corev1.Pod, nodes []corev1.Node, interfaces int) ([]string, map[string]string, error) {
+	overlays := &l2smv1.OverlayList{}
+	if err := a.Client.List(ctx, overlays, client.InNamespace(a.SwitchesNamespace)); err != nil {
+		return nil, nil, fmt.Errorf("could not list overlays: %w", err)
+	}
+	switchNodes := map[string]bool{}
+	for _, overlay := range overlays.Items {
+		if overlay.Spec.Topology == nil {
+			continue
+		}
+		for _, node := range overlay.Spec.Topology.Nodes {
+			switchNodes[node] = true
+		}
+	}
+
+	freeInterfaces := map[string]int{}
+	failed := map[string]string{}
+	var fit []string
+	for i := range nodes {
+		node := &nodes[i]
+		if reason := nodeUnfitReason(pod, node); reason != "" {
+			failed[node.Name] = reason
+			continue
+		}
+		if !switchNodes[node.Name] {
+			failed[node.Name] = "node has no l2sm switch"
+			continue
+		}
+		free := len(GetFreeNetAttachDefs(ctx, a.Client, a.SwitchesNamespace, networkannotation.NET_ATTACH_LABEL_PREFIX+node.Name).Items)
+		if free < interfaces {
+			failed[node.Name] = fmt.Sprintf("node has %d free l2sm interfaces, %d needed", free, interfaces)
+			continue
+		}
+		freeInterfaces[node.Name] = free
+		fit = append(fit, node.Name)
+	}
+
+	sort.SliceStable(fit, func(i, j int) bool { return freeInterfaces[fit[i]] > freeInterfaces[fit[j]] })
+	return fit, failed, nil
+}
+
+// BindPod assigns the interfaces of the node to the pod and binds it to the node. It is used by the scheduler
+// extender, once the scheduler has chosen the node, so it only binds the pods with l2sm networks it was asked for.
+func (a *PodAnnotator) BindPod(ctx context.Context, key types.NamespacedName, uid types.UID, nodeName string) error {
+	pod := &corev1.Pod{}
+	if err := a.Client.Get(ctx, key, pod); err != nil {
+		return err
+	}
+	if pod.UID != uid {
+		return fmt.Errorf("pod %s has uid %s, not %s", key, pod.UID, uid)
+	}
+	annot, ok := pod.Annotations[networkannotation.L2SM_NETWORK_ANNOTATION]
+	if !ok {
+		return fmt.Errorf("pod %s has no l2sm networks", key)
+	}
+
+	assigned := false
+	if _, ok := pod.Annotations[networkannotation.MULTUS_ANNOTATION_KEY]; !ok {
+		l2NetAnnotations, err := networkannotation.ExtractNetworks(annot, a.SwitchesNamespace)
+		if err != nil {
+			return fmt.Errorf("could not extract l2 networks from the pod annotations: %w", err)
+		}
+		if err := a.resolveAddresses(ctx, pod, l2NetAnnotations); err != nil {
+			return err
+		}
+		pod.Spec.NodeName = nodeName
+		if err := a.assignNetworks(ctx, pod, l2NetAnnotations); err != nil {
+			return err
+		}
+		// the node name is set through the binding, so only the annotations are updated
+		pod.Spec.NodeName = ""
+		if err := a.Client.Update(ctx, pod); err != nil {
+			return fmt.Errorf("could not update pod annotations: %w", err)
+		}
+		log.FromContext(ctx).Info("Pod assigned to the l2networks", "pod", key.String(), "node", nodeName)
+		assigned = true
+	}
+
+	binding := &corev1.Binding{
+		ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace, UID: pod.UID},
+		Target:     corev1.ObjectReference{Kind: "Node", Name: nodeName},
+	}
+	if err := a.Client.SubResource("binding").Create(ctx, pod, binding); err != nil {
+		// the scheduler may pick another node on the next try, so the interfaces of this one are given back
+		if assigned {
+			a.unassignNetworks(ctx, pod, nodeName)
+		}
+		return err
+	}
+	return nil
+}
+
+// unassignNetworks gives back the interfaces of the node and the addresses the pod was assigned, and takes them out
+// of its annotations, so that the pod can be assigned again in another node.
+func (a *PodAnnotator) unassignNetworks(ctx context.Context, pod *corev1.Pod, nodeName string) {
+	logger := log.FromContext(ctx)
+	podInterfaces, err := GetPodInterfaces(pod, a.SwitchesNamespace)
+	if err != nil {
+		logger.Error(err, "Could not get the interfaces of the pod", "pod", pod.Name)
+		return
+	}
+	var lookup []networkannotation.NetworkAnnotation
+	for _, podInterface := range podInterfaces {
+		lookup = append(lookup, networkannotation.NetworkAnnotation{Name: podInterface.Network})
+	}
+	networks, _ := GetL2NetworksMap(ctx, a.Client, lookup)
+
+	netAttachDefLabel := networkannotation.NET_ATTACH_LABEL_PREFIX + nodeName
+	for _, podInterface := range podInterfaces {
+		netAttachDef := &nettypes.NetworkAttachmentDefinition{}
+		err := a.Client.Get(ctx, client.ObjectKey{Name: podInterface.NetAttachDef.Name, Namespace: a.SwitchesNamespace}, netAttachDef)
+		if err == nil {
+			delete(netAttachDef.Labels, netAttachDefLabel)
+			err = a.Client.Update(ctx, netAttachDef)
+		}
+		if client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "Could not release network attachment definition", "netattachdef", podInterface.NetAttachDef.Name)
+		}
+
+		network, ok := networks[podInterface.Network]
+		if !ok {
+			continue
+		}
+		if err := a.allocator().Release(ctx, client.ObjectKeyFromObject(&network), podLeaseOwner(pod)); err != nil {
+			logger.Error(err, "Could not release the addresses of the pod", "network", network.Name)
+		}
+		err = UpdateL2NetworkStatus(ctx, a.Client, client.ObjectKeyFromObject(&network), func(status *l2smv1.L2NetworkStatus) {
+			if status.ConnectedPodCount > 0 {
+				status.ConnectedPodCount--
+			}
+		})
+		if err != nil {
+			logger.Error(err, "Could not update l2network status", "network", network.Name)
+		}
+	}
+
+	delete(pod.Annotations, networkannotation.MULTUS_ANNOTATION_KEY)
+	delete(pod.Annotations, networkannotation.ATTACHED_NETWORKS_ANNOTATION)
+	if err := a.Client.Update(ctx, pod); err != nil {
+		logger.Error(err, "Could not remove the interfaces from the pod annotations", "pod", pod.Name)
+	}
+}
+
+// nodeUnfitReason checks the scheduling constraints of the pod against the node, returning why it doesn't fit or an
+// empty string if it does.
+func nodeUnfitReason(pod *corev1.Pod, node *corev1.Node) string {
+	if node.Spec.Unschedulable {
+		return "node is unschedulable"
+	}
+	if !labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return "node doesn't match the pod node selector"
+	}
+	if affinity := pod.Spec.Affinity; affinity != nil && affinity.NodeAffinity != nil {
+		if required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution; required != nil && !matchesNodeSelectorTerms(node, required.NodeSelectorTerms) {
+			return "node doesn't match the pod node affinity"
+		}
+	}
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect != corev1.TaintEffectNoSchedule && taint.Effect != corev1.TaintEffectNoExecute {
+			continue
+		}
+		if !slices.ContainsFunc(pod.Spec.Tolerations, func(toleration corev1.Toleration) bool { return toleration.ToleratesTaint(taint) }) {
+			return fmt.Sprintf("node has the untolerated taint %s", taint.Key)
+		}
+	}
+	return ""
+}
+
+// matchesNodeSelectorTerms reports whether the node matches any of the terms. Within a term, every requirement has
+// to be met.
+func matchesNodeSelectorTerms(node *corev1.Node, terms []corev1.NodeSelectorTerm) bool {
+	for _, term := range terms {
+		if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+			continue
+		}
+		selector, err := nodeSelectorRequirementsAsSelector(term.MatchExpressions)
+		if err != nil || !selector.Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		fieldSelector, err := nodeSelectorRequirementsAsSelector(term.MatchFields)
+		if err != nil || !fieldSelector.Matches(labels.Set{"metadata.name": node.Name}) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func nodeSelectorRequirementsAsSelector(requirements []corev1.NodeSelectorRequirement) (labels.Selector, error) {
+	operators := map[corev1.NodeSelectorOperator]selection.Operator{
+		corev1.NodeSelectorOpIn:           selection.In,
+		corev1.NodeSelectorOpNotIn:        selection.NotIn,
+		corev1.NodeSelectorOpExists:       selection.Exists,
+		corev1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+		corev1.NodeSelectorOpGt:           selection.GreaterThan,
+		corev1.NodeSelectorOpLt:           selection.LessThan,
+	}
+
+	selector := labels.NewSelector()
+	for _, requirement := range requirements {
+		operator, ok := operators[requirement.Operator]
+		if !ok {
+			return nil, fmt.Errorf("invalid node selector operator %q", requirement.Operator)
+		}
+		r, err := labels.NewRequirement(requirement.Key, operator, requirement.Values)
+		if err != nil {
+			return nil, err
+		}
+		selector = selector.Add(*r)
+	}
+	return selector, nil
+}
+
+func summarizeFailedNodes(failed map[string]string) string {
+	if len(failed) == 0 {
+		return "no nodes"
+	}
+	names := make([]string, 0, len(failed))
+	for name := range failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reasons := make([]string, 0, len(names))
+	for _, name := range names {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", name, failed[name]))
+	}
+	return strings.Join(reasons, "; ")
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+
+	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
+)
+
+var _ = Describe("Pod node selection", func() {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{"zone": "edge", "cores": "8"}},
+		Spec: corev1.NodeSpec{
+			Taints: []corev1.Taint{{Key: "dedicated", Value: "l2sm", Effect: corev1.TaintEffectNoSchedule}},
+		},
+	}
+	toleration := corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "l2sm", Effect: corev1.TaintEffectNoSchedule}
+
+	It("accepts a node that matches every constraint", func() {
+		pod := &corev1.Pod{Spec: corev1.PodSpec{
+			NodeSelector: map[string]string{"zone": "edge"},
+			Tolerations:  []corev1.Toleration{toleration},
+			Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "cores", Operator: corev1.NodeSelectorOpGt, Values: []string{"4"}}}},
+				}},
+			}},
+		}}
+		Expect(nodeUnfitReason(pod, node)).To(BeEmpty())
+	})
+
+	It("rejects nodes with untolerated taints", func() {
+		pod := &corev1.Pod{}
+		Expect(nodeUnfitReason(pod, node)).To(ContainSubstring("taint"))
+	})
+
+	It("rejects nodes outside the node selector or affinity", func() {
+		pod := &corev1.Pod{Spec: corev1.PodSpec{
+			NodeSelector: map[string]string{"zone": "core"},
+			Tolerations:  []corev1.Toleration{toleration},
+		}}
+		Expect(nodeUnfitReason(pod, node)).To(ContainSubstring("node selector"))
+
+		pod.Spec.NodeSelector = nil
+		pod.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+				{MatchFields: []corev1.NodeSelectorRequirement{{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{"node-b"}}}},
+			}},
+		}}
+		Expect(nodeUnfitReason(pod, node)).To(ContainSubstring("affinity"))
+	})
+})
+
+var _ = Describe("Pod binding", func() {
+	ctx := context.Background()
+
+	It("only binds the pods with l2sm networks it was asked for", func() {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		plain := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: "default", UID: "plain-uid"}}
+		l2sm := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "ping", Namespace: "default", UID: "ping-uid",
+			Annotations: map[string]string{networkannotation.L2SM_NETWORK_ANNOTATION: "ping-network"}}}
+		annotator := &PodAnnotator{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(plain, l2sm).Build()}
+
+		err := annotator.BindPod(ctx, client.ObjectKeyFromObject(plain), plain.UID, "node-a")
+		Expect(err).To(MatchError(ContainSubstring("has no l2sm networks")))
+
+		err = annotator.BindPod(ctx, client.ObjectKeyFromObject(l2sm), "other-uid", "node-a")
+		Expect(err).To(MatchError(ContainSubstring("has uid ping-uid")))
+	})
+
+	It("gives back the interfaces and addresses when the pod can't be bound", func() {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(l2smv1.AddToScheme(scheme)).To(Succeed())
+		Expect(nettypes.AddToScheme(scheme)).To(Succeed())
+		network := &l2smv1.L2Network{
+			ObjectMeta: metav1.ObjectMeta{Name: "net", Namespace: "default"},
+			Spec:       l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, NetworkCIDR: "10.0.0.0/24"},
+		}
+		netAttachDef := &nettypes.NetworkAttachmentDefinition{
+			ObjectMeta: metav1.ObjectMeta{Name: "veth1", Namespace: "l2sm-system", Labels: map[string]string{"app": "l2sm"}},
+		}
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "ping", Namespace: "default", UID: "ping-uid",
+			Annotations: map[string]string{networkannotation.L2SM_NETWORK_ANNOTATION: `[{"name":"net"}]`}}}
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&l2smv1.L2Network{}).
+			WithObjects(network, netAttachDef, pod).
+			WithInterceptorFuncs(interceptor.Funcs{
+				SubResourceCreate: func(context.Context, client.Client, string, client.Object, client.Object, ...client.SubResourceCreateOption) error {
+					return errors.New("node is gone")
+				},
+			}).Build()
+		annotator := &PodAnnotator{Client: c, SwitchesNamespace: "l2sm-system"}
+
+		err := annotator.BindPod(ctx, client.ObjectKeyFromObject(pod), pod.UID, "node-a")
+		Expect(err).To(MatchError("node is gone"))
+
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(network), network)).To(Succeed())
+		Expect(network.Status.AssignedIPs).To(BeEmpty())
+		Expect(network.Status.ConnectedPodCount).To(Equal(0))
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(netAttachDef), netAttachDef)).To(Succeed())
+		Expect(netAttachDef.Labels).NotTo(HaveKey(networkannotation.NET_ATTACH_LABEL_PREFIX + "node-a"))
+		Expect(c.Get(ctx, client.ObjectKeyFromObject(pod), pod)).To(Succeed())
+		Expect(pod.Annotations).NotTo(HaveKey(networkannotation.MULTUS_ANNOTATION_KEY))
+	})
+})
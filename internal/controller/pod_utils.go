@@ -18,17 +18,20 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
-	"net"
 	"strings"
 
 	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
 	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
 	"github.com/Networks-it-uc3m/L2S-M/internal/talpainterface"
+	"github.com/Networks-it-uc3m/L2S-M/internal/utils"
+	dp "github.com/Networks-it-uc3m/l2sm-switch/pkg/datapath"
 	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
@@ -36,8 +39,19 @@ import (
 const (
 	ERROR_ANNOTATION   = "l2sm/error"
 	L2SM_PODNAME_LABEL = "l2sm/app"
+	// leaseOwnerAnnotation holds the token the addresses of a pod are leased to when it is admitted without a name,
+	// as the pods of a ReplicaSet are, until the pod controller hands them over to its name.
+	leaseOwnerAnnotation = "l2sm/lease-owner"
 )
 
+// podLeaseOwner returns who the addresses of the pod are leased to.
+func podLeaseOwner(pod *corev1.Pod) string {
+	if owner, ok := pod.Annotations[leaseOwnerAnnotation]; ok {
+		return owner
+	}
+	return pod.Name
+}
+
 func GetL2Networks(ctx context.Context, c client.Client, networks []networkannotation.NetworkAnnotation) ([]l2smv1.L2Network, error) {
 	// List all L2Networks
 	l2Networks := &l2smv1.L2NetworkList{}
@@ -46,10 +60,7 @@ func GetL2Networks(ctx context.Context, c client.Client, networks []networkannot
 	}
 
 	// Create a map of existing L2Network names to L2Network objects for quick lookup
-	existingNetworks := make(map[string]l2smv1.L2Network)
-	for _, network := range l2Networks.Items {
-		existingNetworks[network.Name] = network
-	}
+	existingNetworks := l2NetworksByName(l2Networks.Items)
 
 	// Collect the L2Networks that match the requested networks
 	var result l2smv1.L2NetworkList
@@ -64,6 +75,15 @@ func GetL2Networks(ctx context.Context, c client.Client, networks []networkannot
 	return result.Items, nil
 }
 
+// l2NetworksByName indexes the networks by their name, which is how pods refer to them, whatever their namespace.
+func l2NetworksByName(networks []l2smv1.L2Network) map[string]l2smv1.L2Network {
+	byName := make(map[string]l2smv1.L2Network, len(networks))
+	for _, network := range networks {
+		byName[network.Name] = network
+	}
+	return byName
+}
+
 // TODO: join methods together
 func GetL2NetworksMap(ctx context.Context, c client.Client, networks []networkannotation.NetworkAnnotation) (map[string]l2smv1.L2Network, error) {
 	// List all L2Networks
@@ -74,23 +94,145 @@ func GetL2NetworksMap(ctx context.Context, c client.Client, networks []networkan
 	}
 
 	// Create a map of existing L2Network names to L2Network objects for quick lookup
-	existingNetworks := make(map[string]l2smv1.L2Network)
-	for _, network := range l2Networks.Items {
-		existingNetworks[network.Name] = network
-	}
+	existingNetworks := l2NetworksByName(l2Networks.Items)
 
-	// Collect the L2Networks that match the requested networks
+	// Collect the L2Networks that match the requested networks. The ones that exist are returned even if some don't.
+	var missing []string
 	for _, net := range networks {
 		if l2net, exists := existingNetworks[net.Name]; exists {
 			result[net.Name] = l2net
 		} else {
-			return result, fmt.Errorf("network %s doesn't exist", net.Name)
+			missing = append(missing, net.Name)
 		}
 	}
+	if len(missing) != 0 {
+		return result, fmt.Errorf("network %s doesn't exist", strings.Join(missing, ", "))
+	}
 
 	return result, nil
 }
 
+// Bounds of the MTU of the interfaces. IPv6, which every pod interface uses for its link local address, needs at least
+// 1280, and 9216 is the largest jumbo frame most NICs take.
+const (
+	defaultInterfaceMTU = 1400
+	minInterfaceMTU     = 1280
+	maxInterfaceMTU     = 9216
+)
+
+// overlayInterfaceMTU returns the MTU of the interfaces and bridges of the overlay.
+func overlayInterfaceMTU(overlay *l2smv1.Overlay) int {
+	if overlay.Spec.InterfaceOptions == nil || overlay.Spec.InterfaceOptions.MTU == 0 {
+		return defaultInterfaceMTU
+	}
+	return overlay.Spec.InterfaceOptions.MTU
+}
+
+// networkCNIArgs returns the CNI arguments of the interfaces attached to the network. They carry the MTU of the
+// network, if it overrides the one of the overlay, for the tuning plugin of the interface.
+func networkCNIArgs(network *l2smv1.L2Network) map[string]any {
+	if network.Spec.MTU == 0 {
+		return nil
+	}
+	return map[string]any{"mtu": network.Spec.MTU}
+}
+
+// PodNetworkPort is the switch port a pod uses to reach one of its l2networks.
+type PodNetworkPort struct {
+	Network string
+	Port    string
+}
+
+// PodInterface is one of the multus interfaces of a pod, along with the l2network it is attached to. Network is empty
+// when the interface has been detached from its network.
+type PodInterface struct {
+	Network      string
+	NetAttachDef networkannotation.NetworkAnnotation
+}
+
+// GetPodInterfaces pairs the multus interfaces of the pod with the l2networks they are attached to. Pods that were
+// attached before the attached networks annotation existed have their interfaces in the order of the l2sm annotation.
+func GetPodInterfaces(pod *corev1.Pod, switchesNamespace string) ([]PodInterface, error) {
+	multusAnnotations, ok := pod.Annotations[networkannotation.MULTUS_ANNOTATION_KEY]
+	if !ok {
+		return nil, fmt.Errorf("pod is missing the multus annotation")
+	}
+	multusNetAttachDefinitions, err := networkannotation.ExtractNetworks(multusAnnotations, switchesNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("could not extract multus annotations: %w", err)
+	}
+
+	var attached []string
+	if annotation, ok := pod.Annotations[networkannotation.ATTACHED_NETWORKS_ANNOTATION]; ok {
+		if attached, err = networkannotation.ExtractAttachedNetworks(annotation); err != nil {
+			return nil, err
+		}
+	} else {
+		networkAnnotations, err := networkannotation.ExtractNetworks(pod.Annotations[networkannotation.L2SM_NETWORK_ANNOTATION], switchesNamespace)
+		if err != nil {
+			return nil, fmt.Errorf("could not extract l2 networks from the pod annotations: %w", err)
+		}
+		for _, networkAnnotation := range networkAnnotations {
+			attached = append(attached, networkAnnotation.Name)
+		}
+	}
+	if len(multusNetAttachDefinitions) != len(attached) {
+		return nil, fmt.Errorf("pod has %d l2 networks but %d multus attachments", len(attached), len(multusNetAttachDefinitions))
+	}
+
+	interfaces := make([]PodInterface, 0, len(attached))
+	for i := range attached {
+		interfaces = append(interfaces, PodInterface{Network: attached[i], NetAttachDef: multusNetAttachDefinitions[i]})
+	}
+	return interfaces, nil
+}
+
+// SetPodInterfaces writes the interfaces in the multus and attached networks annotations of the pod.
+func SetPodInterfaces(pod *corev1.Pod, interfaces []PodInterface) {
+	multusAnnotations := make([]networkannotation.NetworkAnnotation, 0, len(interfaces))
+	attached := make([]string, 0, len(interfaces))
+	for _, podInterface := range interfaces {
+		multusAnnotations = append(multusAnnotations, podInterface.NetAttachDef)
+		attached = append(attached, podInterface.Network)
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[networkannotation.MULTUS_ANNOTATION_KEY] = networkannotation.MultusAnnotationToString(multusAnnotations)
+	pod.Annotations[networkannotation.ATTACHED_NETWORKS_ANNOTATION] = networkannotation.AttachedNetworksToString(attached)
+}
+
+// GetPodSwitchPort returns the port of the node switch the interface is plugged into.
+func GetPodSwitchPort(pod *corev1.Pod, netAttachDef networkannotation.NetworkAnnotation) (string, error) {
+	portNumber, err := utils.GetPortNumberFromNetAttachDef(netAttachDef.Name)
+	if err != nil {
+		return "", fmt.Errorf("could not get port number from network attachment definition %q: %w", netAttachDef.Name, err)
+	}
+	ofID := fmt.Sprintf("of:%s", dp.GenerateID(dp.GetSwitchName(dp.DatapathParams{NodeName: pod.Spec.NodeName, ProviderName: l2smv1.OVERLAY_PROVIDER})))
+	return fmt.Sprintf("%s/%s", ofID, portNumber), nil
+}
+
+// GetPodNetworkPorts returns the switch port of every interface of the pod that is attached to a l2network.
+func GetPodNetworkPorts(pod *corev1.Pod, switchesNamespace string) ([]PodNetworkPort, error) {
+	interfaces, err := GetPodInterfaces(pod, switchesNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make([]PodNetworkPort, 0, len(interfaces))
+	for _, podInterface := range interfaces {
+		if podInterface.Network == "" {
+			continue
+		}
+		port, err := GetPodSwitchPort(pod, podInterface.NetAttachDef)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, PodNetworkPort{Network: podInterface.Network, Port: port})
+	}
+	return ports, nil
+}
+
 func GetFreeNetAttachDefs(ctx context.Context, c client.Client, switchesNamespace, label string) nettypes.NetworkAttachmentDefinitionList {
 
 	// We define the network attachment definition list that will be later filled.
@@ -197,35 +339,15 @@ func CreateErrorEvent(
 
 }
 
-// GetNextAvailableIP generates the next available IP dynamically and returns it with the subnet mask.
-func GetNextAvailableIP(networkCIDR, lastAssignedIP string, assignedIPs map[string]string) (string, string, error) {
-	_, ipNet, err := net.ParseCIDR(networkCIDR)
-	if err != nil {
-		return "", "", fmt.Errorf("invalid CIDR: %s", networkCIDR)
-	}
-
-	mask, _ := ipNet.Mask.Size()
-	subnetMask := fmt.Sprintf("/%d", mask)
-
-	// Convert last assigned IP to net.IP
-	var startIP net.IP
-	if lastAssignedIP == "" || net.ParseIP(lastAssignedIP) == nil {
-		// Start at the first usable IP
-		startIP = ipNet.IP
-		startIP[len(startIP)-1]++ // Increment to skip network address
-	} else {
-		startIP = net.ParseIP(lastAssignedIP)
-		startIP[len(startIP)-1]++ // Move to the next IP
-
-	}
-
-	// Find the next unallocated IP
-	for ip := startIP; ipNet.Contains(ip); ip[len(ip)-1]++ {
-		if _, exists := assignedIPs[ip.String()]; !exists {
-			// Return the IP and subnet
-			return ip.String(), subnetMask, nil
+// UpdateL2NetworkStatus applies the mutation over the latest version of the network status, retrying when it
+// conflicts with a concurrent writer such as the IPAM allocator.
+func UpdateL2NetworkStatus(ctx context.Context, c client.Client, key types.NamespacedName, mutate func(status *l2smv1.L2NetworkStatus)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		network := &l2smv1.L2Network{}
+		if err := c.Get(ctx, key, network); err != nil {
+			return err
 		}
-	}
-
-	return "", "", fmt.Errorf("no available IPs in CIDR: %s", networkCIDR)
+		mutate(&network.Status)
+		return c.Status().Update(ctx, network)
+	})
 }
@@ -25,6 +25,7 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
 	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -152,6 +153,64 @@ var _ = Describe("Pod Utils", func() {
 			Expect(names).To(ContainElements("nad2", "nad3"))
 		})
 	})
+
+	Describe("GetPodNetworkPorts", func() {
+		It("should pair every l2network with the switch port of its interface", func() {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "ping",
+					Annotations: map[string]string{
+						networkannotation.L2SM_NETWORK_ANNOTATION: "v-network-1, v-network-2",
+						networkannotation.MULTUS_ANNOTATION_KEY:   `[{"name": "l2sm-overlay-veth3"}, {"name": "l2sm-overlay-veth12"}]`,
+					},
+				},
+				Spec: corev1.PodSpec{NodeName: "node-a"},
+			}
+
+			ports, err := GetPodNetworkPorts(pod, "l2sm-system")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ports).To(HaveLen(2))
+			Expect(ports[0].Network).To(Equal("v-network-1"))
+			Expect(ports[0].Port).To(MatchRegexp(`^of:[0-9a-f]+/3$`))
+			Expect(ports[1].Network).To(Equal("v-network-2"))
+			Expect(ports[1].Port).To(MatchRegexp(`^of:[0-9a-f]+/12$`))
+		})
+
+		It("should fail when the interfaces don't match the networks", func() {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "ping",
+					Annotations: map[string]string{
+						networkannotation.L2SM_NETWORK_ANNOTATION: "v-network-1, v-network-2",
+						networkannotation.MULTUS_ANNOTATION_KEY:   `[{"name": "l2sm-overlay-veth3"}]`,
+					},
+				},
+			}
+
+			_, err := GetPodNetworkPorts(pod, "l2sm-system")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should follow the attached networks and skip detached interfaces", func() {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "ping",
+					Annotations: map[string]string{
+						networkannotation.L2SM_NETWORK_ANNOTATION:      "v-network-2",
+						networkannotation.MULTUS_ANNOTATION_KEY:        `[{"name": "l2sm-overlay-veth3"}, {"name": "l2sm-overlay-veth12"}]`,
+						networkannotation.ATTACHED_NETWORKS_ANNOTATION: `["", "v-network-2"]`,
+					},
+				},
+				Spec: corev1.PodSpec{NodeName: "node-a"},
+			}
+
+			ports, err := GetPodNetworkPorts(pod, "l2sm-system")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ports).To(HaveLen(1))
+			Expect(ports[0].Network).To(Equal("v-network-2"))
+			Expect(ports[0].Port).To(MatchRegexp(`^of:[0-9a-f]+/12$`))
+		})
+	})
 })
 
 func createNamespace(ctx context.Context, name string) error {
@@ -17,13 +17,15 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
-	"net"
 	"net/http"
 
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/ipam"
 	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
 	"github.com/go-logr/logr"
+	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	corev1 "k8s.io/api/core/v1"
@@ -31,11 +33,17 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
+//+kubebuilder:rbac:groups="",resources=pods/binding,verbs=create
+
 // +kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=fail,groups="",resources=pods,verbs=update,versions=v1,name=mpod.kb.io
 type PodAnnotator struct {
 	Client            client.Client
 	Decoder           *admission.Decoder
 	SwitchesNamespace string
+	IPAM              ipam.Allocator
+	// ExtenderSchedulerName is the scheduler that uses the l2sm scheduler extender, if any. Its pods are left without
+	// a node, so that it places them with the help of the extender, which assigns the interfaces when binding the pod.
+	ExtenderSchedulerName string
 }
 
 func (a *PodAnnotator) Handle(ctx context.Context, req admission.Request) admission.Response {
@@ -51,169 +59,234 @@ func (a *PodAnnotator) Handle(ctx context.Context, req admission.Request) admiss
 	if !pod.ObjectMeta.DeletionTimestamp.IsZero() {
 		return admission.Allowed("Allowing pod's deletion")
 	}
-
-	if pod.Spec.NodeName == "" {
-		// List available nodes.
-		nodeList := &corev1.NodeList{}
-		if err := a.Client.List(ctx, nodeList); err != nil {
-			log.Error(err, "Error listing nodes")
-			return admission.Errored(http.StatusInternalServerError, err)
-		}
-		if len(nodeList.Items) == 0 {
-			return admission.Errored(http.StatusInternalServerError, fmt.Errorf("no available nodes"))
-		}
-
-		// Seed the random number generator.
-
-		selectedNode := nodeList.Items[rand.Intn(len(nodeList.Items))].Name
-		log.Info("Selected node", "node", selectedNode)
-		pod.Spec.NodeName = selectedNode
-	}
 	if _, ok := pod.Annotations[ERROR_ANNOTATION]; ok {
 		return admission.Allowed("Already errored creation")
 	}
 	// Check if the pod has the annotation l2sm/networks. This webhook operation only will happen if so. Else, it will just
 	// let the creation begin.
-	if annot, ok := pod.Annotations[networkannotation.L2SM_NETWORK_ANNOTATION]; ok {
-		if _, ok := pod.Annotations[networkannotation.MULTUS_ANNOTATION_KEY]; ok {
-			return admission.Allowed("Pod already using multus cni plugin")
-		}
-		netAttachDefLabel := networkannotation.NET_ATTACH_LABEL_PREFIX + pod.Spec.NodeName
-		// We extract which networks the user intends to attach the pod to. If there is any error, or the
-		// Networks aren't created, the pod will be set as errored, until a network is created.
-		l2NetAnnotations, err := networkannotation.ExtractNetworks(annot, a.SwitchesNamespace)
-		if err != nil {
-			log.Error(err, "L2S-M Network annotations could not be extracted")
-			return admission.Errored(http.StatusInternalServerError, err)
-		}
-
-		// Map of the l2networks for quick lookup
-		networkResources, err := GetL2NetworksMap(ctx, a.Client, l2NetAnnotations)
-		if err != nil {
-			log.Info("Pod's network annotation incorrect. L2Network not attached.")
-			// return admission.Allowed("Pod's network annotation incorrect. L2Network not attached.")
+	annot, ok := pod.Annotations[networkannotation.L2SM_NETWORK_ANNOTATION]
+	if !ok {
+		return admission.Allowed("Pod not using l2sm networks")
+	}
+	if _, ok := pod.Annotations[networkannotation.MULTUS_ANNOTATION_KEY]; ok {
+		return admission.Allowed("Pod already using multus cni plugin")
+	}
 
-		}
+	// Pods created with a generated name don't have it yet, so their addresses are leased to a token of the request
+	if pod.Name == "" {
+		pod.Annotations[leaseOwnerAnnotation] = pod.GenerateName + string(req.UID)
+	}
 
-		// We get the available network attachment definitions. These are interfaces attached to the switches, so
-		// by using labelling, we can know which interfaces the switch has.
-		var multusAnnotations []networkannotation.NetworkAnnotation
-		netAttachDefs := GetFreeNetAttachDefs(ctx, a.Client, a.SwitchesNamespace, netAttachDefLabel)
+	// We extract which networks the user intends to attach the pod to. If there is any error, or the
+	// Networks aren't created, the pod will be set as errored, until a network is created.
+	l2NetAnnotations, err := networkannotation.ExtractNetworks(annot, a.SwitchesNamespace)
+	if err != nil {
+		log.Error(err, "L2S-M Network annotations could not be extracted")
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
 
-		// If there are no available network attachment definitions, we can't attach the pod to the desired networks
-		// So, we launch an error.
-		fmt.Println(a.SwitchesNamespace)
-		if len(netAttachDefs.Items) < len(l2NetAnnotations) {
-			msg := fmt.Sprintf("No interfaces available for node %s", pod.Spec.NodeName)
-			return patchErrorPod(req, pod, &log, msg)
+	// The static addresses, or the ones reserved for the pod, are checked before anything is changed, so that a pod
+	// that can't get them is rejected instead of created in error.
+	if err := a.resolveAddresses(ctx, pod, l2NetAnnotations); err != nil {
+		if errors.Is(err, ipam.ErrConflict) || errors.Is(err, ipam.ErrOutOfRange) {
+			log.Info("Pod addresses rejected", "reason", err.Error())
+			return admission.Denied(err.Error())
 		}
-		// Now we create the multus annotations, by using the network attachment definition name
-		// And the desired IP address.
-		for index, l2NetAnnot := range l2NetAnnotations {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
 
-			// We get the l2network from the l2network map, based on the annotation
-			network, ok := networkResources[l2NetAnnot.Name]
-			if !ok {
-				log.Error(err, "Could not retrieve l2network")
-			}
+	if pod.Spec.NodeName == "" {
+		// When the pod uses the scheduler of the extender, it picks the node and the interfaces are assigned when binding
+		if a.ExtenderSchedulerName != "" && pod.Spec.SchedulerName == a.ExtenderSchedulerName {
+			return admission.Allowed("Pod scheduling deferred to the l2sm scheduler extender")
+		}
 
-			// Array of the ip addresses we'll try to assign the pod
-			var assignIPAddr []string
+		// We pick a node that fits the pod scheduling constraints and has enough free interfaces for all its networks
+		selectedNode, err := a.selectNode(ctx, pod, len(l2NetAnnotations))
+		if err != nil {
+			log.Error(err, "No node fits the pod")
+			return patchErrorPod(req, pod, &log, err.Error())
+		}
+		log.Info("Selected node", "node", selectedNode)
+		pod.Spec.NodeName = selectedNode
+	}
 
-			// We get the network attachment definition as an annotation for the pod. If switches namespace is not set,
-			//it will be the same as the pod's namespace
-			netAttachDef := &netAttachDefs.Items[index]
+	if err := a.assignNetworks(ctx, pod, l2NetAnnotations); err != nil {
+		var attachErr *podAttachError
+		if errors.As(err, &attachErr) {
+			return patchErrorPod(req, pod, &log, attachErr.Error())
+		}
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
 
-			// New annotation is the multus that will be attached to the pod
-			multusAnnotation := networkannotation.NetworkAnnotation{Name: netAttachDef.Name, Namespace: a.SwitchesNamespace}
+	// pod.Annotations["k8s.v1.cni.cncf.io/networks"] = `[{"name": "veth10","ips": ["10.0.0.1/24"]}]`
+	log.Info("Pod assigned to the l2networks")
 
-			// If the user specified a static ip address, we will use that
-			if len(l2NetAnnot.IPAddresses) != 0 {
-				assignIPAddr = l2NetAnnot.IPAddresses
-			} else {
+	marshaledPod, err := json.Marshal(pod)
+	if err != nil {
+		log.Error(err, "Error marshaling pod")
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+}
 
-				// Else, we check if the l2network has a l3 config or not
-				// If it hasn't got an ip address, and the network is not set to layer 3, by default it will be layer 2
-				if network.Spec.NetworkCIDR != "" {
+// podAttachError is an error that prevents the pod from being attached to its networks, and that is reported to the
+// user through the pod error annotation.
+type podAttachError struct {
+	msg string
+}
 
-					// We take the network address range and the pod address range. The network one specifies the routing option; the pod range is
-					// inside that subnet specifying which available ip address to take. This is because we want compatibility with inter domain networks
-					// where logic is not fully shared
-					addressRange := network.Spec.NetworkCIDR
-					_, ipNet, err := net.ParseCIDR(addressRange)
-					subnet, _ := ipNet.Mask.Size()
-					subnetMask := fmt.Sprintf("/%d", subnet)
+func (e *podAttachError) Error() string {
+	return e.msg
+}
 
-					if err != nil {
-						log.Error(err, "NetworkCIDR couldn't be parsed correctly", "network", network.Name)
-					}
+// resolveAddresses sets the addresses the pod gets in each network, the static ones or the ones reserved for it, and
+// checks that they can be reserved. Networks that don't exist are left to assignNetworks.
+func (a *PodAnnotator) resolveAddresses(ctx context.Context, pod *corev1.Pod, l2NetAnnotations []networkannotation.NetworkAnnotation) error {
+	networkResources, _ := GetL2NetworksMap(ctx, a.Client, l2NetAnnotations)
+	for i := range l2NetAnnotations {
+		network, ok := networkResources[l2NetAnnotations[i].Name]
+		if !ok {
+			continue
+		}
+		requested, err := ipam.RequestedAddresses(network.Spec, pod.Name, pod.Labels, l2NetAnnotations[i].IPAddresses)
+		if err != nil {
+			return fmt.Errorf("invalid addresses for network %s: %w", network.Name, err)
+		}
+		if err := ipam.Check(&network, podLeaseOwner(pod), requested); err != nil {
+			return fmt.Errorf("invalid addresses for network %s: %w", network.Name, err)
+		}
+		l2NetAnnotations[i].IPAddresses = requested
+	}
+	return nil
+}
 
-					if network.Spec.PodAddressRange != "" {
-						addressRange = network.Spec.PodAddressRange
-					}
+// assignNetworks picks a free interface of the pod node for every network and reserves its addresses, writing the
+// resulting multus annotation in the pod.
+func (a *PodAnnotator) assignNetworks(ctx context.Context, pod *corev1.Pod, l2NetAnnotations []networkannotation.NetworkAnnotation) error {
+	log := log.FromContext(ctx)
+	netAttachDefLabel := networkannotation.NET_ATTACH_LABEL_PREFIX + pod.Spec.NodeName
 
-					// We take the next available ip address from the network assigned ips, checking it's not been already assigned.
-					nextIP, _, err := GetNextAvailableIP(addressRange, network.Status.LastAssignedIP, network.Status.AssignedIPs)
+	// Map of the l2networks for quick lookup
+	networkResources, err := GetL2NetworksMap(ctx, a.Client, l2NetAnnotations)
+	if err != nil {
+		log.Info("Pod's network annotation incorrect. L2Network not attached.")
+		// return admission.Allowed("Pod's network annotation incorrect. L2Network not attached.")
 
-					if err != nil {
-						log.Error(err, "No available IP addresses for network", "network", network.Name)
-					}
+	}
 
-					network.Status.LastAssignedIP = nextIP
-					assignIPAddr = append(assignIPAddr, nextIP+subnetMask)
-				}
+	// We get the available network attachment definitions. These are interfaces attached to the switches, so
+	// by using labelling, we can know which interfaces the switch has.
+	var interfaces []PodInterface
+	netAttachDefs := GetFreeNetAttachDefs(ctx, a.Client, a.SwitchesNamespace, netAttachDefLabel)
 
+	// If there are no available network attachment definitions, we can't attach the pod to the desired networks
+	// So, we launch an error.
+	if len(netAttachDefs.Items) < len(l2NetAnnotations) {
+		return &podAttachError{msg: fmt.Sprintf("No interfaces available for node %s", pod.Spec.NodeName)}
+	}
+	// If a network can't be attached, the interfaces and addresses taken for the previous ones are given back
+	var taken []*nettypes.NetworkAttachmentDefinition
+	var allocated, counted []*l2smv1.L2Network
+	rollback := func() {
+		for _, netAttachDef := range taken {
+			delete(netAttachDef.Labels, netAttachDefLabel)
+			if err := a.Client.Update(ctx, netAttachDef); err != nil {
+				log.Error(err, "Could not release network attachment definition", "netattachdef", netAttachDef.Name)
 			}
-
-			// If there is ipv4, we update the multus annotation and network to notify the new ip and pod
-			if len(assignIPAddr) != 0 {
-				multusAnnotation.IPAddresses = assignIPAddr
-				if network.Status.AssignedIPs == nil {
-					network.Status.AssignedIPs = make(map[string]string)
+		}
+		for _, network := range allocated {
+			if err := a.allocator().Release(ctx, client.ObjectKeyFromObject(network), podLeaseOwner(pod)); err != nil {
+				log.Error(err, "Could not release the addresses of the pod", "network", network.Name)
+			}
+		}
+		for _, network := range counted {
+			err := UpdateL2NetworkStatus(ctx, a.Client, client.ObjectKeyFromObject(network), func(status *l2smv1.L2NetworkStatus) {
+				if status.ConnectedPodCount > 0 {
+					status.ConnectedPodCount--
 				}
+			})
+			if err != nil {
+				log.Error(err, "Could not update l2network status", "network", network.Name)
+			}
+		}
+	}
 
-				// Now safely assign the IP to the pod
-				assignedIPAddress, _, _ := net.ParseCIDR(multusAnnotation.IPAddresses[0])
-				network.Status.AssignedIPs[assignedIPAddress.String()] = pod.Name
-
-			} else {
+	// Now we create the multus annotations, by using the network attachment definition name
+	// And the desired IP address.
+	for index, l2NetAnnot := range l2NetAnnotations {
 
-				// If there is no ipv4, it means its L2, so to bypass the static ipam plugin, we give a localhost ipv6 to the annotation
-				multusAnnotation.GenerateIPv6Address()
-			}
+		// We get the l2network from the l2network map, based on the annotation
+		network, ok := networkResources[l2NetAnnot.Name]
+		if !ok {
+			log.Error(err, "Could not retrieve l2network")
+		}
 
-			// We update the net attach definition to specify that for this pod node it's taken, and the network to say it has 1 more pod
-			network.Status.ConnectedPodCount++
+		// We get the network attachment definition as an annotation for the pod. If switches namespace is not set,
+		//it will be the same as the pod's namespace
+		netAttachDef := &netAttachDefs.Items[index]
 
-			netAttachDef.Labels[netAttachDefLabel] = "true"
+		// New annotation is the multus that will be attached to the pod
+		multusAnnotation := networkannotation.NetworkAnnotation{Name: netAttachDef.Name, Namespace: a.SwitchesNamespace}
 
-			err = a.Client.Update(ctx, netAttachDef)
+		// If the user specified a static ip address, we will use that
+		assignIPAddr := l2NetAnnot.IPAddresses
+		if ok {
+			// The allocator reserves the static addresses, or, if there are none and the network has a l3 config, takes the next available
+			// ones from the pod address range. This is done atomically over the l2network status, so concurrent pods never get the same address.
+			assignIPAddr, err = a.allocator().Allocate(ctx, client.ObjectKeyFromObject(&network), podLeaseOwner(pod), l2NetAnnot.IPAddresses)
 			if err != nil {
-				log.Error(err, "Could not update network attachment definition")
-
+				log.Error(err, "No available IP addresses for network", "network", network.Name)
+				rollback()
+				return &podAttachError{msg: fmt.Sprintf("Could not assign an IP address in network %s: %v", network.Name, err)}
 			}
-			err = a.Client.Status().Update(ctx, &network)
-			if err != nil {
-				log.Error(err, "Could not update l2network status")
+			allocated = append(allocated, &network)
+		}
 
-			}
-			multusAnnotations = append(multusAnnotations, multusAnnotation)
+		if ok {
+			multusAnnotation.CNIArgs = networkCNIArgs(&network)
 		}
-		pod.Annotations[networkannotation.MULTUS_ANNOTATION_KEY] = networkannotation.MultusAnnotationToString(multusAnnotations)
 
-		// pod.Annotations["k8s.v1.cni.cncf.io/networks"] = `[{"name": "veth10","ips": ["10.0.0.1/24"]}]`
-		log.Info("Pod assigned to the l2networks")
+		if len(assignIPAddr) != 0 {
+			multusAnnotation.IPAddresses = assignIPAddr
+		} else {
+
+			// If there is no ip address, it means its L2, so to bypass the static ipam plugin, we give a localhost ipv6 to the annotation
+			multusAnnotation.GenerateIPv6Address()
+		}
 
-		marshaledPod, err := json.Marshal(pod)
+		// We update the net attach definition to specify that for this pod node it's taken, and the network to say it has 1 more pod
+		netAttachDef.Labels[netAttachDefLabel] = "true"
+
+		err = a.Client.Update(ctx, netAttachDef)
 		if err != nil {
-			log.Error(err, "Error marshaling pod")
-			return admission.Errored(http.StatusInternalServerError, err)
+			log.Error(err, "Could not update network attachment definition")
+
+		} else {
+			taken = append(taken, netAttachDef)
 		}
-		return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+		if ok {
+			err = UpdateL2NetworkStatus(ctx, a.Client, client.ObjectKeyFromObject(&network), func(status *l2smv1.L2NetworkStatus) {
+				status.ConnectedPodCount++
+			})
+			if err != nil {
+				log.Error(err, "Could not update l2network status")
 
+			} else {
+				counted = append(counted, &network)
+			}
+		}
+		interfaces = append(interfaces, PodInterface{Network: l2NetAnnot.Name, NetAttachDef: multusAnnotation})
 	}
-	return admission.Allowed("Pod not using l2sm networks")
+	SetPodInterfaces(pod, interfaces)
+	return nil
+}
 
+func (a *PodAnnotator) allocator() ipam.Allocator {
+	if a.IPAM != nil {
+		return a.IPAM
+	}
+	return ipam.NewCRDAllocator(a.Client, nil)
 }
 
 func (a *PodAnnotator) InjectDecoder(d *admission.Decoder) error {
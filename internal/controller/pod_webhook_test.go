@@ -0,0 +1,205 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	nettypes "github.com/k8snetworkplumbingwg/network-attachment-definition-client/pkg/apis/k8s.cni.cncf.io/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
+)
+
+var _ = Describe("Pod mutating webhook addresses", func() {
+	ctx := context.Background()
+	var annotator *PodAnnotator
+	var network *l2smv1.L2Network
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(l2smv1.AddToScheme(scheme)).To(Succeed())
+		Expect(nettypes.AddToScheme(scheme)).To(Succeed())
+
+		network = &l2smv1.L2Network{
+			ObjectMeta: metav1.ObjectMeta{Name: "net", Namespace: "default"},
+			Spec: l2smv1.L2NetworkSpec{
+				Type:         l2smv1.NetworkTypeVnet,
+				NetworkCIDR:  "10.101.0.0/16",
+				IPAM:         &l2smv1.IPAMSpec{Gateways: []string{"10.101.0.1"}},
+				Reservations: []l2smv1.IPReservation{{PodName: "reserved", IPs: []string{"10.101.0.10"}}},
+			},
+			Status: l2smv1.L2NetworkStatus{AssignedIPs: map[string]string{"10.101.0.7": "other"}},
+		}
+		netAttachDef := &nettypes.NetworkAttachmentDefinition{
+			ObjectMeta: metav1.ObjectMeta{Name: "veth1", Namespace: "l2sm-system", Labels: map[string]string{"app": "l2sm"}},
+		}
+		annotator = &PodAnnotator{
+			Client: fake.NewClientBuilder().WithScheme(scheme).
+				WithObjects(network, netAttachDef).WithStatusSubresource(network).Build(),
+			Decoder:           admission.NewDecoder(scheme),
+			SwitchesNamespace: "l2sm-system",
+		}
+	})
+
+	request := func(name string, ips ...string) admission.Request {
+		annotation := networkannotation.MultusAnnotationToString([]networkannotation.NetworkAnnotation{{Name: "net", IPAddresses: ips}})
+		pod := &corev1.Pod{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Labels:      map[string]string{"l2sm": "true"},
+				Annotations: map[string]string{networkannotation.L2SM_NETWORK_ANNOTATION: annotation},
+			},
+			Spec: corev1.PodSpec{NodeName: "node-a"},
+		}
+		raw, err := json.Marshal(pod)
+		Expect(err).NotTo(HaveOccurred())
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}}
+	}
+
+	assignedIPs := func() map[string]string {
+		latest := &l2smv1.L2Network{}
+		Expect(annotator.Client.Get(ctx, client.ObjectKeyFromObject(network), latest)).To(Succeed())
+		return latest.Status.AssignedIPs
+	}
+
+	DescribeTable("rejects static addresses that can't be reserved",
+		func(ip, message string) {
+			resp := annotator.Handle(ctx, request("ping", ip))
+			Expect(resp.Allowed).To(BeFalse())
+			Expect(resp.Result.Message).To(ContainSubstring(message))
+			Expect(assignedIPs()).To(HaveLen(1))
+		},
+		Entry("outside of the network CIDR", "10.102.0.5/16", "is not inside the network CIDR"),
+		Entry("the network address", "10.101.0.0/16", "can't be assigned to a pod"),
+		Entry("the gateway", "10.101.0.1/16", "is reserved"),
+		Entry("held by another pod", "10.101.0.7/16", "is held by other"),
+		Entry("reserved for another pod", "10.101.0.10/16", "is reserved for another pod"),
+	)
+
+	It("reserves a free static address", func() {
+		resp := annotator.Handle(ctx, request("ping", "10.101.0.5/16"))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(assignedIPs()).To(HaveKeyWithValue("10.101.0.5", "ping"))
+	})
+
+	It("passes the MTU of the network to the interface", func() {
+		network.Spec.MTU = 9000
+		Expect(annotator.Client.Update(ctx, network)).To(Succeed())
+
+		resp := annotator.Handle(ctx, request("ping"))
+		Expect(resp.Allowed).To(BeTrue())
+		var values []string
+		for _, patch := range resp.Patches {
+			values = append(values, fmt.Sprint(patch.Value))
+		}
+		Expect(values).To(ContainElement(ContainSubstring(`"cni-args":{"mtu":9000}`)))
+	})
+
+	It("gives back what it took for the previous networks when one can't be attached", func() {
+		full := &l2smv1.L2Network{
+			ObjectMeta: metav1.ObjectMeta{Name: "full", Namespace: "default"},
+			Spec:       l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet, NetworkCIDR: "10.102.0.0/30"},
+			Status:     l2smv1.L2NetworkStatus{AssignedIPs: map[string]string{"10.102.0.1": "other", "10.102.0.2": "other"}},
+		}
+		Expect(annotator.Client.Create(ctx, full)).To(Succeed())
+		Expect(annotator.Client.Status().Update(ctx, full)).To(Succeed())
+		Expect(annotator.Client.Create(ctx, &nettypes.NetworkAttachmentDefinition{
+			ObjectMeta: metav1.ObjectMeta{Name: "veth2", Namespace: "l2sm-system", Labels: map[string]string{"app": "l2sm"}},
+		})).To(Succeed())
+
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "ping", Namespace: "default"}, Spec: corev1.PodSpec{NodeName: "node-a"}}
+		err := annotator.assignNetworks(ctx, pod, []networkannotation.NetworkAnnotation{{Name: "net"}, {Name: "full"}})
+		Expect(err).To(HaveOccurred())
+
+		Expect(assignedIPs()).To(Equal(map[string]string{"10.101.0.7": "other"}))
+		latest := &l2smv1.L2Network{}
+		Expect(annotator.Client.Get(ctx, client.ObjectKeyFromObject(network), latest)).To(Succeed())
+		Expect(latest.Status.ConnectedPodCount).To(Equal(0))
+		netAttachDefs := &nettypes.NetworkAttachmentDefinitionList{}
+		Expect(annotator.Client.List(ctx, netAttachDefs)).To(Succeed())
+		for _, netAttachDef := range netAttachDefs.Items {
+			Expect(netAttachDef.Labels).NotTo(HaveKey(networkannotation.NET_ATTACH_LABEL_PREFIX + "node-a"))
+		}
+	})
+
+	It("gives different addresses to the pods created with a generated name", func() {
+		Expect(annotator.Client.Create(ctx, &nettypes.NetworkAttachmentDefinition{
+			ObjectMeta: metav1.ObjectMeta{Name: "veth2", Namespace: "l2sm-system", Labels: map[string]string{"app": "l2sm"}},
+		})).To(Succeed())
+		generated := func(uid types.UID) admission.Request {
+			req := request("")
+			pod := &corev1.Pod{}
+			Expect(json.Unmarshal(req.Object.Raw, pod)).To(Succeed())
+			pod.GenerateName = "web-"
+			raw, err := json.Marshal(pod)
+			Expect(err).NotTo(HaveOccurred())
+			req.UID, req.Object.Raw = uid, raw
+			return req
+		}
+
+		Expect(annotator.Handle(ctx, generated("uid-1")).Allowed).To(BeTrue())
+		Expect(annotator.Handle(ctx, generated("uid-2")).Allowed).To(BeTrue())
+		Expect(assignedIPs()).To(HaveLen(3))
+		Expect(assignedIPs()).To(ContainElements("web-uid-1", "web-uid-2"))
+	})
+
+	It("only leaves the pods of the extender scheduler without a node", func() {
+		annotator.ExtenderSchedulerName = "l2sm-scheduler"
+		unscheduled := func(schedulerName string) admission.Request {
+			req := request("ping")
+			pod := &corev1.Pod{}
+			Expect(json.Unmarshal(req.Object.Raw, pod)).To(Succeed())
+			pod.Spec.NodeName, pod.Spec.SchedulerName = "", schedulerName
+			raw, err := json.Marshal(pod)
+			Expect(err).NotTo(HaveOccurred())
+			req.Object.Raw = raw
+			return req
+		}
+
+		resp := annotator.Handle(ctx, unscheduled("l2sm-scheduler"))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(resp.Patches).To(BeEmpty())
+
+		// the webhook picks the node of the rest, and there is none in this cluster
+		resp = annotator.Handle(ctx, unscheduled("default-scheduler"))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(resp.Patches).NotTo(BeEmpty())
+	})
+
+	It("gives a pod the addresses reserved for it", func() {
+		resp := annotator.Handle(ctx, request("reserved"))
+		Expect(resp.Allowed).To(BeTrue())
+		Expect(assignedIPs()).To(HaveKeyWithValue("10.101.0.10", "reserved"))
+	})
+})
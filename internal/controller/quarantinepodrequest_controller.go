@@ -17,14 +17,12 @@ package controller
 import (
 	"context"
 	"fmt"
-	"net"
+	"slices"
 
 	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
-	"github.com/Networks-it-uc3m/L2S-M/internal/env"
+	"github.com/Networks-it-uc3m/L2S-M/internal/ipam"
 	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
 	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient"
-	"github.com/Networks-it-uc3m/L2S-M/internal/utils"
-	dp "github.com/Networks-it-uc3m/l2sm-switch/pkg/datapath"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
@@ -32,6 +30,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -40,6 +39,7 @@ type QuarantinePodRequestReconciler struct {
 	client.Client
 	Scheme         *runtime.Scheme
 	InternalClient sdnclient.Client
+	IPAM           ipam.Allocator
 }
 
 // +kubebuilder:rbac:groups=l2sm.l2sm.k8s.local,resources=quarantinepodrequests,verbs=get;list;watch;create;update;patch;delete
@@ -84,7 +84,7 @@ func (r *QuarantinePodRequestReconciler) Reconcile(ctx context.Context, req ctrl
 		return ctrl.Result{}, r.setQuarantineStatus(ctx, quarantineRequest, metav1.ConditionFalse, "SDNClientNotConfigured", "internal SDN client is not configured", sourceNetwork.Name, targetNetwork.Name, 0, 0)
 	}
 
-	exists, err := r.InternalClient.CheckNetworkExists(targetNetwork.Spec.Type, targetNetwork.Name)
+	exists, err := r.InternalClient.CheckNetworkExists(ctx, targetNetwork.Spec.Type, targetNetwork.Name)
 	if err != nil {
 		return ctrl.Result{}, r.setQuarantineStatus(ctx, quarantineRequest, metav1.ConditionFalse, "TargetL2NetworkCheckFailed", fmt.Sprintf("could not check target L2Network in SDN controller: %v", err), sourceNetwork.Name, targetNetwork.Name, 0, 0)
 	}
@@ -150,8 +150,7 @@ func (r *QuarantinePodRequestReconciler) movePodToTargetNetwork(ctx context.Cont
 	if !ok {
 		return false, nil
 	}
-	multusNetworksRaw, ok := pod.Annotations[networkannotation.MULTUS_ANNOTATION_KEY]
-	if !ok {
+	if _, ok := pod.Annotations[networkannotation.MULTUS_ANNOTATION_KEY]; !ok {
 		return false, nil
 	}
 
@@ -164,38 +163,40 @@ func (r *QuarantinePodRequestReconciler) movePodToTargetNetwork(ctx context.Cont
 		return false, nil
 	}
 
-	multusNetworks, err := networkannotation.ExtractNetworks(multusNetworksRaw, pod.Namespace)
+	podInterfaces, err := GetPodInterfaces(pod, pod.Namespace)
 	if err != nil {
-		return false, fmt.Errorf("could not extract pod Multus annotations: %w", err)
+		return false, err
 	}
-	if len(multusNetworks) != len(l2smNetworks) {
-		return false, fmt.Errorf("pod has mismatched l2sm and Multus annotation counts: %d l2sm networks, %d Multus networks", len(l2smNetworks), len(multusNetworks))
+	interfaceIndex := slices.IndexFunc(podInterfaces, func(podInterface PodInterface) bool { return podInterface.Network == sourceNetwork.Name })
+	if interfaceIndex == -1 {
+		return false, nil
 	}
 
-	portNumber, err := utils.GetPortNumberFromNetAttachDef(multusNetworks[sourceIndex].Name)
+	ofPort, err := GetPodSwitchPort(pod, podInterfaces[interfaceIndex].NetAttachDef)
 	if err != nil {
-		return false, fmt.Errorf("could not get port number from network attachment definition %q: %w", multusNetworks[sourceIndex].Name, err)
+		return false, err
 	}
-	ofID := fmt.Sprintf("of:%s", dp.GenerateID(dp.GetSwitchName(dp.DatapathParams{NodeName: pod.Spec.NodeName, ProviderName: l2smv1.OVERLAY_PROVIDER})))
-	ofPort := fmt.Sprintf("%s/%s", ofID, portNumber)
 
 	sourcePayload := sdnclient.VnetPayload{NetworkId: sourceNetwork.Name, Port: []string{ofPort}}
-	if err := r.InternalClient.DetachPodFromNetwork(sourceNetwork.Spec.Type, sourcePayload); err != nil {
+	if err := r.InternalClient.DetachPodFromNetwork(ctx, sourceNetwork.Spec.Type, sourcePayload); err != nil {
 		return false, fmt.Errorf("could not detach pod %s/%s port %s from source L2Network %q: %w", pod.Namespace, pod.Name, ofPort, sourceNetwork.Name, err)
 	}
 
 	targetPayload := sdnclient.VnetPayload{NetworkId: targetNetwork.Name, Port: []string{ofPort}}
-	if err := r.InternalClient.AttachPodToNetwork(targetNetwork.Spec.Type, targetPayload); err != nil {
+	if err := r.InternalClient.AttachPodToNetwork(ctx, targetNetwork.Spec.Type, targetPayload); err != nil {
 		return false, fmt.Errorf("could not attach pod %s/%s port %s to target L2Network %q: %w", pod.Namespace, pod.Name, ofPort, targetNetwork.Name, err)
 	}
 
 	l2smNetworks[sourceIndex].Name = targetNetwork.Name
 	pod.Annotations[networkannotation.L2SM_NETWORK_ANNOTATION] = networkannotation.MultusAnnotationToString(l2smNetworks)
+	// the interface is now attached to the target network, so the move is not taken as a change to hot-plug
+	podInterfaces[interfaceIndex].Network = targetNetwork.Name
+	SetPodInterfaces(pod, podInterfaces)
 	if err := r.Update(ctx, pod); err != nil {
 		return false, fmt.Errorf("could not update pod network annotation: %w", err)
 	}
 
-	if err := r.updateNetworkStatuses(ctx, sourceNetwork, targetNetwork, pod.Name, multusNetworks[sourceIndex].IPAddresses); err != nil {
+	if err := r.updateNetworkStatuses(ctx, sourceNetwork, targetNetwork, podLeaseOwner(pod), podInterfaces[interfaceIndex].NetAttachDef.IPAddresses); err != nil {
 		return false, err
 	}
 
@@ -203,34 +204,41 @@ func (r *QuarantinePodRequestReconciler) movePodToTargetNetwork(ctx context.Cont
 }
 
 func (r *QuarantinePodRequestReconciler) updateNetworkStatuses(ctx context.Context, sourceNetwork, targetNetwork *l2smv1.L2Network, podName string, ipAddresses []string) error {
-	if sourceNetwork.Status.ConnectedPodCount > 0 {
-		sourceNetwork.Status.ConnectedPodCount--
+	// The pod keeps its addresses, so they are released in the source network and reserved as they are in the target one
+	if err := r.allocator().Release(ctx, client.ObjectKeyFromObject(sourceNetwork), podName); err != nil {
+		return err
 	}
-	targetNetwork.Status.ConnectedPodCount++
-
-	for _, podCIDR := range ipAddresses {
-		ip, _, err := net.ParseCIDR(podCIDR)
-		if err != nil {
-			continue
-		}
-		ipString := ip.String()
-		delete(sourceNetwork.Status.AssignedIPs, ipString)
-		if targetNetwork.Status.AssignedIPs == nil {
-			targetNetwork.Status.AssignedIPs = map[string]string{}
+	if len(ipAddresses) != 0 {
+		if _, err := r.allocator().Allocate(ctx, client.ObjectKeyFromObject(targetNetwork), podName, ipAddresses); err != nil {
+			return err
 		}
-		targetNetwork.Status.AssignedIPs[ipString] = podName
 	}
 
-	if err := r.Status().Update(ctx, sourceNetwork); err != nil {
+	err := UpdateL2NetworkStatus(ctx, r.Client, client.ObjectKeyFromObject(sourceNetwork), func(status *l2smv1.L2NetworkStatus) {
+		if status.ConnectedPodCount > 0 {
+			status.ConnectedPodCount--
+		}
+	})
+	if err != nil {
 		return fmt.Errorf("could not update source L2Network status: %w", err)
 	}
-	if err := r.Status().Update(ctx, targetNetwork); err != nil {
+	err = UpdateL2NetworkStatus(ctx, r.Client, client.ObjectKeyFromObject(targetNetwork), func(status *l2smv1.L2NetworkStatus) {
+		status.ConnectedPodCount++
+	})
+	if err != nil {
 		return fmt.Errorf("could not update target L2Network status: %w", err)
 	}
 
 	return nil
 }
 
+func (r *QuarantinePodRequestReconciler) allocator() ipam.Allocator {
+	if r.IPAM != nil {
+		return r.IPAM
+	}
+	return ipam.NewCRDAllocator(r.Client, nil)
+}
+
 func (r *QuarantinePodRequestReconciler) setQuarantineStatus(ctx context.Context, request *l2smv1.QuarantinePodRequest, conditionStatus metav1.ConditionStatus, reason, message, sourceNetworkName, targetNetworkName string, matchedPodCount, movedPodCount int32) error {
 	request.Status.ObservedGeneration = request.Generation
 	request.Status.SourceL2NetworkName = sourceNetworkName
@@ -251,6 +259,69 @@ func (r *QuarantinePodRequestReconciler) setQuarantineStatus(ctx context.Context
 	return nil
 }
 
+// quarantineLabel is set on the pods quarantined automatically, and on their source network, to their uid, so that
+// the QuarantinePodRequest selects only them.
+const quarantineLabel = "l2sm/quarantine"
+
+// requestPodQuarantine creates the QuarantinePodRequest, owned by owner, that moves the pod from the network to the
+// target network, and returns its name. The pod and the network are labeled with their uid, so that the request
+// selects only them. If the pod is already labeled and another request selects it, e.g. one of the alert policy of
+// the network and one of a QuarantinePolicy, no request is created and the name of that one is returned instead.
+func requestPodQuarantine(ctx context.Context, c client.Client, scheme *runtime.Scheme, owner client.Object, name string, labels map[string]string, network *l2smv1.L2Network, pod *corev1.Pod, target string) (string, error) {
+	if pod.Labels[quarantineLabel] == string(pod.UID) {
+		requests := &l2smv1.QuarantinePodRequestList{}
+		if err := c.List(ctx, requests, client.InNamespace(network.Namespace)); err != nil {
+			return "", err
+		}
+		for _, request := range requests.Items {
+			if request.Spec.Selector.PodLabelSelector.MatchLabels[quarantineLabel] == string(pod.UID) {
+				return request.Name, nil
+			}
+		}
+	} else {
+		patch := client.MergeFrom(pod.DeepCopy())
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		pod.Labels[quarantineLabel] = string(pod.UID)
+		if err := c.Patch(ctx, pod, patch); err != nil {
+			return "", err
+		}
+	}
+	if network.Labels[quarantineLabel] != string(network.UID) {
+		patch := client.MergeFrom(network.DeepCopy())
+		if network.Labels == nil {
+			network.Labels = map[string]string{}
+		}
+		network.Labels[quarantineLabel] = string(network.UID)
+		if err := c.Patch(ctx, network, patch); err != nil {
+			return "", err
+		}
+	}
+
+	request := &l2smv1.QuarantinePodRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: network.Namespace,
+			Labels:    labels,
+		},
+		Spec: l2smv1.QuarantinePodRequestSpec{
+			Selector: l2smv1.QuarantinePodSelector{
+				PodLabelSelector:  metav1.LabelSelector{MatchLabels: map[string]string{quarantineLabel: string(pod.UID)}},
+				L2NetworkSelector: metav1.LabelSelector{MatchLabels: map[string]string{quarantineLabel: string(network.UID)}},
+			},
+			TargetL2Network: target,
+		},
+	}
+	if err := controllerutil.SetControllerReference(owner, request, scheme); err != nil {
+		return "", err
+	}
+	if err := c.Create(ctx, request); err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", err
+	}
+	return name, nil
+}
+
 func networkAnnotationIndex(networks []networkannotation.NetworkAnnotation, networkName string) int {
 	for i := range networks {
 		if networks[i].Name == networkName {
@@ -263,8 +334,12 @@ func networkAnnotationIndex(networks []networkannotation.NetworkAnnotation, netw
 // SetupWithManager sets up the controller with the Manager.
 func (r *QuarantinePodRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if r.InternalClient == nil {
-		clientConfig := sdnclient.ClientConfig{BaseURL: fmt.Sprintf("http://%s:%s/onos", env.GetControllerIP(), env.GetControllerPort()), Username: "karaf", Password: "karaf"}
-		internalClient, err := sdnclient.NewClient(sdnclient.InternalType, clientConfig)
+		ctx := context.Background()
+		clientConfig, err := sdnclient.InternalConfig(ctx, mgr.GetAPIReader())
+		if err != nil {
+			return err
+		}
+		internalClient, err := sdnclient.NewClient(ctx, sdnclient.InternalType, clientConfig)
 		if err != nil {
 			return err
 		}
@@ -137,35 +137,43 @@ type fakeQuarantineSDNClient struct {
 	calls            []string
 }
 
-func (c *fakeQuarantineSDNClient) CreateNetwork(l2smv1.NetworkType, interface{}) error {
+func (c *fakeQuarantineSDNClient) CreateNetwork(context.Context, l2smv1.NetworkType, interface{}) error {
 	return nil
 }
 
-func (c *fakeQuarantineSDNClient) DeleteNetwork(l2smv1.NetworkType, string) error {
+func (c *fakeQuarantineSDNClient) DeleteNetwork(context.Context, l2smv1.NetworkType, string) error {
 	return nil
 }
 
-func (c *fakeQuarantineSDNClient) CheckNetworkExists(_ l2smv1.NetworkType, networkID string) (bool, error) {
+func (c *fakeQuarantineSDNClient) CheckNetworkExists(_ context.Context, _ l2smv1.NetworkType, networkID string) (bool, error) {
 	c.calls = append(c.calls, fmt.Sprintf("check:%s", networkID))
 	return c.existingNetworks[networkID], nil
 }
 
-func (c *fakeQuarantineSDNClient) AttachPodToNetwork(_ l2smv1.NetworkType, config interface{}) error {
+func (c *fakeQuarantineSDNClient) AttachPodToNetwork(_ context.Context, _ l2smv1.NetworkType, config interface{}) error {
 	payload := config.(sdnclient.VnetPayload)
 	c.calls = append(c.calls, fmt.Sprintf("attach:%s:%s", payload.NetworkId, payload.Port[0]))
 	return nil
 }
 
-func (c *fakeQuarantineSDNClient) DetachPodFromNetwork(_ l2smv1.NetworkType, config interface{}) error {
+func (c *fakeQuarantineSDNClient) DetachPodFromNetwork(_ context.Context, _ l2smv1.NetworkType, config interface{}) error {
 	payload := config.(sdnclient.VnetPayload)
 	c.calls = append(c.calls, fmt.Sprintf("detach:%s:%s", payload.NetworkId, payload.Port[0]))
 	return nil
 }
 
-func (c *fakeQuarantineSDNClient) SetUpMirrorPort(l2smv1.NetworkType, any) error {
+func (c *fakeQuarantineSDNClient) SetUpMirrorPort(context.Context, l2smv1.NetworkType, any) error {
 	return nil
 }
 
+func (c *fakeQuarantineSDNClient) RemoveMirrorPort(context.Context, l2smv1.NetworkType, any) error {
+	return nil
+}
+
+func (c *fakeQuarantineSDNClient) ListNetworks(context.Context, l2smv1.NetworkType) ([]sdnclient.VnetPayload, error) {
+	return nil, nil
+}
+
 func createL2Network(ctx context.Context, name string, labels map[string]string, connectedPodCount int) {
 	network := &l2smv1.L2Network{
 		ObjectMeta: metav1.ObjectMeta{
@@ -0,0 +1,421 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/env"
+	"github.com/Networks-it-uc3m/L2S-M/internal/ids"
+)
+
+const (
+	// maxQuarantineAuditEntries is how many decisions the audit trail of a policy keeps.
+	maxQuarantineAuditEntries = 50
+
+	// quarantinePolicyLabel is set on the QuarantinePodRequests of a policy to its name.
+	quarantinePolicyLabel = "l2sm/quarantine-policy"
+)
+
+// QuarantinePolicyReconciler reconciles a QuarantinePolicy object
+type QuarantinePolicyReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// firedTrigger is a trigger of a policy that fired for a pod.
+type firedTrigger struct {
+	pod     *corev1.Pod
+	trigger string
+}
+
+// +kubebuilder:rbac:groups=l2sm.l2sm.k8s.local,resources=quarantinepolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=l2sm.l2sm.k8s.local,resources=quarantinepolicies/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=l2sm.l2sm.k8s.local,resources=quarantinepolicies/finalizers,verbs=update
+// +kubebuilder:rbac:groups=l2sm.l2sm.k8s.local,resources=quarantinepodrequests,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups=l2sm.l2sm.k8s.local,resources=l2networks,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=l2sm.l2sm.k8s.local,resources=overlays,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups="",resources=events,verbs=list;watch
+
+// Reconcile checks the triggers of the policy against the pods of its source L2Network, and creates a
+// QuarantinePodRequest for every pod a trigger fires for, within the rate limit of the policy. The triggers are
+// checked again periodically, since the alerts and link metrics they watch don't change the policy.
+func (r *QuarantinePolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	policy := &l2smv1.QuarantinePolicy{}
+	if err := r.Get(ctx, req.NamespacedName, policy); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !policy.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	if problem := validateQuarantinePolicy(policy); problem != "" {
+		return ctrl.Result{}, r.setPolicyStatus(ctx, policy, metav1.ConditionFalse, "InvalidPolicy", problem)
+	}
+
+	network := &l2smv1.L2Network{}
+	if err := r.Get(ctx, client.ObjectKey{Name: policy.Spec.SourceL2Network, Namespace: policy.Namespace}, network); err != nil {
+		if apierrors.IsNotFound(err) {
+			err = r.setPolicyStatus(ctx, policy, metav1.ConditionFalse, "SourceL2NetworkNotFound", fmt.Sprintf("source L2Network %q does not exist", policy.Spec.SourceL2Network))
+			return ctrl.Result{RequeueAfter: env.GetQuarantinePolicyInterval()}, err
+		}
+		return ctrl.Result{}, err
+	}
+
+	if err := r.trackQuarantinedPods(ctx, policy); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	pods, err := r.sourcePods(ctx, network)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	fired, err := r.evaluateTriggers(ctx, policy, network, pods)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	now := metav1.Now()
+	pruneRecentQuarantines(policy, now.Time)
+	for _, f := range fired {
+		r.applyQuarantine(ctx, policy, network, f, now)
+	}
+
+	message := fmt.Sprintf("%d pod(s) quarantined", len(policy.Status.QuarantinedPods))
+	if policy.Spec.DryRun {
+		message = "dry run, no pod is quarantined"
+	}
+	return ctrl.Result{RequeueAfter: env.GetQuarantinePolicyInterval()}, r.setPolicyStatus(ctx, policy, metav1.ConditionTrue, "TriggersEvaluated", message)
+}
+
+// validateQuarantinePolicy returns what is wrong with the policy, if anything.
+func validateQuarantinePolicy(policy *l2smv1.QuarantinePolicy) string {
+	triggers := policy.Spec.Triggers
+	switch {
+	case policy.Spec.SourceL2Network == policy.Spec.TargetL2Network:
+		return "source and target L2Network are the same"
+	case triggers.IDSAlerts == nil && triggers.LinkMetrics == nil && triggers.PodSelector == nil:
+		return "the policy has no triggers"
+	}
+	if triggers.PodSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(triggers.PodSelector); err != nil {
+			return fmt.Sprintf("invalid pod selector: %v", err)
+		}
+	}
+	return ""
+}
+
+// trackQuarantinedPods records which quarantined pods were already moved. The pods whose request was deleted are
+// forgotten, so that they can be quarantined again.
+func (r *QuarantinePolicyReconciler) trackQuarantinedPods(ctx context.Context, policy *l2smv1.QuarantinePolicy) error {
+	tracked := policy.Status.QuarantinedPods[:0]
+	for _, quarantined := range policy.Status.QuarantinedPods {
+		request := &l2smv1.QuarantinePodRequest{}
+		err := r.Get(ctx, client.ObjectKey{Name: quarantined.Request, Namespace: policy.Namespace}, request)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		quarantined.Moved = quarantined.Moved || request.Status.MovedPodCount > 0
+		tracked = append(tracked, quarantined)
+	}
+	policy.Status.QuarantinedPods = tracked
+	return nil
+}
+
+// sourcePods returns the pods attached to the network, by name.
+func (r *QuarantinePolicyReconciler) sourcePods(ctx context.Context, network *l2smv1.L2Network) ([]*corev1.Pod, error) {
+	names := map[string]bool{}
+	for _, owner := range network.Status.AssignedIPs {
+		names[owner] = true
+	}
+	pods := make([]*corev1.Pod, 0, len(names))
+	for name := range names {
+		pod := &corev1.Pod{}
+		if err := r.Get(ctx, client.ObjectKey{Name: name, Namespace: network.Namespace}, pod); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if pod.DeletionTimestamp.IsZero() {
+			pods = append(pods, pod)
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	return pods, nil
+}
+
+// evaluateTriggers returns the pods the triggers of the policy fire for, with the first trigger that fired.
+func (r *QuarantinePolicyReconciler) evaluateTriggers(ctx context.Context, policy *l2smv1.QuarantinePolicy, network *l2smv1.L2Network, pods []*corev1.Pod) ([]firedTrigger, error) {
+	triggers := policy.Spec.Triggers
+
+	selector := labels.Nothing()
+	if triggers.PodSelector != nil {
+		// the selector was validated
+		selector, _ = metav1.LabelSelectorAsSelector(triggers.PodSelector)
+	}
+	alerts := map[string]string{}
+	if triggers.IDSAlerts != nil {
+		var err error
+		if alerts, err = r.podsWithIDSAlerts(ctx, policy, network); err != nil {
+			return nil, err
+		}
+	}
+	nodes := map[string]string{}
+	if triggers.LinkMetrics != nil {
+		var err error
+		if nodes, err = r.nodesCrossingThresholds(ctx, triggers.LinkMetrics, policy.Namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	var fired []firedTrigger
+	for _, pod := range pods {
+		switch {
+		case selector.Matches(labels.Set(pod.Labels)):
+			fired = append(fired, firedTrigger{pod: pod, trigger: "podSelector"})
+		case alerts[pod.Name] != "":
+			fired = append(fired, firedTrigger{pod: pod, trigger: alerts[pod.Name]})
+		case nodes[pod.Spec.NodeName] != "":
+			fired = append(fired, firedTrigger{pod: pod, trigger: nodes[pod.Spec.NodeName]})
+		}
+	}
+	return fired, nil
+}
+
+// podsWithIDSAlerts returns the pods with alerts of the IDS of the network that match the trigger, looked up in the
+// events the IDS alerts are recorded in. Only the alerts recorded within the window of the trigger, and after the
+// policy was created, count.
+func (r *QuarantinePolicyReconciler) podsWithIDSAlerts(ctx context.Context, policy *l2smv1.QuarantinePolicy, network *l2smv1.L2Network) (map[string]string, error) {
+	trigger := policy.Spec.Triggers.IDSAlerts
+	window := trigger.Window.Duration
+	if window <= 0 {
+		window = 10 * time.Minute
+	}
+	since := time.Now().Add(-window)
+	if since.Before(policy.CreationTimestamp.Time) {
+		since = policy.CreationTimestamp.Time
+	}
+
+	// events are not cached, so this lists the alerts of the network in the API server
+	events := &corev1.EventList{}
+	if err := r.List(ctx, events, client.InNamespace(network.Namespace), client.MatchingLabels{ids.NetworkLabel: network.Name}); err != nil {
+		return nil, fmt.Errorf("could not list ids alert events: %w", err)
+	}
+	pods := map[string]string{}
+	for _, event := range events.Items {
+		if event.InvolvedObject.Kind != "Pod" || event.LastTimestamp.Time.Before(since) {
+			continue
+		}
+		severity, err := strconv.ParseInt(event.Labels[idsSeverityLabel], 10, 32)
+		if err != nil || int32(severity) > trigger.Severity {
+			continue
+		}
+		signatureID, err := strconv.ParseInt(event.Labels[idsSignatureLabel], 10, 64)
+		if err != nil || (len(trigger.SignatureIDs) != 0 && !slices.Contains(trigger.SignatureIDs, signatureID)) {
+			continue
+		}
+		pods[event.InvolvedObject.Name] = fmt.Sprintf("idsAlerts: sid %d, severity %d", signatureID, severity)
+	}
+	return pods, nil
+}
+
+// nodesCrossingThresholds returns the nodes with a link of the overlay that crosses a threshold of the trigger.
+func (r *QuarantinePolicyReconciler) nodesCrossingThresholds(ctx context.Context, trigger *l2smv1.LinkMetricsTrigger, namespace string) (map[string]string, error) {
+	overlay := &l2smv1.Overlay{}
+	if err := r.Get(ctx, client.ObjectKey{Name: trigger.Overlay, Namespace: namespace}, overlay); err != nil {
+		if apierrors.IsNotFound(err) {
+			logf.FromContext(ctx).V(1).Info("Overlay of the link metrics trigger not found", "overlay", trigger.Overlay)
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+	nodes := map[string]string{}
+	if overlay.Status.LinkMetrics == nil {
+		return nodes, nil
+	}
+	for _, link := range *overlay.Status.LinkMetrics {
+		if _, found := nodes[link.SourceNode]; found {
+			continue
+		}
+		for _, metric := range link.Metrics {
+			if crossed := crossedThreshold(trigger.Thresholds, metric); crossed != "" {
+				nodes[link.SourceNode] = fmt.Sprintf("linkMetrics: %s from %s to %s", crossed, link.SourceNode, link.TargetNode)
+				break
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// crossedThreshold describes the threshold the metric crosses, if any.
+func crossedThreshold(thresholds []l2smv1.MetricThreshold, metric l2smv1.MetricValue) string {
+	value, err := strconv.ParseFloat(metric.Value, 64)
+	if err != nil {
+		return ""
+	}
+	for _, threshold := range thresholds {
+		if threshold.Metric != metric.Name {
+			continue
+		}
+		if above, err := strconv.ParseFloat(threshold.Above, 64); err == nil && value > above {
+			return fmt.Sprintf("%s %s above %s", metric.Name, metric.Value, threshold.Above)
+		}
+		if below, err := strconv.ParseFloat(threshold.Below, 64); err == nil && value < below {
+			return fmt.Sprintf("%s %s below %s", metric.Name, metric.Value, threshold.Below)
+		}
+	}
+	return ""
+}
+
+// applyQuarantine quarantines the pod a trigger fired for, unless it already was, the policy is a dry run or its
+// rate limit was reached. The decision is recorded in the audit trail.
+func (r *QuarantinePolicyReconciler) applyQuarantine(ctx context.Context, policy *l2smv1.QuarantinePolicy, network *l2smv1.L2Network, fired firedTrigger, now metav1.Time) {
+	pod := fired.pod
+	if slices.ContainsFunc(policy.Status.QuarantinedPods, func(quarantined l2smv1.QuarantinedPod) bool { return quarantined.Pod == pod.Name }) {
+		return
+	}
+
+	if policy.Spec.DryRun {
+		auditQuarantine(policy, now, pod.Name, l2smv1.QuarantineActionDryRun, fired.trigger,
+			fmt.Sprintf("would move the pod to %q", policy.Spec.TargetL2Network))
+		return
+	}
+	if quarantineRateLimited(policy, now.Time) {
+		auditQuarantine(policy, now, pod.Name, l2smv1.QuarantineActionRateLimited, fired.trigger,
+			fmt.Sprintf("%d pod(s) were already quarantined in the last %s", policy.Spec.RateLimit.MaxPods, policy.Spec.RateLimit.Period.Duration))
+		return
+	}
+
+	name := fmt.Sprintf("%s-%s", policy.Name, pod.Name)
+	request, err := requestPodQuarantine(ctx, r.Client, r.Scheme, policy, name, map[string]string{quarantinePolicyLabel: policy.Name}, network, pod, policy.Spec.TargetL2Network)
+	if err != nil {
+		logf.FromContext(ctx).Error(err, "could not quarantine pod", "policy", policy.Name, "pod", pod.Name)
+		auditQuarantine(policy, now, pod.Name, l2smv1.QuarantineActionFailed, fired.trigger, err.Error())
+		return
+	}
+	policy.Status.QuarantinedPods = append(policy.Status.QuarantinedPods, l2smv1.QuarantinedPod{
+		Pod:     pod.Name,
+		Request: request,
+		Trigger: fired.trigger,
+		Time:    now,
+	})
+	if request != name {
+		// another request, e.g. of the alert policy of the network, already quarantines the pod
+		auditQuarantine(policy, now, pod.Name, l2smv1.QuarantineActionQuarantined, fired.trigger,
+			fmt.Sprintf("the pod is already quarantined by QuarantinePodRequest %s", request))
+		return
+	}
+	policy.Status.RecentQuarantines = append(policy.Status.RecentQuarantines, now)
+	auditQuarantine(policy, now, pod.Name, l2smv1.QuarantineActionQuarantined, fired.trigger,
+		fmt.Sprintf("QuarantinePodRequest %s moves the pod to %q", name, policy.Spec.TargetL2Network))
+}
+
+// quarantineRateLimited reports whether the policy already quarantined as many pods as it can in the current period.
+func quarantineRateLimited(policy *l2smv1.QuarantinePolicy, now time.Time) bool {
+	rateLimit := policy.Spec.RateLimit
+	if rateLimit == nil {
+		return false
+	}
+	var recent int32
+	for _, quarantined := range policy.Status.RecentQuarantines {
+		if now.Sub(quarantined.Time) < rateLimitPeriod(rateLimit) {
+			recent++
+		}
+	}
+	return recent >= rateLimit.MaxPods
+}
+
+// pruneRecentQuarantines forgets the quarantines that are out of the period of the rate limit.
+func pruneRecentQuarantines(policy *l2smv1.QuarantinePolicy, now time.Time) {
+	if policy.Spec.RateLimit == nil {
+		policy.Status.RecentQuarantines = nil
+		return
+	}
+	period := rateLimitPeriod(policy.Spec.RateLimit)
+	policy.Status.RecentQuarantines = slices.DeleteFunc(policy.Status.RecentQuarantines, func(quarantined metav1.Time) bool {
+		return now.Sub(quarantined.Time) >= period
+	})
+}
+
+func rateLimitPeriod(rateLimit *l2smv1.QuarantineRateLimit) time.Duration {
+	if rateLimit.Period.Duration <= 0 {
+		return time.Hour
+	}
+	return rateLimit.Period.Duration
+}
+
+// auditQuarantine adds a decision to the audit trail of the policy. A decision that repeats the last one about the
+// same pod isn't added again, so that the trail isn't flooded by the pods waiting for the rate limit or a dry run.
+func auditQuarantine(policy *l2smv1.QuarantinePolicy, now metav1.Time, pod, action, trigger, message string) {
+	trail := policy.Status.AuditTrail
+	for i := len(trail) - 1; i >= 0; i-- {
+		if trail[i].Pod == pod {
+			if trail[i].Action == action && trail[i].Trigger == trigger {
+				return
+			}
+			break
+		}
+	}
+	trail = append(trail, l2smv1.QuarantineAuditEntry{Time: now, Pod: pod, Action: action, Trigger: trigger, Message: message})
+	if len(trail) > maxQuarantineAuditEntries {
+		trail = trail[len(trail)-maxQuarantineAuditEntries:]
+	}
+	policy.Status.AuditTrail = trail
+}
+
+func (r *QuarantinePolicyReconciler) setPolicyStatus(ctx context.Context, policy *l2smv1.QuarantinePolicy, conditionStatus metav1.ConditionStatus, reason, message string) error {
+	policy.Status.ObservedGeneration = policy.Generation
+	meta.SetStatusCondition(&policy.Status.Conditions, metav1.Condition{
+		Type:               "Available",
+		Status:             conditionStatus,
+		ObservedGeneration: policy.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+
+	if err := r.Status().Update(ctx, policy); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *QuarantinePolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&l2smv1.QuarantinePolicy{}).
+		Owns(&l2smv1.QuarantinePodRequest{}).
+		Named("quarantinepolicy").
+		Complete(r)
+}
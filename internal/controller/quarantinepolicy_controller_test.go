@@ -0,0 +1,298 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/ids"
+)
+
+var _ = Describe("QuarantinePolicy Controller", func() {
+	ctx := context.Background()
+	key := client.ObjectKey{Name: "isolate", Namespace: "default"}
+
+	var scheme *runtime.Scheme
+	var objects []client.Object
+	var policy *l2smv1.QuarantinePolicy
+
+	BeforeEach(func() {
+		scheme = runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(l2smv1.AddToScheme(scheme)).To(Succeed())
+
+		network := &l2smv1.L2Network{
+			ObjectMeta: metav1.ObjectMeta{Name: "production", Namespace: "default", UID: "production-uid"},
+			Spec:       l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVnet},
+			Status: l2smv1.L2NetworkStatus{
+				AssignedIPs: map[string]string{"10.0.0.4": "ping", "10.0.0.5": "pong", "10.0.0.6": "web"},
+			},
+		}
+		objects = []client.Object{
+			network,
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "ping", Namespace: "default", UID: "ping-uid", Labels: map[string]string{"compromised": "true"}},
+				Spec:       corev1.PodSpec{NodeName: "node-a"},
+			},
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pong", Namespace: "default", UID: "pong-uid"},
+				Spec:       corev1.PodSpec{NodeName: "node-b"},
+			},
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "web-uid", Labels: map[string]string{"compromised": "true"}},
+				Spec:       corev1.PodSpec{NodeName: "node-a"},
+			},
+		}
+		policy = &l2smv1.QuarantinePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace, UID: "isolate-uid"},
+			Spec: l2smv1.QuarantinePolicySpec{
+				SourceL2Network: "production",
+				TargetL2Network: "quarantine",
+				Triggers: l2smv1.QuarantineTriggers{
+					PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"compromised": "true"}},
+				},
+			},
+		}
+	})
+
+	reconcileOnce := func(c client.Client) {
+		reconciler := &QuarantinePolicyReconciler{Client: c, Scheme: scheme}
+		_, err := reconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+		Expect(err).NotTo(HaveOccurred())
+	}
+	build := func() client.Client {
+		return fake.NewClientBuilder().WithScheme(scheme).
+			WithStatusSubresource(&l2smv1.QuarantinePolicy{}, &l2smv1.L2Network{}, &l2smv1.QuarantinePodRequest{}).
+			WithObjects(append(objects, policy)...).Build()
+	}
+	requestNames := func(c client.Client) []string {
+		requests := &l2smv1.QuarantinePodRequestList{}
+		Expect(c.List(ctx, requests, client.InNamespace("default"))).To(Succeed())
+		var names []string
+		for _, request := range requests.Items {
+			names = append(names, request.Name)
+		}
+		return names
+	}
+	getPolicy := func(c client.Client) *l2smv1.QuarantinePolicy {
+		current := &l2smv1.QuarantinePolicy{}
+		Expect(c.Get(ctx, key, current)).To(Succeed())
+		return current
+	}
+
+	It("should quarantine the pods matched by the pod selector", func() {
+		c := build()
+		reconcileOnce(c)
+
+		Expect(requestNames(c)).To(ConsistOf("isolate-ping", "isolate-web"))
+		request := &l2smv1.QuarantinePodRequest{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: "isolate-ping", Namespace: "default"}, request)).To(Succeed())
+		Expect(request.Spec.TargetL2Network).To(Equal("quarantine"))
+		Expect(request.Spec.Selector.PodLabelSelector.MatchLabels).To(Equal(map[string]string{quarantineLabel: "ping-uid"}))
+		Expect(request.Labels[quarantinePolicyLabel]).To(Equal("isolate"))
+		Expect(metav1.IsControlledBy(request, policy)).To(BeTrue())
+
+		current := getPolicy(c)
+		Expect(current.Status.QuarantinedPods).To(HaveLen(2))
+		Expect(current.Status.QuarantinedPods[0].Trigger).To(Equal("podSelector"))
+		Expect(current.Status.AuditTrail).To(HaveLen(2))
+		Expect(current.Status.AuditTrail[0].Action).To(Equal(l2smv1.QuarantineActionQuarantined))
+		Expect(meta.IsStatusConditionTrue(current.Status.Conditions, "Available")).To(BeTrue())
+
+		reconcileOnce(c)
+		Expect(getPolicy(c).Status.AuditTrail).To(HaveLen(2), "quarantined pods should not be audited again")
+	})
+
+	It("should only audit the pods in a dry run", func() {
+		policy.Spec.DryRun = true
+		c := build()
+		reconcileOnce(c)
+		reconcileOnce(c)
+
+		Expect(requestNames(c)).To(BeEmpty())
+		current := getPolicy(c)
+		Expect(current.Status.QuarantinedPods).To(BeEmpty())
+		Expect(current.Status.AuditTrail).To(HaveLen(2))
+		for _, entry := range current.Status.AuditTrail {
+			Expect(entry.Action).To(Equal(l2smv1.QuarantineActionDryRun))
+		}
+	})
+
+	It("should stop quarantining pods at the rate limit", func() {
+		policy.Spec.RateLimit = &l2smv1.QuarantineRateLimit{MaxPods: 1, Period: metav1.Duration{Duration: time.Hour}}
+		c := build()
+		reconcileOnce(c)
+		reconcileOnce(c)
+
+		Expect(requestNames(c)).To(ConsistOf("isolate-ping"))
+		current := getPolicy(c)
+		Expect(current.Status.AuditTrail).To(HaveLen(2))
+		Expect(current.Status.AuditTrail[1].Pod).To(Equal("web"))
+		Expect(current.Status.AuditTrail[1].Action).To(Equal(l2smv1.QuarantineActionRateLimited))
+	})
+
+	It("should keep the rate limit when the requests are deleted", func() {
+		policy.Spec.RateLimit = &l2smv1.QuarantineRateLimit{MaxPods: 1, Period: metav1.Duration{Duration: time.Hour}}
+		c := build()
+		reconcileOnce(c)
+		Expect(c.Delete(ctx, &l2smv1.QuarantinePodRequest{ObjectMeta: metav1.ObjectMeta{Name: "isolate-ping", Namespace: "default"}})).To(Succeed())
+		reconcileOnce(c)
+
+		Expect(requestNames(c)).NotTo(ContainElement("isolate-web"))
+		current := getPolicy(c)
+		Expect(current.Status.RecentQuarantines).To(HaveLen(1))
+		Expect(current.Status.AuditTrail[len(current.Status.AuditTrail)-1].Action).To(Equal(l2smv1.QuarantineActionRateLimited))
+	})
+
+	It("should not quarantine again the pods quarantined by the alert policy", func() {
+		objects[1].SetLabels(map[string]string{"compromised": "true", quarantineLabel: "ping-uid"})
+		objects = append(objects, &l2smv1.QuarantinePodRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: "production-ids-ping", Namespace: "default"},
+			Spec: l2smv1.QuarantinePodRequestSpec{
+				Selector: l2smv1.QuarantinePodSelector{
+					PodLabelSelector: metav1.LabelSelector{MatchLabels: map[string]string{quarantineLabel: "ping-uid"}},
+				},
+				TargetL2Network: "quarantine",
+			},
+		})
+		c := build()
+		reconcileOnce(c)
+
+		Expect(requestNames(c)).To(ConsistOf("production-ids-ping", "isolate-web"))
+		current := getPolicy(c)
+		Expect(current.Status.QuarantinedPods).To(ContainElement(HaveField("Request", "production-ids-ping")))
+		Expect(current.Status.RecentQuarantines).To(HaveLen(1))
+	})
+
+	It("should quarantine the pods with matching IDS alerts", func() {
+		policy.CreationTimestamp = metav1.NewTime(time.Now().Add(-time.Hour))
+		policy.Spec.Triggers = l2smv1.QuarantineTriggers{IDSAlerts: &l2smv1.IDSAlertTrigger{
+			Severity:     2,
+			SignatureIDs: []int64{2100498, 2100499},
+			Window:       metav1.Duration{Duration: 30 * time.Minute},
+		}}
+		idsEvent := func(name, pod, signatureID, severity string, age time.Duration) *corev1.Event {
+			return &corev1.Event{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{
+					ids.NetworkLabel: "production", idsSignatureLabel: signatureID, idsSeverityLabel: severity,
+				}},
+				InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: pod, Namespace: "default"},
+				Reason:         ReasonIDSAlert,
+				LastTimestamp:  metav1.NewTime(time.Now().Add(-age)),
+			}
+		}
+		objects = append(objects,
+			idsEvent("alert-1", "pong", "2100498", "1", time.Minute),
+			idsEvent("alert-2", "ping", "2100498", "3", time.Minute),
+			idsEvent("alert-3", "web", "5000001", "1", time.Minute),
+		)
+		c := build()
+		reconcileOnce(c)
+
+		Expect(requestNames(c)).To(ConsistOf("isolate-pong"))
+		Expect(getPolicy(c).Status.QuarantinedPods[0].Trigger).To(Equal("idsAlerts: sid 2100498, severity 1"))
+	})
+
+	It("should ignore the IDS alerts out of the window or raised before the policy", func() {
+		policy.CreationTimestamp = metav1.NewTime(time.Now().Add(-10 * time.Minute))
+		policy.Spec.Triggers = l2smv1.QuarantineTriggers{IDSAlerts: &l2smv1.IDSAlertTrigger{
+			Severity: 1,
+			Window:   metav1.Duration{Duration: 5 * time.Minute},
+		}}
+		idsEvent := func(name, pod string, age time.Duration) *corev1.Event {
+			return &corev1.Event{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{
+					ids.NetworkLabel: "production", idsSignatureLabel: "2100498", idsSeverityLabel: "1",
+				}},
+				InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: pod, Namespace: "default"},
+				Reason:         ReasonIDSAlert,
+				LastTimestamp:  metav1.NewTime(time.Now().Add(-age)),
+			}
+		}
+		objects = append(objects,
+			idsEvent("before-policy", "ping", time.Hour),
+			idsEvent("out-of-window", "web", 7*time.Minute),
+			idsEvent("recent", "pong", time.Minute),
+		)
+		c := build()
+		reconcileOnce(c)
+
+		Expect(requestNames(c)).To(ConsistOf("isolate-pong"))
+	})
+
+	It("should quarantine the pods on nodes whose links cross a threshold", func() {
+		policy.Spec.Triggers = l2smv1.QuarantineTriggers{LinkMetrics: &l2smv1.LinkMetricsTrigger{
+			Overlay:    "backbone",
+			Thresholds: []l2smv1.MetricThreshold{{Metric: "rtt", Above: "200"}, {Metric: "throughput", Below: "10"}},
+		}}
+		objects = append(objects, &l2smv1.Overlay{
+			ObjectMeta: metav1.ObjectMeta{Name: "backbone", Namespace: "default"},
+			Status: l2smv1.OverlayStatus{LinkMetrics: &[]l2smv1.LinkStatus{
+				{SourceNode: "node-a", TargetNode: "node-b", Metrics: []l2smv1.MetricValue{{Name: "rtt", Value: "12.5"}, {Name: "throughput", Value: "940"}}},
+				{SourceNode: "node-b", TargetNode: "node-a", Metrics: []l2smv1.MetricValue{{Name: "rtt", Value: "250.0"}}},
+			}},
+		})
+		c := build()
+		reconcileOnce(c)
+
+		Expect(requestNames(c)).To(ConsistOf("isolate-pong"))
+		Expect(getPolicy(c).Status.QuarantinedPods[0].Trigger).To(Equal("linkMetrics: rtt 250.0 above 200 from node-b to node-a"))
+	})
+
+	It("should track the moved pods and forget the deleted requests", func() {
+		c := build()
+		reconcileOnce(c)
+
+		request := &l2smv1.QuarantinePodRequest{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: "isolate-ping", Namespace: "default"}, request)).To(Succeed())
+		request.Status.MovedPodCount = 1
+		Expect(c.Status().Update(ctx, request)).To(Succeed())
+		Expect(c.Delete(ctx, &l2smv1.QuarantinePodRequest{ObjectMeta: metav1.ObjectMeta{Name: "isolate-web", Namespace: "default"}})).To(Succeed())
+		pod := &corev1.Pod{}
+		Expect(c.Get(ctx, client.ObjectKey{Name: "web", Namespace: "default"}, pod)).To(Succeed())
+		pod.Labels = map[string]string{}
+		Expect(c.Update(ctx, pod)).To(Succeed())
+		reconcileOnce(c)
+
+		current := getPolicy(c)
+		Expect(current.Status.QuarantinedPods).To(HaveLen(1))
+		Expect(current.Status.QuarantinedPods[0].Pod).To(Equal("ping"))
+		Expect(current.Status.QuarantinedPods[0].Moved).To(BeTrue())
+	})
+
+	It("should reject a policy that moves pods to its own source", func() {
+		policy.Spec.TargetL2Network = "production"
+		c := build()
+		reconcileOnce(c)
+
+		Expect(requestNames(c)).To(BeEmpty())
+		condition := meta.FindStatusCondition(getPolicy(c).Status.Conditions, "Available")
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal("InvalidPolicy"))
+	})
+})
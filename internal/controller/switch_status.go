@@ -0,0 +1,139 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// switchStatus returns the state of the switch that the replica set runs in the node. Switch pods share their labels
+// with other l2sm pods, so only the ones controlled by the replica set are considered.
+func switchStatus(ctx context.Context, c client.Client, key client.ObjectKey, node string) (l2smv1.NodeSwitchStatus, error) {
+	status := l2smv1.NodeSwitchStatus{Node: node}
+
+	replicaSet := &appsv1.ReplicaSet{}
+	if err := c.Get(ctx, key, replicaSet); err != nil {
+		if apierrors.IsNotFound(err) {
+			status.Message = "switch not created"
+			return status, nil
+		}
+		return status, fmt.Errorf("failed to get switch %s: %w", key.Name, err)
+	}
+
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(key.Namespace), client.MatchingLabels(replicaSet.Spec.Selector.MatchLabels)); err != nil {
+		return status, fmt.Errorf("failed to list the pods of switch %s: %w", key.Name, err)
+	}
+
+	status.Message = "switch pod not created"
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil || owner.UID != replicaSet.UID || !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		status.Pod = pod.Name
+		status.Ready, status.Message = podReadiness(pod)
+		if status.Ready {
+			break
+		}
+	}
+	return status, nil
+}
+
+// podReadiness tells whether the pod is running and ready and, if it isn't, why.
+func podReadiness(pod *corev1.Pod) (bool, string) {
+	if pod.Status.Phase == corev1.PodRunning {
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				return true, ""
+			}
+		}
+	}
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if containerStatus.State.Waiting != nil && containerStatus.State.Waiting.Reason != "" {
+			return false, fmt.Sprintf("container %s is waiting: %s", containerStatus.Name, containerStatus.State.Waiting.Reason)
+		}
+	}
+	if pod.Status.Phase != corev1.PodRunning && pod.Status.Phase != "" {
+		return false, fmt.Sprintf("pod is %s", pod.Status.Phase)
+	}
+	return false, "pod is not ready"
+}
+
+// switchesReadyCondition builds the SwitchesReady condition out of the state of every switch.
+func switchesReadyCondition(switches []l2smv1.NodeSwitchStatus, generation int64) metav1.Condition {
+	var notReady []string
+	for _, status := range switches {
+		if !status.Ready {
+			notReady = append(notReady, status.Node)
+		}
+	}
+	condition := metav1.Condition{
+		Type:               l2smv1.SwitchesReadyCondition,
+		Status:             metav1.ConditionTrue,
+		Reason:             "SwitchesReady",
+		Message:            fmt.Sprintf("%d/%d switches ready", len(switches)-len(notReady), len(switches)),
+		ObservedGeneration: generation,
+	}
+	if len(notReady) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "SwitchesNotReady"
+		condition.Message = fmt.Sprintf("switches not ready in nodes: %s", strings.Join(notReady, ", "))
+	}
+	return condition
+}
+
+// resultCondition builds a condition that is true when err is nil, and false with the error as message otherwise.
+func resultCondition(conditionType, reason, failedReason string, err error, generation int64) metav1.Condition {
+	if err != nil {
+		return metav1.Condition{
+			Type:               conditionType,
+			Status:             metav1.ConditionFalse,
+			Reason:             failedReason,
+			Message:            err.Error(),
+			ObservedGeneration: generation,
+		}
+	}
+	return metav1.Condition{
+		Type:               conditionType,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason,
+		ObservedGeneration: generation,
+	}
+}
+
+// fabricPhase summarizes the conditions: pending while no switch is ready, ready once every condition is met and
+// degraded otherwise.
+func fabricPhase(conditions []metav1.Condition, readySwitches int) l2smv1.FabricPhase {
+	if readySwitches == 0 {
+		return l2smv1.FabricPending
+	}
+	for _, condition := range conditions {
+		if condition.Status != metav1.ConditionTrue {
+			return l2smv1.FabricDegraded
+		}
+	}
+	return l2smv1.FabricReady
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Switch status", func() {
+	It("should only consider running and ready pods as ready switches", func() {
+		pod := &corev1.Pod{Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		}}
+		ready, _ := podReadiness(pod)
+		Expect(ready).To(BeTrue())
+
+		pod.Status.Conditions[0].Status = corev1.ConditionFalse
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+			Name:  "l2sm-switch",
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+		}}
+		ready, message := podReadiness(pod)
+		Expect(ready).To(BeFalse())
+		Expect(message).To(ContainSubstring("CrashLoopBackOff"))
+	})
+
+	It("should summarize the conditions in a phase", func() {
+		switches := []l2smv1.NodeSwitchStatus{{Node: "node-a", Ready: true}, {Node: "node-b"}}
+		conditions := []metav1.Condition{
+			switchesReadyCondition(switches, 1),
+			resultCondition(l2smv1.ControllerConnectedCondition, "Connected", "Unreachable", nil, 1),
+		}
+		Expect(conditions[0].Message).To(ContainSubstring("node-b"))
+		Expect(fabricPhase(conditions, 1)).To(Equal(l2smv1.FabricDegraded))
+		Expect(fabricPhase(conditions, 0)).To(Equal(l2smv1.FabricPending))
+
+		switches[1].Ready = true
+		conditions[0] = switchesReadyCondition(switches, 1)
+		Expect(conditions[0].Message).To(Equal("2/2 switches ready"))
+		Expect(fabricPhase(conditions, 2)).To(Equal(l2smv1.FabricReady))
+
+		conditions[1] = resultCondition(l2smv1.ControllerConnectedCondition, "Connected", "Unreachable", errors.New("timeout"), 1)
+		Expect(fabricPhase(conditions, 2)).To(Equal(l2smv1.FabricDegraded))
+	})
+})
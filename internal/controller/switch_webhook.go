@@ -0,0 +1,93 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// The webhooks of the overlays and network edge devices share these checks of the provider and the nodes the
+// switches are deployed in.
+
+// validateProvider checks that the provider of a switch names the SDN controller and where it can be reached.
+func validateProvider(provider *l2smv1.ProviderSpec) []string {
+	if provider == nil {
+		return []string{"provider is required"}
+	}
+	var problems []string
+	if provider.Name == "" {
+		problems = append(problems, "provider name is required")
+	}
+	if len(provider.Domain) == 0 {
+		problems = append(problems, "provider domain is required")
+	}
+	for _, domain := range provider.Domain {
+		if !validDomain(domain) {
+			problems = append(problems, fmt.Sprintf("provider domain %q is not a valid IP address or domain name", domain))
+		}
+	}
+	return problems
+}
+
+// validDomain reports whether the value is an IP address or a DNS name.
+func validDomain(value string) bool {
+	if _, err := netip.ParseAddr(value); err == nil {
+		return true
+	}
+	return len(validation.IsDNS1123Subdomain(value)) == 0
+}
+
+// missingNodes returns the nodes that don't exist in the cluster.
+func missingNodes(ctx context.Context, c client.Client, nodes []string) ([]string, error) {
+	var missing []string
+	for _, node := range nodes {
+		err := c.Get(ctx, client.ObjectKey{Name: node}, &corev1.Node{})
+		switch {
+		case apierrors.IsNotFound(err):
+			missing = append(missing, node)
+		case err != nil:
+			return nil, fmt.Errorf("could not get node %s: %w", node, err)
+		}
+	}
+	return missing, nil
+}
+
+// validateSwitchTemplate checks that the switch template, once defaulted, has a container to run.
+func validateSwitchTemplate(template *l2smv1.SwitchTemplateSpec) []string {
+	if template != nil && len(template.Spec.Containers) == 0 {
+		return []string{"switchTemplate must have at least one container"}
+	}
+	return nil
+}
+
+// patchDefaults returns the patch that turns the object of the request into the defaulted one.
+func patchDefaults(req admission.Request, obj client.Object) admission.Response {
+	marshaled, err := json.Marshal(obj)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
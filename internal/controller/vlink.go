@@ -0,0 +1,120 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	dp "github.com/Networks-it-uc3m/l2sm-switch/pkg/datapath"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+)
+
+const (
+	// VlinkPathCondition reports whether the path of a vlink network runs through the switches of a single overlay.
+	VlinkPathCondition = "VlinkPathValid"
+
+	ReasonPathAccepted = "PathAccepted"
+	ReasonPathRejected = "PathRejected"
+)
+
+// vlinkHops finds the overlay that contains the whole path of a vlink network and computes the switch hops the
+// SDN controller has to program for it.
+func (r *L2NetworkReconciler) vlinkHops(ctx context.Context, network *l2smv1.L2Network) ([]string, error) {
+	if len(network.Spec.Path) < 2 {
+		return nil, fmt.Errorf("vlink network %q needs a path of at least two nodes, got %d", network.Name, len(network.Spec.Path))
+	}
+
+	overlays := &l2smv1.OverlayList{}
+	if err := r.List(ctx, overlays, client.InNamespace(r.SwitchesNamespace)); err != nil {
+		return nil, fmt.Errorf("could not list overlays: %w", err)
+	}
+
+	// every hop must be a switch of the same overlay, as the switches of different overlays aren't linked to each other
+	var candidates []l2smv1.Overlay
+	for _, overlay := range overlays.Items {
+		if overlay.Spec.Topology == nil || slices.ContainsFunc(network.Spec.Path, func(node string) bool {
+			return !slices.Contains(overlay.Spec.Topology.Nodes, node)
+		}) {
+			continue
+		}
+		candidates = append(candidates, overlay)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no overlay topology contains every node of the vlink path %v", network.Spec.Path)
+	}
+
+	slices.SortFunc(candidates, func(a, b l2smv1.Overlay) int { return strings.Compare(a.Name, b.Name) })
+	var errs []error
+	for _, overlay := range candidates {
+		hops, err := computeVlinkHops(network.Spec.Path, overlay.Spec.Topology)
+		if err == nil {
+			return hops, nil
+		}
+		errs = append(errs, fmt.Errorf("overlay %q: %w", overlay.Name, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// setVlinkPathCondition records whether the path of the vlink network was accepted.
+func setVlinkPathCondition(network *l2smv1.L2Network, err error) {
+	condition := metav1.Condition{
+		Type:               VlinkPathCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: network.Generation,
+		Reason:             ReasonPathAccepted,
+		Message:            "the vlink path runs through the switches of a single overlay",
+	}
+	if err != nil {
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, ReasonPathRejected, err.Error()
+	}
+	meta.SetStatusCondition(&network.Status.Conditions, condition)
+}
+
+// computeVlinkHops checks that every node of the path has a switch in the topology and that consecutive nodes
+// are linked, and then translates each node into the OpenFlow ID of its switch.
+func computeVlinkHops(path []string, topology *l2smv1.TopologySpec) ([]string, error) {
+	hops := make([]string, 0, len(path))
+	for i, node := range path {
+		if !slices.Contains(topology.Nodes, node) {
+			return nil, fmt.Errorf("node %q of the vlink path is not part of the overlay topology", node)
+		}
+		if slices.Contains(path[:i], node) {
+			return nil, fmt.Errorf("node %q appears more than once in the vlink path", node)
+		}
+		if i > 0 && !topologyLinked(topology, path[i-1], node) {
+			return nil, fmt.Errorf("nodes %q and %q of the vlink path are not linked in the overlay topology", path[i-1], node)
+		}
+		hops = append(hops, fmt.Sprintf("of:%s", dp.GenerateID(dp.GetSwitchName(dp.DatapathParams{NodeName: node, ProviderName: l2smv1.OVERLAY_PROVIDER}))))
+	}
+	return hops, nil
+}
+
+// topologyLinked reports whether there is a link between both nodes. Links are bidirectional.
+func topologyLinked(topology *l2smv1.TopologySpec, nodeA, nodeB string) bool {
+	for _, link := range topology.Links {
+		if (link.EndpointA == nodeA && link.EndpointB == nodeB) || (link.EndpointA == nodeB && link.EndpointB == nodeA) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+)
+
+var _ = Describe("Vlink path computation", func() {
+	topology := &l2smv1.TopologySpec{
+		Nodes: []string{"node-a", "node-b", "node-c"},
+		Links: []l2smv1.Link{
+			{EndpointA: "node-a", EndpointB: "node-b"},
+			{EndpointA: "node-c", EndpointB: "node-b"},
+		},
+	}
+
+	It("translates every node of a linked path into a switch hop", func() {
+		hops, err := computeVlinkHops([]string{"node-a", "node-b", "node-c"}, topology)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hops).To(HaveLen(3))
+		for _, hop := range hops {
+			Expect(hop).To(HavePrefix("of:"))
+		}
+		Expect(hops[0]).NotTo(Equal(hops[2]))
+	})
+
+	It("rejects consecutive nodes that are not linked", func() {
+		_, err := computeVlinkHops([]string{"node-a", "node-c"}, topology)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects nodes outside the overlay and loops", func() {
+		_, err := computeVlinkHops([]string{"node-a", "node-z"}, topology)
+		Expect(err).To(HaveOccurred())
+		_, err = computeVlinkHops([]string{"node-a", "node-b", "node-a"}, topology)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Vlink overlay selection", func() {
+	const switchesNamespace = "l2sm-system"
+	ctx := context.Background()
+	var reconciler *L2NetworkReconciler
+
+	overlay := func(name string, nodes ...string) *l2smv1.Overlay {
+		topology := &l2smv1.TopologySpec{Nodes: nodes}
+		for i := 1; i < len(nodes); i++ {
+			topology.Links = append(topology.Links, l2smv1.Link{EndpointA: nodes[i-1], EndpointB: nodes[i]})
+		}
+		return &l2smv1.Overlay{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: switchesNamespace},
+			Spec:       l2smv1.OverlaySpec{Topology: topology},
+		}
+	}
+	vlink := func(path ...string) *l2smv1.L2Network {
+		return &l2smv1.L2Network{
+			ObjectMeta: metav1.ObjectMeta{Name: "pinned", Namespace: "default"},
+			Spec:       l2smv1.L2NetworkSpec{Type: l2smv1.NetworkTypeVlink, Path: path},
+		}
+	}
+
+	BeforeEach(func() {
+		scheme := runtime.NewScheme()
+		Expect(corev1.AddToScheme(scheme)).To(Succeed())
+		Expect(l2smv1.AddToScheme(scheme)).To(Succeed())
+
+		// both overlays have a switch in node-b
+		c := fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&l2smv1.L2Network{}).
+			WithObjects(overlay("overlay-1", "node-a", "node-b"), overlay("overlay-2", "node-b", "node-c")).Build()
+		reconciler = &L2NetworkReconciler{Client: c, Scheme: scheme, SwitchesNamespace: switchesNamespace}
+	})
+
+	It("uses the overlay that contains every node of the path", func() {
+		hops, err := reconciler.vlinkHops(ctx, vlink("node-b", "node-c"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(hops).To(HaveLen(2))
+	})
+
+	It("rejects the vlink with a condition when its path spans overlays", func() {
+		network := vlink("node-a", "node-b", "node-c")
+		Expect(reconciler.Create(ctx, network)).To(Succeed())
+
+		_, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: types.NamespacedName{Name: "pinned", Namespace: "default"}})
+		Expect(err).To(HaveOccurred())
+
+		Expect(reconciler.Get(ctx, types.NamespacedName{Name: "pinned", Namespace: "default"}, network)).To(Succeed())
+		condition := meta.FindStatusCondition(network.Status.Conditions, VlinkPathCondition)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(ReasonPathRejected))
+		Expect(condition.Message).To(ContainSubstring("no overlay topology contains every node"))
+		Expect(network.Status.Hops).To(BeEmpty())
+		Expect(network.Finalizers).To(BeEmpty())
+	})
+})
@@ -17,11 +17,15 @@ package dnsinterface
 import (
 	"context"
 	"fmt"
+	"slices"
 
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/Networks-it-uc3m/L2S-M/internal/env"
 	"github.com/Networks-it-uc3m/l2sm-dns/pkg/configmapmanager"
+	"github.com/Networks-it-uc3m/l2sm-dns/pkg/corefile"
 )
 
 func AddServerToLocalCoreDNS(c client.Client, networkName, remoteServerDomain, remoteServerPort string) error {
@@ -38,6 +42,43 @@ func AddServerToLocalCoreDNS(c client.Client, networkName, remoteServerDomain, r
 		return fmt.Errorf("could not create DNS manager: %v", err)
 	}
 
-	domainName := fmt.Sprintf("%s.%s.l2sm", networkName, "inter")
-	return dnsManager.AddServerToConfigMap(context.TODO(), domainName, remoteServerDomain, remoteServerPort)
+	return dnsManager.AddServerToConfigMap(context.TODO(), interDomainName(networkName), remoteServerDomain, remoteServerPort)
+}
+
+// RemoveServerFromLocalCoreDNS removes the server block AddServerToLocalCoreDNS added for the network. It does nothing if
+// the block, or the CoreDNS ConfigMap, doesn't exist.
+func RemoveServerFromLocalCoreDNS(ctx context.Context, c client.Client, networkName string) error {
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: env.GetIntraConfigmapNamespace(), Name: env.GetIntraConfigmapName()}, cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get ConfigMap: %w", err)
+	}
+
+	coreFileString, ok := cm.Data["Corefile"]
+	if !ok {
+		return nil
+	}
+	cf, err := corefile.New(coreFileString)
+	if err != nil {
+		return fmt.Errorf("could not parse existing corefile: %v", err)
+	}
+
+	domainName := interDomainName(networkName)
+	servers := slices.DeleteFunc(slices.Clone(cf.Servers), func(server *corefile.Server) bool {
+		return slices.Equal(server.DomPorts, []string{domainName})
+	})
+	if len(servers) == len(cf.Servers) {
+		return nil
+	}
+	cf.Servers = servers
+
+	cm.Data["Corefile"] = cf.ToString()
+	return c.Update(ctx, cm)
+}
+
+// interDomainName is the DNS zone of the pods of an inter-domain network.
+func interDomainName(networkName string) string {
+	return fmt.Sprintf("%s.%s.l2sm", networkName, "inter")
 }
@@ -16,6 +16,7 @@ package env
 
 import (
 	"os"
+	"time"
 )
 
 func getEnv(key, defaultValue string) string {
@@ -53,3 +54,86 @@ func GetIntraConfigmapName() string {
 	return getEnv("INTRA_CONFIGMAP_NAME", "coredns")
 
 }
+
+// GetSDNResyncInterval returns how often L2Networks are compared against the state held by the SDN controller.
+func GetSDNResyncInterval() time.Duration {
+	interval, err := time.ParseDuration(getEnv("SDN_RESYNC_INTERVAL", "60s"))
+	if err != nil || interval <= 0 {
+		return time.Minute
+	}
+	return interval
+}
+
+// GetIPAMGCInterval returns how often the address leases of pods that no longer exist are reclaimed.
+func GetIPAMGCInterval() time.Duration {
+	interval, err := time.ParseDuration(getEnv("IPAM_GC_INTERVAL", "5m"))
+	if err != nil || interval <= 0 {
+		return 5 * time.Minute
+	}
+	return interval
+}
+
+// GetLinkMetricsInterval returns how often the LPM collectors are scraped to refresh the link metrics of overlays and
+// network edge devices.
+func GetLinkMetricsInterval() time.Duration {
+	interval, err := time.ParseDuration(getEnv("LINK_METRICS_INTERVAL", "30s"))
+	if err != nil || interval <= 0 {
+		return 30 * time.Second
+	}
+	return interval
+}
+
+// GetControllerScheme returns the scheme used to reach the l2sm-controller API, http or https.
+func GetControllerScheme() string {
+	return getEnv("CONTROLLER_SCHEME", "http")
+}
+
+// GetControllerCredentialsSecret returns the name of the Secret that holds the credentials of the l2sm-controller API.
+// Requests to the controller are not authenticated if it's empty.
+func GetControllerCredentialsSecret() string {
+	return getEnv("CONTROLLER_CREDENTIALS_SECRET", "")
+}
+
+// GetPodNamespace returns the namespace the manager runs in.
+func GetPodNamespace() string {
+	return getEnv("POD_NAMESPACE", "l2sm-system")
+}
+
+// GetIDSRulesRefreshInterval returns how often the remote rule sources of the intrusion detection systems are fetched
+// again.
+func GetIDSRulesRefreshInterval() time.Duration {
+	interval, err := time.ParseDuration(getEnv("IDS_RULES_REFRESH_INTERVAL", "24h"))
+	if err != nil || interval <= 0 {
+		return 24 * time.Hour
+	}
+	return interval
+}
+
+// GetIDSAlertsInterval returns how often the alerts of the intrusion detection systems are read.
+func GetIDSAlertsInterval() time.Duration {
+	interval, err := time.ParseDuration(getEnv("IDS_ALERTS_INTERVAL", "30s"))
+	if err != nil || interval <= 0 {
+		return 30 * time.Second
+	}
+	return interval
+}
+
+// GetQuarantinePolicyInterval returns how often the triggers of the quarantine policies are checked.
+func GetQuarantinePolicyInterval() time.Duration {
+	interval, err := time.ParseDuration(getEnv("QUARANTINE_POLICY_INTERVAL", "30s"))
+	if err != nil || interval <= 0 {
+		return 30 * time.Second
+	}
+	return interval
+}
+
+// GetETOpenURL returns where the Emerging Threats Open ruleset is downloaded from. A file with its MD5 checksum is
+// expected next to it, with the .md5 suffix.
+func GetETOpenURL() string {
+	return getEnv("ET_OPEN_URL", "https://rules.emergingthreats.net/open/suricata-7.0.3/emerging.rules.tar.gz")
+}
+
+// GetIDSImage returns the Suricata image of the intrusion detection systems that don't set their own.
+func GetIDSImage() string {
+	return getEnv("IDS_IMAGE", "jasonish/suricata:7.0.8")
+}
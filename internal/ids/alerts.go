@@ -0,0 +1,119 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ids
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// EveContainerName is the container of the IDS pod that writes the EVE events of Suricata to its log.
+	EveContainerName = "eve"
+
+	// NetworkLabel is set on the IDS pods to the name of the network they inspect.
+	NetworkLabel = "l2sm/ids-network"
+
+	// eveTimeLayout is the layout of the timestamps of the EVE events.
+	eveTimeLayout = "2006-01-02T15:04:05.999999-0700"
+
+	// maxEveLineSize is the longest EVE event read. Events with large payloads are skipped.
+	maxEveLineSize = 1 << 20
+)
+
+// Alert is an alert raised by Suricata.
+type Alert struct {
+	Timestamp   time.Time
+	SrcIP       string
+	DestIP      string
+	SignatureID int64
+	Signature   string
+	Category    string
+	Severity    int32
+}
+
+// eveEvent is the part of an EVE event read for alerts.
+type eveEvent struct {
+	Timestamp string `json:"timestamp"`
+	EventType string `json:"event_type"`
+	SrcIP     string `json:"src_ip"`
+	DestIP    string `json:"dest_ip"`
+	Alert     struct {
+		SignatureID int64  `json:"signature_id"`
+		Signature   string `json:"signature"`
+		Category    string `json:"category"`
+		Severity    int32  `json:"severity"`
+	} `json:"alert"`
+}
+
+// ParseAlerts reads the alerts of the EVE events of r, one per line, raised after since. The other events, and the
+// lines that aren't EVE events, are skipped.
+func ParseAlerts(r io.Reader, since time.Time) ([]Alert, error) {
+	var alerts []Alert
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxEveLineSize)
+	for scanner.Scan() {
+		var event eveEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || event.EventType != "alert" {
+			continue
+		}
+		timestamp, err := time.Parse(eveTimeLayout, event.Timestamp)
+		if err != nil || !timestamp.After(since) {
+			continue
+		}
+		alerts = append(alerts, Alert{
+			Timestamp:   timestamp,
+			SrcIP:       event.SrcIP,
+			DestIP:      event.DestIP,
+			SignatureID: event.Alert.SignatureID,
+			Signature:   event.Alert.Signature,
+			Category:    event.Alert.Category,
+			Severity:    event.Alert.Severity,
+		})
+	}
+	return alerts, scanner.Err()
+}
+
+// AlertReader reads the alerts an IDS pod raised after a given time.
+type AlertReader interface {
+	ReadAlerts(ctx context.Context, pod *corev1.Pod, since time.Time) ([]Alert, error)
+}
+
+// LogAlertReader reads the alerts from the log of the eve container of the IDS pods.
+type LogAlertReader struct {
+	Clientset kubernetes.Interface
+}
+
+func (r *LogAlertReader) ReadAlerts(ctx context.Context, pod *corev1.Pod, since time.Time) ([]Alert, error) {
+	options := &corev1.PodLogOptions{Container: EveContainerName}
+	if !since.IsZero() {
+		// the log is only filtered to the second, the alerts are filtered again when parsed
+		options.SinceTime = &metav1.Time{Time: since}
+	}
+	stream, err := r.Clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, options).Stream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the log of ids pod %s: %w", pod.Name, err)
+	}
+	defer stream.Close()
+	return ParseAlerts(stream, since)
+}
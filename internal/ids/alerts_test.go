@@ -0,0 +1,84 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ids
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+)
+
+const eveLog = `{"timestamp":"2024-05-02T10:00:00.000100+0000","event_type":"flow","src_ip":"10.0.0.4","dest_ip":"10.0.0.5"}
+{"timestamp":"2024-05-02T10:00:01.000200+0000","event_type":"alert","src_ip":"10.0.0.4","dest_ip":"10.0.0.5","alert":{"signature_id":2100498,"signature":"GPL ATTACK_RESPONSE id check returned root","category":"Potentially Bad Traffic","severity":2}}
+tail: '/var/log/suricata/eve.json' has appeared;  following new file
+{"timestamp":"2024-05-02T12:00:02.000300+0200","event_type":"alert","src_ip":"10.0.0.5","dest_ip":"10.0.0.4","alert":{"signature_id":5000001,"signature":"ping","category":"","severity":3}}
+`
+
+func TestParseAlerts(t *testing.T) {
+	alerts, err := ParseAlerts(strings.NewReader(eveLog), time.Time{})
+	if err != nil {
+		t.Fatalf("ParseAlerts returned error: %v", err)
+	}
+	if len(alerts) != 2 {
+		t.Fatalf("expected 2 alerts, got %+v", alerts)
+	}
+	first := alerts[0]
+	if first.SrcIP != "10.0.0.4" || first.DestIP != "10.0.0.5" || first.SignatureID != 2100498 || first.Severity != 2 ||
+		first.Signature != "GPL ATTACK_RESPONSE id check returned root" || first.Category != "Potentially Bad Traffic" {
+		t.Fatalf("unexpected alert: %+v", first)
+	}
+	if want := time.Date(2024, 5, 2, 10, 0, 1, 200000, time.UTC); !first.Timestamp.Equal(want) {
+		t.Fatalf("expected timestamp %v, got %v", want, first.Timestamp)
+	}
+
+	// the alerts raised up to since were already read
+	alerts, err = ParseAlerts(strings.NewReader(eveLog), time.Date(2024, 5, 2, 10, 0, 1, 200000, time.UTC))
+	if err != nil {
+		t.Fatalf("ParseAlerts returned error: %v", err)
+	}
+	if len(alerts) != 1 || alerts[0].SignatureID != 5000001 {
+		t.Fatalf("expected only the alert after since, got %+v", alerts)
+	}
+}
+
+func TestIDSPodWritesEveEventsToItsLog(t *testing.T) {
+	_, deployment := generateDeployment(t, &l2smv1.IdsRules{Enabled: true, Node: "node-a"})
+	template := deployment.Spec.Template
+	if template.Labels[NetworkLabel] != "monitored-network" {
+		t.Fatalf("ids pods are not labeled with their network: %v", template.Labels)
+	}
+	if _, found := deployment.Spec.Selector.MatchLabels[NetworkLabel]; found {
+		t.Fatalf("the selector of existing deployments can't change: %v", deployment.Spec.Selector.MatchLabels)
+	}
+
+	containers := template.Spec.Containers
+	if len(containers) != 2 || containers[1].Name != EveContainerName {
+		t.Fatalf("expected the suricata and eve containers, got %+v", containers)
+	}
+	if !strings.Contains(strings.Join(containers[1].Command, " "), "/var/log/suricata/eve.json") {
+		t.Fatalf("eve container doesn't follow eve.json: %v", containers[1].Command)
+	}
+	for _, container := range containers {
+		found := false
+		for _, mount := range container.VolumeMounts {
+			found = found || (mount.Name == "logs" && mount.MountPath == "/var/log/suricata")
+		}
+		if !found {
+			t.Fatalf("container %s doesn't share the log directory", container.Name)
+		}
+	}
+}
@@ -15,6 +15,9 @@
 package ids
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -25,12 +28,61 @@ import (
 
 	// Assuming your types are in this package
 	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/env"
 	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
 	"github.com/Networks-it-uc3m/L2S-M/internal/utils"
 )
 
-// GenerateExternalResources orchestrates the creation of the ConfigMap and Deployment.
-func GenerateExternalResources(network *l2smv1.L2Network, netAttachString string) ([]client.Object, error) {
+const (
+	// MaxRuleChunks is how many ConfigMaps the compressed remote rules can span, about 7 MiB.
+	MaxRuleChunks = 8
+
+	// maxRuleChunkSize keeps every rules ConfigMap under the 1 MiB the API server allows.
+	maxRuleChunkSize = 900 << 10
+
+	// fetchedRulesKey prefixes the keys of the chunks of the remote rules. The chunks are put back together in the IDS
+	// pod in the order of their keys.
+	fetchedRulesKey = "fetched.rules.gz"
+
+	bpfFilterKey = "bpf-filter"
+)
+
+// ErrRulesTooLarge is returned when the remote rules don't fit in the rules ConfigMaps, even compressed.
+var ErrRulesTooLarge = errors.New("rules don't fit in the rules configmaps")
+
+// suricataScript merges the rules into the active rules file before Suricata starts, and again whenever the mounted
+// rules change, in which case Suricata is told to reload them. A merge that fails, e.g. while the chunks of the remote
+// rules are being updated, keeps the previous rules.
+const suricataScript = `merge() {
+  { cat /var/lib/suricata/generated-rules/suricata.rules; cat /var/lib/suricata/custom-rules/*/* 2>/dev/null; cat /var/lib/suricata/fetched-rules/%[1]s.* | gunzip; } > /var/lib/suricata/rules/suricata.rules.tmp && mv /var/lib/suricata/rules/suricata.rules.tmp /var/lib/suricata/rules/suricata.rules
+}
+fingerprint() {
+  cat /var/lib/suricata/generated-rules/* /var/lib/suricata/custom-rules/*/* /var/lib/suricata/fetched-rules/* 2>/dev/null | md5sum
+}
+merge
+last=$(fingerprint)
+(while sleep 60; do
+  current=$(fingerprint)
+  if [ "$current" != "$last" ] && merge; then
+    last=$current
+    kill -USR2 $(cat /var/run/suricata.pid)
+  fi
+done) &
+suricata -D -i net1%[2]s && touch /var/log/suricata/fast.log && tail -f /var/log/suricata/fast.log -s 1
+`
+
+// Namespace returns the namespace the IDS resources of the network are deployed in.
+func Namespace(network *l2smv1.L2Network) string {
+	// if a namespace was specified, we use it. if none was, we use the network namespace
+	if network.Spec.Ids != nil && network.Spec.Ids.Namespace != "" {
+		return network.Spec.Ids.Namespace
+	}
+	return network.Namespace
+}
+
+// GenerateExternalResources orchestrates the creation of the ConfigMap and Deployment. customConfig is the
+// suricata.yaml of the custom profile, and is ignored by the other profiles.
+func GenerateExternalResources(network *l2smv1.L2Network, netAttachString, customConfig string) ([]client.Object, error) {
 	if network == nil {
 		return nil, fmt.Errorf("network is nil")
 	}
@@ -40,22 +92,26 @@ func GenerateExternalResources(network *l2smv1.L2Network, netAttachString string
 
 	resArray := []client.Object{}
 
-	// if a namespace was specified, we use it. if none was, we use the network namespace
-	namespace := network.Spec.Ids.Namespace
-	if namespace == "" {
-		namespace = network.Namespace
+	namespace := Namespace(network)
+	config, err := SuricataConfig(network.Spec.Ids, customConfig)
+	if err != nil {
+		return nil, err
 	}
+
 	// 1. Create the ConfigMap containing the rules
 	// We pass the custom sources defined in the CR
 	cm, err := constructConfigMap(network, namespace)
 	if err != nil {
 		return nil, fmt.Errorf("failed to construct configmap: %w", err)
 	}
+	cm.Data[SuricataConfigKey] = config
 	resArray = append(resArray, cm)
 
 	// 2. Create the Suricata Deployment
 	// We pass the ConfigMap name AND the custom sources so we can mount the refs
 	suri := generateSuricataDeployment(network.Spec.Ids, network.Name, netAttachString, namespace)
+	// Suricata only reads its configuration when it starts, so a new one rolls the pod
+	suri.Spec.Template.Annotations[ConfigHashAnnotation] = configHash(config)
 	resArray = append(resArray, suri)
 
 	return resArray, nil
@@ -83,6 +139,13 @@ func constructConfigMap(network *l2smv1.L2Network, namespace string) (*corev1.Co
 		// ConfigMapRef is handled in the Deployment volume projection, not here
 	}
 
+	data := map[string]string{
+		"suricata.rules": rulesBuilder.String(),
+	}
+	if filter := bpfFilter(idsRules.IgnorePorts); filter != "" {
+		data[bpfFilterKey] = filter
+	}
+
 	configMap := &corev1.ConfigMap{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "ConfigMap",
@@ -92,48 +155,160 @@ func constructConfigMap(network *l2smv1.L2Network, namespace string) (*corev1.Co
 			Name:      utils.GenerateIdsCMName(network.Name),
 			Namespace: namespace,
 		},
-		Data: map[string]string{
-			"suricata.rules": rulesBuilder.String(),
-		},
+		Data: data,
 	}
 
 	return configMap, nil
 }
 
+// image returns the Suricata image of the IDS.
+func image(idsRules *l2smv1.IdsRules) string {
+	if idsRules.Image != "" {
+		return idsRules.Image
+	}
+	return env.GetIDSImage()
+}
+
+// bpfFilter returns the BPF filter that leaves the traffic of the ignored ports out of inspection.
+func bpfFilter(ignorePorts []int32) string {
+	if len(ignorePorts) == 0 {
+		return ""
+	}
+	ports := make([]string, 0, len(ignorePorts))
+	for _, port := range ignorePorts {
+		ports = append(ports, fmt.Sprintf("port %d", port))
+	}
+	return fmt.Sprintf("not (%s)", strings.Join(ports, " or "))
+}
+
+// InlineRuleCount returns the number of inline rules of the IDS.
+func InlineRuleCount(idsRules *l2smv1.IdsRules) int {
+	count := 0
+	for _, source := range idsRules.CustomRuleSources {
+		n, _ := CountRules(source.Inline)
+		count += n
+	}
+	return count
+}
+
+// GenerateRuleConfigMaps renders the remote rules into the ConfigMaps the IDS pod mounts. Rulesets like ET Open don't
+// fit in a single ConfigMap, so the rules are compressed and split in chunks, one per ConfigMap, that the pod puts
+// back together.
+func GenerateRuleConfigMaps(networkName, namespace string, sources []SourceRules) ([]*corev1.ConfigMap, error) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	for _, source := range sources {
+		fmt.Fprintf(gz, "\n# Source: %s\n%s\n", source.Name, source.Rules)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	data := compressed.Bytes()
+	chunks := (len(data) + maxRuleChunkSize - 1) / maxRuleChunkSize
+	if chunks > MaxRuleChunks {
+		return nil, fmt.Errorf("%w: %d bytes compressed", ErrRulesTooLarge, len(data))
+	}
+
+	configMaps := make([]*corev1.ConfigMap, 0, chunks)
+	for i := 0; i < chunks; i++ {
+		chunk := data[i*maxRuleChunkSize : min((i+1)*maxRuleChunkSize, len(data))]
+		configMaps = append(configMaps, &corev1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "ConfigMap",
+				APIVersion: "v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      utils.GenerateIdsRulesCMName(networkName, i),
+				Namespace: namespace,
+			},
+			BinaryData: map[string][]byte{
+				fmt.Sprintf("%s.%02d", fetchedRulesKey, i): chunk,
+			},
+		})
+	}
+	return configMaps, nil
+}
+
 // generateSuricataDeployment creates the deployment definition
 func generateSuricataDeployment(idsRules *l2smv1.IdsRules, networkName, netAttachAnnotation, namespace string) *appsv1.Deployment {
 	labels := map[string]string{
 		"app":            "suricata-ids",
 		"l2sm/component": "ids",
 	}
+	// the selector of existing deployments can't change, so only the pods are labeled with their network
+	podLabels := map[string]string{NetworkLabel: networkName}
+	for key, value := range labels {
+		podLabels[key] = value
+	}
 
 	// This makes sure the Pod runs as Root to allow packet capture capabilities
 	privileged := true
 
-	// Construct the Projected Volume Sources
-	// This allows us to merge the generated inline rules AND any external ConfigMaps (like the portscan one)
-	// into a single directory: /var/lib/suricata/rules/
-	projectedSources := []corev1.VolumeProjection{
+	// The rules are merged by the pod into the active rules directory, /var/lib/suricata/rules/, from the generated
+	// inline rules, the remote rules, and any external ConfigMaps (like the portscan one)
+	volumes := []corev1.Volume{
+		{
+			Name:         "active-rules",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+		{
+			Name:         "logs",
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
 		{
+			Name: "generated-rules",
+			VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: utils.GenerateIdsCMName(networkName)},
+			}},
+		},
+	}
+	volumeMounts := []corev1.VolumeMount{
+		{Name: "active-rules", MountPath: "/var/lib/suricata/rules"},
+		{Name: "logs", MountPath: "/var/log/suricata"},
+		{Name: "generated-rules", MountPath: "/var/lib/suricata/generated-rules", ReadOnly: true},
+		{Name: "generated-rules", MountPath: "/etc/suricata/suricata.yaml", SubPath: SuricataConfigKey, ReadOnly: true},
+		{Name: "fetched-rules", MountPath: "/var/lib/suricata/fetched-rules", ReadOnly: true},
+	}
+
+	// The chunks of the remote rules are optional, so that the pod doesn't change with their number
+	optional := true
+	fetchedSources := make([]corev1.VolumeProjection, 0, MaxRuleChunks)
+	for i := 0; i < MaxRuleChunks; i++ {
+		fetchedSources = append(fetchedSources, corev1.VolumeProjection{
 			ConfigMap: &corev1.ConfigMapProjection{
-				LocalObjectReference: corev1.LocalObjectReference{
-					Name: utils.GenerateIdsCMName(networkName),
-				},
+				LocalObjectReference: corev1.LocalObjectReference{Name: utils.GenerateIdsRulesCMName(networkName, i)},
+				Optional:             &optional,
 			},
-		},
+		})
 	}
+	volumes = append(volumes, corev1.Volume{
+		Name:         "fetched-rules",
+		VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{Sources: fetchedSources}},
+	})
 
-	// Add any user-provided ConfigMapRefs to the volume projection
-	for _, source := range idsRules.CustomRuleSources {
+	// Add any user-provided ConfigMapRefs, each in its own directory
+	for i, source := range idsRules.CustomRuleSources {
 		if source.ConfigMapRef != nil {
-			projectedSources = append(projectedSources, corev1.VolumeProjection{
-				ConfigMap: &corev1.ConfigMapProjection{
+			name := fmt.Sprintf("custom-rules-%d", i)
+			volumes = append(volumes, corev1.Volume{
+				Name: name,
+				VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
 					LocalObjectReference: *source.ConfigMapRef,
-				},
+				}},
+			})
+			volumeMounts = append(volumeMounts, corev1.VolumeMount{
+				Name: name, MountPath: fmt.Sprintf("/var/lib/suricata/custom-rules/%d", i), ReadOnly: true,
 			})
 		}
 	}
 
+	// Traffic of the ignored ports is filtered out before it reaches Suricata
+	filterArg := ""
+	if len(idsRules.IgnorePorts) != 0 {
+		filterArg = " -F /var/lib/suricata/generated-rules/" + bpfFilterKey
+	}
+
 	return &appsv1.Deployment{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Deployment",
@@ -149,7 +324,7 @@ func generateSuricataDeployment(idsRules *l2smv1.IdsRules, networkName, netAttac
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
+					Labels: podLabels,
 					Annotations: map[string]string{
 						networkannotation.MULTUS_ANNOTATION_KEY: netAttachAnnotation,
 					},
@@ -158,12 +333,12 @@ func generateSuricataDeployment(idsRules *l2smv1.IdsRules, networkName, netAttac
 					Containers: []corev1.Container{
 						{
 							Name:  "suricata",
-							Image: "jasonish/suricata:latest",
+							Image: image(idsRules),
 							// Command args to listen specifically on the Multus interface (net1)
 							Command: []string{"/bin/bash", "-c"},
 							Args: []string{
 								// Merge generated inline rules with every file from referenced rule ConfigMaps.
-								"suricata -D -i net1 && touch /var/log/suricata/fast.log && tail -f /var/log/suricata/fast.log -s 1",
+								fmt.Sprintf(suricataScript, fetchedRulesKey, filterArg),
 							},
 							SecurityContext: &corev1.SecurityContext{
 								// Suricata needs privileges to capture packets
@@ -172,26 +347,22 @@ func generateSuricataDeployment(idsRules *l2smv1.IdsRules, networkName, netAttac
 									Add: []corev1.Capability{"NET_ADMIN", "NET_RAW", "IPC_LOCK"},
 								},
 							},
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "rules-volume",
-									MountPath: "/var/lib/suricata/rules", // All ConfigMaps will be merged here
-									ReadOnly:  true,
-								},
-							},
+							VolumeMounts: volumeMounts,
+							Resources:    profileOf(idsRules).Resources,
 						},
-					},
-					NodeName: idsRules.Node,
-					Volumes: []corev1.Volume{
 						{
-							Name: "rules-volume",
-							VolumeSource: corev1.VolumeSource{
-								Projected: &corev1.ProjectedVolumeSource{
-									Sources: projectedSources,
-								},
+							// The EVE events are written to the log of this container, where the manager reads the
+							// alerts from
+							Name:    EveContainerName,
+							Image:   image(idsRules),
+							Command: []string{"tail", "-F", "-n", "+1", "/var/log/suricata/eve.json"},
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "logs", MountPath: "/var/log/suricata", ReadOnly: true},
 							},
 						},
 					},
+					NodeName: idsRules.Node,
+					Volumes:  volumes,
 				},
 			},
 		},
@@ -52,7 +52,7 @@ func TestGenerateExternalResourcesMountsConfigMapRuleSources(t *testing.T) {
 		},
 	}
 
-	objects, err := GenerateExternalResources(network, `[{"name":"ids-net"}]`)
+	objects, err := GenerateExternalResources(network, `[{"name":"ids-net"}]`, "")
 	if err != nil {
 		t.Fatalf("GenerateExternalResources returned error: %v", err)
 	}
@@ -0,0 +1,234 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ids
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+)
+
+const (
+	ProfileLightweight = "lightweight"
+	ProfileBalanced    = "balanced"
+	ProfileFull        = "full"
+	ProfileCustom      = "custom"
+	// ProfileSuricata is the profile the first IDS were created with, kept as an alias of the balanced one.
+	ProfileSuricata = "suricata"
+
+	// SuricataConfigKey is the key of the suricata.yaml in the generated ConfigMap and in the ConfigMaps of custom
+	// profiles.
+	SuricataConfigKey = "suricata.yaml"
+
+	// ConfigHashAnnotation holds the hash of the suricata.yaml in the pod template, so that the IDS is restarted
+	// when its configuration changes.
+	ConfigHashAnnotation = "l2sm/ids-config-hash"
+)
+
+// appLayerProtocols are the protocols Suricata can parse. The ones a profile doesn't use are disabled.
+var appLayerProtocols = []string{
+	"dcerpc", "dhcp", "dnp3", "dns", "enip", "ftp", "http", "http2", "ike", "krb5", "modbus", "mqtt", "nfs", "ntp",
+	"quic", "rdp", "sip", "smb", "smtp", "snmp", "ssh", "tftp", "tls",
+}
+
+// Profile sets how much Suricata inspects and the resources it takes.
+type Profile struct {
+	// Threads is the number of af-packet capture threads.
+	Threads int
+	// DetectProfile trades memory for speed in the detection engine: low, medium or high.
+	DetectProfile string
+	// AppLayer are the protocols that are parsed.
+	AppLayer []string
+	// EveTypes are the events logged in eve.json.
+	EveTypes  []string
+	Resources corev1.ResourceRequirements
+}
+
+func resources(requestCPU, requestMemory, limitCPU, limitMemory string) corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(requestCPU),
+			corev1.ResourceMemory: resource.MustParse(requestMemory),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(limitCPU),
+			corev1.ResourceMemory: resource.MustParse(limitMemory),
+		},
+	}
+}
+
+var profiles = map[string]Profile{
+	ProfileLightweight: {
+		Threads:       1,
+		DetectProfile: "low",
+		AppLayer:      []string{"dns", "http", "ssh", "tls"},
+		EveTypes:      []string{"alert"},
+		Resources:     resources("100m", "256Mi", "500m", "1Gi"),
+	},
+	ProfileBalanced: {
+		Threads:       2,
+		DetectProfile: "medium",
+		AppLayer:      []string{"dcerpc", "dhcp", "dns", "ftp", "http", "http2", "krb5", "nfs", "smb", "smtp", "ssh", "tls"},
+		EveTypes:      []string{"alert", "anomaly", "dns", "http", "tls"},
+		Resources:     resources("500m", "1Gi", "2", "3Gi"),
+	},
+	ProfileFull: {
+		Threads:       4,
+		DetectProfile: "high",
+		AppLayer:      appLayerProtocols,
+		EveTypes:      []string{"alert", "anomaly", "dns", "http", "tls", "ssh", "smtp", "fileinfo", "flow"},
+		Resources:     resources("2", "4Gi", "4", "8Gi"),
+	},
+}
+
+// IsProfile reports whether the profile is known. An empty profile is the balanced one.
+func IsProfile(name string) bool {
+	_, found := profiles[profileName(name)]
+	return found || name == ProfileCustom
+}
+
+// profileName resolves the aliases of the profiles.
+func profileName(name string) string {
+	if name == "" || name == ProfileSuricata {
+		return ProfileBalanced
+	}
+	return name
+}
+
+// profileOf returns the profile of the IDS. Custom profiles, and unknown ones, take the resources of the balanced
+// profile.
+func profileOf(idsRules *l2smv1.IdsRules) Profile {
+	if profile, found := profiles[profileName(idsRules.Profile)]; found {
+		return profile
+	}
+	return profiles[ProfileBalanced]
+}
+
+// SuricataConfig renders the suricata.yaml of the profile of the IDS. The one of custom profiles is given, and used
+// as is.
+func SuricataConfig(idsRules *l2smv1.IdsRules, customConfig string) (string, error) {
+	if idsRules.Profile == ProfileCustom {
+		if strings.TrimSpace(customConfig) == "" {
+			return "", fmt.Errorf("the custom profile has no %s", SuricataConfigKey)
+		}
+		return customConfig, nil
+	}
+	if !IsProfile(idsRules.Profile) {
+		return "", fmt.Errorf("unknown ids profile %q", idsRules.Profile)
+	}
+	profile := profileOf(idsRules)
+
+	homeNet, externalNet := "any", "any"
+	if len(idsRules.HomeNetCIDR) != 0 {
+		homeNet, externalNet = fmt.Sprintf("[%s]", strings.Join(idsRules.HomeNetCIDR, ",")), "!$HOME_NET"
+	}
+
+	protocols := map[string]any{}
+	for _, protocol := range appLayerProtocols {
+		protocols[protocol] = map[string]any{"enabled": contains(profile.AppLayer, protocol)}
+	}
+
+	config := map[string]any{
+		"vars": map[string]any{
+			"address-groups": map[string]any{
+				"HOME_NET":       homeNet,
+				"EXTERNAL_NET":   externalNet,
+				"HTTP_SERVERS":   "$HOME_NET",
+				"SMTP_SERVERS":   "$HOME_NET",
+				"SQL_SERVERS":    "$HOME_NET",
+				"DNS_SERVERS":    "$HOME_NET",
+				"TELNET_SERVERS": "$HOME_NET",
+				"AIM_SERVERS":    "$EXTERNAL_NET",
+				"DC_SERVERS":     "$HOME_NET",
+				"DNP3_SERVER":    "$HOME_NET",
+				"DNP3_CLIENT":    "$HOME_NET",
+				"MODBUS_CLIENT":  "$HOME_NET",
+				"MODBUS_SERVER":  "$HOME_NET",
+				"ENIP_CLIENT":    "$HOME_NET",
+				"ENIP_SERVER":    "$HOME_NET",
+			},
+			"port-groups": map[string]any{
+				"HTTP_PORTS":      "80",
+				"SHELLCODE_PORTS": "!80",
+				"ORACLE_PORTS":    "1521",
+				"SSH_PORTS":       "22",
+				"DNP3_PORTS":      "20000",
+				"MODBUS_PORTS":    "502",
+				"FILE_DATA_PORTS": "[$HTTP_PORTS,110,143]",
+				"FTP_PORTS":       "21",
+				"GENEVE_PORTS":    "6081",
+				"VXLAN_PORTS":     "4789",
+				"TEREDO_PORTS":    "3544",
+			},
+		},
+		"default-log-dir": "/var/log/suricata/",
+		// the rules are reloaded by signaling the pid in this file
+		"pid-file":              "/var/run/suricata.pid",
+		"default-rule-path":     "/var/lib/suricata/rules",
+		"rule-files":            []string{"suricata.rules"},
+		"classification-file":   "/etc/suricata/classification.config",
+		"reference-config-file": "/etc/suricata/reference.config",
+		"outputs": []any{
+			map[string]any{"fast": map[string]any{"enabled": true, "filename": "fast.log", "append": true}},
+			map[string]any{"eve-log": map[string]any{
+				"enabled":  true,
+				"filetype": "regular",
+				"filename": "eve.json",
+				"types":    profile.EveTypes,
+			}},
+		},
+		"af-packet": []any{
+			map[string]any{
+				"interface":    "net1",
+				"threads":      profile.Threads,
+				"cluster-id":   99,
+				"cluster-type": "cluster_flow",
+				"defrag":       true,
+			},
+		},
+		"detect": map[string]any{"profile": profile.DetectProfile},
+		"app-layer": map[string]any{
+			"protocols": protocols,
+		},
+	}
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	// Suricata only loads YAML 1.1 documents with their header
+	return "%YAML 1.1\n---\n" + string(out), nil
+}
+
+// configHash returns a short hash of the configuration of Suricata.
+func configHash(config string) string {
+	sum := sha256.Sum256([]byte(config))
+	return hex.EncodeToString(sum[:8])
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
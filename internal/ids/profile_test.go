@@ -0,0 +1,185 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ids
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+)
+
+// renderedConfig is the part of suricata.yaml the profiles change.
+type renderedConfig struct {
+	Vars struct {
+		AddressGroups map[string]string `json:"address-groups"`
+	} `json:"vars"`
+	AfPacket []struct {
+		Interface string `json:"interface"`
+		Threads   int    `json:"threads"`
+	} `json:"af-packet"`
+	Detect struct {
+		Profile string `json:"profile"`
+	} `json:"detect"`
+	AppLayer struct {
+		Protocols map[string]struct {
+			Enabled bool `json:"enabled"`
+		} `json:"protocols"`
+	} `json:"app-layer"`
+	Outputs []map[string]struct {
+		Types []string `json:"types"`
+	} `json:"outputs"`
+}
+
+func renderConfig(t *testing.T, idsRules *l2smv1.IdsRules) renderedConfig {
+	t.Helper()
+	config, err := SuricataConfig(idsRules, "")
+	if err != nil {
+		t.Fatalf("SuricataConfig returned error: %v", err)
+	}
+	const header = "%YAML 1.1\n---\n"
+	if len(config) < len(header) || config[:len(header)] != header {
+		t.Fatalf("suricata.yaml doesn't start with the YAML 1.1 header: %q", config)
+	}
+	var rendered renderedConfig
+	if err := yaml.Unmarshal([]byte(config[len(header):]), &rendered); err != nil {
+		t.Fatalf("suricata.yaml can't be parsed: %v", err)
+	}
+	return rendered
+}
+
+func TestProfilesRenderSuricataConfig(t *testing.T) {
+	tests := []struct {
+		profile       string
+		threads       int
+		detectProfile string
+		enabled       []string
+		disabled      []string
+		eveTypes      int
+	}{
+		{profile: "lightweight", threads: 1, detectProfile: "low", enabled: []string{"http", "tls"}, disabled: []string{"smb", "modbus"}, eveTypes: 1},
+		{profile: "balanced", threads: 2, detectProfile: "medium", enabled: []string{"http", "smb"}, disabled: []string{"modbus"}, eveTypes: 5},
+		{profile: "suricata", threads: 2, detectProfile: "medium", enabled: []string{"http", "smb"}, disabled: []string{"modbus"}, eveTypes: 5},
+		{profile: "", threads: 2, detectProfile: "medium", enabled: []string{"http", "smb"}, disabled: []string{"modbus"}, eveTypes: 5},
+		{profile: "full", threads: 4, detectProfile: "high", enabled: []string{"http", "smb", "modbus"}, eveTypes: 9},
+	}
+	for _, test := range tests {
+		t.Run(test.profile, func(t *testing.T) {
+			config := renderConfig(t, &l2smv1.IdsRules{Profile: test.profile, HomeNetCIDR: []string{"10.10.0.0/24"}})
+			if len(config.AfPacket) != 1 || config.AfPacket[0].Interface != "net1" || config.AfPacket[0].Threads != test.threads {
+				t.Fatalf("unexpected af-packet configuration: %+v", config.AfPacket)
+			}
+			if config.Detect.Profile != test.detectProfile {
+				t.Fatalf("expected detect profile %s, got %s", test.detectProfile, config.Detect.Profile)
+			}
+			for _, protocol := range test.enabled {
+				if !config.AppLayer.Protocols[protocol].Enabled {
+					t.Fatalf("expected %s to be parsed", protocol)
+				}
+			}
+			for _, protocol := range test.disabled {
+				if parser, found := config.AppLayer.Protocols[protocol]; !found || parser.Enabled {
+					t.Fatalf("expected %s to be disabled", protocol)
+				}
+			}
+			if eve, found := config.Outputs[1]["eve-log"]; !found || len(eve.Types) != test.eveTypes {
+				t.Fatalf("expected %d eve types, got %+v", test.eveTypes, config.Outputs)
+			}
+			if config.Vars.AddressGroups["HOME_NET"] != "[10.10.0.0/24]" || config.Vars.AddressGroups["EXTERNAL_NET"] != "!$HOME_NET" {
+				t.Fatalf("unexpected address groups: %v", config.Vars.AddressGroups)
+			}
+		})
+	}
+}
+
+func TestCustomProfileUsesItsConfig(t *testing.T) {
+	config, err := SuricataConfig(&l2smv1.IdsRules{Profile: ProfileCustom}, "%YAML 1.1\n---\nmax-pending-packets: 1024\n")
+	if err != nil {
+		t.Fatalf("SuricataConfig returned error: %v", err)
+	}
+	if config != "%YAML 1.1\n---\nmax-pending-packets: 1024\n" {
+		t.Fatalf("custom configuration was changed: %q", config)
+	}
+	if _, err := SuricataConfig(&l2smv1.IdsRules{Profile: ProfileCustom}, ""); err == nil {
+		t.Fatal("expected an error for a custom profile without configuration")
+	}
+	if _, err := SuricataConfig(&l2smv1.IdsRules{Profile: "snort"}, ""); err == nil {
+		t.Fatal("expected an error for an unknown profile")
+	}
+}
+
+func generateDeployment(t *testing.T, idsRules *l2smv1.IdsRules) (*corev1.ConfigMap, *appsv1.Deployment) {
+	t.Helper()
+	network := &l2smv1.L2Network{
+		ObjectMeta: metav1.ObjectMeta{Name: "monitored-network", Namespace: "default"},
+		Spec:       l2smv1.L2NetworkSpec{Ids: idsRules},
+	}
+	objects, err := GenerateExternalResources(network, `[{"name":"ids-net"}]`, "")
+	if err != nil {
+		t.Fatalf("GenerateExternalResources returned error: %v", err)
+	}
+	return objects[0].(*corev1.ConfigMap), objects[1].(*appsv1.Deployment)
+}
+
+func TestProfileSetsResourcesAndConfig(t *testing.T) {
+	cm, deployment := generateDeployment(t, &l2smv1.IdsRules{Enabled: true, Node: "node-a", Profile: ProfileLightweight})
+	if cm.Data[SuricataConfigKey] == "" {
+		t.Fatalf("configmap has no %s", SuricataConfigKey)
+	}
+
+	container := deployment.Spec.Template.Spec.Containers[0]
+	if !container.Resources.Limits.Memory().Equal(resource.MustParse("1Gi")) || !container.Resources.Requests.Cpu().Equal(resource.MustParse("100m")) {
+		t.Fatalf("unexpected resources: %+v", container.Resources)
+	}
+	found := false
+	for _, mount := range container.VolumeMounts {
+		if mount.MountPath == "/etc/suricata/suricata.yaml" && mount.Name == "generated-rules" && mount.SubPath == SuricataConfigKey {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("suricata.yaml is not mounted: %+v", container.VolumeMounts)
+	}
+
+	// a new profile changes the pod template, so that Suricata restarts with it
+	lightweightHash := deployment.Spec.Template.Annotations[ConfigHashAnnotation]
+	_, deployment = generateDeployment(t, &l2smv1.IdsRules{Enabled: true, Node: "node-a", Profile: ProfileFull})
+	if lightweightHash == "" || deployment.Spec.Template.Annotations[ConfigHashAnnotation] == lightweightHash {
+		t.Fatalf("config hash didn't change with the profile: %q", lightweightHash)
+	}
+}
+
+func TestIDSImage(t *testing.T) {
+	_, deployment := generateDeployment(t, &l2smv1.IdsRules{Enabled: true, Node: "node-a"})
+	if image := deployment.Spec.Template.Spec.Containers[0].Image; image != "jasonish/suricata:7.0.8" {
+		t.Fatalf("expected the pinned default image, got %s", image)
+	}
+
+	t.Setenv("IDS_IMAGE", "registry.example.com/suricata:7.0.7")
+	_, deployment = generateDeployment(t, &l2smv1.IdsRules{Enabled: true, Node: "node-a"})
+	if image := deployment.Spec.Template.Spec.Containers[0].Image; image != "registry.example.com/suricata:7.0.7" {
+		t.Fatalf("expected the image of IDS_IMAGE, got %s", image)
+	}
+
+	_, deployment = generateDeployment(t, &l2smv1.IdsRules{Enabled: true, Node: "node-a", Image: "jasonish/suricata:7.0.6"})
+	if image := deployment.Spec.Template.Spec.Containers[0].Image; image != "jasonish/suricata:7.0.6" {
+		t.Fatalf("expected the image of the network, got %s", image)
+	}
+}
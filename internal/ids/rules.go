@@ -0,0 +1,310 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ids
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+)
+
+const (
+	// ETOpenSourceName names the Emerging Threats Open ruleset among the fetched sources.
+	ETOpenSourceName = "emerging-threats-open"
+
+	// defaultMaxDownloadSize bounds the size of a rule source, both as downloaded and once decompressed.
+	defaultMaxDownloadSize = 64 << 20
+)
+
+// ErrInvalidRules is returned when a rule source doesn't match its checksum or holds something that isn't a rule.
+var ErrInvalidRules = errors.New("invalid rules")
+
+// ruleActions are the actions a Suricata rule can start with.
+var ruleActions = map[string]bool{
+	"alert": true, "pass": true, "drop": true, "reject": true, "rejectsrc": true, "rejectdst": true, "rejectboth": true,
+}
+
+// SourceRules are the rules fetched from one source.
+type SourceRules struct {
+	Name  string
+	Rules string
+	Count int
+}
+
+// RuleFetcher downloads the remote rule sources of the IDS. The last good copy of every URL is cached, so that sources
+// that didn't change are not downloaded again and sources that can't be reached keep their previous rules.
+type RuleFetcher struct {
+	Client    *http.Client
+	ETOpenURL string
+	// MaxSize bounds the size of every source, 64 MiB if it's not set.
+	MaxSize int64
+
+	mu    sync.Mutex
+	cache map[string]cachedSource
+}
+
+type cachedSource struct {
+	rules        string
+	count        int
+	etag         string
+	lastModified string
+}
+
+// NewRuleFetcher returns a fetcher that downloads ET Open from the given URL. A client with a timeout is used if none
+// is given.
+func NewRuleFetcher(client *http.Client, etOpenURL string) *RuleFetcher {
+	if client == nil {
+		client = &http.Client{Timeout: 2 * time.Minute}
+	}
+	return &RuleFetcher{Client: client, ETOpenURL: etOpenURL}
+}
+
+// Fetch returns the rules of the remote sources of the IDS, ET Open first if it's used. A source that can't be fetched
+// is left out unless a previous copy of it is cached, and its error is joined into the returned one.
+func (f *RuleFetcher) Fetch(ctx context.Context, idsRules *l2smv1.IdsRules) ([]SourceRules, error) {
+	var sources []SourceRules
+	var errs []error
+	add := func(name, url string, verify func(context.Context, []byte) error) {
+		source, err := f.fetch(ctx, url, verify)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule source %s: %w", name, err))
+		}
+		if source.rules != "" {
+			sources = append(sources, SourceRules{Name: name, Rules: source.rules, Count: source.count})
+		}
+	}
+
+	if idsRules.UseEmergingThreatsOpen {
+		add(ETOpenSourceName, f.ETOpenURL, f.checkMD5File(f.ETOpenURL+".md5"))
+	}
+	for _, ruleSource := range idsRules.CustomRuleSources {
+		if ruleSource.URL == "" {
+			continue
+		}
+		var verify func(context.Context, []byte) error
+		if ruleSource.SHA256 != "" {
+			verify = checkSHA256(ruleSource.SHA256)
+		}
+		add(ruleSource.Name, ruleSource.URL, verify)
+	}
+	return sources, errors.Join(errs...)
+}
+
+// fetch downloads the rules at the url, returning the cached copy, if any, when it fails.
+func (f *RuleFetcher) fetch(ctx context.Context, url string, verify func(context.Context, []byte) error) (cachedSource, error) {
+	f.mu.Lock()
+	cached := f.cache[url]
+	f.mu.Unlock()
+
+	source, err := f.download(ctx, url, cached, verify)
+	if err != nil {
+		return cached, err
+	}
+
+	f.mu.Lock()
+	if f.cache == nil {
+		f.cache = map[string]cachedSource{}
+	}
+	f.cache[url] = source
+	f.mu.Unlock()
+	return source, nil
+}
+
+func (f *RuleFetcher) download(ctx context.Context, url string, cached cachedSource, verify func(context.Context, []byte) error) (cachedSource, error) {
+	header := http.Header{}
+	if cached.rules != "" {
+		if cached.etag != "" {
+			header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+	resp, body, err := f.get(ctx, url, header)
+	if err != nil {
+		return cachedSource{}, err
+	}
+	if resp.StatusCode == http.StatusNotModified && cached.rules != "" {
+		return cached, nil
+	}
+
+	if verify != nil {
+		if err := verify(ctx, body); err != nil {
+			return cachedSource{}, err
+		}
+	}
+	rules, err := extractRules(body, f.maxSize())
+	if err != nil {
+		return cachedSource{}, err
+	}
+	count, err := CountRules(rules)
+	if err != nil {
+		return cachedSource{}, err
+	}
+	return cachedSource{rules: rules, count: count, etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}, nil
+}
+
+// get downloads the url, failing if the answer is not OK, or not modified when the request was conditional.
+func (f *RuleFetcher) get(ctx context.Context, url string, header http.Header) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header = header
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusNotModified && len(header) != 0:
+		return resp, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("could not download %s: %s", url, resp.Status)
+	}
+	body, err := readLimited(resp.Body, f.maxSize())
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not download %s: %w", url, err)
+	}
+	return resp, body, nil
+}
+
+func (f *RuleFetcher) maxSize() int64 {
+	if f.MaxSize > 0 {
+		return f.MaxSize
+	}
+	return defaultMaxDownloadSize
+}
+
+// checkMD5File verifies downloads against the MD5 checksum published at url, the way ET Open is distributed.
+func (f *RuleFetcher) checkMD5File(url string) func(context.Context, []byte) error {
+	return func(ctx context.Context, body []byte) error {
+		_, checksum, err := f.get(ctx, url, http.Header{})
+		if err != nil {
+			return fmt.Errorf("could not get checksum: %w", err)
+		}
+		fields := strings.Fields(string(checksum))
+		sum := md5.Sum(body)
+		if len(fields) == 0 || !strings.EqualFold(fields[0], hex.EncodeToString(sum[:])) {
+			return fmt.Errorf("%w: md5 checksum doesn't match", ErrInvalidRules)
+		}
+		return nil
+	}
+}
+
+// checkSHA256 verifies downloads against a hex SHA256 checksum.
+func checkSHA256(expected string) func(context.Context, []byte) error {
+	return func(_ context.Context, body []byte) error {
+		sum := sha256.Sum256(body)
+		if !strings.EqualFold(strings.TrimSpace(expected), hex.EncodeToString(sum[:])) {
+			return fmt.Errorf("%w: sha256 checksum doesn't match", ErrInvalidRules)
+		}
+		return nil
+	}
+}
+
+// extractRules returns the rules of a plain rules file, or of every .rules file of a gzip or tar.gz archive.
+func extractRules(body []byte, maxSize int64) (string, error) {
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidRules, err)
+		}
+		if body, err = readLimited(gz, maxSize); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidRules, err)
+		}
+	}
+	// tar archives are told apart by the magic of their first header
+	if len(body) < 262 || string(body[257:262]) != "ustar" {
+		return string(body), nil
+	}
+
+	var rules strings.Builder
+	archive := tar.NewReader(bytes.NewReader(body))
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidRules, err)
+		}
+		if header.Typeflag != tar.TypeReg || !strings.HasSuffix(header.Name, ".rules") {
+			continue
+		}
+		fmt.Fprintf(&rules, "# File: %s\n", header.Name)
+		if _, err := io.Copy(&rules, archive); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidRules, err)
+		}
+		rules.WriteString("\n")
+	}
+	return rules.String(), nil
+}
+
+// CountRules returns the number of rules in a rules file, failing on lines that are neither rules nor comments.
+// Rules can span several lines that end with a backslash.
+func CountRules(rules string) (int, error) {
+	count := 0
+	var rule strings.Builder
+	for i, line := range strings.Split(rules, "\n") {
+		line = strings.TrimSpace(line)
+		if rule.Len() == 0 && (line == "" || strings.HasPrefix(line, "#")) {
+			continue
+		}
+		if continued, found := strings.CutSuffix(line, "\\"); found {
+			rule.WriteString(continued)
+			continue
+		}
+		rule.WriteString(line)
+
+		action, _, _ := strings.Cut(rule.String(), " ")
+		if !ruleActions[action] || !strings.Contains(rule.String(), "sid:") {
+			return 0, fmt.Errorf("%w: line %d is not a rule", ErrInvalidRules, i+1)
+		}
+		rule.Reset()
+		count++
+	}
+	if rule.Len() != 0 {
+		return 0, fmt.Errorf("%w: the last rule is not finished", ErrInvalidRules)
+	}
+	return count, nil
+}
+
+// readLimited reads r, failing if it holds more than maxSize bytes.
+func readLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxSize {
+		return nil, fmt.Errorf("larger than %d bytes", maxSize)
+	}
+	return body, nil
+}
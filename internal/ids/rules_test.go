@@ -0,0 +1,258 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ids
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+)
+
+const (
+	ruleA = `alert tcp any any -> any 80 (msg:"a"; sid:1000001; rev:1;)`
+	ruleB = `alert udp any any -> any 53 (msg:"b"; sid:1000002; rev:1;)`
+)
+
+// ruleServer serves the given files, counting the downloads and answering conditional requests with their ETag.
+type ruleServer struct {
+	files     map[string][]byte
+	downloads int
+	down      bool
+}
+
+func (s *ruleServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, found := s.files[r.URL.Path]
+	switch {
+	case s.down:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case !found:
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		sum := md5.Sum(body)
+		etag := `"` + hex.EncodeToString(sum[:]) + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		s.downloads++
+		w.Header().Set("ETag", etag)
+		w.Write(body)
+	}
+}
+
+func tarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		archive.Write([]byte(content))
+	}
+	archive.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestFetchVerifiesETOpenAndURLSources(t *testing.T) {
+	etOpen := tarGz(t, map[string]string{"rules/emerging-scan.rules": ruleA + "\n#" + ruleB + "\n", "rules/LICENSE": "not rules"})
+	etSum := md5.Sum(etOpen)
+	custom := []byte("# custom\n" + ruleB + "\n")
+	customSum := sha256.Sum256(custom)
+
+	server := &ruleServer{files: map[string][]byte{
+		"/emerging.rules.tar.gz":     etOpen,
+		"/emerging.rules.tar.gz.md5": []byte(hex.EncodeToString(etSum[:]) + "\n"),
+		"/custom.rules":              custom,
+	}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	fetcher := NewRuleFetcher(ts.Client(), ts.URL+"/emerging.rules.tar.gz")
+	idsRules := &l2smv1.IdsRules{
+		UseEmergingThreatsOpen: true,
+		CustomRuleSources: []l2smv1.IDSRuleSource{
+			{Name: "custom", URL: ts.URL + "/custom.rules", SHA256: hex.EncodeToString(customSum[:])},
+			{Name: "inline", Inline: ruleA},
+		},
+	}
+
+	sources, err := fetcher.Fetch(context.Background(), idsRules)
+	if err != nil {
+		t.Fatalf("Fetch returned error: %v", err)
+	}
+	if len(sources) != 2 || sources[0].Name != ETOpenSourceName || sources[1].Name != "custom" {
+		t.Fatalf("unexpected sources: %+v", sources)
+	}
+	if sources[0].Count != 1 || !strings.Contains(sources[0].Rules, "sid:1000001") || strings.Contains(sources[0].Rules, "not rules") {
+		t.Fatalf("ET Open rules were not extracted from the archive: %+v", sources[0])
+	}
+	if sources[1].Count != 1 {
+		t.Fatalf("expected 1 custom rule, got %d", sources[1].Count)
+	}
+
+	// unchanged sources are not downloaded again
+	downloads := server.downloads
+	if _, err := fetcher.Fetch(context.Background(), idsRules); err != nil {
+		t.Fatalf("Fetch returned error: %v", err)
+	}
+	if server.downloads != downloads {
+		t.Fatalf("unchanged sources were downloaded again")
+	}
+}
+
+func TestFetchKeepsLastGoodCopy(t *testing.T) {
+	server := &ruleServer{files: map[string][]byte{"/custom.rules": []byte(ruleA + "\n")}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	fetcher := NewRuleFetcher(ts.Client(), "")
+	idsRules := &l2smv1.IdsRules{CustomRuleSources: []l2smv1.IDSRuleSource{{Name: "custom", URL: ts.URL + "/custom.rules"}}}
+	if _, err := fetcher.Fetch(context.Background(), idsRules); err != nil {
+		t.Fatalf("Fetch returned error: %v", err)
+	}
+
+	server.down = true
+	sources, err := fetcher.Fetch(context.Background(), idsRules)
+	if err == nil {
+		t.Fatalf("expected an error while the source is down")
+	}
+	if len(sources) != 1 || sources[0].Count != 1 {
+		t.Fatalf("the cached copy was not kept: %+v", sources)
+	}
+
+	// a source that was never fetched has nothing to fall back to
+	idsRules.CustomRuleSources = append(idsRules.CustomRuleSources, l2smv1.IDSRuleSource{Name: "missing", URL: ts.URL + "/missing.rules"})
+	if sources, _ := fetcher.Fetch(context.Background(), idsRules); len(sources) != 1 {
+		t.Fatalf("expected only the cached source, got %+v", sources)
+	}
+}
+
+func TestFetchRejectsInvalidSources(t *testing.T) {
+	etOpen := tarGz(t, map[string]string{"emerging.rules": ruleA + "\n"})
+	server := &ruleServer{files: map[string][]byte{
+		"/emerging.rules.tar.gz":     etOpen,
+		"/emerging.rules.tar.gz.md5": []byte("0123456789abcdef0123456789abcdef"),
+		"/custom.rules":              []byte(ruleA + "\n"),
+		"/page.html":                 []byte("<html>not found</html>\n"),
+	}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	tests := map[string]*l2smv1.IdsRules{
+		"md5 mismatch": {UseEmergingThreatsOpen: true},
+		"sha256 mismatch": {CustomRuleSources: []l2smv1.IDSRuleSource{
+			{Name: "custom", URL: ts.URL + "/custom.rules", SHA256: strings.Repeat("0", 64)},
+		}},
+		"not rules": {CustomRuleSources: []l2smv1.IDSRuleSource{{Name: "page", URL: ts.URL + "/page.html"}}},
+	}
+	for name, idsRules := range tests {
+		t.Run(name, func(t *testing.T) {
+			fetcher := NewRuleFetcher(ts.Client(), ts.URL+"/emerging.rules.tar.gz")
+			sources, err := fetcher.Fetch(context.Background(), idsRules)
+			if !errors.Is(err, ErrInvalidRules) {
+				t.Fatalf("expected ErrInvalidRules, got %v", err)
+			}
+			if len(sources) != 0 {
+				t.Fatalf("invalid sources were returned: %+v", sources)
+			}
+		})
+	}
+}
+
+func TestCountRules(t *testing.T) {
+	count, err := CountRules("# comment\n\n" + ruleA + "\nalert tcp any any -> any 443 \\\n  (msg:\"c\"; sid:1000003; rev:1;)\n")
+	if err != nil || count != 2 {
+		t.Fatalf("expected 2 rules, got %d: %v", count, err)
+	}
+	if _, err := CountRules("var HOME_NET any\n"); !errors.Is(err, ErrInvalidRules) {
+		t.Fatalf("expected ErrInvalidRules, got %v", err)
+	}
+}
+
+func TestGenerateRuleConfigMapsSplitsCompressedRules(t *testing.T) {
+	// random-looking rules barely compress, so they need several chunks
+	var rules strings.Builder
+	for i := 0; rules.Len() < 3*maxRuleChunkSize; i++ {
+		sum := sha256.Sum256([]byte{byte(i), byte(i >> 8), byte(i >> 16)})
+		rules.WriteString(`alert tcp any any -> any any (msg:"` + hex.EncodeToString(sum[:]) + `"; sid:1;)` + "\n")
+	}
+	configMaps, err := GenerateRuleConfigMaps("net", "default", []SourceRules{{Name: "big", Rules: rules.String()}})
+	if err != nil {
+		t.Fatalf("GenerateRuleConfigMaps returned error: %v", err)
+	}
+	if len(configMaps) < 2 {
+		t.Fatalf("expected the rules to be split, got %d configmaps", len(configMaps))
+	}
+
+	var compressed []byte
+	for i, cm := range configMaps {
+		if cm.Name != fmt.Sprintf("net-ids-rules-%d", i) {
+			t.Fatalf("unexpected configmap name %s", cm.Name)
+		}
+		for _, chunk := range cm.BinaryData {
+			if len(chunk) > maxRuleChunkSize {
+				t.Fatalf("chunk of %d bytes is too large", len(chunk))
+			}
+			compressed = append(compressed, chunk...)
+		}
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, _ := io.ReadAll(gz)
+	if !strings.Contains(string(merged), "# Source: big") || !strings.Contains(string(merged), rules.String()) {
+		t.Fatalf("the chunks don't put the rules back together")
+	}
+
+	// without remote rules, the pod still gets an empty chunk to merge
+	configMaps, err = GenerateRuleConfigMaps("net", "default", nil)
+	if err != nil || len(configMaps) != 1 {
+		t.Fatalf("expected a single chunk, got %d: %v", len(configMaps), err)
+	}
+}
+
+func TestIgnorePortsGenerateBPFFilter(t *testing.T) {
+	network := &l2smv1.L2Network{}
+	network.Name, network.Namespace = "net", "default"
+	network.Spec.Ids = &l2smv1.IdsRules{Node: "node-a", IgnorePorts: []int32{22, 443}}
+
+	cm, err := constructConfigMap(network, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.Data[bpfFilterKey] != "not (port 22 or port 443)" {
+		t.Fatalf("unexpected bpf filter %q", cm.Data[bpfFilterKey])
+	}
+	deployment := generateSuricataDeployment(network.Spec.Ids, network.Name, "", "default")
+	if !strings.Contains(deployment.Spec.Template.Spec.Containers[0].Args[0], "suricata -D -i net1 -F /var/lib/suricata/generated-rules/bpf-filter") {
+		t.Fatalf("suricata does not use the bpf filter: %s", deployment.Spec.Template.Spec.Containers[0].Args[0])
+	}
+}
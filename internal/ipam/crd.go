@@ -0,0 +1,234 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+)
+
+// CRDAllocator keeps the leases in the status of the L2Network itself. Every change is written with the resource
+// version it was computed from and recomputed on conflict, so concurrent webhook calls never hand out the same
+// address twice.
+type CRDAllocator struct {
+	Client client.Client
+	// Reader is used to read the networks. Reading straight from the API server instead of the cache avoids
+	// retrying over and over on a stale copy while the cache catches up.
+	Reader client.Reader
+}
+
+// NewCRDAllocator returns an allocator backed by the L2Network status. The reader is optional, the client is
+// used when it is nil.
+func NewCRDAllocator(c client.Client, reader client.Reader) *CRDAllocator {
+	if reader == nil {
+		reader = c
+	}
+	return &CRDAllocator{Client: c, Reader: reader}
+}
+
+func (a *CRDAllocator) Allocate(ctx context.Context, key types.NamespacedName, owner string, requested []string) ([]string, error) {
+	var allocated []string
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		network := &l2smv1.L2Network{}
+		if err := a.Reader.Get(ctx, key, network); err != nil {
+			return err
+		}
+
+		var changed bool
+		var err error
+		allocated, changed, err = allocate(network, owner, requested)
+		if err != nil || !changed {
+			return err
+		}
+		return a.Client.Status().Update(ctx, network)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not allocate addresses in network %s: %w", key.Name, err)
+	}
+	return allocated, nil
+}
+
+func (a *CRDAllocator) Release(ctx context.Context, key types.NamespacedName, owner string) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		network := &l2smv1.L2Network{}
+		if err := a.Reader.Get(ctx, key, network); err != nil {
+			return err
+		}
+		if !release(network, owner) {
+			return nil
+		}
+		return a.Client.Status().Update(ctx, network)
+	})
+	if client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("could not release addresses in network %s: %w", key.Name, err)
+	}
+	return nil
+}
+
+func (a *CRDAllocator) Rebind(ctx context.Context, key types.NamespacedName, from, to string) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		network := &l2smv1.L2Network{}
+		if err := a.Reader.Get(ctx, key, network); err != nil {
+			return err
+		}
+		if !rebind(network, from, to) {
+			return nil
+		}
+		return a.Client.Status().Update(ctx, network)
+	})
+	if client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("could not rebind addresses in network %s: %w", key.Name, err)
+	}
+	return nil
+}
+
+// allocate computes the leases of the owner over the network status, reporting whether the status changed.
+func allocate(network *l2smv1.L2Network, owner string, requested []string) ([]string, bool, error) {
+	ranges, err := RangesForNetwork(network.Spec)
+	if err != nil {
+		return nil, false, err
+	}
+	if network.Status.AssignedIPs == nil {
+		network.Status.AssignedIPs = map[string]string{}
+	}
+
+	reservations, err := ReservationsForNetwork(network.Spec)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(requested) != 0 {
+		changed := false
+		allocated := make([]string, 0, len(requested))
+		for _, value := range requested {
+			// addresses reserved for pods are checked by the caller, which knows the pod they are requested for
+			addr, cidr, err := checkRequested(ranges, reservations, value)
+			if err != nil {
+				return nil, false, err
+			}
+			allocated = append(allocated, cidr)
+			if holder, taken := network.Status.AssignedIPs[addr.String()]; taken {
+				if holder != owner || owner == "" {
+					return nil, false, fmt.Errorf("%w: %s is held by %s", ErrConflict, addr, holder)
+				}
+				continue
+			}
+			network.Status.AssignedIPs[addr.String()] = owner
+			changed = true
+		}
+		return allocated, changed, nil
+	}
+
+	if len(ranges) == 0 {
+		return nil, false, nil
+	}
+
+	// the owner may be asking again, e.g. when the admission request is retried, unless it is unknown
+	if held := heldBy(network.Status.AssignedIPs, owner, ranges); owner != "" && len(held) != 0 {
+		return held, false, nil
+	}
+
+	last, _ := netip.ParseAddr(network.Status.LastAssignedIP)
+	allocated := make([]string, 0, len(ranges))
+	for i, r := range ranges {
+		// only the first range keeps track of the last address, the rest are searched from the beginning
+		if i != 0 {
+			last = netip.Addr{}
+		}
+		addr, err := NextFree(r, last, network.Status.AssignedIPs, reservations)
+		if err != nil {
+			return nil, false, err
+		}
+		network.Status.AssignedIPs[addr.String()] = owner
+		if i == 0 {
+			network.Status.LastAssignedIP = addr.String()
+		}
+		allocated = append(allocated, netip.PrefixFrom(addr, r.Subnet.Bits()).String())
+	}
+	return allocated, true, nil
+}
+
+// release drops every lease of the owner, reporting whether there was any.
+func release(network *l2smv1.L2Network, owner string) bool {
+	changed := false
+	for ip, holder := range network.Status.AssignedIPs {
+		if holder == owner {
+			delete(network.Status.AssignedIPs, ip)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// rebind gives the leases of an owner to another one, reporting whether there was any.
+func rebind(network *l2smv1.L2Network, from, to string) bool {
+	changed := false
+	for ip, holder := range network.Status.AssignedIPs {
+		if holder == from {
+			network.Status.AssignedIPs[ip] = to
+			changed = true
+		}
+	}
+	return changed
+}
+
+// heldBy returns the leases of the owner in CIDR notation, sorted so IPv4 comes first.
+func heldBy(assigned map[string]string, owner string, ranges []Range) []string {
+	var addrs []netip.Addr
+	for ip, holder := range assigned {
+		if holder != owner {
+			continue
+		}
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Less(addrs[j]) })
+
+	held := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		bits := addr.BitLen()
+		if r, ok := Contains(ranges, addr); ok {
+			bits = r.Subnet.Bits()
+		}
+		held = append(held, netip.PrefixFrom(addr, bits).String())
+	}
+	return held
+}
+
+// parseAddr accepts both plain addresses and addresses in CIDR notation.
+func parseAddr(value string) (netip.Addr, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("invalid address %q: %w", value, err)
+		}
+		return prefix.Addr(), nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid address %q: %w", value, err)
+	}
+	return addr, nil
+}
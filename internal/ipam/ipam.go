@@ -0,0 +1,366 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ipam hands out the addresses of L2Networks to the pods attached to them.
+package ipam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+)
+
+var (
+	// ErrExhausted is returned when a range has no free addresses left.
+	ErrExhausted = errors.New("no available addresses")
+	// ErrConflict is returned when a requested address is already held by another owner.
+	ErrConflict = errors.New("address already assigned")
+	// ErrOutOfRange is returned when a requested address is not inside the network CIDR, or is one that can't be
+	// assigned to a pod, like the network address.
+	ErrOutOfRange = errors.New("address out of range")
+)
+
+// Allocator reserves and frees the addresses of an L2Network. Owners are identified by the name of the pod that
+// holds the addresses, which is what the L2Network status records, or by a token of the pod while it has no name
+// yet. An empty owner is never the same owner twice.
+type Allocator interface {
+	// Allocate reserves one address per range of the network for the owner and returns them in CIDR notation, using
+	// the mask of the network. If requested is not empty, those addresses are reserved instead. Allocating again
+	// for an owner that already holds addresses returns them without reserving new ones. Networks without ranges
+	// are layer 2 only, so nothing is returned.
+	Allocate(ctx context.Context, network types.NamespacedName, owner string, requested []string) ([]string, error)
+
+	// Release frees every address held by the owner in the network.
+	Release(ctx context.Context, network types.NamespacedName, owner string) error
+
+	// Rebind hands the addresses held by an owner in the network over to another one.
+	Rebind(ctx context.Context, network types.NamespacedName, from, to string) error
+}
+
+// Range is a pool of addresses that can be assigned to pods, inside the subnet that gives them their mask.
+type Range struct {
+	Pool   netip.Prefix
+	Subnet netip.Prefix
+}
+
+// RangesForNetwork returns the address ranges of the network, one per address family. NetworkCIDR defines the
+// subnet and PodAddressRange, if set, the pool inside of it. Both accept a comma separated list for dual-stack
+// networks.
+func RangesForNetwork(spec l2smv1.L2NetworkSpec) ([]Range, error) {
+	subnets, err := parsePrefixes(spec.NetworkCIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network CIDR: %w", err)
+	}
+	pools, err := parsePrefixes(spec.PodAddressRange)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pod address range: %w", err)
+	}
+
+	ranges := make([]Range, 0, len(subnets))
+	for _, subnet := range subnets {
+		r := Range{Pool: subnet, Subnet: subnet}
+		for _, pool := range pools {
+			if pool.Addr().Is4() == subnet.Addr().Is4() {
+				if !subnet.Contains(pool.Addr()) || pool.Bits() < subnet.Bits() {
+					return nil, fmt.Errorf("pod address range %s is not inside the network CIDR %s", pool, subnet)
+				}
+				r.Pool = pool
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// Reservations holds the addresses of a network that can't be handed out, besides the ones already assigned. Addrs
+// and Prefixes can't be assigned at all, while Pinned can only be assigned to the pods they are reserved for.
+type Reservations struct {
+	Addrs    []netip.Addr
+	Prefixes []netip.Prefix
+	Pinned   []netip.Addr
+}
+
+// ReservationsForNetwork parses the exclusions and gateways of the IPAM spec, and the addresses reserved for pods.
+func ReservationsForNetwork(spec l2smv1.L2NetworkSpec) (Reservations, error) {
+	reservations := Reservations{}
+	for _, reservation := range spec.Reservations {
+		for _, ip := range reservation.IPs {
+			addr, err := parseAddr(ip)
+			if err != nil {
+				return reservations, fmt.Errorf("invalid reservation: %w", err)
+			}
+			reservations.Pinned = append(reservations.Pinned, addr)
+		}
+	}
+	if spec.IPAM == nil {
+		return reservations, nil
+	}
+	for _, exclusion := range spec.IPAM.Exclusions {
+		if strings.Contains(exclusion, "/") {
+			prefix, err := netip.ParsePrefix(exclusion)
+			if err != nil {
+				return reservations, fmt.Errorf("invalid exclusion %q: %w", exclusion, err)
+			}
+			reservations.Prefixes = append(reservations.Prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(exclusion)
+		if err != nil {
+			return reservations, fmt.Errorf("invalid exclusion %q: %w", exclusion, err)
+		}
+		reservations.Addrs = append(reservations.Addrs, addr)
+	}
+	for _, gateway := range spec.IPAM.Gateways {
+		addr, err := netip.ParseAddr(gateway)
+		if err != nil {
+			return reservations, fmt.Errorf("invalid gateway %q: %w", gateway, err)
+		}
+		reservations.Addrs = append(reservations.Addrs, addr)
+	}
+	return reservations, nil
+}
+
+// RequestedAddresses returns the addresses a pod gets in the network: the ones it requested or, if there are none, the
+// ones reserved for it, in CIDR notation. Requesting an address reserved for another pod is a conflict.
+func RequestedAddresses(spec l2smv1.L2NetworkSpec, podName string, podLabels map[string]string, requested []string) ([]string, error) {
+	ranges, err := RangesForNetwork(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(requested) == 0 {
+		for _, reservation := range spec.Reservations {
+			matches, err := reservedFor(reservation, podName, podLabels)
+			if err != nil {
+				return nil, err
+			}
+			if !matches {
+				continue
+			}
+			addresses := make([]string, 0, len(reservation.IPs))
+			for _, ip := range reservation.IPs {
+				address, err := withMask(ranges, ip)
+				if err != nil {
+					return nil, err
+				}
+				addresses = append(addresses, address)
+			}
+			return addresses, nil
+		}
+		return nil, nil
+	}
+
+	for _, value := range requested {
+		addr, err := parseAddr(value)
+		if err != nil {
+			return nil, err
+		}
+		for _, reservation := range spec.Reservations {
+			if !reserves(reservation, addr) {
+				continue
+			}
+			matches, err := reservedFor(reservation, podName, podLabels)
+			if err != nil {
+				return nil, err
+			}
+			if !matches {
+				return nil, fmt.Errorf("%w: %s is reserved for another pod", ErrConflict, addr)
+			}
+		}
+	}
+	return requested, nil
+}
+
+// Check verifies that the requested addresses can be reserved for the owner in the network as it is now, without
+// reserving them.
+func Check(network *l2smv1.L2Network, owner string, requested []string) error {
+	if len(requested) == 0 {
+		return nil
+	}
+	_, _, err := allocate(network.DeepCopy(), owner, requested)
+	return err
+}
+
+// reservedFor reports whether the reservation is meant for the pod.
+func reservedFor(reservation l2smv1.IPReservation, podName string, podLabels map[string]string) (bool, error) {
+	if reservation.PodName != "" {
+		return reservation.PodName == podName, nil
+	}
+	if reservation.PodSelector == nil {
+		return false, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(reservation.PodSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid reservation pod selector: %w", err)
+	}
+	return selector.Matches(labels.Set(podLabels)), nil
+}
+
+// reserves reports whether the address is one of the reservation.
+func reserves(reservation l2smv1.IPReservation, addr netip.Addr) bool {
+	for _, ip := range reservation.IPs {
+		if reserved, err := parseAddr(ip); err == nil && reserved == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRequested verifies that a requested address can be assigned to a pod, and returns it in CIDR notation with
+// the mask of the network if it had none. Networks without ranges are layer 2 only, so any address is accepted.
+func checkRequested(ranges []Range, reservations Reservations, value string) (netip.Addr, string, error) {
+	addr, err := parseAddr(value)
+	if err != nil {
+		return netip.Addr{}, "", err
+	}
+	if len(ranges) == 0 {
+		return addr, value, nil
+	}
+	r, ok := Contains(ranges, addr)
+	if !ok {
+		return netip.Addr{}, "", fmt.Errorf("%w: %s is not inside the network CIDR", ErrOutOfRange, addr)
+	}
+	if !usable(r, addr, nil, Reservations{}) {
+		return netip.Addr{}, "", fmt.Errorf("%w: %s can't be assigned to a pod in %s", ErrOutOfRange, addr, r.Subnet)
+	}
+	if slices.Contains(reservations.Addrs, addr) {
+		return netip.Addr{}, "", fmt.Errorf("%w: %s is reserved", ErrConflict, addr)
+	}
+	if _, excluded := reservations.excludedUntil(addr); excluded {
+		return netip.Addr{}, "", fmt.Errorf("%w: %s is excluded", ErrConflict, addr)
+	}
+	if !strings.Contains(value, "/") {
+		value = netip.PrefixFrom(addr, r.Subnet.Bits()).String()
+	}
+	return addr, value, nil
+}
+
+// withMask returns the address in CIDR notation, with the mask of the network if it had none.
+func withMask(ranges []Range, value string) (string, error) {
+	addr, err := parseAddr(value)
+	if err != nil || strings.Contains(value, "/") {
+		return value, err
+	}
+	if r, ok := Contains(ranges, addr); ok {
+		return netip.PrefixFrom(addr, r.Subnet.Bits()).String(), nil
+	}
+	return value, nil
+}
+
+// NextFree returns the first address of the range after last that is neither assigned nor reserved, wrapping
+// around to the beginning of the pool once it reaches its end. The network address, and the broadcast address
+// in IPv4, are never returned.
+func NextFree(r Range, last netip.Addr, assigned map[string]string, reservations Reservations) (netip.Addr, error) {
+	first, end := r.Pool.Masked().Addr(), lastAddr(r.Pool)
+
+	start := first
+	if last.IsValid() && r.Pool.Contains(last) && last != end {
+		start = last.Next()
+	}
+
+	addr, wrapped := start, false
+	for !wrapped || addr.Less(start) {
+		if skipTo, excluded := reservations.excludedUntil(addr); excluded {
+			// the whole excluded prefix is skipped at once, which matters for large IPv6 ranges
+			addr = skipTo
+		} else if usable(r, addr, assigned, reservations) {
+			return addr, nil
+		}
+
+		if addr == end || !r.Pool.Contains(addr) {
+			if wrapped {
+				break
+			}
+			addr, wrapped = first, true
+			continue
+		}
+		addr = addr.Next()
+	}
+	return netip.Addr{}, fmt.Errorf("%w in %s", ErrExhausted, r.Pool)
+}
+
+// Contains returns the range whose subnet holds the address, if any.
+func Contains(ranges []Range, addr netip.Addr) (Range, bool) {
+	for _, r := range ranges {
+		if r.Subnet.Contains(addr) {
+			return r, true
+		}
+	}
+	return Range{}, false
+}
+
+func usable(r Range, addr netip.Addr, assigned map[string]string, reservations Reservations) bool {
+	if addr == r.Subnet.Masked().Addr() {
+		return false
+	}
+	if addr.Is4() && r.Subnet.Bits() < 31 && addr == lastAddr(r.Subnet) {
+		return false
+	}
+	if _, taken := assigned[addr.String()]; taken {
+		return false
+	}
+	return !slices.Contains(reservations.Addrs, addr) && !slices.Contains(reservations.Pinned, addr)
+}
+
+// excludedUntil reports whether the address falls in an excluded prefix, and returns the last address of it.
+func (r Reservations) excludedUntil(addr netip.Addr) (netip.Addr, bool) {
+	for _, prefix := range r.Prefixes {
+		if prefix.Contains(addr) {
+			return lastAddr(prefix), true
+		}
+	}
+	return addr, false
+}
+
+// lastAddr returns the highest address of the prefix.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Masked().Addr().AsSlice()
+	hostBits := len(bytes)*8 - prefix.Bits()
+	for i := len(bytes) - 1; i >= 0 && hostBits > 0; i-- {
+		if hostBits >= 8 {
+			bytes[i] = 0xff
+			hostBits -= 8
+		} else {
+			bytes[i] |= byte(1<<hostBits) - 1
+			hostBits = 0
+		}
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+func parsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, cidr := range strings.Split(value, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
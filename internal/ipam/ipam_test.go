@@ -0,0 +1,290 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipam
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+)
+
+func testNetwork(spec l2smv1.L2NetworkSpec) *l2smv1.L2Network {
+	return &l2smv1.L2Network{Spec: spec}
+}
+
+func TestAllocateSkipsNetworkAndGateway(t *testing.T) {
+	network := testNetwork(l2smv1.L2NetworkSpec{
+		NetworkCIDR: "10.0.0.0/24",
+		IPAM:        &l2smv1.IPAMSpec{Gateways: []string{"10.0.0.1"}, Exclusions: []string{"10.0.0.2/31"}},
+	})
+
+	allocated, changed, err := allocate(network, "ping", nil)
+	if err != nil {
+		t.Fatalf("allocate returned error: %v", err)
+	}
+	if !changed {
+		t.Fatalf("expected the status to change")
+	}
+	if got, want := allocated, []string{"10.0.0.4/24"}; len(got) != 1 || got[0] != want[0] {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got := network.Status.AssignedIPs["10.0.0.4"]; got != "ping" {
+		t.Fatalf("expected 10.0.0.4 to be assigned to ping, got %q", got)
+	}
+}
+
+func TestAllocateRollsOverOctets(t *testing.T) {
+	network := testNetwork(l2smv1.L2NetworkSpec{NetworkCIDR: "10.0.0.0/16"})
+	network.Status.LastAssignedIP = "10.0.0.255"
+
+	allocated, _, err := allocate(network, "ping", nil)
+	if err != nil {
+		t.Fatalf("allocate returned error: %v", err)
+	}
+	if got, want := allocated[0], "10.0.1.0/16"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestAllocateWrapsAroundAndExhausts(t *testing.T) {
+	network := testNetwork(l2smv1.L2NetworkSpec{NetworkCIDR: "10.0.0.0/30"})
+	network.Status.LastAssignedIP = "10.0.0.2"
+
+	allocated, _, err := allocate(network, "ping", nil)
+	if err != nil {
+		t.Fatalf("allocate returned error: %v", err)
+	}
+	if got, want := allocated[0], "10.0.0.1/30"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	if _, _, err := allocate(network, "pong", nil); err != nil {
+		t.Fatalf("allocate returned error: %v", err)
+	}
+	if _, _, err := allocate(network, "pang", nil); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("expected ErrExhausted, got %v", err)
+	}
+}
+
+func TestAllocateIsIdempotentPerOwner(t *testing.T) {
+	network := testNetwork(l2smv1.L2NetworkSpec{NetworkCIDR: "10.0.0.0/24"})
+
+	first, _, err := allocate(network, "ping", nil)
+	if err != nil {
+		t.Fatalf("allocate returned error: %v", err)
+	}
+	second, changed, err := allocate(network, "ping", nil)
+	if err != nil {
+		t.Fatalf("allocate returned error: %v", err)
+	}
+	if changed || first[0] != second[0] {
+		t.Fatalf("expected the same lease %v, got %v (changed=%v)", first, second, changed)
+	}
+}
+
+func TestAllocateNeverSharesEmptyOwner(t *testing.T) {
+	network := testNetwork(l2smv1.L2NetworkSpec{NetworkCIDR: "10.0.0.0/24"})
+
+	first, _, err := allocate(network, "", nil)
+	if err != nil {
+		t.Fatalf("allocate returned error: %v", err)
+	}
+	second, _, err := allocate(network, "", nil)
+	if err != nil {
+		t.Fatalf("allocate returned error: %v", err)
+	}
+	if first[0] == second[0] {
+		t.Fatalf("expected two leases, got %v twice", first)
+	}
+	if _, _, err := allocate(network, "", []string{first[0]}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+}
+
+func TestAllocateDualStack(t *testing.T) {
+	network := testNetwork(l2smv1.L2NetworkSpec{
+		NetworkCIDR:     "10.0.0.0/24,fd00:1::/64",
+		PodAddressRange: "10.0.0.128/25",
+	})
+
+	allocated, _, err := allocate(network, "ping", nil)
+	if err != nil {
+		t.Fatalf("allocate returned error: %v", err)
+	}
+	if len(allocated) != 2 {
+		t.Fatalf("expected one address per family, got %v", allocated)
+	}
+	if got, want := allocated[0], "10.0.0.128/24"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	if got, want := allocated[1], "fd00:1::1/64"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestAllocateRequestedConflict(t *testing.T) {
+	network := testNetwork(l2smv1.L2NetworkSpec{NetworkCIDR: "10.0.0.0/24"})
+	network.Status.AssignedIPs = map[string]string{"10.0.0.7": "ping"}
+
+	if _, _, err := allocate(network, "pong", []string{"10.0.0.7/24"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if _, changed, err := allocate(network, "ping", []string{"10.0.0.7/24"}); err != nil || changed {
+		t.Fatalf("expected the owner to keep its address, got changed=%v err=%v", changed, err)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	network := testNetwork(l2smv1.L2NetworkSpec{NetworkCIDR: "10.0.0.0/24"})
+	network.Status.AssignedIPs = map[string]string{"10.0.0.7": "ping", "10.0.0.8": "pong"}
+
+	if !release(network, "ping") {
+		t.Fatalf("expected the lease of ping to be released")
+	}
+	if _, ok := network.Status.AssignedIPs["10.0.0.7"]; ok {
+		t.Fatalf("expected 10.0.0.7 to be free")
+	}
+	if release(network, "ping") {
+		t.Fatalf("expected nothing left to release")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	network := testNetwork(l2smv1.L2NetworkSpec{NetworkCIDR: "10.0.0.0/24"})
+	network.Status.AssignedIPs = map[string]string{"10.0.0.7": "ping-token", "10.0.0.8": "pong"}
+
+	if !rebind(network, "ping-token", "ping") {
+		t.Fatalf("expected the lease of the token to be rebound")
+	}
+	if got := network.Status.AssignedIPs["10.0.0.7"]; got != "ping" {
+		t.Fatalf("expected 10.0.0.7 to be held by ping, got %q", got)
+	}
+	if rebind(network, "ping-token", "ping") {
+		t.Fatalf("expected nothing left to rebind")
+	}
+}
+
+func TestNextFreeSkipsLargeExclusions(t *testing.T) {
+	r := Range{Pool: netip.MustParsePrefix("fd00::/48"), Subnet: netip.MustParsePrefix("fd00::/48")}
+	reservations := Reservations{Prefixes: []netip.Prefix{netip.MustParsePrefix("fd00::/64")}}
+
+	addr, err := NextFree(r, netip.Addr{}, nil, reservations)
+	if err != nil {
+		t.Fatalf("NextFree returned error: %v", err)
+	}
+	if got, want := addr.String(), "fd00:0:0:1::"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestAllocateRequestedOutOfRange(t *testing.T) {
+	network := testNetwork(l2smv1.L2NetworkSpec{
+		NetworkCIDR: "10.0.0.0/24",
+		IPAM:        &l2smv1.IPAMSpec{Gateways: []string{"10.0.0.1"}, Exclusions: []string{"10.0.0.64/26"}},
+	})
+
+	for _, requested := range []string{"10.0.1.7", "10.0.0.0/24", "10.0.0.255"} {
+		if _, _, err := allocate(network, "ping", []string{requested}); !errors.Is(err, ErrOutOfRange) {
+			t.Fatalf("expected ErrOutOfRange for %s, got %v", requested, err)
+		}
+	}
+	for _, requested := range []string{"10.0.0.1", "10.0.0.70/24"} {
+		if _, _, err := allocate(network, "ping", []string{requested}); !errors.Is(err, ErrConflict) {
+			t.Fatalf("expected ErrConflict for %s, got %v", requested, err)
+		}
+	}
+	if len(network.Status.AssignedIPs) != 0 {
+		t.Fatalf("expected nothing to be assigned, got %v", network.Status.AssignedIPs)
+	}
+
+	allocated, _, err := allocate(network, "ping", []string{"10.0.0.7"})
+	if err != nil {
+		t.Fatalf("allocate returned error: %v", err)
+	}
+	if got, want := allocated[0], "10.0.0.7/24"; got != want {
+		t.Fatalf("expected the mask of the network to be added, %q, got %q", want, got)
+	}
+}
+
+func TestAllocateSkipsPinnedAddresses(t *testing.T) {
+	network := testNetwork(l2smv1.L2NetworkSpec{
+		NetworkCIDR:  "10.0.0.0/24",
+		Reservations: []l2smv1.IPReservation{{PodName: "ping", IPs: []string{"10.0.0.1"}}},
+	})
+
+	allocated, _, err := allocate(network, "pong", nil)
+	if err != nil {
+		t.Fatalf("allocate returned error: %v", err)
+	}
+	if got, want := allocated[0], "10.0.0.2/24"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestRequestedAddresses(t *testing.T) {
+	spec := l2smv1.L2NetworkSpec{
+		NetworkCIDR: "10.0.0.0/24,fd00:1::/64",
+		Reservations: []l2smv1.IPReservation{
+			{PodName: "ping", IPs: []string{"10.0.0.10", "fd00:1::10"}},
+			{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "pong"}}, IPs: []string{"10.0.0.20/24"}},
+		},
+	}
+
+	addresses, err := RequestedAddresses(spec, "ping", nil, nil)
+	if err != nil {
+		t.Fatalf("RequestedAddresses returned error: %v", err)
+	}
+	if want := []string{"10.0.0.10/24", "fd00:1::10/64"}; !slices.Equal(addresses, want) {
+		t.Fatalf("expected the reservation of ping %v, got %v", want, addresses)
+	}
+
+	addresses, err = RequestedAddresses(spec, "pong-7d9f", map[string]string{"app": "pong"}, nil)
+	if err != nil {
+		t.Fatalf("RequestedAddresses returned error: %v", err)
+	}
+	if want := []string{"10.0.0.20/24"}; !slices.Equal(addresses, want) {
+		t.Fatalf("expected the reservation of the selector %v, got %v", want, addresses)
+	}
+
+	if addresses, err = RequestedAddresses(spec, "pang", nil, nil); err != nil || addresses != nil {
+		t.Fatalf("expected no addresses for a pod without reservation, got %v (err=%v)", addresses, err)
+	}
+	if _, err = RequestedAddresses(spec, "pang", nil, []string{"10.0.0.10/24"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict requesting the address of another pod, got %v", err)
+	}
+	if _, err = RequestedAddresses(spec, "ping", nil, []string{"10.0.0.10/24"}); err != nil {
+		t.Fatalf("expected ping to request its own address, got %v", err)
+	}
+}
+
+func TestCheckDoesNotReserve(t *testing.T) {
+	network := testNetwork(l2smv1.L2NetworkSpec{NetworkCIDR: "10.0.0.0/24"})
+	network.Status.AssignedIPs = map[string]string{"10.0.0.7": "ping"}
+
+	if err := Check(network, "pong", []string{"10.0.0.8"}); err != nil {
+		t.Fatalf("Check returned error: %v", err)
+	}
+	if _, ok := network.Status.AssignedIPs["10.0.0.8"]; ok {
+		t.Fatalf("expected Check to leave the status untouched")
+	}
+	if err := Check(network, "pong", []string{"10.0.0.7"}); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+}
@@ -119,7 +119,7 @@ func BuildMonitoringCollectorResources(
 	if overlay == nil {
 		return nil, nil, fmt.Errorf("overlay is nil")
 	}
-	if len(overlay.Spec.Topology.Nodes) == 0 {
+	if overlay.Spec.Topology == nil || len(overlay.Spec.Topology.Nodes) == 0 {
 		return nil, nil, fmt.Errorf("overlay topology has no nodes")
 	}
 
@@ -167,7 +167,7 @@ func BuildMonitoringCollectorResources(
 	}
 	nodes := overlay.Spec.Topology.Nodes
 
-	allocated, mask, err := utils.AllocateIPv4s(*opts.NetworkCIDR, *opts.IPStart, len(nodes))
+	allocated, mask, err := utils.AllocateIPs(*opts.NetworkCIDR, *opts.IPStart, len(nodes))
 	if err != nil {
 		return nil, nil, fmt.Errorf("monitoring CIDR allocation failed: %w", err)
 	}
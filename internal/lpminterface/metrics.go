@@ -0,0 +1,148 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpminterface
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	// The collector names its metrics net_<metric>_<unit>_<link hash>, e.g. net_rtt_ms_1a2b, and labels them with
+	// the nodes at both ends of the link.
+	collectorMetricPrefix = "net_"
+	sourceNodeLabel       = "source_node"
+	targetNodeLabel       = "target_node"
+
+	LinkUp       = "Up"
+	LinkDown     = "Down"
+	LinkDegraded = "Degraded"
+)
+
+// CollectorMetricsURL returns the endpoint where the collector reachable at host serves its measurements.
+func CollectorMetricsURL(host string) string {
+	return fmt.Sprintf("http://%s:%d/metrics", host, defaultCollectorPort)
+}
+
+// ScrapeCollector reads the latest measurements of a collector and summarizes them per link.
+func ScrapeCollector(ctx context.Context, httpClient *http.Client, url string) ([]l2smv1.LinkStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not scrape collector %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("collector %s responded with status %d", url, resp.StatusCode)
+	}
+
+	return ParseCollectorMetrics(resp.Body)
+}
+
+// ParseCollectorMetrics summarizes the measurements in the Prometheus text format served by a collector per link,
+// sorted by source and target node. A link is up when its rtt and throughput were measured, down when none of them
+// was and degraded otherwise. The collector reports 0 until a measurement succeeds, so jitter, which can be 0 in a
+// healthy link, doesn't count towards the status.
+func ParseCollectorMetrics(r io.Reader) ([]l2smv1.LinkStatus, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse collector metrics: %w", err)
+	}
+
+	type linkKey struct{ source, target string }
+	links := map[linkKey]*l2smv1.LinkStatus{}
+	measured := map[linkKey][2]int{}
+	for name, family := range families {
+		if !strings.HasPrefix(name, collectorMetricPrefix) {
+			continue
+		}
+		// net_rtt_ms_1a2b -> rtt
+		metricName, _, _ := strings.Cut(strings.TrimPrefix(name, collectorMetricPrefix), "_")
+
+		for _, metric := range family.GetMetric() {
+			var key linkKey
+			for _, label := range metric.GetLabel() {
+				switch label.GetName() {
+				case sourceNodeLabel:
+					key.source = label.GetValue()
+				case targetNodeLabel:
+					key.target = label.GetValue()
+				}
+			}
+			if key.source == "" || key.target == "" {
+				continue
+			}
+
+			var value float64
+			switch {
+			case metric.GetCounter() != nil:
+				value = metric.GetCounter().GetValue()
+			case metric.GetGauge() != nil:
+				value = metric.GetGauge().GetValue()
+			default:
+				value = metric.GetUntyped().GetValue()
+			}
+
+			link, ok := links[key]
+			if !ok {
+				link = &l2smv1.LinkStatus{SourceNode: key.source, TargetNode: key.target}
+				links[key] = link
+			}
+			link.Metrics = append(link.Metrics, l2smv1.MetricValue{Name: metricName, Value: strconv.FormatFloat(value, 'f', 2, 64)})
+
+			if metricName != "jitter" {
+				count := measured[key]
+				count[0]++
+				if value > 0 {
+					count[1]++
+				}
+				measured[key] = count
+			}
+		}
+	}
+
+	result := make([]l2smv1.LinkStatus, 0, len(links))
+	for key, link := range links {
+		slices.SortFunc(link.Metrics, func(a, b l2smv1.MetricValue) int { return strings.Compare(a.Name, b.Name) })
+		switch count := measured[key]; {
+		case count[1] == 0:
+			link.Status = LinkDown
+		case count[1] < count[0]:
+			link.Status = LinkDegraded
+		default:
+			link.Status = LinkUp
+		}
+		result = append(result, *link)
+	}
+	slices.SortFunc(result, func(a, b l2smv1.LinkStatus) int {
+		if c := strings.Compare(a.SourceNode, b.SourceNode); c != 0 {
+			return c
+		}
+		return strings.Compare(a.TargetNode, b.TargetNode)
+	})
+	return result, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lpminterface
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const collectorOutput = `# HELP net_rtt_ms_1a2b
+# TYPE net_rtt_ms_1a2b counter
+net_rtt_ms_1a2b{source_node="node-a",target_node="node-b"} 1.25
+# HELP net_jitter_ms_1a2b
+# TYPE net_jitter_ms_1a2b counter
+net_jitter_ms_1a2b{source_node="node-a",target_node="node-b"} 0
+# HELP net_throughput_kbps_1a2b
+# TYPE net_throughput_kbps_1a2b counter
+net_throughput_kbps_1a2b{source_node="node-a",target_node="node-b"} 940000
+# HELP net_rtt_ms_3c4d
+# TYPE net_rtt_ms_3c4d counter
+net_rtt_ms_3c4d{source_node="node-a",target_node="node-c"} 0
+# HELP net_throughput_kbps_3c4d
+# TYPE net_throughput_kbps_3c4d counter
+net_throughput_kbps_3c4d{source_node="node-a",target_node="node-c"} 0
+# HELP go_goroutines Number of goroutines that currently exist.
+# TYPE go_goroutines gauge
+go_goroutines 12
+`
+
+func TestParseCollectorMetrics(t *testing.T) {
+	links, err := ParseCollectorMetrics(strings.NewReader(collectorOutput))
+	if err != nil {
+		t.Fatalf("ParseCollectorMetrics returned error: %v", err)
+	}
+	if len(links) != 2 {
+		t.Fatalf("expected 2 links, got %+v", links)
+	}
+
+	up := links[0]
+	if up.SourceNode != "node-a" || up.TargetNode != "node-b" || up.Status != LinkUp {
+		t.Fatalf("unexpected link %+v", up)
+	}
+	want := []string{"jitter=0.00", "rtt=1.25", "throughput=940000.00"}
+	if len(up.Metrics) != len(want) {
+		t.Fatalf("expected metrics %v, got %+v", want, up.Metrics)
+	}
+	for i, metric := range up.Metrics {
+		if got := metric.Name + "=" + metric.Value; got != want[i] {
+			t.Fatalf("expected metric %s, got %s", want[i], got)
+		}
+	}
+
+	if down := links[1]; down.TargetNode != "node-c" || down.Status != LinkDown {
+		t.Fatalf("expected the link to node-c to be down, got %+v", down)
+	}
+}
+
+func TestParseCollectorMetricsDegraded(t *testing.T) {
+	output := `net_rtt_ms_1a2b{source_node="node-a",target_node="node-b"} 1.25
+net_throughput_kbps_1a2b{source_node="node-a",target_node="node-b"} 0
+`
+	links, err := ParseCollectorMetrics(strings.NewReader(output))
+	if err != nil {
+		t.Fatalf("ParseCollectorMetrics returned error: %v", err)
+	}
+	if len(links) != 1 || links[0].Status != LinkDegraded {
+		t.Fatalf("expected a degraded link, got %+v", links)
+	}
+}
+
+func TestScrapeCollector(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(collectorOutput))
+	}))
+	defer server.Close()
+
+	links, err := ScrapeCollector(context.Background(), server.Client(), server.URL+"/metrics")
+	if err != nil {
+		t.Fatalf("ScrapeCollector returned error: %v", err)
+	}
+	if len(links) != 2 {
+		t.Fatalf("expected 2 links, got %+v", links)
+	}
+
+	if _, err := ScrapeCollector(context.Background(), server.Client(), server.URL+"/missing"); err == nil {
+		t.Fatalf("expected an error when the collector doesn't serve metrics")
+	}
+}
@@ -15,47 +15,48 @@
 package monitoringnetwork
 
 import (
-	"fmt"
+	"context"
 
 	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
-	"github.com/Networks-it-uc3m/L2S-M/internal/env"
 	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient"
-	"github.com/Networks-it-uc3m/L2S-M/internal/utils"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type ClientFactory interface {
-	Internal() (sdnclient.Client, error)
-	ForProvider(provider *l2smv1.ProviderSpec) (sdnclient.Client, error)
+	Internal(ctx context.Context) (sdnclient.Client, error)
+	ForProvider(ctx context.Context, provider *l2smv1.ProviderSpec, namespace string) (sdnclient.Client, error)
 }
 
-type DefaultClientFactory struct{}
+// DefaultClientFactory builds the clients with the credentials read through Reader.
+type DefaultClientFactory struct {
+	Reader client.Reader
+}
 
-func (DefaultClientFactory) Internal() (sdnclient.Client, error) {
-	clientConfig := sdnclient.ClientConfig{
-		BaseURL:  fmt.Sprintf("http://%s:%s/onos", env.GetControllerIP(), env.GetControllerPort()),
-		Username: "karaf",
-		Password: "karaf",
+func (f DefaultClientFactory) Internal(ctx context.Context) (sdnclient.Client, error) {
+	clientConfig, err := sdnclient.InternalConfig(ctx, f.Reader)
+	if err != nil {
+		return nil, err
 	}
 
-	return sdnclient.NewClient(sdnclient.InternalType, clientConfig)
+	return sdnclient.NewClient(ctx, sdnclient.InternalType, clientConfig)
 }
 
-func (DefaultClientFactory) ForProvider(provider *l2smv1.ProviderSpec) (sdnclient.Client, error) {
-	if provider == nil {
-		return nil, fmt.Errorf("provider is nil")
-	}
-	if len(provider.Domain) == 0 || provider.Domain[0] == "" {
-		return nil, fmt.Errorf("provider %q has no domain configured", provider.Name)
+func (f DefaultClientFactory) ForProvider(ctx context.Context, provider *l2smv1.ProviderSpec, namespace string) (sdnclient.Client, error) {
+	clientConfig, err := sdnclient.ProviderConfig(ctx, f.Reader, provider, namespace)
+	if err != nil {
+		return nil, err
 	}
 
-	clientConfig := sdnclient.ClientConfig{
-		BaseURL:  fmt.Sprintf("http://%s:%s/onos", provider.Domain[0], utils.DefaultIfEmpty(provider.SDNPort, "30808")),
-		Username: "karaf",
-		Password: "karaf",
-	}
+	return sdnclient.NewClient(ctx, sdnclient.ExternalType, clientConfig)
+}
+
+// StaticClientFactory always returns Client as the client of the internal controller, and builds the clients of the
+// providers like DefaultClientFactory.
+type StaticClientFactory struct {
+	DefaultClientFactory
+	Client sdnclient.Client
+}
 
-	// Monitoring networks still rely on the vnets API plus port attachment.
-	// Keep provider selection isolated here so the concrete client type can be
-	// switched once the external provider exposes equivalent capabilities.
-	return sdnclient.NewClient(sdnclient.InternalType, clientConfig)
+func (f StaticClientFactory) Internal(ctx context.Context) (sdnclient.Client, error) {
+	return f.Client, nil
 }
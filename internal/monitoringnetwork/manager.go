@@ -15,7 +15,9 @@
 package monitoringnetwork
 
 import (
+	"context"
 	"fmt"
+	"slices"
 
 	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
 	"github.com/Networks-it-uc3m/L2S-M/internal/lpminterface"
@@ -29,13 +31,13 @@ type Manager struct {
 	Client sdnclient.Client
 }
 
-func (m Manager) Ensure(name, providerName string, nodes []string) error {
+func (m Manager) Ensure(ctx context.Context, name, providerName string, nodes []string) error {
 	if m.Client == nil {
 		return fmt.Errorf("monitoring network client is nil")
 	}
 
 	lpmNetName := utils.GenerateLPMNetworkName(name)
-	if err := m.Client.CreateNetwork(l2smv1.NetworkTypeVnet, sdnclient.VnetPayload{NetworkId: lpmNetName}); err != nil {
+	if err := m.Client.CreateNetwork(ctx, l2smv1.NetworkTypeVnet, sdnclient.VnetPayload{NetworkId: lpmNetName}); err != nil {
 		return fmt.Errorf("create monitoring network %q: %w", lpmNetName, err)
 	}
 
@@ -45,6 +47,7 @@ func (m Manager) Ensure(name, providerName string, nodes []string) error {
 	}
 
 	if err := m.Client.AttachPodToNetwork(
+		ctx,
 		l2smv1.NetworkTypeVnet,
 		sdnclient.VnetPayload{NetworkId: lpmNetName, Port: lpmPorts},
 	); err != nil {
@@ -54,13 +57,67 @@ func (m Manager) Ensure(name, providerName string, nodes []string) error {
 	return nil
 }
 
-func (m Manager) Delete(name string) error {
+// Sync brings the ports of the monitoring network in line with the given nodes, creating the network if it doesn't
+// exist yet. Ports of nodes that are no longer part of the network are detached.
+func (m Manager) Sync(ctx context.Context, name, providerName string, nodes []string) error {
 	if m.Client == nil {
 		return fmt.Errorf("monitoring network client is nil")
 	}
 
 	lpmNetName := utils.GenerateLPMNetworkName(name)
-	if err := m.Client.DeleteNetwork(l2smv1.NetworkTypeVnet, lpmNetName); err != nil {
+	networks, err := m.Client.ListNetworks(ctx, l2smv1.NetworkTypeVnet)
+	if err != nil {
+		return fmt.Errorf("list networks: %w", err)
+	}
+	idx := slices.IndexFunc(networks, func(network sdnclient.VnetPayload) bool { return network.NetworkId == lpmNetName })
+	if idx == -1 {
+		return m.Ensure(ctx, name, providerName, nodes)
+	}
+
+	current := networks[idx].Port
+	desired := lpminterface.GenerateLPMPorts(nodes, providerName)
+
+	var toAttach, toDetach []string
+	for _, port := range desired {
+		if !slices.Contains(current, port) {
+			toAttach = append(toAttach, port)
+		}
+	}
+	for _, port := range current {
+		if !slices.Contains(desired, port) {
+			toDetach = append(toDetach, port)
+		}
+	}
+
+	if len(toDetach) != 0 {
+		if err := m.Client.DetachPodFromNetwork(
+			ctx,
+			l2smv1.NetworkTypeVnet,
+			sdnclient.VnetPayload{NetworkId: lpmNetName, Port: toDetach},
+		); err != nil {
+			return fmt.Errorf("detach monitoring ports from network %q: %w", lpmNetName, err)
+		}
+	}
+	if len(toAttach) != 0 {
+		if err := m.Client.AttachPodToNetwork(
+			ctx,
+			l2smv1.NetworkTypeVnet,
+			sdnclient.VnetPayload{NetworkId: lpmNetName, Port: toAttach},
+		); err != nil {
+			return fmt.Errorf("attach monitoring ports to network %q: %w", lpmNetName, err)
+		}
+	}
+
+	return nil
+}
+
+func (m Manager) Delete(ctx context.Context, name string) error {
+	if m.Client == nil {
+		return fmt.Errorf("monitoring network client is nil")
+	}
+
+	lpmNetName := utils.GenerateLPMNetworkName(name)
+	if err := m.Client.DeleteNetwork(ctx, l2smv1.NetworkTypeVnet, lpmNetName); err != nil && !sdnclient.IsNotFound(err) {
 		return fmt.Errorf("delete monitoring network %q: %w", lpmNetName, err)
 	}
 
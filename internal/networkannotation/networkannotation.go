@@ -25,6 +25,9 @@ const (
 	MULTUS_ANNOTATION_KEY   = "k8s.v1.cni.cncf.io/networks"
 	NET_ATTACH_LABEL_PREFIX = "used-"
 	L2SM_NETWORK_ANNOTATION = "l2sm/networks"
+	// ATTACHED_NETWORKS_ANNOTATION holds the l2network every multus interface of the pod is attached to, in the same
+	// order as the multus annotation. Detached interfaces have an empty name.
+	ATTACHED_NETWORKS_ANNOTATION = "l2sm/attached-networks"
 )
 
 type NetworkAnnotation struct {
@@ -32,6 +35,8 @@ type NetworkAnnotation struct {
 	Namespace   string   `json:"namespace,omitempty"`
 	IPAddresses []string `json:"ips,omitempty"`
 	IfName      string   `json:"ifname,omitempty"`
+	// CNIArgs are passed to the CNI plugins of the interface, e.g. the MTU of the network for the tuning plugin.
+	CNIArgs map[string]any `json:"cni-args,omitempty"`
 }
 
 func MultusAnnotationToString(multusAnnotations []NetworkAnnotation) string {
@@ -42,6 +47,24 @@ func MultusAnnotationToString(multusAnnotations []NetworkAnnotation) string {
 	return string(jsonData)
 }
 
+// AttachedNetworksToString encodes the networks the pod interfaces are attached to.
+func AttachedNetworksToString(attached []string) string {
+	jsonData, err := json.Marshal(attached)
+	if err != nil {
+		return ""
+	}
+	return string(jsonData)
+}
+
+// ExtractAttachedNetworks decodes the attached networks annotation.
+func ExtractAttachedNetworks(annotation string) ([]string, error) {
+	var attached []string
+	if err := json.Unmarshal([]byte(annotation), &attached); err != nil {
+		return nil, fmt.Errorf("invalid attached networks annotation: %w", err)
+	}
+	return attached, nil
+}
+
 func ExtractNetworks(annotations, namespace string) ([]NetworkAnnotation, error) {
 
 	var networks []NetworkAnnotation
@@ -69,6 +92,28 @@ func ExtractNetworks(annotations, namespace string) ([]NetworkAnnotation, error)
 	return networks, nil
 }
 
+// RemoveNetwork returns the l2sm annotation without the network, keeping the format it was written in.
+func RemoveNetwork(annotation, name string) string {
+	var networks []NetworkAnnotation
+	if err := json.Unmarshal([]byte(annotation), &networks); err == nil {
+		kept := make([]NetworkAnnotation, 0, len(networks))
+		for _, network := range networks {
+			if network.Name != name {
+				kept = append(kept, network)
+			}
+		}
+		return MultusAnnotationToString(kept)
+	}
+
+	var kept []string
+	for _, network := range strings.Split(annotation, ",") {
+		if network = strings.TrimSpace(network); network != "" && network != name {
+			kept = append(kept, network)
+		}
+	}
+	return strings.Join(kept, ",")
+}
+
 func (network *NetworkAnnotation) GenerateIPv6Address() {
 
 	// Generating the interface ID (64 bits)
@@ -0,0 +1,267 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package schedulerextender serves the filter and bind verbs of a kube-scheduler extender, so that the default
+// scheduler only places l2sm pods in nodes with enough free interfaces and the interfaces are assigned once the node
+// is known. It is served over TLS, as the bind verb binds pods to nodes, and it only accepts pods with l2sm networks,
+// so it's meant for a scheduler that only schedules them.
+package schedulerextender
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
+)
+
+const (
+	FilterPath = "/filter"
+	BindPath   = "/bind"
+)
+
+// ExtenderArgs mirrors the arguments the scheduler sends to the filter verb (k8s.io/kube-scheduler/extender/v1).
+type ExtenderArgs struct {
+	Pod       *corev1.Pod      `json:"pod"`
+	Nodes     *corev1.NodeList `json:"nodes,omitempty"`
+	NodeNames *[]string        `json:"nodenames,omitempty"`
+}
+
+// ExtenderFilterResult mirrors the result of the filter verb.
+type ExtenderFilterResult struct {
+	Nodes                      *corev1.NodeList  `json:"nodes,omitempty"`
+	NodeNames                  *[]string         `json:"nodenames,omitempty"`
+	FailedNodes                map[string]string `json:"failedNodes,omitempty"`
+	FailedAndUnresolvableNodes map[string]string `json:"failedAndUnresolvableNodes,omitempty"`
+	Error                      string            `json:"error,omitempty"`
+}
+
+// ExtenderBindingArgs mirrors the arguments of the bind verb.
+type ExtenderBindingArgs struct {
+	PodName      string    `json:"podName"`
+	PodNamespace string    `json:"podNamespace"`
+	PodUID       types.UID `json:"podUID"`
+	Node         string    `json:"node"`
+}
+
+// ExtenderBindingResult mirrors the result of the bind verb.
+type ExtenderBindingResult struct {
+	Error string `json:"error,omitempty"`
+}
+
+// Scheduler is implemented by the pod annotator, which knows how to filter nodes and assign interfaces.
+type Scheduler interface {
+	FilterNodes(ctx context.Context, pod *corev1.Pod, nodes []corev1.Node, interfaces int) ([]string, map[string]string, error)
+	BindPod(ctx context.Context, key types.NamespacedName, uid types.UID, nodeName string) error
+}
+
+// Server is a manager runnable that serves the scheduler extender verbs.
+type Server struct {
+	BindAddress string
+
+	// CertDir is the directory with the tls.crt and tls.key the extender is served with.
+	CertDir string
+
+	// ClientCAFile is the CA bundle the client certificate of the scheduler must be signed by. Clients are not
+	// asked for a certificate if it's empty.
+	ClientCAFile string
+
+	// TLSOpts are applied to the TLS configuration of the server.
+	TLSOpts []func(*tls.Config)
+
+	Scheduler         Scheduler
+	SwitchesNamespace string
+}
+
+// NeedLeaderElection is false, as every replica can answer the scheduler.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	watcher, err := certwatcher.New(filepath.Join(s.CertDir, "tls.crt"), filepath.Join(s.CertDir, "tls.key"))
+	if err != nil {
+		return fmt.Errorf("could not load the scheduler extender certificate: %w", err)
+	}
+	go func() {
+		if err := watcher.Start(ctx); err != nil {
+			log.FromContext(ctx).Error(err, "scheduler extender certificate watcher stopped")
+		}
+	}()
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: watcher.GetCertificate}
+	if s.ClientCAFile != "" {
+		caCert, err := os.ReadFile(s.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("could not read the scheduler extender client CA: %w", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(caCert) {
+			return fmt.Errorf("no certificate found in the scheduler extender client CA %s", s.ClientCAFile)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	for _, opt := range s.TLSOpts {
+		opt(config)
+	}
+
+	listener, err := tls.Listen("tcp", s.BindAddress, config)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %w", s.BindAddress, err)
+	}
+	return s.serve(ctx, listener)
+}
+
+// serve answers the scheduler in the listener until the context is done.
+func (s *Server) serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{Handler: s.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	log.FromContext(ctx).Info("Starting scheduler extender", "address", listener.Addr().String())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("scheduler extender stopped: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(FilterPath, s.handleFilter)
+	mux.HandleFunc(BindPath, s.handleBind)
+	return mux
+}
+
+func (s *Server) handleFilter(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	args := ExtenderArgs{}
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil || args.Pod == nil {
+		writeJSON(w, ExtenderFilterResult{Error: fmt.Sprintf("invalid filter arguments: %v", err)})
+		return
+	}
+	writeJSON(w, s.filter(r.Context(), args))
+}
+
+func (s *Server) filter(ctx context.Context, args ExtenderArgs) ExtenderFilterResult {
+	var nodes []corev1.Node
+	if args.Nodes != nil {
+		for i := range args.Nodes.Items {
+			nodes = append(nodes, *args.Nodes.Items[i].DeepCopy())
+		}
+	} else if args.NodeNames != nil {
+		for _, name := range *args.NodeNames {
+			node := corev1.Node{}
+			node.Name = name
+			nodes = append(nodes, node)
+		}
+	}
+
+	annotation, ok := args.Pod.Annotations[networkannotation.L2SM_NETWORK_ANNOTATION]
+	if !ok {
+		return ExtenderFilterResult{Error: fmt.Sprintf("pod %s/%s has no l2sm networks", args.Pod.Namespace, args.Pod.Name)}
+	}
+	networks, err := networkannotation.ExtractNetworks(annotation, s.SwitchesNamespace)
+	if err != nil {
+		return ExtenderFilterResult{Error: err.Error()}
+	}
+
+	// the node constraints of the pod have already been checked by the scheduler, so only the interfaces are
+	// filtered here
+	pod := args.Pod.DeepCopy()
+	pod.Spec.NodeSelector, pod.Spec.Affinity, pod.Spec.Tolerations = nil, nil, nil
+	for i := range nodes {
+		nodes[i].Spec.Unschedulable, nodes[i].Spec.Taints = false, nil
+	}
+
+	fit, failed, err := s.Scheduler.FilterNodes(ctx, pod, nodes, len(networks))
+	if err != nil {
+		return ExtenderFilterResult{Error: err.Error()}
+	}
+	result := filterResult(args, nodes, fit)
+	result.FailedNodes = failed
+	return result
+}
+
+func (s *Server) handleBind(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	args := ExtenderBindingArgs{}
+	if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+		writeJSON(w, ExtenderBindingResult{Error: fmt.Sprintf("invalid bind arguments: %v", err)})
+		return
+	}
+	if args.PodName == "" || args.PodNamespace == "" || args.PodUID == "" || args.Node == "" {
+		writeJSON(w, ExtenderBindingResult{Error: "the pod name, namespace and uid and the node are required"})
+		return
+	}
+	key := types.NamespacedName{Name: args.PodName, Namespace: args.PodNamespace}
+	if err := s.Scheduler.BindPod(r.Context(), key, args.PodUID, args.Node); err != nil {
+		log.FromContext(r.Context()).Error(err, "could not bind pod", "pod", key.String(), "node", args.Node)
+		writeJSON(w, ExtenderBindingResult{Error: err.Error()})
+		return
+	}
+	writeJSON(w, ExtenderBindingResult{})
+}
+
+// filterResult answers in the same shape the scheduler asked in, full nodes or only their names.
+func filterResult(args ExtenderArgs, nodes []corev1.Node, fit []string) ExtenderFilterResult {
+	keep := map[string]bool{}
+	for _, name := range fit {
+		keep[name] = true
+	}
+
+	if args.Nodes != nil {
+		list := &corev1.NodeList{}
+		for _, node := range args.Nodes.Items {
+			if keep[node.Name] {
+				list.Items = append(list.Items, node)
+			}
+		}
+		return ExtenderFilterResult{Nodes: list}
+	}
+	names := []string{}
+	for _, node := range nodes {
+		if keep[node.Name] {
+			names = append(names, node.Name)
+		}
+	}
+	return ExtenderFilterResult{NodeNames: &names}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,238 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedulerextender
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/Networks-it-uc3m/L2S-M/internal/networkannotation"
+)
+
+// fakeScheduler fits the nodes with free interfaces and records the pods it binds.
+type fakeScheduler struct {
+	free  map[string]int
+	bound map[types.NamespacedName]string
+}
+
+func (f *fakeScheduler) FilterNodes(_ context.Context, _ *corev1.Pod, nodes []corev1.Node, interfaces int) ([]string, map[string]string, error) {
+	var fit []string
+	failed := map[string]string{}
+	for _, node := range nodes {
+		if f.free[node.Name] >= interfaces {
+			fit = append(fit, node.Name)
+		} else {
+			failed[node.Name] = "not enough free interfaces"
+		}
+	}
+	return fit, failed, nil
+}
+
+func (f *fakeScheduler) BindPod(_ context.Context, key types.NamespacedName, _ types.UID, nodeName string) error {
+	f.bound[key] = nodeName
+	return nil
+}
+
+func newTestServer() (*Server, *fakeScheduler) {
+	scheduler := &fakeScheduler{free: map[string]int{"node-a": 1, "node-b": 3}, bound: map[types.NamespacedName]string{}}
+	return &Server{Scheduler: scheduler, SwitchesNamespace: "default"}, scheduler
+}
+
+func post(t *testing.T, handler http.Handler, path string, body, result any) {
+	t.Helper()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("could not marshal request: %v", err)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload)))
+	if err := json.Unmarshal(recorder.Body.Bytes(), result); err != nil {
+		t.Fatalf("could not decode response %q: %v", recorder.Body.String(), err)
+	}
+}
+
+func TestFilterKeepsNodesWithFreeInterfaces(t *testing.T) {
+	server, _ := newTestServer()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "ping", Namespace: "default",
+		Annotations: map[string]string{networkannotation.L2SM_NETWORK_ANNOTATION: "ping-network,monitoring"}}}
+
+	names := []string{"node-a", "node-b"}
+	result := ExtenderFilterResult{}
+	post(t, server.handler(), FilterPath, ExtenderArgs{Pod: pod, NodeNames: &names}, &result)
+	if result.Error != "" || result.NodeNames == nil || len(*result.NodeNames) != 1 || (*result.NodeNames)[0] != "node-b" {
+		t.Fatalf("expected only node-b to fit, got %+v", result)
+	}
+	if _, ok := result.FailedNodes["node-a"]; !ok {
+		t.Fatalf("expected node-a to be reported as failed, got %v", result.FailedNodes)
+	}
+
+	nodes := &corev1.NodeList{Items: []corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}, {ObjectMeta: metav1.ObjectMeta{Name: "node-b"}}}}
+	result = ExtenderFilterResult{}
+	post(t, server.handler(), FilterPath, ExtenderArgs{Pod: pod, Nodes: nodes}, &result)
+	if result.Nodes == nil || len(result.Nodes.Items) != 1 || result.Nodes.Items[0].Name != "node-b" {
+		t.Fatalf("expected the node list to only keep node-b, got %+v", result)
+	}
+}
+
+func TestFilterRejectsPodsWithoutNetworks(t *testing.T) {
+	server, _ := newTestServer()
+	names := []string{"node-a"}
+	result := ExtenderFilterResult{}
+	post(t, server.handler(), FilterPath, ExtenderArgs{Pod: &corev1.Pod{}, NodeNames: &names}, &result)
+	if result.Error == "" {
+		t.Fatalf("expected an error for a pod without l2sm networks, got %+v", result)
+	}
+}
+
+func TestBind(t *testing.T) {
+	server, scheduler := newTestServer()
+	result := ExtenderBindingResult{}
+	post(t, server.handler(), BindPath, ExtenderBindingArgs{PodName: "ping", PodNamespace: "default", PodUID: "ping-uid", Node: "node-b"}, &result)
+	if result.Error != "" {
+		t.Fatalf("bind returned error: %s", result.Error)
+	}
+	if node := scheduler.bound[types.NamespacedName{Name: "ping", Namespace: "default"}]; node != "node-b" {
+		t.Fatalf("expected the pod to be bound to node-b, got %q", node)
+	}
+
+	result = ExtenderBindingResult{}
+	post(t, server.handler(), BindPath, ExtenderBindingArgs{PodName: "pong", Node: "node-b"}, &result)
+	if result.Error == "" || len(scheduler.bound) != 1 {
+		t.Fatalf("expected incomplete arguments to be rejected, got %+v", result)
+	}
+
+	recorder := httptest.NewRecorder()
+	server.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, BindPath, nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected GET to be rejected, got %d", recorder.Code)
+	}
+}
+
+func TestServeRequiresClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := newCertificate(t, nil, nil, "l2sm-ca")
+	writePEM(t, filepath.Join(dir, "ca.crt"), "CERTIFICATE", ca.Raw)
+	serving, servingKey := newCertificate(t, ca, caKey, "localhost")
+	writePEM(t, filepath.Join(dir, "tls.crt"), "CERTIFICATE", serving.Raw)
+	writeKey(t, filepath.Join(dir, "tls.key"), servingKey)
+	client, clientKey := newCertificate(t, ca, caKey, "kube-scheduler")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not find a free port: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	server, _ := newTestServer()
+	server.BindAddress, server.CertDir, server.ClientCAFile = address, dir, filepath.Join(dir, "ca.crt")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = server.Start(ctx) }()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	bind := func(certificates []tls.Certificate) error {
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certificates, ServerName: "localhost"}}}
+		payload, _ := json.Marshal(ExtenderBindingArgs{PodName: "ping", PodNamespace: "default", PodUID: "ping-uid", Node: "node-b"})
+		resp, err := httpClient.Post("https://"+address+BindPath, "application/json", bytes.NewReader(payload))
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	var bindErr error
+	clientCertificate := tls.Certificate{Certificate: [][]byte{client.Raw}, PrivateKey: clientKey}
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(50 * time.Millisecond) {
+		if bindErr = bind([]tls.Certificate{clientCertificate}); bindErr == nil {
+			break
+		}
+	}
+	if bindErr != nil {
+		t.Fatalf("bind with a client certificate failed: %v", bindErr)
+	}
+	if err := bind(nil); err == nil {
+		t.Fatalf("expected bind without a client certificate to fail")
+	}
+}
+
+// newCertificate creates a certificate signed by the parent, or a self-signed CA if there's no parent.
+func newCertificate(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("could not create certificate: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatalf("could not parse certificate: %v", err)
+	}
+	return certificate, key
+}
+
+func writePEM(t *testing.T, path, blockType string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data}), 0o600); err != nil {
+		t.Fatalf("could not write %s: %v", path, err)
+	}
+}
+
+func writeKey(t *testing.T, path string, key *ecdsa.PrivateKey) {
+	t.Helper()
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("could not marshal key: %v", err)
+	}
+	writePEM(t, path, "EC PRIVATE KEY", der)
+}
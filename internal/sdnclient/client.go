@@ -15,9 +15,14 @@
 package sdnclient
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"net/http"
+	"time"
 
 	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 type ClientType string
@@ -27,39 +32,60 @@ const (
 	ExternalType ClientType = "external"
 )
 
-// NetworkStrategy defines the interface for network strategies
+// Client manages the networks of an SDN controller. Requests that fail transiently are retried as set by the Backoff of
+// the ClientConfig, up to four attempts with DefaultBackoff, or until the context is done. Failures are returned as errors
+// that can be checked with IsNotFound, IsConflict, IsUnavailable and IsUnauthorized. Creating a network that already
+// exists succeeds.
 type Client interface {
-	CreateNetwork(networkType l2smv1.NetworkType, config interface{}) error
-	DeleteNetwork(networkType l2smv1.NetworkType, networkID string) error
-	CheckNetworkExists(networkType l2smv1.NetworkType, networkID string) (bool, error)
-	AttachPodToNetwork(networkType l2smv1.NetworkType, config interface{}) error
-	DetachPodFromNetwork(networkType l2smv1.NetworkType, config interface{}) error
-	SetUpMirrorPort(networkType l2smv1.NetworkType, config any) error
+	CreateNetwork(ctx context.Context, networkType l2smv1.NetworkType, config interface{}) error
+	DeleteNetwork(ctx context.Context, networkType l2smv1.NetworkType, networkID string) error
+	CheckNetworkExists(ctx context.Context, networkType l2smv1.NetworkType, networkID string) (bool, error)
+	AttachPodToNetwork(ctx context.Context, networkType l2smv1.NetworkType, config interface{}) error
+	DetachPodFromNetwork(ctx context.Context, networkType l2smv1.NetworkType, config interface{}) error
+	SetUpMirrorPort(ctx context.Context, networkType l2smv1.NetworkType, config any) error
+	RemoveMirrorPort(ctx context.Context, networkType l2smv1.NetworkType, config any) error
+	ListNetworks(ctx context.Context, networkType l2smv1.NetworkType) ([]VnetPayload, error)
 }
 
 type ClientConfig struct {
 	BaseURL  string
 	Username string
 	Password string
+	// Token is sent as a bearer token instead of the username and password when set.
+	Token string
+	// CACert is the PEM bundle used to verify the certificate of the controller. The system roots are used if empty.
+	CACert []byte
+	// Timeout bounds every attempt of a request. It's 10 seconds if not set.
+	Timeout time.Duration
+	// Backoff sets how the requests that fail transiently are retried. DefaultBackoff is used if not set.
+	Backoff *wait.Backoff
+	// Transport sends the requests instead of the default transport when set, e.g. to serve them with an in-process fake
+	// controller. CACert is ignored in that case.
+	Transport http.RoundTripper
 }
 
-func NewClient(clientType ClientType, config ClientConfig) (Client, error) {
-	sessionClient := NewSessionClient(config.BaseURL, config.Username, config.Password)
+// NewClient creates a client of the given type and checks that the controller accepts its credentials.
+func NewClient(ctx context.Context, clientType ClientType, config ClientConfig) (Client, error) {
+	sessionClient, err := NewSessionClient(config)
+	if err != nil {
+		return nil, err
+	}
 
+	var client interface {
+		Client
+		ping(ctx context.Context) error
+	}
 	switch clientType {
 	case InternalType:
-		client := &InternalClient{Session: sessionClient}
-		if !client.beginSessionController() {
-			return nil, errors.New("could not initialize session with SDN controller. Please check the connection details and credentials.")
-		}
-		return client, nil
+		client = &InternalClient{Session: sessionClient}
 	case ExternalType:
-		client := &ExternalClient{Session: sessionClient}
-		if !client.beginSessionController() {
-			return nil, errors.New("could not initialize session with SDN controller. Please check the connection details and credentials.")
-		}
-		return client, nil
+		client = &ExternalClient{Session: sessionClient}
 	default:
 		return nil, errors.New("unsupported client type")
 	}
+
+	if err := client.ping(ctx); err != nil {
+		return nil, fmt.Errorf("could not initialize session with SDN controller, please check the connection details and credentials: %w", err)
+	}
+	return client, nil
 }
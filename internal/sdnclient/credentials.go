@@ -0,0 +1,95 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sdnclient
+
+import (
+	"context"
+	"fmt"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/env"
+	"github.com/Networks-it-uc3m/L2S-M/internal/utils"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Keys of the Secrets that hold the credentials of an SDN controller.
+const (
+	SecretUsernameKey = "username"
+	SecretPasswordKey = "password"
+	SecretTokenKey    = "token"
+	SecretCACertKey   = "ca.crt"
+)
+
+// CredentialsLabel must be set to "true" in the Secrets that providers reference. The manager can read the Secrets
+// of every namespace, so this keeps it from sending the ones that were not meant for an SDN controller.
+const CredentialsLabel = "l2sm/sdn-credentials"
+
+// InternalConfig returns the configuration of the client of the l2sm-controller, with the credentials of the Secret
+// named by CONTROLLER_CREDENTIALS_SECRET in the namespace of the manager.
+func InternalConfig(ctx context.Context, reader client.Reader) (ClientConfig, error) {
+	config := ClientConfig{
+		BaseURL: fmt.Sprintf("%s://%s:%s/onos", env.GetControllerScheme(), env.GetControllerIP(), env.GetControllerPort()),
+	}
+	name := env.GetControllerCredentialsSecret()
+	if name == "" {
+		return config, nil
+	}
+	_, err := loadCredentials(ctx, reader, client.ObjectKey{Namespace: env.GetPodNamespace(), Name: name}, &config)
+	return config, err
+}
+
+// ProviderConfig returns the configuration of the client of the provider SDN controller, with the credentials of the
+// Secret the provider references. namespace is the one of the resource the provider belongs to, and the only one the
+// Secret is read from, so that a resource can't send the credentials of another namespace to a controller of its choice.
+func ProviderConfig(ctx context.Context, reader client.Reader, provider *l2smv1.ProviderSpec, namespace string) (ClientConfig, error) {
+	if provider == nil {
+		return ClientConfig{}, fmt.Errorf("provider is nil")
+	}
+	if len(provider.Domain) == 0 || provider.Domain[0] == "" {
+		return ClientConfig{}, fmt.Errorf("provider %q has no domain configured", provider.Name)
+	}
+
+	config := ClientConfig{
+		BaseURL: fmt.Sprintf("%s://%s:%s/onos", utils.DefaultIfEmpty(provider.SDNScheme, "http"), provider.Domain[0], utils.DefaultIfEmpty(provider.SDNPort, "30808")),
+	}
+	if provider.CredentialsSecretRef == nil {
+		return config, nil
+	}
+	key := client.ObjectKey{Namespace: namespace, Name: provider.CredentialsSecretRef.Name}
+	secret, err := loadCredentials(ctx, reader, key, &config)
+	if err == nil && secret.Labels[CredentialsLabel] != "true" {
+		return ClientConfig{}, fmt.Errorf("secret %s is not labeled %s=true", key, CredentialsLabel)
+	}
+	return config, err
+}
+
+// loadCredentials fills the config with the bearer token or the username and password of the Secret, and its CA
+// bundle if it has one.
+func loadCredentials(ctx context.Context, reader client.Reader, key client.ObjectKey, config *ClientConfig) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := reader.Get(ctx, key, secret); err != nil {
+		return nil, fmt.Errorf("could not get the SDN controller credentials from secret %s: %w", key, err)
+	}
+
+	config.Token = string(secret.Data[SecretTokenKey])
+	config.Username = string(secret.Data[SecretUsernameKey])
+	config.Password = string(secret.Data[SecretPasswordKey])
+	config.CACert = secret.Data[SecretCACertKey]
+	if config.Token == "" && config.Username == "" {
+		return secret, fmt.Errorf("secret %s has neither a %q nor a %q key", key, SecretTokenKey, SecretUsernameKey)
+	}
+	return secret, nil
+}
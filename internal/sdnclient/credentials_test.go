@@ -0,0 +1,109 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sdnclient
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestProviderConfigReadsSecret(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "idco-credentials", Namespace: "default", Labels: map[string]string{CredentialsLabel: "true"}},
+		Data:       map[string][]byte{SecretUsernameKey: []byte("admin"), SecretPasswordKey: []byte("s3cret")},
+	}
+	unlabeled := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "kube-credentials", Namespace: "default"},
+		Data:       map[string][]byte{SecretTokenKey: []byte("abc")},
+	}
+	reader := fake.NewClientBuilder().WithObjects(secret, unlabeled).Build()
+	provider := &l2smv1.ProviderSpec{
+		Name:                 "idco",
+		Domain:               []string{"10.0.0.1"},
+		SDNScheme:            "https",
+		CredentialsSecretRef: &corev1.LocalObjectReference{Name: "idco-credentials"},
+	}
+
+	config, err := ProviderConfig(context.Background(), reader, provider, "default")
+	if err != nil {
+		t.Fatalf("ProviderConfig returned error: %v", err)
+	}
+	if config.BaseURL != "https://10.0.0.1:30808/onos" {
+		t.Fatalf("unexpected base URL %q", config.BaseURL)
+	}
+	if config.Username != "admin" || config.Password != "s3cret" {
+		t.Fatalf("unexpected credentials %q:%q", config.Username, config.Password)
+	}
+
+	if _, err := ProviderConfig(context.Background(), reader, provider, "other"); err == nil {
+		t.Fatalf("expected an error when the secret is not in the namespace of the resource")
+	}
+
+	unlabeledProvider := *provider
+	unlabeledProvider.CredentialsSecretRef = &corev1.LocalObjectReference{Name: "kube-credentials"}
+	if _, err := ProviderConfig(context.Background(), reader, &unlabeledProvider, "default"); err == nil {
+		t.Fatalf("expected an error when the secret is not labeled for the SDN controllers")
+	}
+
+	provider.CredentialsSecretRef = nil
+	config, err = ProviderConfig(context.Background(), reader, provider, "default")
+	if err != nil || config.Username != "" || config.Token != "" {
+		t.Fatalf("expected no credentials without a secret, got %+v, %v", config, err)
+	}
+}
+
+func TestSessionClientTokenOverTLS(t *testing.T) {
+	var authorization string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	session, err := NewSessionClient(ClientConfig{BaseURL: server.URL, Token: "abc", Username: "ignored", CACert: caCert})
+	if err != nil {
+		t.Fatalf("NewSessionClient returned error: %v", err)
+	}
+	resp, err := session.Do(context.Background(), http.MethodGet, "/vnets/api/status", nil)
+	if err != nil {
+		t.Fatalf("request with the custom CA failed: %v", err)
+	}
+	resp.Body.Close()
+	if authorization != "Bearer abc" {
+		t.Fatalf("expected a bearer token, got %q", authorization)
+	}
+
+	untrusted, err := NewSessionClient(ClientConfig{BaseURL: server.URL, Backoff: &wait.Backoff{Duration: time.Millisecond, Steps: 1}})
+	if err != nil {
+		t.Fatalf("NewSessionClient returned error: %v", err)
+	}
+	if _, err := untrusted.Do(context.Background(), http.MethodGet, "/vnets/api/status", nil); err == nil {
+		t.Fatalf("expected the certificate to be rejected without the CA")
+	}
+
+	if _, err := NewSessionClient(ClientConfig{BaseURL: server.URL, CACert: []byte("not a certificate")}); err == nil {
+		t.Fatalf("expected an error with an invalid CA bundle")
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sdnclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Classes of the errors returned by the clients, to be checked with errors.Is.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrUnavailable  = errors.New("sdn controller unavailable")
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// StatusError is returned when the SDN controller answers a request with an unexpected status code. It matches the
+// class of error its status code belongs to.
+type StatusError struct {
+	// Op describes the operation that failed, e.g. "create network".
+	Op         string
+	StatusCode int
+	// Message is the body of the response, if any.
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("failed to %s, sdn controller responded with status code %d", e.Op, e.StatusCode)
+	}
+	return fmt.Sprintf("failed to %s, sdn controller responded with status code %d: %s", e.Op, e.StatusCode, e.Message)
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		// the onos apps report networks that already exist as internal errors
+		return e.StatusCode == http.StatusConflict || strings.Contains(strings.ToLower(e.Message), "already exists")
+	case ErrUnavailable:
+		return isTransientStatus(e.StatusCode)
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// isTransientStatus reports whether a request that got the status code may succeed if retried.
+func isTransientStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// IsNotFound reports whether the network or port the request refers to doesn't exist in the SDN controller.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// IsConflict reports whether the request conflicts with the state of the SDN controller, e.g. the network already
+// exists.
+func IsConflict(err error) bool {
+	return errors.Is(err, ErrConflict)
+}
+
+// IsUnavailable reports whether the SDN controller couldn't be reached or was temporarily unable to serve the request.
+func IsUnavailable(err error) bool {
+	return errors.Is(err, ErrUnavailable)
+}
+
+// IsUnauthorized reports whether the SDN controller rejected the credentials.
+func IsUnauthorized(err error) bool {
+	return errors.Is(err, ErrUnauthorized)
+}
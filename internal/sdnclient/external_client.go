@@ -15,7 +15,7 @@
 package sdnclient
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/http"
 
@@ -28,90 +28,109 @@ type ExternalClient struct {
 	Session *SessionClient
 }
 
-// DetachPodFromNetwork implements Client.
-func (c *ExternalClient) DetachPodFromNetwork(networkType l2smv1.NetworkType, config interface{}) error {
-	panic("unimplemented")
+// MscsPayload describes a multi-site connectivity service, the inter-domain network the IDCO controller stretches
+// across the network edge devices of every cluster. Endpoints are the ports of the NEDs attached to it. This is the
+// body the IDCO API takes and returns on /idco/mscs; it's kept apart from VnetPayload, the body of the internal
+// controller, so that either API can change without breaking the other.
+type MscsPayload struct {
+	NetworkId  string   `json:"networkId"`
+	Endpoints  []string `json:"networkEndpoints,omitempty"`
+	MirrorPort string   `json:"mirrorPort,omitempty"`
 }
 
-func (c *ExternalClient) beginSessionController() bool {
-	//TODO: implement healthcheck in idco onos app
-	resp, err := c.Session.Get("/idco/mscs/status")
-	if err != nil {
-		return false
+// mscsPayload converts the configuration given to the client into the payload of the IDCO API. The configuration may
+// be a MscsPayload or, for callers that handle both kinds of controllers alike, a VnetPayload.
+func mscsPayload(config any) (MscsPayload, error) {
+	switch payload := config.(type) {
+	case MscsPayload:
+		return payload, nil
+	case *MscsPayload:
+		return *payload, nil
+	case VnetPayload:
+		return MscsPayload{NetworkId: payload.NetworkId, Endpoints: payload.Port, MirrorPort: payload.MirrorPort}, nil
+	case *VnetPayload:
+		return MscsPayload{NetworkId: payload.NetworkId, Endpoints: payload.Port, MirrorPort: payload.MirrorPort}, nil
 	}
-	defer resp.Body.Close()
-
-	// Check if the status code indicates success (HTTP 200 OK).
-	return resp.StatusCode == http.StatusOK
+	return MscsPayload{}, fmt.Errorf("unsupported payload type %T for the idco controller", config)
 }
 
-// CreateNetwork creates a new network in the SDN controller
-func (c *ExternalClient) CreateNetwork(networkType l2smv1.NetworkType, config interface{}) error {
+func (c *ExternalClient) ping(ctx context.Context) error {
+	return c.Session.call(ctx, "check the controller status", http.MethodGet, "/idco/mscs/status", nil, nil, http.StatusOK)
+}
 
-	jsonData, err := json.Marshal(config)
+// CreateNetwork creates a new network in the SDN controller. It succeeds if the network already exists.
+func (c *ExternalClient) CreateNetwork(ctx context.Context, networkType l2smv1.NetworkType, config interface{}) error {
+	payload, err := mscsPayload(config)
 	if err != nil {
 		return err
 	}
-	response, err := c.Session.Post("/idco/mscs", jsonData)
-	if err != nil {
-		return err
+	err = c.Session.call(ctx, "create network", http.MethodPost, "/idco/mscs", payload, nil, http.StatusNoContent)
+	if IsConflict(err) {
+		return nil
 	}
-	defer response.Body.Close()
+	return err
+}
 
-	if response.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("failed to create network, status code: %d", response.StatusCode)
+// CheckNetworkExists checks if the specified network exists in the SDN controller
+func (c *ExternalClient) CheckNetworkExists(ctx context.Context, networkType l2smv1.NetworkType, networkID string) (bool, error) {
+	err := c.Session.call(ctx, "get network", http.MethodGet, fmt.Sprintf("/idco/mscs/%s", networkID), nil, nil, http.StatusOK)
+	if IsNotFound(err) {
+		return false, nil
 	}
+	return err == nil, err
+}
 
-	return nil
+// DeleteNetwork deletes an existing network from the SDN controller
+func (c *ExternalClient) DeleteNetwork(ctx context.Context, networkType l2smv1.NetworkType, networkID string) error {
+	return c.Session.call(ctx, "delete network", http.MethodDelete, fmt.Sprintf("/idco/mscs/%s", networkID), nil, nil, http.StatusNoContent)
 }
 
-// CheckNetworkExists checks if the specified network exists in the SDN controller
-func (c *ExternalClient) CheckNetworkExists(networkType l2smv1.NetworkType, networkID string) (bool, error) {
-	response, err := c.Session.Get(fmt.Sprintf("/idco/mscs/%s", networkID))
+// AttachPodToNetwork attaches the endpoints of the payload to its network
+func (c *ExternalClient) AttachPodToNetwork(ctx context.Context, networkType l2smv1.NetworkType, config interface{}) error {
+	payload, err := mscsPayload(config)
 	if err != nil {
-		return false, err
+		return err
 	}
-	defer response.Body.Close()
-
-	return response.StatusCode == http.StatusOK, nil
+	return c.Session.call(ctx, "attach pod", http.MethodPost, "/idco/mscs/port", payload, nil, http.StatusNoContent)
 }
 
-// DeleteNetwork deletes an existing network from the SDN controller
-func (c *ExternalClient) DeleteNetwork(networkType l2smv1.NetworkType, networkID string) error {
-	response, err := c.Session.Delete(fmt.Sprintf("/idco/mscs/%s", networkID), []byte{})
+// DetachPodFromNetwork detaches the endpoints of the payload from its network
+func (c *ExternalClient) DetachPodFromNetwork(ctx context.Context, networkType l2smv1.NetworkType, config interface{}) error {
+	payload, err := mscsPayload(config)
 	if err != nil {
 		return err
 	}
+	return c.Session.call(ctx, "detach pod from network", http.MethodDelete, "/idco/mscs/port", payload, nil, http.StatusNoContent, http.StatusOK)
+}
 
-	defer response.Body.Close()
-
-	if response.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("SDN controller responded with status code: %d", response.StatusCode)
+// SetUpMirrorPort mirrors the traffic of the network to the mirror port of the payload
+func (c *ExternalClient) SetUpMirrorPort(ctx context.Context, networkType l2smv1.NetworkType, config any) error {
+	payload, err := mscsPayload(config)
+	if err != nil {
+		return err
 	}
-
-	return nil
+	return c.Session.call(ctx, "set up mirror port", http.MethodPost, "/idco/mscs/mirror-port", payload, nil, http.StatusNoContent)
 }
 
-// AttachPodToNetwork attaches a pod to a network, using the configuration file
-func (c *ExternalClient) AttachPodToNetwork(networkType l2smv1.NetworkType, config interface{}) error {
-
-	// jsonData, err := json.Marshal(config)
-	// if err != nil {
-	// 	return err
-	// }
-	// response, err := c.Session.Post("/idco/mscs", jsonData)
-	// if err != nil {
-	// 	return err
-	// }
-	// defer response.Body.Close()
-
-	// if response.StatusCode != http.StatusNoContent {
-	// 	return fmt.Errorf("failed to create network, status code: %d", response.StatusCode)
-	// }
-
-	return nil
+// RemoveMirrorPort stops mirroring the traffic of the network to the mirror port of the payload
+func (c *ExternalClient) RemoveMirrorPort(ctx context.Context, networkType l2smv1.NetworkType, config any) error {
+	payload, err := mscsPayload(config)
+	if err != nil {
+		return err
+	}
+	return c.Session.call(ctx, "remove mirror port", http.MethodDelete, "/idco/mscs/mirror-port", payload, nil, http.StatusNoContent, http.StatusOK)
 }
 
-func (c *ExternalClient) SetUpMirrorPort(networkType l2smv1.NetworkType, config any) error {
-	return fmt.Errorf("unimplemented")
+// ListNetworks returns the inter-domain networks held by the IDCO controller, alongside the endpoints attached to them.
+func (c *ExternalClient) ListNetworks(ctx context.Context, networkType l2smv1.NetworkType) ([]VnetPayload, error) {
+	var networks []MscsPayload
+	if err := c.Session.call(ctx, "list networks", http.MethodGet, "/idco/mscs", nil, &networks, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	vnets := make([]VnetPayload, 0, len(networks))
+	for _, network := range networks {
+		vnets = append(vnets, VnetPayload{NetworkId: network.NetworkId, Port: network.Endpoints, MirrorPort: network.MirrorPort})
+	}
+	return vnets, nil
 }
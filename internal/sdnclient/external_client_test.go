@@ -0,0 +1,185 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sdnclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient/idcotest"
+)
+
+func newTestExternalClient(t *testing.T, config ClientConfig) (Client, *idcotest.Server) {
+	t.Helper()
+	server := idcotest.NewServer()
+	t.Cleanup(server.Close)
+
+	config.BaseURL = server.URL
+	config.Backoff = testBackoff
+	c, err := NewClient(context.Background(), ExternalType, config)
+	if err != nil {
+		t.Fatalf("NewClient returned error: %v", err)
+	}
+	return c, server
+}
+
+func TestExternalClientNetworkLifecycle(t *testing.T) {
+	ctx := context.Background()
+	c, server := newTestExternalClient(t, ClientConfig{})
+	netType := l2smv1.NetworkTypeVnet
+
+	if err := c.CreateNetwork(ctx, netType, VnetPayload{NetworkId: "ping-network"}); err != nil {
+		t.Fatalf("CreateNetwork returned error: %v", err)
+	}
+	if err := c.CreateNetwork(ctx, netType, MscsPayload{NetworkId: "ping-network"}); err != nil {
+		t.Fatalf("expected creating an existing network to succeed, got %v", err)
+	}
+	if exists, err := c.CheckNetworkExists(ctx, netType, "ping-network"); err != nil || !exists {
+		t.Fatalf("expected the network to exist, got %v, %v", exists, err)
+	}
+
+	ports := []string{"of:0000000000000001/3", "of:0000000000000002/3"}
+	if err := c.AttachPodToNetwork(ctx, netType, VnetPayload{NetworkId: "ping-network", Port: ports}); err != nil {
+		t.Fatalf("AttachPodToNetwork returned error: %v", err)
+	}
+	if err := c.DetachPodFromNetwork(ctx, netType, VnetPayload{NetworkId: "ping-network", Port: ports[:1]}); err != nil {
+		t.Fatalf("DetachPodFromNetwork returned error: %v", err)
+	}
+	if err := c.SetUpMirrorPort(ctx, netType, VnetPayload{NetworkId: "ping-network", MirrorPort: "of:0000000000000002/4"}); err != nil {
+		t.Fatalf("SetUpMirrorPort returned error: %v", err)
+	}
+
+	network, _ := server.Network("ping-network")
+	if !slices.Equal(network.Endpoints, ports[1:]) || network.MirrorPort != "of:0000000000000002/4" {
+		t.Fatalf("unexpected network in the controller: %+v", network)
+	}
+	if err := c.RemoveMirrorPort(ctx, netType, VnetPayload{NetworkId: "ping-network", MirrorPort: "of:0000000000000002/4"}); err != nil {
+		t.Fatalf("RemoveMirrorPort returned error: %v", err)
+	}
+	if network, _ := server.Network("ping-network"); network.MirrorPort != "" {
+		t.Fatalf("mirror port was not removed: %+v", network)
+	}
+
+	networks, err := c.ListNetworks(ctx, netType)
+	if err != nil || len(networks) != 1 || networks[0].NetworkId != "ping-network" || !slices.Equal(networks[0].Port, ports[1:]) {
+		t.Fatalf("unexpected networks listed: %+v, %v", networks, err)
+	}
+
+	if err := c.DeleteNetwork(ctx, netType, "ping-network"); err != nil {
+		t.Fatalf("DeleteNetwork returned error: %v", err)
+	}
+	if err := c.DeleteNetwork(ctx, netType, "ping-network"); !IsNotFound(err) {
+		t.Fatalf("expected deleting a missing network to be not found, got %v", err)
+	}
+	if exists, err := c.CheckNetworkExists(ctx, netType, "ping-network"); err != nil || exists {
+		t.Fatalf("expected the network to be gone, got %v, %v", exists, err)
+	}
+}
+
+func TestExternalClientErrors(t *testing.T) {
+	ctx := context.Background()
+	c, server := newTestExternalClient(t, ClientConfig{})
+
+	if err := c.AttachPodToNetwork(ctx, l2smv1.NetworkTypeVnet, VnetPayload{NetworkId: "missing", Port: []string{"of:1/1"}}); !IsNotFound(err) {
+		t.Fatalf("expected attaching to a missing network to be not found, got %v", err)
+	}
+	if err := c.AttachPodToNetwork(ctx, l2smv1.NetworkTypeVnet, "not a payload"); err == nil {
+		t.Fatalf("expected an error with an unsupported payload")
+	}
+
+	server.FailNext(http.StatusServiceUnavailable)
+	if err := c.CreateNetwork(ctx, l2smv1.NetworkTypeVnet, VnetPayload{NetworkId: "retried"}); err != nil {
+		t.Fatalf("expected the request to succeed after retrying, got %v", err)
+	}
+	if _, ok := server.Network("retried"); !ok {
+		t.Fatalf("expected the network to be created")
+	}
+}
+
+func TestExternalClientCredentials(t *testing.T) {
+	server := idcotest.NewServer()
+	defer server.Close()
+	server.Authorization = "Bearer abc"
+
+	if _, err := NewClient(context.Background(), ExternalType, ClientConfig{BaseURL: server.URL, Backoff: testBackoff}); !IsUnauthorized(err) {
+		t.Fatalf("expected the client to be rejected without credentials, got %v", err)
+	}
+	if _, err := NewClient(context.Background(), ExternalType, ClientConfig{BaseURL: server.URL, Token: "abc", Backoff: testBackoff}); err != nil {
+		t.Fatalf("NewClient returned error: %v", err)
+	}
+}
+
+func TestExternalClientWireFormat(t *testing.T) {
+	ctx := context.Background()
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/idco/mscs/status":
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodGet && r.URL.Path == "/idco/mscs":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `[{"networkId":"ping-network","networkEndpoints":["of:1/3"],"mirrorPort":"of:1/4"}]`)
+		default:
+			body, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, r.Method+" "+r.URL.Path+" "+strings.TrimSpace(string(body)))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewClient(ctx, ExternalType, ClientConfig{BaseURL: server.URL, Backoff: testBackoff})
+	if err != nil {
+		t.Fatalf("NewClient returned error: %v", err)
+	}
+	netType := l2smv1.NetworkTypeVnet
+
+	if err := c.CreateNetwork(ctx, netType, MscsPayload{NetworkId: "ping-network"}); err != nil {
+		t.Fatalf("CreateNetwork returned error: %v", err)
+	}
+	if err := c.AttachPodToNetwork(ctx, netType, &MscsPayload{NetworkId: "ping-network", Endpoints: []string{"of:1/3"}}); err != nil {
+		t.Fatalf("AttachPodToNetwork returned error: %v", err)
+	}
+	// the payload of the internal controller is mapped to the one of the IDCO API
+	if err := c.DetachPodFromNetwork(ctx, netType, VnetPayload{NetworkId: "ping-network", Port: []string{"of:1/3"}}); err != nil {
+		t.Fatalf("DetachPodFromNetwork returned error: %v", err)
+	}
+	if err := c.SetUpMirrorPort(ctx, netType, &VnetPayload{NetworkId: "ping-network", MirrorPort: "of:1/4"}); err != nil {
+		t.Fatalf("SetUpMirrorPort returned error: %v", err)
+	}
+
+	want := []string{
+		`POST /idco/mscs {"networkId":"ping-network"}`,
+		`POST /idco/mscs/port {"networkId":"ping-network","networkEndpoints":["of:1/3"]}`,
+		`DELETE /idco/mscs/port {"networkId":"ping-network","networkEndpoints":["of:1/3"]}`,
+		`POST /idco/mscs/mirror-port {"networkId":"ping-network","mirrorPort":"of:1/4"}`,
+	}
+	if !slices.Equal(bodies, want) {
+		t.Fatalf("unexpected requests sent to the controller:\n%s\nwant:\n%s", strings.Join(bodies, "\n"), strings.Join(want, "\n"))
+	}
+
+	networks, err := c.ListNetworks(ctx, netType)
+	if err != nil || len(networks) != 1 {
+		t.Fatalf("unexpected networks listed: %+v, %v", networks, err)
+	}
+	if network := networks[0]; network.NetworkId != "ping-network" || !slices.Equal(network.Port, []string{"of:1/3"}) || network.MirrorPort != "of:1/4" {
+		t.Fatalf("unexpected network listed: %+v", network)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fakecontroller provides a stateful, in-process fake of the REST API of the l2sm-controller onos app. It's
+// used by the unit tests of the reconcilers, and by the manager to run without an SDN controller.
+package fakecontroller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient"
+)
+
+// baseURL is the address of the controller in the configuration of the in-process clients. Their requests never leave
+// the process, so the host doesn't need to resolve.
+const baseURL = "http://l2sm-controller.fake/onos"
+
+// Network is a network held by the fake controller.
+type Network struct {
+	NetworkId  string   `json:"networkId"`
+	Endpoints  []string `json:"networkEndpoints,omitempty"`
+	MirrorPort string   `json:"mirrorPort,omitempty"`
+	// Path is the ordered list of switches of a vlink.
+	Path []string `json:"path,omitempty"`
+}
+
+// Fault makes the controller answer the requests that match it with an error status instead of serving them.
+type Fault struct {
+	// Method and Path select the requests the fault applies to, e.g. "POST" and "/vnets/api/port". Path matches by
+	// prefix. Empty values match every request.
+	Method string
+	Path   string
+	// StatusCode is the status of the response. 503 is used if not set.
+	StatusCode int
+	// Times is how many requests fail before the fault is cleared. The fault is kept until ClearFaults if zero.
+	Times int
+}
+
+// Controller is the fake l2sm-controller. It serves the vnets and vlinks APIs, under the /onos prefix or without it.
+type Controller struct {
+	mu       sync.Mutex
+	networks map[string]map[string]*Network
+	faults   []*Fault
+	mux      *http.ServeMux
+}
+
+// New returns a fake controller with no networks.
+func New() *Controller {
+	c := &Controller{networks: map[string]map[string]*Network{"vnets": {}, "vlinks": {}}}
+
+	c.mux = http.NewServeMux()
+	c.mux.HandleFunc("GET /{kind}/api/status", c.withKind(func(w http.ResponseWriter, r *http.Request, networks map[string]*Network) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	c.mux.HandleFunc("GET /{kind}/api", c.withKind(c.listNetworks))
+	c.mux.HandleFunc("POST /{kind}/api", c.withKind(c.createNetwork))
+	c.mux.HandleFunc("GET /{kind}/api/{id}", c.withKind(c.getNetwork))
+	c.mux.HandleFunc("DELETE /{kind}/api/{id}", c.withKind(c.deleteNetwork))
+	c.mux.HandleFunc("POST /{kind}/api/port", c.withKind(c.updateNetwork(func(network *Network, payload Network) {
+		for _, endpoint := range payload.Endpoints {
+			if !slices.Contains(network.Endpoints, endpoint) {
+				network.Endpoints = append(network.Endpoints, endpoint)
+			}
+		}
+	})))
+	c.mux.HandleFunc("DELETE /{kind}/api/port", c.withKind(c.updateNetwork(func(network *Network, payload Network) {
+		network.Endpoints = slices.DeleteFunc(network.Endpoints, func(endpoint string) bool {
+			return slices.Contains(payload.Endpoints, endpoint)
+		})
+	})))
+	c.mux.HandleFunc("POST /{kind}/api/mirror-port", c.withKind(c.updateNetwork(func(network *Network, payload Network) {
+		network.MirrorPort = payload.MirrorPort
+	})))
+	c.mux.HandleFunc("DELETE /{kind}/api/mirror-port", c.withKind(c.updateNetwork(func(network *Network, payload Network) {
+		if network.MirrorPort == payload.MirrorPort {
+			network.MirrorPort = ""
+		}
+	})))
+	return c
+}
+
+// ClientConfig returns the configuration of a client whose requests are served by the controller in-process.
+func (c *Controller) ClientConfig() sdnclient.ClientConfig {
+	return sdnclient.ClientConfig{BaseURL: baseURL, Transport: roundTripper{handler: c}}
+}
+
+// InjectFault makes the controller fail the requests that match the fault.
+func (c *Controller) InjectFault(fault Fault) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fault.StatusCode == 0 {
+		fault.StatusCode = http.StatusServiceUnavailable
+	}
+	c.faults = append(c.faults, &fault)
+}
+
+// ClearFaults removes every fault injected in the controller.
+func (c *Controller) ClearFaults() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.faults = nil
+}
+
+// AddNetwork creates a network in the controller, e.g. to simulate one left behind by a deleted l2network.
+func (c *Controller) AddNetwork(networkType l2smv1.NetworkType, network Network) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.networks[apiKind(networkType)][network.NetworkId] = cloneNetwork(&network)
+}
+
+// Network returns a copy of the network of the given type, if it exists.
+func (c *Controller) Network(networkType l2smv1.NetworkType, networkID string) (Network, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	network, ok := c.networks[apiKind(networkType)][networkID]
+	if !ok {
+		return Network{}, false
+	}
+	return *cloneNetwork(network), true
+}
+
+// Networks returns a copy of every network of the given type, sorted by ID.
+func (c *Controller) Networks(networkType l2smv1.NetworkType) []Network {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return sortedNetworks(c.networks[apiKind(networkType)])
+}
+
+func (c *Controller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/onos")
+	if status, faulty := c.fault(r.Method, path); faulty {
+		http.Error(w, "injected fault", status)
+		return
+	}
+
+	r = r.Clone(r.Context())
+	r.URL.Path = path
+	c.mux.ServeHTTP(w, r)
+}
+
+// fault returns the status of the first fault that matches the request, if any, and counts the request against it.
+func (c *Controller) fault(method, path string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, fault := range c.faults {
+		if (fault.Method != "" && fault.Method != method) || !strings.HasPrefix(path, fault.Path) {
+			continue
+		}
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				c.faults = slices.Delete(c.faults, i, i+1)
+			}
+		}
+		return fault.StatusCode, true
+	}
+	return 0, false
+}
+
+// withKind serves the request with the networks of the API it was sent to, vnets or vlinks.
+func (c *Controller) withKind(handle func(w http.ResponseWriter, r *http.Request, networks map[string]*Network)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		networks, ok := c.networks[r.PathValue("kind")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		handle(w, r, networks)
+	}
+}
+
+func (c *Controller) listNetworks(w http.ResponseWriter, r *http.Request, networks map[string]*Network) {
+	writeJSON(w, sortedNetworks(networks))
+}
+
+func (c *Controller) createNetwork(w http.ResponseWriter, r *http.Request, networks map[string]*Network) {
+	payload, ok := decode(w, r)
+	if !ok {
+		return
+	}
+	// like the onos app, a network that already exists is reported as an internal error
+	if _, exists := networks[payload.NetworkId]; exists {
+		http.Error(w, fmt.Sprintf("Network %s already exists", payload.NetworkId), http.StatusInternalServerError)
+		return
+	}
+	networks[payload.NetworkId] = cloneNetwork(&payload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Controller) getNetwork(w http.ResponseWriter, r *http.Request, networks map[string]*Network) {
+	network, ok := networks[r.PathValue("id")]
+	if !ok {
+		http.Error(w, "network not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, network)
+}
+
+func (c *Controller) deleteNetwork(w http.ResponseWriter, r *http.Request, networks map[string]*Network) {
+	if _, ok := networks[r.PathValue("id")]; !ok {
+		http.Error(w, "network not found", http.StatusNotFound)
+		return
+	}
+	delete(networks, r.PathValue("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// updateNetwork applies the change to the network the payload of the request refers to.
+func (c *Controller) updateNetwork(update func(network *Network, payload Network)) func(http.ResponseWriter, *http.Request, map[string]*Network) {
+	return func(w http.ResponseWriter, r *http.Request, networks map[string]*Network) {
+		payload, ok := decode(w, r)
+		if !ok {
+			return
+		}
+		network, exists := networks[payload.NetworkId]
+		if !exists {
+			http.Error(w, "network not found", http.StatusNotFound)
+			return
+		}
+		update(network, payload)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// roundTripper serves the requests of the in-process clients with the handler of the controller.
+type roundTripper struct {
+	handler http.Handler
+}
+
+func (t roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := r.Context().Err(); err != nil {
+		return nil, err
+	}
+	recorder := httptest.NewRecorder()
+	t.handler.ServeHTTP(recorder, r)
+	return recorder.Result(), nil
+}
+
+func apiKind(networkType l2smv1.NetworkType) string {
+	if networkType == l2smv1.NetworkTypeVlink {
+		return "vlinks"
+	}
+	return "vnets"
+}
+
+func cloneNetwork(network *Network) *Network {
+	return &Network{
+		NetworkId:  network.NetworkId,
+		Endpoints:  slices.Clone(network.Endpoints),
+		MirrorPort: network.MirrorPort,
+		Path:       slices.Clone(network.Path),
+	}
+}
+
+func sortedNetworks(networks map[string]*Network) []Network {
+	list := make([]Network, 0, len(networks))
+	for _, network := range networks {
+		list = append(list, *cloneNetwork(network))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].NetworkId < list[j].NetworkId })
+	return list
+}
+
+func decode(w http.ResponseWriter, r *http.Request) (Network, bool) {
+	var payload Network
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.NetworkId == "" {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return Network{}, false
+	}
+	return payload, true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
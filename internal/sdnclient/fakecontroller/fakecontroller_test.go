@@ -0,0 +1,147 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fakecontroller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"github.com/Networks-it-uc3m/L2S-M/internal/sdnclient"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func newTestClient(t *testing.T, controller *Controller) sdnclient.Client {
+	t.Helper()
+	config := controller.ClientConfig()
+	config.Backoff = &wait.Backoff{Duration: time.Millisecond, Steps: 2}
+	c, err := sdnclient.NewClient(context.Background(), sdnclient.InternalType, config)
+	if err != nil {
+		t.Fatalf("NewClient returned error: %v", err)
+	}
+	return c
+}
+
+func TestNetworkLifecycle(t *testing.T) {
+	ctx := context.Background()
+	controller := New()
+	c := newTestClient(t, controller)
+	vnet := l2smv1.NetworkTypeVnet
+
+	if err := c.CreateNetwork(ctx, vnet, sdnclient.VnetPayload{NetworkId: "ping-network"}); err != nil {
+		t.Fatalf("CreateNetwork returned error: %v", err)
+	}
+	if err := c.CreateNetwork(ctx, vnet, sdnclient.VnetPayload{NetworkId: "ping-network"}); err != nil {
+		t.Fatalf("expected creating an existing network to succeed, got %v", err)
+	}
+
+	ports := []string{"of:0000000000000001/3", "of:0000000000000002/3"}
+	if err := c.AttachPodToNetwork(ctx, vnet, sdnclient.VnetPayload{NetworkId: "ping-network", Port: ports}); err != nil {
+		t.Fatalf("AttachPodToNetwork returned error: %v", err)
+	}
+	if err := c.DetachPodFromNetwork(ctx, vnet, sdnclient.VnetPayload{NetworkId: "ping-network", Port: ports[:1]}); err != nil {
+		t.Fatalf("DetachPodFromNetwork returned error: %v", err)
+	}
+	if err := c.SetUpMirrorPort(ctx, vnet, sdnclient.VnetPayload{NetworkId: "ping-network", MirrorPort: "of:0000000000000002/4"}); err != nil {
+		t.Fatalf("SetUpMirrorPort returned error: %v", err)
+	}
+
+	network, ok := controller.Network(vnet, "ping-network")
+	if !ok || !slices.Equal(network.Endpoints, ports[1:]) || network.MirrorPort != "of:0000000000000002/4" {
+		t.Fatalf("unexpected network in the controller: %+v", network)
+	}
+	if err := c.RemoveMirrorPort(ctx, vnet, sdnclient.VnetPayload{NetworkId: "ping-network", MirrorPort: "of:0000000000000002/4"}); err != nil {
+		t.Fatalf("RemoveMirrorPort returned error: %v", err)
+	}
+	if network, _ := controller.Network(vnet, "ping-network"); network.MirrorPort != "" {
+		t.Fatalf("mirror port was not removed: %+v", network)
+	}
+	networks, err := c.ListNetworks(ctx, vnet)
+	if err != nil || len(networks) != 1 || !slices.Equal(networks[0].Port, ports[1:]) {
+		t.Fatalf("unexpected networks listed: %+v, %v", networks, err)
+	}
+
+	if err := c.DeleteNetwork(ctx, vnet, "ping-network"); err != nil {
+		t.Fatalf("DeleteNetwork returned error: %v", err)
+	}
+	if err := c.DeleteNetwork(ctx, vnet, "ping-network"); !sdnclient.IsNotFound(err) {
+		t.Fatalf("expected deleting a missing network to be not found, got %v", err)
+	}
+}
+
+func TestVlinksAreKeptApart(t *testing.T) {
+	ctx := context.Background()
+	controller := New()
+	c := newTestClient(t, controller)
+
+	path := []string{"of:0000000000000001", "of:0000000000000002"}
+	if err := c.CreateNetwork(ctx, l2smv1.NetworkTypeVlink, sdnclient.VlinkPayload{NetworkId: "link", Path: path}); err != nil {
+		t.Fatalf("CreateNetwork returned error: %v", err)
+	}
+	if exists, _ := c.CheckNetworkExists(ctx, l2smv1.NetworkTypeVnet, "link"); exists {
+		t.Fatalf("expected the vlink not to be listed as a vnet")
+	}
+	if network, ok := controller.Network(l2smv1.NetworkTypeVlink, "link"); !ok || !slices.Equal(network.Path, path) {
+		t.Fatalf("unexpected vlink in the controller: %+v", network)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	ctx := context.Background()
+	controller := New()
+	c := newTestClient(t, controller)
+	vnet := l2smv1.NetworkTypeVnet
+
+	// a single transient failure is retried by the client
+	controller.InjectFault(Fault{Method: http.MethodPost, Path: "/vnets/api", Times: 1})
+	if err := c.CreateNetwork(ctx, vnet, sdnclient.VnetPayload{NetworkId: "ping-network"}); err != nil {
+		t.Fatalf("expected the request to succeed after retrying, got %v", err)
+	}
+
+	controller.InjectFault(Fault{Path: "/vnets/api/port", StatusCode: http.StatusInternalServerError})
+	for i := 0; i < 2; i++ {
+		err := c.AttachPodToNetwork(ctx, vnet, sdnclient.VnetPayload{NetworkId: "ping-network", Port: []string{"of:1/1"}})
+		if err == nil {
+			t.Fatalf("expected the persistent fault to fail request %d", i)
+		}
+	}
+	if exists, err := c.CheckNetworkExists(ctx, vnet, "ping-network"); err != nil || !exists {
+		t.Fatalf("expected requests that don't match the fault to be served, got %v, %v", exists, err)
+	}
+
+	controller.ClearFaults()
+	if err := c.AttachPodToNetwork(ctx, vnet, sdnclient.VnetPayload{NetworkId: "ping-network", Port: []string{"of:1/1"}}); err != nil {
+		t.Fatalf("AttachPodToNetwork returned error after clearing the faults: %v", err)
+	}
+}
+
+func TestServedOverHTTP(t *testing.T) {
+	controller := New()
+	controller.AddNetwork(l2smv1.NetworkTypeVnet, Network{NetworkId: "ping-network"})
+	server := httptest.NewServer(controller)
+	defer server.Close()
+
+	c, err := sdnclient.NewClient(context.Background(), sdnclient.InternalType, sdnclient.ClientConfig{BaseURL: server.URL + "/onos"})
+	if err != nil {
+		t.Fatalf("NewClient returned error: %v", err)
+	}
+	if exists, err := c.CheckNetworkExists(context.Background(), l2smv1.NetworkTypeVnet, "ping-network"); err != nil || !exists {
+		t.Fatalf("expected the network to exist, got %v, %v", exists, err)
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package idcotest provides an in-memory IDCO controller that serves the /idco/mscs API over HTTP, for the unit tests
+// of the inter-domain code paths.
+package idcotest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Network is a multi-site connectivity service held by the fake controller.
+type Network struct {
+	NetworkId  string   `json:"networkId"`
+	Endpoints  []string `json:"networkEndpoints,omitempty"`
+	MirrorPort string   `json:"mirrorPort,omitempty"`
+}
+
+// Server is a fake IDCO controller. Its networks live in memory, so every test gets a clean controller.
+type Server struct {
+	*httptest.Server
+
+	// Authorization, if set, is the value the Authorization header of every request must have. Other requests are
+	// rejected with 401.
+	Authorization string
+
+	mu       sync.Mutex
+	networks map[string]*Network
+	failures []int
+}
+
+// NewServer starts a fake IDCO controller with no networks, whose API is served at the root of its URL. The caller
+// must close it when done.
+func NewServer() *Server {
+	s := &Server{networks: map[string]*Network{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /idco/mscs/status", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET /idco/mscs", s.listNetworks)
+	mux.HandleFunc("POST /idco/mscs", s.createNetwork)
+	mux.HandleFunc("GET /idco/mscs/{id}", s.getNetwork)
+	mux.HandleFunc("DELETE /idco/mscs/{id}", s.deleteNetwork)
+	mux.HandleFunc("POST /idco/mscs/port", s.attachEndpoints)
+	mux.HandleFunc("DELETE /idco/mscs/port", s.detachEndpoints)
+	mux.HandleFunc("POST /idco/mscs/mirror-port", s.setMirrorPort)
+	mux.HandleFunc("DELETE /idco/mscs/mirror-port", s.removeMirrorPort)
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		if len(s.failures) > 0 {
+			status := s.failures[0]
+			s.failures = s.failures[1:]
+			s.mu.Unlock()
+			w.WriteHeader(status)
+			return
+		}
+		s.mu.Unlock()
+
+		if s.Authorization != "" && r.Header.Get("Authorization") != s.Authorization {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+// FailNext makes the next requests fail with the given status codes, one per request.
+func (s *Server) FailNext(statusCodes ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, statusCodes...)
+}
+
+// AddNetwork creates a network in the controller, as if it had been created by another cluster.
+func (s *Server) AddNetwork(networkID string, endpoints ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.networks[networkID] = &Network{NetworkId: networkID, Endpoints: slices.Clone(endpoints)}
+}
+
+// Network returns a copy of the network, if it exists.
+func (s *Server) Network(networkID string) (Network, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	network, ok := s.networks[networkID]
+	if !ok {
+		return Network{}, false
+	}
+	return Network{NetworkId: network.NetworkId, Endpoints: slices.Clone(network.Endpoints), MirrorPort: network.MirrorPort}, true
+}
+
+func (s *Server) listNetworks(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	networks := make([]Network, 0, len(s.networks))
+	for _, network := range s.networks {
+		networks = append(networks, *network)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(networks, func(i, j int) bool { return networks[i].NetworkId < networks[j].NetworkId })
+	writeJSON(w, networks)
+}
+
+func (s *Server) createNetwork(w http.ResponseWriter, r *http.Request) {
+	payload, ok := decode(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.networks[payload.NetworkId]; exists {
+		http.Error(w, "network "+payload.NetworkId+" already exists", http.StatusConflict)
+		return
+	}
+	s.networks[payload.NetworkId] = &Network{NetworkId: payload.NetworkId, Endpoints: payload.Endpoints}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getNetwork(w http.ResponseWriter, r *http.Request) {
+	network, ok := s.Network(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "network not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, network)
+}
+
+func (s *Server) deleteNetwork(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.networks[r.PathValue("id")]; !exists {
+		http.Error(w, "network not found", http.StatusNotFound)
+		return
+	}
+	delete(s.networks, r.PathValue("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) attachEndpoints(w http.ResponseWriter, r *http.Request) {
+	s.updateNetwork(w, r, func(network *Network, payload Network) {
+		for _, endpoint := range payload.Endpoints {
+			if !slices.Contains(network.Endpoints, endpoint) {
+				network.Endpoints = append(network.Endpoints, endpoint)
+			}
+		}
+	})
+}
+
+func (s *Server) detachEndpoints(w http.ResponseWriter, r *http.Request) {
+	s.updateNetwork(w, r, func(network *Network, payload Network) {
+		network.Endpoints = slices.DeleteFunc(network.Endpoints, func(endpoint string) bool {
+			return slices.Contains(payload.Endpoints, endpoint)
+		})
+	})
+}
+
+func (s *Server) setMirrorPort(w http.ResponseWriter, r *http.Request) {
+	s.updateNetwork(w, r, func(network *Network, payload Network) {
+		network.MirrorPort = payload.MirrorPort
+	})
+}
+
+func (s *Server) removeMirrorPort(w http.ResponseWriter, r *http.Request) {
+	s.updateNetwork(w, r, func(network *Network, payload Network) {
+		if network.MirrorPort == payload.MirrorPort {
+			network.MirrorPort = ""
+		}
+	})
+}
+
+// updateNetwork applies the change to the network the payload of the request refers to.
+func (s *Server) updateNetwork(w http.ResponseWriter, r *http.Request, update func(network *Network, payload Network)) {
+	payload, ok := decode(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	network, exists := s.networks[payload.NetworkId]
+	if !exists {
+		http.Error(w, "network not found", http.StatusNotFound)
+		return
+	}
+	update(network, payload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decode(w http.ResponseWriter, r *http.Request) (Network, bool) {
+	var payload Network
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.NetworkId == "" {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return Network{}, false
+	}
+	return payload, true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
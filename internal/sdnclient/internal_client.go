@@ -15,7 +15,7 @@
 package sdnclient
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/http"
 
@@ -34,128 +34,72 @@ type VnetPayload struct {
 	MirrorPort string   `json:"mirrorPort,omitempty"`
 }
 
-func (c *InternalClient) beginSessionController() bool {
-	resp, err := c.Session.Get("/vnets/api/status")
+// VlinkPayload describes a point-to-point virtual link. Path holds the ordered list of OpenFlow device IDs
+// the link traverses, so the controller pins the traffic between both endpoints to that route.
+type VlinkPayload struct {
+	NetworkId string   `json:"networkId"`
+	Path      []string `json:"path"`
+	Port      []string `json:"networkEndpoints,omitempty"`
+}
 
-	if err != nil {
-		fmt.Println(err)
-		return false
+// apiPrefix returns the l2sm-controller REST resource that manages the given type of network.
+func apiPrefix(networkType l2smv1.NetworkType) string {
+	if networkType == l2smv1.NetworkTypeVlink {
+		return "vlinks"
 	}
-	defer resp.Body.Close()
-	// Check if the status code indicates success (HTTP 200 OK).
-	return resp.StatusCode == http.StatusOK
+	return "vnets"
 }
 
-// CreateNetwork creates a new network in the SDN controller
-func (c *InternalClient) CreateNetwork(networkType l2smv1.NetworkType, config interface{}) error {
-
-	//TODO: Remove hard-code
-	networkType = "vnets"
-	jsonData, err := json.Marshal(config)
-	if err != nil {
-		return err
-	}
-	response, err := c.Session.Post(fmt.Sprintf("/%s/api", networkType), jsonData)
-	if err != nil {
-		return err
-	}
-	defer response.Body.Close()
+func (c *InternalClient) ping(ctx context.Context) error {
+	return c.Session.call(ctx, "check the controller status", http.MethodGet, "/vnets/api/status", nil, nil, http.StatusOK)
+}
 
-	if response.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("failed to create network, status code: %d", response.StatusCode)
+// CreateNetwork creates a new network in the SDN controller. It succeeds if the network already exists.
+func (c *InternalClient) CreateNetwork(ctx context.Context, networkType l2smv1.NetworkType, config interface{}) error {
+	err := c.Session.call(ctx, "create network", http.MethodPost, fmt.Sprintf("/%s/api", apiPrefix(networkType)), config, nil, http.StatusNoContent)
+	if IsConflict(err) {
+		return nil
 	}
-
-	return nil
+	return err
 }
 
 // CheckNetworkExists checks if the specified network exists in the SDN controller
-func (c *InternalClient) CheckNetworkExists(networkType l2smv1.NetworkType, networkID string) (bool, error) {
-	networkType = "vnets"
-
-	response, err := c.Session.Get(fmt.Sprintf("/%s/api/%s", networkType, networkID))
-	if err != nil {
-		return false, err
+func (c *InternalClient) CheckNetworkExists(ctx context.Context, networkType l2smv1.NetworkType, networkID string) (bool, error) {
+	err := c.Session.call(ctx, "get network", http.MethodGet, fmt.Sprintf("/%s/api/%s", apiPrefix(networkType), networkID), nil, nil, http.StatusOK)
+	if IsNotFound(err) {
+		return false, nil
 	}
-	defer response.Body.Close()
-
-	return response.StatusCode == http.StatusOK, nil
+	return err == nil, err
 }
 
 // DeleteNetwork deletes an existing network from the SDN controller
-func (c *InternalClient) DeleteNetwork(networkType l2smv1.NetworkType, networkID string) error {
-	networkType = "vnets"
-
-	response, err := c.Session.Delete(fmt.Sprintf("/%s/api/%s", networkType, networkID), nil)
-	if err != nil {
-		return err
-	}
-
-	defer response.Body.Close()
-
-	if response.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("SDN controller responded with status code: %d", response.StatusCode)
-	}
-
-	return nil
+func (c *InternalClient) DeleteNetwork(ctx context.Context, networkType l2smv1.NetworkType, networkID string) error {
+	return c.Session.call(ctx, "delete network", http.MethodDelete, fmt.Sprintf("/%s/api/%s", apiPrefix(networkType), networkID), nil, nil, http.StatusNoContent)
 }
 
-// AttachPodToNetwork checks if the specified network exists in the SDN controller
-func (c *InternalClient) AttachPodToNetwork(networkType l2smv1.NetworkType, config interface{}) error {
-
-	networkType = "vnets"
-	jsonData, err := json.Marshal(config)
-	if err != nil {
-		return err
-	}
-	response, err := c.Session.Post(fmt.Sprintf("/%s/api/port", networkType), jsonData)
-	if err != nil {
-		return err
-	}
-	defer response.Body.Close()
-
-	if response.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("failed to attach pod, status code: %d", response.StatusCode)
-	}
-
-	return nil
+// AttachPodToNetwork attaches the ports of the payload to its network
+func (c *InternalClient) AttachPodToNetwork(ctx context.Context, networkType l2smv1.NetworkType, config interface{}) error {
+	return c.Session.call(ctx, "attach pod", http.MethodPost, fmt.Sprintf("/%s/api/port", apiPrefix(networkType)), config, nil, http.StatusNoContent)
 }
 
-func (c *InternalClient) DetachPodFromNetwork(networkType l2smv1.NetworkType, config any) error {
-
-	networkType = "vnets"
-	jsonData, err := json.Marshal(config)
-	if err != nil {
-		return err
-	}
-	response, err := c.Session.Delete(fmt.Sprintf("/%s/api/port", networkType), jsonData)
-	if err != nil {
-		return err
-	}
-	defer response.Body.Close()
-
-	if response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to detach pod from network, status code: %d", response.StatusCode)
-	}
-
-	return nil
+func (c *InternalClient) DetachPodFromNetwork(ctx context.Context, networkType l2smv1.NetworkType, config any) error {
+	return c.Session.call(ctx, "detach pod from network", http.MethodDelete, fmt.Sprintf("/%s/api/port", apiPrefix(networkType)), config, nil, http.StatusNoContent, http.StatusOK)
 }
 
-func (c *InternalClient) SetUpMirrorPort(networkType l2smv1.NetworkType, config any) error {
+func (c *InternalClient) SetUpMirrorPort(ctx context.Context, networkType l2smv1.NetworkType, config any) error {
+	return c.Session.call(ctx, "set up mirror port", http.MethodPost, fmt.Sprintf("/%s/api/mirror-port", apiPrefix(networkType)), config, nil, http.StatusNoContent)
+}
 
-	networkType = "vnets"
-	jsonData, err := json.Marshal(config)
-	if err != nil {
-		return err
-	}
-	response, err := c.Session.Post(fmt.Sprintf("/%s/api/mirror-port", networkType), jsonData)
-	if err != nil {
-		return err
-	}
-	defer response.Body.Close()
+// RemoveMirrorPort stops mirroring the traffic of the network to the mirror port of the payload
+func (c *InternalClient) RemoveMirrorPort(ctx context.Context, networkType l2smv1.NetworkType, config any) error {
+	return c.Session.call(ctx, "remove mirror port", http.MethodDelete, fmt.Sprintf("/%s/api/mirror-port", apiPrefix(networkType)), config, nil, http.StatusNoContent, http.StatusOK)
+}
 
-	if response.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("failed to attach pod, status code: %d", response.StatusCode)
+// ListNetworks returns every network of the given type held by the SDN controller, alongside the ports attached to them.
+func (c *InternalClient) ListNetworks(ctx context.Context, networkType l2smv1.NetworkType) ([]VnetPayload, error) {
+	var networks []VnetPayload
+	if err := c.Session.call(ctx, "list networks", http.MethodGet, fmt.Sprintf("/%s/api", apiPrefix(networkType)), nil, &networks, http.StatusOK); err != nil {
+		return nil, err
 	}
-
-	return nil
+	return networks, nil
 }
@@ -16,68 +16,151 @@ package sdnclient
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptrace"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
+const defaultTimeout = 10 * time.Second
+
+// DefaultBackoff retries a request up to three times, waiting 200ms, 400ms and 800ms between attempts.
+var DefaultBackoff = wait.Backoff{Duration: 200 * time.Millisecond, Factor: 2, Jitter: 0.1, Steps: 4}
+
 // SessionClient wraps around http.Client and automatically adds authorization headers.
 type SessionClient struct {
 	httpClient *http.Client
 	BaseURL    string
-	AuthToken  string
+	// Authorization is the value of the Authorization header sent in every request. Requests are not authenticated if
+	// it's empty.
+	Authorization string
+	// Backoff sets how many times and how often the requests that fail transiently are retried.
+	Backoff wait.Backoff
 }
 
-// NewSessionClient creates a new SessionClient with basic auth credentials.
-func NewSessionClient(baseURL, username, password string) *SessionClient {
-	authToken := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
-	return &SessionClient{
-		httpClient: &http.Client{Timeout: time.Second * 10},
-		BaseURL:    baseURL,
-		AuthToken:  authToken,
+// NewSessionClient creates a new SessionClient that authenticates with the bearer token of the config or, if it has
+// none, with its basic auth credentials. The controller certificate is verified against the CA bundle of the config
+// when it has one.
+func NewSessionClient(config ClientConfig) (*SessionClient, error) {
+	httpClient := &http.Client{Timeout: defaultTimeout}
+	if config.Timeout > 0 {
+		httpClient.Timeout = config.Timeout
+	}
+	if config.Transport != nil {
+		httpClient.Transport = config.Transport
+	} else if len(config.CACert) > 0 {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(config.CACert) {
+			return nil, errors.New("the CA bundle of the SDN controller has no valid PEM certificates")
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+		httpClient.Transport = transport
 	}
-}
 
-// newRequest creates a new HTTP request with the necessary authentication headers.
-func (c *SessionClient) newRequest(method, url string, body []byte) (*http.Request, error) {
-	req, err := http.NewRequest(method, c.BaseURL+url, bytes.NewBuffer(body))
-	if err != nil {
-		return nil, err
+	var authorization string
+	switch {
+	case config.Token != "":
+		authorization = "Bearer " + config.Token
+	case config.Username != "":
+		authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(config.Username+":"+config.Password))
 	}
 
-	req.Header.Add("Authorization", "Basic "+c.AuthToken)
-	req.Header.Add("Content-Type", "application/json")
-	return req, nil
-}
+	backoff := DefaultBackoff
+	if config.Backoff != nil {
+		backoff = *config.Backoff
+	}
 
-// Do sends an HTTP request and returns an HTTP response, similar to http.Client's Do.
-func (c *SessionClient) Do(req *http.Request) (*http.Response, error) {
-	return c.httpClient.Do(req)
+	return &SessionClient{
+		httpClient:    httpClient,
+		BaseURL:       config.BaseURL,
+		Authorization: authorization,
+		Backoff:       backoff,
+	}, nil
 }
 
-// Get wraps the GET method with authorization.
-func (c *SessionClient) Get(url string) (*http.Response, error) {
-	req, err := c.newRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
+// Do sends a request to the path of the controller API, retrying with exponential backoff while the controller can't
+// be reached or answers with a transient error. POST requests, which create networks and attach ports, aren't safe to
+// apply twice, so they are only retried after a connection error if it happened before the request was sent. The
+// response of the last attempt is returned, and its body must be closed by the caller.
+func (c *SessionClient) Do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	backoff := c.Backoff
+	for {
+		var sent atomic.Bool
+		trace := &httptrace.ClientTrace{WroteHeaders: func() { sent.Store(true) }}
+		req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), method, c.BaseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if c.Authorization != "" {
+			req.Header.Add("Authorization", c.Authorization)
+		}
+		req.Header.Add("Content-Type", "application/json")
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			err = fmt.Errorf("%w: %w", ErrUnavailable, err)
+			if method == http.MethodPost && sent.Load() {
+				// the controller may have applied the request before the connection broke
+				return nil, err
+			}
+		}
+		if (err == nil && !isTransientStatus(resp.StatusCode)) || backoff.Steps <= 1 {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff.Step()):
+		}
 	}
-	return c.Do(req)
 }
 
-// Post wraps the POST method with authorization.
-func (c *SessionClient) Post(url string, body []byte) (*http.Response, error) {
-	req, err := c.newRequest("POST", url, body)
-	if err != nil {
-		return nil, err
+// call sends the payload, if any, as JSON to the path and decodes the response into out, if not nil. Responses with a
+// status code other than the expected ones are returned as a StatusError for the operation.
+func (c *SessionClient) call(ctx context.Context, op, method, path string, payload, out any, expected ...int) error {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return fmt.Errorf("failed to %s: %w", op, err)
+		}
 	}
-	return c.Do(req)
-}
 
-// Delete wraps the DELETE method with authorization.
-func (c *SessionClient) Delete(url string, body []byte) (*http.Response, error) {
-	req, err := c.newRequest("DELETE", url, body)
+	resp, err := c.Do(ctx, method, path, body)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to %s: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	if !slices.Contains(expected, resp.StatusCode) {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &StatusError{Op: op, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("could not decode the response to %s: %w", op, err)
+		}
 	}
-	return c.Do(req)
+	return nil
 }
@@ -0,0 +1,210 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sdnclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	l2smv1 "github.com/Networks-it-uc3m/L2S-M/api/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+var testBackoff = &wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 3}
+
+func newTestInternalClient(t *testing.T, handler http.HandlerFunc) *InternalClient {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	session, err := NewSessionClient(ClientConfig{BaseURL: server.URL, Backoff: testBackoff})
+	if err != nil {
+		t.Fatalf("NewSessionClient returned error: %v", err)
+	}
+	return &InternalClient{Session: session}
+}
+
+func TestRetriesTransientErrors(t *testing.T) {
+	var attempts atomic.Int32
+	c := newTestInternalClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	if err := c.DeleteNetwork(context.Background(), l2smv1.NetworkTypeVnet, "net"); err != nil {
+		t.Fatalf("expected the request to succeed after retrying, got %v", err)
+	}
+	if attempts.Load() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts.Load())
+	}
+}
+
+func TestGivesUpAfterBackoffSteps(t *testing.T) {
+	var attempts atomic.Int32
+	c := newTestInternalClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	err := c.DeleteNetwork(context.Background(), l2smv1.NetworkTypeVnet, "net")
+	if !IsUnavailable(err) {
+		t.Fatalf("expected an unavailable error, got %v", err)
+	}
+	if attempts.Load() != int32(testBackoff.Steps) {
+		t.Fatalf("expected %d attempts, got %d", testBackoff.Steps, attempts.Load())
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		is     func(error) bool
+		name   string
+	}{
+		{status: http.StatusNotFound, is: IsNotFound, name: "not found"},
+		{status: http.StatusConflict, is: IsConflict, name: "conflict"},
+		{status: http.StatusInternalServerError, body: "Network net already exists", is: IsConflict, name: "already exists"},
+		{status: http.StatusUnauthorized, is: IsUnauthorized, name: "unauthorized"},
+		{status: http.StatusForbidden, is: IsUnauthorized, name: "forbidden"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestInternalClient(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			})
+			err := c.DeleteNetwork(context.Background(), l2smv1.NetworkTypeVnet, "net")
+			if !tt.is(err) {
+				t.Fatalf("unexpected error class for %v", err)
+			}
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status {
+				t.Fatalf("expected a StatusError with code %d, got %v", tt.status, err)
+			}
+		})
+	}
+}
+
+func TestCreateExistingNetworkSucceeds(t *testing.T) {
+	c := newTestInternalClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("Network net already exists"))
+	})
+
+	if err := c.CreateNetwork(context.Background(), l2smv1.NetworkTypeVnet, VnetPayload{NetworkId: "net"}); err != nil {
+		t.Fatalf("expected creating an existing network to succeed, got %v", err)
+	}
+}
+
+func TestCheckNetworkExists(t *testing.T) {
+	c := newTestInternalClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/vnets/api/present" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	if exists, err := c.CheckNetworkExists(context.Background(), l2smv1.NetworkTypeVnet, "present"); err != nil || !exists {
+		t.Fatalf("expected the network to exist, got %v, %v", exists, err)
+	}
+	if exists, err := c.CheckNetworkExists(context.Background(), l2smv1.NetworkTypeVnet, "missing"); err != nil || exists {
+		t.Fatalf("expected the network to be missing, got %v, %v", exists, err)
+	}
+}
+
+func TestCancelledContextStopsRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := newTestInternalClient(t, func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	c.Session.Backoff = wait.Backoff{Duration: time.Hour, Steps: 5}
+
+	err := c.DeleteNetwork(ctx, l2smv1.NetworkTypeVnet, "net")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the context error, got %v", err)
+	}
+}
+
+func TestDoesNotResendPostAfterConnectionLoss(t *testing.T) {
+	var attempts atomic.Int32
+	c := newTestInternalClient(t, func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		_, _ = io.Copy(io.Discard, r.Body)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("could not hijack the connection: %v", err)
+			return
+		}
+		conn.Close()
+	})
+
+	err := c.AttachPodToNetwork(context.Background(), l2smv1.NetworkTypeVnet, VnetPayload{NetworkId: "net", Port: []string{"of:1/1"}})
+	if !IsUnavailable(err) {
+		t.Fatalf("expected an unavailable error, got %v", err)
+	}
+	if attempts.Load() != 1 {
+		t.Fatalf("expected the attachment to be sent once, got %d attempts", attempts.Load())
+	}
+}
+
+// failFirst fails the first request before sending it, as when the controller can't be dialed.
+type failFirst struct {
+	failed atomic.Bool
+}
+
+func (f *failFirst) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !f.failed.Swap(true) {
+		return nil, errors.New("connection refused")
+	}
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestRetriesPostThatWasNotSent(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	session, err := NewSessionClient(ClientConfig{BaseURL: server.URL, Backoff: testBackoff, Transport: &failFirst{}})
+	if err != nil {
+		t.Fatalf("NewSessionClient returned error: %v", err)
+	}
+	c := &InternalClient{Session: session}
+
+	if err := c.CreateNetwork(context.Background(), l2smv1.NetworkTypeVnet, VnetPayload{NetworkId: "net"}); err != nil {
+		t.Fatalf("expected the request to succeed after retrying, got %v", err)
+	}
+	if attempts.Load() != 2 {
+		t.Fatalf("expected the controller to get 2 requests, got %d", attempts.Load())
+	}
+}
@@ -15,28 +15,14 @@
 package utils
 
 import (
-	"encoding/binary"
 	"fmt"
-	"net"
+	"net/netip"
 )
 
-func ipv4ToUint32(ip net.IP) (uint32, error) {
-	v4 := ip.To4()
-	if v4 == nil {
-		return 0, fmt.Errorf("not an IPv4 address: %v", ip)
-	}
-	return binary.BigEndian.Uint32(v4), nil
-}
-
-func uint32ToIPv4(n uint32) net.IP {
-	b := make([]byte, 4)
-	binary.BigEndian.PutUint32(b, n)
-	return net.IP(b)
-}
-
-// allocateIPv4s returns count IPs starting at host offset ipStart within cidr.
-// It enforces: IPv4, ipStart>=1, and avoids network/broadcast addresses for typical subnets.
-func AllocateIPv4s(cidr string, ipStart int, count int) ([]string, string, error) {
+// AllocateIPs returns count addresses of the cidr starting at the host offset ipStart, along with the mask of the
+// cidr, e.g. "/24". It works with IPv4 and IPv6, and never returns the network address, nor the broadcast address in
+// IPv4.
+func AllocateIPs(cidr string, ipStart int, count int) ([]string, string, error) {
 	if count <= 0 {
 		return nil, "", fmt.Errorf("count must be > 0")
 	}
@@ -44,50 +30,46 @@ func AllocateIPv4s(cidr string, ipStart int, count int) ([]string, string, error
 		return nil, "", fmt.Errorf("ipStart must be >= 1 (got %d)", ipStart)
 	}
 
-	ip, ipnet, err := net.ParseCIDR(cidr)
+	prefix, err := netip.ParsePrefix(cidr)
 	if err != nil {
 		return nil, "", fmt.Errorf("invalid CIDR %q: %w", cidr, err)
 	}
+	prefix = prefix.Masked()
+	maskStr := fmt.Sprintf("/%d", prefix.Bits())
 
-	base := ip.To4()
-	if base == nil {
-		return nil, "", fmt.Errorf("CIDR %q is not IPv4 (IPv6 not supported)", cidr)
-	}
-
-	ones, bits := ipnet.Mask.Size()
-	if bits != 32 {
-		return nil, "", fmt.Errorf("unexpected mask size for %q", cidr)
-	}
-
-	maskStr := fmt.Sprintf("/%d", ones)
-	// total addresses in subnet
-	total := 1 << uint32(32-ones)
-	if total < 4 {
+	// Compute the highest usable host offset, which leaves out the broadcast address in IPv4. IPv6 subnets are too big
+	// to ever run out of addresses past /64, so their size is not computed.
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if prefix.Addr().Is4() && hostBits < 2 {
 		// /31, /32 have special semantics; keep it simple unless you want to support them explicitly
-		return nil, "", fmt.Errorf("CIDR %q too small / not supported (size=%d)", cidr, total)
+		return nil, "", fmt.Errorf("CIDR %q too small / not supported (size=%d)", cidr, 1<<hostBits)
 	}
-
-	// Compute usable host range: [1, total-2] to avoid network (0) and broadcast (total-1)
-	minHost := 1
-	maxHost := total - 2
-
-	lastHost := ipStart + count - 1
-	if ipStart < minHost || lastHost > maxHost {
-		return nil, "", fmt.Errorf(
-			"CIDR %q cannot allocate %d IPs from start %d (usable host range %d..%d)",
-			cidr, count, ipStart, minHost, maxHost,
-		)
-	}
-
-	baseU, err := ipv4ToUint32(base)
-	if err != nil {
-		return nil, "", err
+	lastHost := uint64(ipStart + count - 1)
+	if hostBits < 64 {
+		maxHost := uint64(1)<<hostBits - 1
+		if prefix.Addr().Is4() {
+			maxHost--
+		}
+		if lastHost > maxHost {
+			return nil, "", fmt.Errorf("CIDR %q cannot allocate %d IPs from start %d (usable host range 1..%d)", cidr, count, ipStart, maxHost)
+		}
 	}
 
 	ips := make([]string, 0, count)
 	for i := 0; i < count; i++ {
-		hostOffset := uint32(ipStart + i)
-		ips = append(ips, uint32ToIPv4(baseU+hostOffset).String())
+		ips = append(ips, addOffset(prefix.Addr(), uint64(ipStart+i)).String())
 	}
 	return ips, maskStr, nil
 }
+
+// addOffset returns the address offset positions after addr.
+func addOffset(addr netip.Addr, offset uint64) netip.Addr {
+	bytes := addr.AsSlice()
+	for i := len(bytes) - 1; i >= 0 && offset > 0; i-- {
+		sum := uint64(bytes[i]) + offset&0xff
+		bytes[i] = byte(sum)
+		offset = offset>>8 + sum>>8
+	}
+	result, _ := netip.AddrFromSlice(bytes)
+	return result
+}
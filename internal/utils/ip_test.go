@@ -0,0 +1,58 @@
+// Copyright 2024 Universidad Carlos III de Madrid
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAllocateIPs(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidr    string
+		start   int
+		count   int
+		want    []string
+		mask    string
+		wantErr bool
+	}{
+		{name: "ipv4", cidr: "10.0.0.0/24", start: 2, count: 3, want: []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"}, mask: "/24"},
+		{name: "ipv4 carries into the next byte", cidr: "10.0.0.0/16", start: 255, count: 2, want: []string{"10.0.0.255", "10.0.1.0"}, mask: "/16"},
+		{name: "ipv4 never hands out the broadcast address", cidr: "10.0.0.0/30", start: 2, count: 2, wantErr: true},
+		{name: "ipv4 too small", cidr: "10.0.0.0/31", start: 1, count: 1, wantErr: true},
+		{name: "ipv6", cidr: "fd00:10::/64", start: 2, count: 2, want: []string{"fd00:10::2", "fd00:10::3"}, mask: "/64"},
+		{name: "ipv6 unmasked cidr", cidr: "fd00:10::7/120", start: 255, count: 1, want: []string{"fd00:10::ff"}, mask: "/120"},
+		{name: "ipv6 out of a small subnet", cidr: "fd00:10::/126", start: 3, count: 2, wantErr: true},
+		{name: "invalid cidr", cidr: "10.0.0.0", start: 1, count: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, mask, err := AllocateIPs(tt.cidr, tt.start, tt.count)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(got, tt.want) || mask != tt.mask {
+				t.Errorf("got %v%s, want %v%s", got, mask, tt.want, tt.mask)
+			}
+		})
+	}
+}
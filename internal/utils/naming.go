@@ -51,3 +51,8 @@ func GenerateIdsCMName(networkName string) string {
 func GenerateIdsDeployname(networkName string) string {
 	return fmt.Sprintf("%s-ids", networkName)
 }
+
+// GenerateIdsRulesCMName returns the name of the ConfigMap that holds the given chunk of the remote IDS rules.
+func GenerateIdsRulesCMName(networkName string, chunk int) string {
+	return fmt.Sprintf("%s-ids-rules-%d", networkName, chunk)
+}